@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/dingqing/consistent-hash/api"
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// Client 在调用方本地维护一份与代理相同的环，直接把请求发给后端，省去经过代理的一跳
+// 启动时从代理的gRPC接口取得完整拓扑，之后订阅拓扑变化，每次变化后重新同步
+// 本地环不知道代理上的负载，因此只支持普通一致性哈希，不支持ModeCapacious
+type Client struct {
+	conn     *grpc.ClientConn
+	registry api.RegistryClient
+	ring     atomic.Pointer[core.Consistent]
+
+	dialOpts []grpc.DialOption
+	http     *http.Client
+	scheme   string
+	resync   time.Duration
+	logger   core.Logger
+
+	// 容量为1，合并连续到达的拓扑事件
+	dirty  chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+const (
+	defaultResyncInterval = 30 * time.Second
+	initialSyncTimeout    = 10 * time.Second
+	maxWatchRetryDelay    = 30 * time.Second
+)
+
+// New 连接target（代理的gRPC地址）并完成第一次同步
+func New(target string, opts ...Option) (*Client, error) {
+	c := &Client{
+		http:   http.DefaultClient,
+		scheme: "http",
+		resync: defaultResyncInterval,
+		logger: slog.Default(),
+		dirty:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if len(c.dialOpts) == 0 {
+		c.dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+
+	conn, err := grpc.Dial(target, c.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.registry = api.NewRegistryClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), initialSyncTimeout)
+	defer cancel()
+	if err = c.refresh(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	ctx, c.cancel = context.WithCancel(context.Background())
+	go c.watch(ctx)
+	go c.run(ctx)
+	return c, nil
+}
+
+// Close 停止同步并断开与代理的连接
+func (c *Client) Close() error {
+	c.cancel()
+	<-c.done
+	return c.conn.Close()
+}
+
+// GetHost 在本地环上查找key对应的服务器
+func (c *Client) GetHost(key string) (string, error) {
+	return c.ring.Load().GetHost(key)
+}
+
+// Hosts 本地环上的服务器
+func (c *Client) Hosts() []string {
+	return c.ring.Load().Hosts()
+}
+
+// Do 把req直接发给key对应的后端，req.URL中的host会被替换
+func (c *Client) Do(req *http.Request, key string) (*http.Response, error) {
+	host, err := c.GetHost(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := req.Clone(req.Context())
+	out.URL.Scheme = c.scheme
+	out.URL.Host = host
+	out.Host = ""
+	out.RequestURI = ""
+	return c.http.Do(out)
+}
+
+// refresh 取得代理上环的快照，在本地重建后整体替换
+func (c *Client) refresh(ctx context.Context) error {
+	resp, err := c.registry.GetTopology(ctx, &api.GetTopologyRequest{})
+	if err != nil {
+		return err
+	}
+	ring, err := core.Restore(resp.Snapshot, core.WithLogger(c.logger))
+	if err != nil {
+		return err
+	}
+	c.ring.Store(ring)
+	return nil
+}
+
+func (c *Client) notify() {
+	select {
+	case c.dirty <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) run(ctx context.Context) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.resync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.dirty:
+		case <-ticker.C:
+		}
+		if err := c.refresh(ctx); err != nil && ctx.Err() == nil {
+			c.logger.Warn("sync topology failed", "error", err)
+		}
+	}
+}
+
+// watch 订阅拓扑变化，断开后退避重连；重连后立即同步一次，补上断开期间的变化
+func (c *Client) watch(ctx context.Context) {
+	delay := time.Second
+	for {
+		stream, err := c.registry.Watch(ctx, &api.WatchRequest{})
+		if err == nil {
+			c.notify()
+			for {
+				if _, err = stream.Recv(); err != nil {
+					break
+				}
+				c.notify()
+				delay = time.Second
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		c.logger.Warn("watch topology failed", "error", err, "retry_in", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(delay*2, maxWatchRetryDelay)
+	}
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+type Option func(c *Client)
+
+// WithDialOptions 设置连接代理gRPC端口的参数，如TLS凭据，默认不加密
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *Client) {
+		c.dialOpts = append(c.dialOpts, opts...)
+	}
+}
+
+// WithHTTPClient 设置Do直接请求后端时使用的http.Client
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		if hc != nil {
+			c.http = hc
+		}
+	}
+}
+
+// WithScheme 设置请求后端使用的协议，默认为http
+func WithScheme(scheme string) Option {
+	return func(c *Client) {
+		c.scheme = scheme
+	}
+}
+
+// WithResyncInterval 除了订阅拓扑变化，每隔d再完整同步一次，防止错过事件
+func WithResyncInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.resync = d
+	}
+}
+
+// WithLogger 设置客户端及其本地环使用的日志
+func WithLogger(l core.Logger) Option {
+	return func(c *Client) {
+		if l != nil {
+			c.logger = l
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+
+	"github.com/dingqing/consistent-hash/core"
+	"github.com/dingqing/consistent-hash/proxy"
+)
+
+// Applier 在本实例上应用已提交的变更，*proxy.Proxy满足该接口
+type Applier interface {
+	ApplyChange(c proxy.Change) error
+	Topology() []proxy.Change
+	ResetTopology(changes []proxy.Change) error
+}
+
+// RaftConfig Raft节点的配置
+type RaftConfig struct {
+	// 节点ID，必须是本节点管理接口的地址（如 http://proxy-1:18890），follower据此把写请求转发给leader
+	ID string
+	// Raft节点之间通信的地址
+	BindAddr string
+	// 其他节点连接本节点使用的地址，为空时使用BindAddr
+	Advertise string
+	// 保存日志和快照的目录
+	Dir string
+	// 第一次启动时以单节点集群初始化，集群中只应有一个节点开启
+	Bootstrap bool
+	// 任一已有节点的管理接口地址，第一次启动时请求加入集群
+	Join string
+	// 调用其他节点管理接口使用的bearer token
+	Token        string
+	ApplyTimeout time.Duration
+	Client       *http.Client
+	Logger       core.Logger
+}
+
+// Raft 通过Raft日志复制拓扑变更，所有节点按相同的顺序应用相同的变更
+// 实现proxy.Replicator，非leader节点把写请求转发给leader
+type Raft struct {
+	raft   *raft.Raft
+	config RaftConfig
+	logger core.Logger
+}
+
+var ErrNoLeader = errors.New("raft: no leader")
+
+const (
+	defaultApplyTimeout = 5 * time.Second
+	retainSnapshots     = 2
+)
+
+func NewRaft(config RaftConfig, applier Applier) (*Raft, error) {
+	if config.ApplyTimeout <= 0 {
+		config.ApplyTimeout = defaultApplyTimeout
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: config.ApplyTimeout}
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	if config.Advertise == "" {
+		config.Advertise = config.BindAddr
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	store, err := raftboltdb.NewBoltStore(filepath.Join(config.Dir, "raft.db"))
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(config.Dir, retainSnapshots, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	advertise, err := net.ResolveTCPAddr("tcp", config.Advertise)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := raft.NewTCPTransport(config.BindAddr, advertise, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := raft.DefaultConfig()
+	rc.LocalID = raft.ServerID(config.ID)
+	rc.LogLevel = "WARN"
+
+	r, err := raft.NewRaft(rc, &fsm{applier: applier}, store, store, snapshots, transport)
+	if err != nil {
+		return nil, err
+	}
+	node := &Raft{raft: r, config: config, logger: config.Logger}
+
+	existing, err := raft.HasExistingState(store, store, snapshots)
+	if err != nil {
+		return nil, err
+	}
+	if !existing && config.Bootstrap {
+		err = r.BootstrapCluster(raft.Configuration{Servers: []raft.Server{{
+			ID:      rc.LocalID,
+			Address: transport.LocalAddr(),
+		}}}).Error()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// JoinCluster 请求config.Join指定的节点把本节点加入集群，失败时重试直到ctx取消
+func (n *Raft) JoinCluster(ctx context.Context) error {
+	if n.config.Join == "" {
+		return nil
+	}
+
+	req := joinRequest{ID: n.config.ID, Address: n.config.Advertise}
+	for {
+		_, err := n.post(ctx, n.config.Join+"/v1/raft/join", req)
+		if err == nil {
+			n.logger.Info("joined raft cluster", "via", n.config.Join)
+			return nil
+		}
+		n.logger.Warn("join raft cluster failed", "via", n.config.Join, "error", err)
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Replicate leader直接写入Raft日志，等本节点应用后返回结果
+// 其他节点转发给leader，再等本节点应用到同一条日志，之后本节点读到的拓扑已包含该变更
+func (n *Raft) Replicate(c proxy.Change) error {
+	index, err := n.replicate(c)
+	if err != nil {
+		return err
+	}
+	return n.waitApplied(index)
+}
+
+func (n *Raft) replicate(c proxy.Change) (uint64, error) {
+	if n.raft.State() != raft.Leader {
+		return n.forward("/v1/raft/apply", c)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return 0, err
+	}
+	f := n.raft.Apply(data, n.config.ApplyTimeout)
+	if err = f.Error(); err != nil {
+		return 0, err
+	}
+	if err, ok := f.Response().(error); ok {
+		return 0, err
+	}
+	return f.Index(), nil
+}
+
+func (n *Raft) waitApplied(index uint64) error {
+	deadline := time.Now().Add(n.config.ApplyTimeout)
+	for n.raft.AppliedIndex() < index {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("raft: timed out waiting for index %d", index)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return nil
+}
+
+func (n *Raft) Shutdown() error {
+	return n.raft.Shutdown().Error()
+}
+
+func (n *Raft) forward(path string, v interface{}) (uint64, error) {
+	_, leader := n.raft.LeaderWithID()
+	if leader == "" {
+		return 0, ErrNoLeader
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.ApplyTimeout)
+	defer cancel()
+	return n.post(ctx, string(leader)+path, v)
+}
+
+func (n *Raft) post(ctx context.Context, url string, v interface{}) (uint64, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.config.Token)
+	}
+
+	resp, err := n.config.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var res result
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return 0, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	if res.Error != nil {
+		return 0, res.Error.err()
+	}
+	return res.Index, nil
+}
+
+// Handler 节点之间使用的接口，挂在管理端口上：
+//
+//	POST /v1/raft/apply  follower转发来的写请求
+//	POST /v1/raft/join   把节点加入集群
+//	GET  /v1/raft/status 节点状态
+func (n *Raft) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/raft/apply", n.handleApply)
+	mux.HandleFunc("/v1/raft/join", n.handleJoin)
+	mux.HandleFunc("/v1/raft/status", n.handleStatus)
+	return mux
+}
+
+type joinRequest struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+type statusResponse struct {
+	ID     string `json:"id"`
+	State  string `json:"state"`
+	Leader string `json:"leader"`
+}
+
+func (n *Raft) handleApply(w http.ResponseWriter, r *http.Request) {
+	var c proxy.Change
+	if !decodePost(w, r, &c) {
+		return
+	}
+	index, err := n.replicate(c)
+	writeResult(w, index, err)
+}
+
+func (n *Raft) handleJoin(w http.ResponseWriter, r *http.Request) {
+	var req joinRequest
+	if !decodePost(w, r, &req) {
+		return
+	}
+	if n.raft.State() != raft.Leader {
+		index, err := n.forward("/v1/raft/join", req)
+		writeResult(w, index, err)
+		return
+	}
+
+	f := n.raft.AddVoter(raft.ServerID(req.ID), raft.ServerAddress(req.Address), 0, n.config.ApplyTimeout)
+	if err := f.Error(); err != nil {
+		writeResult(w, 0, err)
+		return
+	}
+	n.logger.Info("raft node joined", "id", req.ID, "address", req.Address)
+	writeResult(w, f.Index(), nil)
+}
+
+func (n *Raft) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	_, leader := n.raft.LeaderWithID()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(statusResponse{
+		ID:     n.config.ID,
+		State:  n.raft.State().String(),
+		Leader: string(leader),
+	})
+}
+
+func decodePost(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// 节点之间转发时保留core的错误，让发起请求的节点返回与leader相同的错误
+var coreErrors = map[string]error{
+	"host_already_exists": core.ErrHostAlreadyExists,
+	"host_not_found":      core.ErrHostNotFound,
+	"no_ttl":              core.ErrNoTTL,
+	"invalid_ttl":         core.ErrInvalidTTL,
+}
+
+// result 节点之间转发请求的结果，成功时返回变更在日志中的位置
+type result struct {
+	Index uint64    `json:"index,omitempty"`
+	Error *apiError `json:"error,omitempty"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) err() error {
+	return &remoteError{message: e.Message, err: coreErrors[e.Code]}
+}
+
+// remoteError leader返回的错误，errors.Is可以识别其中的core错误
+type remoteError struct {
+	message string
+	err     error
+}
+
+func (e *remoteError) Error() string { return e.message }
+func (e *remoteError) Unwrap() error { return e.err }
+
+func writeResult(w http.ResponseWriter, index uint64, err error) {
+	res := result{Index: index}
+	if err != nil {
+		res.Error = &apiError{Code: "internal", Message: err.Error()}
+		for code, e := range coreErrors {
+			if errors.Is(err, e) {
+				res.Error.Code = code
+				break
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// fsm 把Raft日志中的变更应用到本实例的环
+type fsm struct {
+	applier Applier
+}
+
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var c proxy.Change
+	if err := json.Unmarshal(l.Data, &c); err != nil {
+		return err
+	}
+	return f.applier.ApplyChange(c)
+}
+
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	data, err := json.Marshal(f.applier.Topology())
+	if err != nil {
+		return nil, err
+	}
+	return fsmSnapshot(data), nil
+}
+
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	var changes []proxy.Change
+	if err := json.NewDecoder(rc).Decode(&changes); err != nil {
+		return err
+	}
+	return f.applier.ResetTopology(changes)
+}
+
+type fsmSnapshot []byte
+
+func (s fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s fsmSnapshot) Release() {}
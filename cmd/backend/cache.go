@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cache 带过期时间和容量限制的内存kv
+// 超过maxEntries或maxBytes时按淘汰策略移除key，过期的key在读取时或定期清理时删除
+type cache struct {
+	items      map[string]item
+	policy     evictionPolicy
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	stats      cacheStats
+	sync.Mutex
+}
+
+var errTooLarge = errors.New("value exceeds the cache size limit")
+
+type item struct {
+	value string
+	// 为零值时永不过期
+	expireAt time.Time
+}
+
+func (it item) expired(now time.Time) bool {
+	return !it.expireAt.IsZero() && now.After(it.expireAt)
+}
+
+func itemSize(key string, it item) int64 {
+	return int64(len(key) + len(it.value))
+}
+
+type cacheStats struct {
+	hits        atomic.Int64
+	misses      atomic.Int64
+	evictions   atomic.Int64
+	expirations atomic.Int64
+}
+
+type statsResponse struct {
+	Policy      string `json:"policy"`
+	Entries     int    `json:"entries"`
+	Bytes       int64  `json:"bytes"`
+	MaxEntries  int    `json:"max_entries"`
+	MaxBytes    int64  `json:"max_bytes"`
+	Hits        int64  `json:"hits"`
+	Misses      int64  `json:"misses"`
+	Evictions   int64  `json:"evictions"`
+	Expirations int64  `json:"expirations"`
+}
+
+// newCache maxEntries、maxBytes为0时不限制
+func newCache(policy evictionPolicy, maxEntries int, maxBytes int64) *cache {
+	return &cache{
+		items:      make(map[string]item),
+		policy:     policy,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+}
+
+func (c *cache) get(key string) (item, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	it, ok := c.items[key]
+	if ok && it.expired(time.Now()) {
+		c.removeLocked(key)
+		c.stats.expirations.Add(1)
+		ok = false
+	}
+	if !ok {
+		c.stats.misses.Add(1)
+		return item{}, false
+	}
+
+	c.stats.hits.Add(1)
+	c.policy.touch(key)
+	return it, true
+}
+
+// set ttl为0时永不过期，返回写入的值以及key之前是否存在；覆盖已有的key时保留其访问记录
+func (c *cache) set(key, value string, ttl time.Duration) (item, bool, error) {
+	it := item{value: value}
+	if ttl > 0 {
+		it.expireAt = time.Now().Add(ttl)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	old, existed := c.items[key]
+	if c.maxBytes > 0 && itemSize(key, it) > c.maxBytes {
+		if existed {
+			c.removeLocked(key)
+		}
+		return item{}, false, errTooLarge
+	}
+
+	if existed {
+		c.bytes -= itemSize(key, old)
+		c.policy.touch(key)
+	} else {
+		c.policy.add(key)
+	}
+	c.items[key] = it
+	c.bytes += itemSize(key, it)
+	c.evictLocked(key)
+	return it, existed && !old.expired(time.Now()), nil
+}
+
+func (c *cache) delete(key string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	it, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.removeLocked(key)
+	return !it.expired(time.Now())
+}
+
+func (c *cache) snapshotStats() statsResponse {
+	c.Lock()
+	defer c.Unlock()
+
+	return statsResponse{
+		Policy:      c.policy.name(),
+		Entries:     len(c.items),
+		Bytes:       c.bytes,
+		MaxEntries:  c.maxEntries,
+		MaxBytes:    c.maxBytes,
+		Hits:        c.stats.hits.Load(),
+		Misses:      c.stats.misses.Load(),
+		Evictions:   c.stats.evictions.Load(),
+		Expirations: c.stats.expirations.Load(),
+	}
+}
+
+// 需要持有锁
+func (c *cache) removeLocked(key string) {
+	c.bytes -= itemSize(key, c.items[key])
+	delete(c.items, key)
+	c.policy.remove(key)
+}
+
+// 需要持有锁，按淘汰策略移除key直到满足容量限制，不会淘汰刚写入的keep
+func (c *cache) evictLocked(keep string) {
+	for (c.maxEntries > 0 && len(c.items) > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		key, ok := c.policy.victim(keep)
+		if !ok {
+			return
+		}
+		c.removeLocked(key)
+		c.stats.evictions.Add(1)
+	}
+}
+
+// sweep 定期删除过期的key，直到stop关闭
+func (c *cache) sweep(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		c.Lock()
+		for key, it := range c.items {
+			if it.expired(now) {
+				c.removeLocked(key)
+				c.stats.expirations.Add(1)
+			}
+		}
+		c.Unlock()
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+)
+
+// evictionPolicy 记录key的访问情况，选出容量不足时要淘汰的key，由cache加锁后调用
+type evictionPolicy interface {
+	name() string
+	add(key string)
+	touch(key string)
+	remove(key string)
+	// victim 返回应淘汰的key，跳过skip（刚写入的key）
+	victim(skip string) (string, bool)
+}
+
+func newEvictionPolicy(name string) (evictionPolicy, error) {
+	switch name {
+	case "lru":
+		return newLRU(), nil
+	case "lfu":
+		return newLFU(), nil
+	}
+	return nil, fmt.Errorf("unknown cache policy %q", name)
+}
+
+// lru 淘汰最久未访问的key
+type lru struct {
+	order *list.List
+	elems map[string]*list.Element
+}
+
+func newLRU() *lru {
+	return &lru{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (p *lru) name() string { return "lru" }
+
+func (p *lru) add(key string) {
+	p.elems[key] = p.order.PushFront(key)
+}
+
+func (p *lru) touch(key string) {
+	if e, ok := p.elems[key]; ok {
+		p.order.MoveToFront(e)
+	}
+}
+
+func (p *lru) remove(key string) {
+	if e, ok := p.elems[key]; ok {
+		p.order.Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+func (p *lru) victim(skip string) (string, bool) {
+	for e := p.order.Back(); e != nil; e = e.Prev() {
+		if key := e.Value.(string); key != skip {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// lfu 淘汰访问次数最少的key，次数相同时淘汰其中最久未访问的
+// 按访问次数分桶，各操作都是O(1)
+type lfu struct {
+	buckets map[int]*list.List
+	entries map[string]*lfuEntry
+	minFreq int
+}
+
+type lfuEntry struct {
+	freq int
+	elem *list.Element
+}
+
+func newLFU() *lfu {
+	return &lfu{buckets: make(map[int]*list.List), entries: make(map[string]*lfuEntry)}
+}
+
+func (p *lfu) name() string { return "lfu" }
+
+func (p *lfu) add(key string) {
+	p.entries[key] = &lfuEntry{freq: 1, elem: p.bucket(1).PushFront(key)}
+	p.minFreq = 1
+}
+
+func (p *lfu) touch(key string) {
+	e, ok := p.entries[key]
+	if !ok {
+		return
+	}
+
+	p.unlink(e)
+	if e.freq == p.minFreq && p.buckets[e.freq] == nil {
+		p.minFreq++
+	}
+	e.freq++
+	e.elem = p.bucket(e.freq).PushFront(key)
+}
+
+func (p *lfu) remove(key string) {
+	if e, ok := p.entries[key]; ok {
+		p.unlink(e)
+		delete(p.entries, key)
+	}
+}
+
+func (p *lfu) victim(skip string) (string, bool) {
+	if len(p.entries) == 0 {
+		return "", false
+	}
+	// remove之后minFreq可能指向已经清空的桶
+	for p.buckets[p.minFreq] == nil {
+		p.minFreq++
+	}
+
+	// 访问次数最少的桶里只有skip时，到次数更多的桶中找
+	for freq, seen := p.minFreq, 0; seen < len(p.entries); freq++ {
+		b, ok := p.buckets[freq]
+		if !ok {
+			continue
+		}
+		for e := b.Back(); e != nil; e = e.Prev() {
+			if key := e.Value.(string); key != skip {
+				return key, true
+			}
+			seen++
+		}
+	}
+	return "", false
+}
+
+func (p *lfu) bucket(freq int) *list.List {
+	b, ok := p.buckets[freq]
+	if !ok {
+		b = list.New()
+		p.buckets[freq] = b
+	}
+	return b
+}
+
+// 从所在的桶中移除，桶空时删除
+func (p *lfu) unlink(e *lfuEntry) {
+	b := p.buckets[e.freq]
+	b.Remove(e.elem)
+	if b.Len() == 0 {
+		delete(p.buckets, e.freq)
+	}
+}
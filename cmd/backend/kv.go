@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// kv接口，key从查询参数中读取，路径不限，经代理转发的请求（如 /host?key=）同样适用：
+//
+//	GET    /?key=       读取
+//	PUT    /?key=       写入，请求体为 {"value": "...", "ttl_seconds": 60}
+//	DELETE /?key=       删除
+//	POST   /kv/bulk     批量操作，只处理本服务器上的key
+//	GET    /kv/stats    缓存的命中、未命中、淘汰、过期次数及容量
+func newKVHandler(c *cache) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/kv/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.snapshotStats())
+	})
+	mux.HandleFunc("/kv/bulk", func(w http.ResponseWriter, r *http.Request) {
+		handleBulk(c, w, r)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		handleKey(c, w, r)
+	})
+	return mux
+}
+
+type putRequest struct {
+	Value string `json:"value"`
+	// 有效期（秒），0表示永不过期
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+}
+
+type valueResponse struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// 剩余有效期（秒），永不过期时省略
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+}
+
+type bulkOp struct {
+	Op         string `json:"op"`
+	Key        string `json:"key"`
+	Value      string `json:"value,omitempty"`
+	TTLSeconds int64  `json:"ttl_seconds,omitempty"`
+}
+
+type bulkRequest struct {
+	Ops []bulkOp `json:"ops"`
+}
+
+// bulkResult 每个操作的结果，顺序与请求相同
+type bulkResult struct {
+	Key   string    `json:"key"`
+	Found bool      `json:"found"`
+	Value string    `json:"value,omitempty"`
+	Error *apiError `json:"error,omitempty"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func handleKey(c *cache, w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "missing_param", "missing key")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		it, ok := c.get(key)
+		if !ok {
+			writeError(w, http.StatusNotFound, "key_not_found", "key not found")
+			return
+		}
+		res := newValueResponse(key, it)
+		// 代理等缓存不应在key过期之后继续返回它
+		if res.TTLSeconds > 0 {
+			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", res.TTLSeconds))
+		}
+		writeJSON(w, http.StatusOK, res)
+
+	case http.MethodPut:
+		var req putRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		if req.TTLSeconds < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_param", "ttl_seconds must not be negative")
+			return
+		}
+
+		it, existed, err := c.set(key, req.Value, time.Duration(req.TTLSeconds)*time.Second)
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_large", err.Error())
+			return
+		}
+		slog.Debug("set key", "key", key, "ttl_seconds", req.TTLSeconds, "request_id", r.Header.Get("X-Request-ID"))
+
+		status := http.StatusCreated
+		if existed {
+			status = http.StatusOK
+		}
+		writeJSON(w, status, newValueResponse(key, it))
+
+	case http.MethodDelete:
+		if !c.delete(key) {
+			writeError(w, http.StatusNotFound, "key_not_found", "key not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodPut, http.MethodDelete}, ", "))
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+	}
+}
+
+func handleBulk(c *cache, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+
+	var req bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	results := make([]bulkResult, 0, len(req.Ops))
+	for _, op := range req.Ops {
+		res := bulkResult{Key: op.Key}
+		if err := applyOp(c, op, &res); err != nil {
+			code := "invalid_param"
+			if errors.Is(err, errTooLarge) {
+				code = "too_large"
+			}
+			res.Error = &apiError{Code: code, Message: err.Error()}
+		}
+		results = append(results, res)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func applyOp(c *cache, op bulkOp, res *bulkResult) error {
+	if op.Key == "" {
+		return errors.New("missing key")
+	}
+
+	switch op.Op {
+	case "get":
+		it, ok := c.get(op.Key)
+		res.Found, res.Value = ok, it.value
+	case "put":
+		if op.TTLSeconds < 0 {
+			return errors.New("ttl_seconds must not be negative")
+		}
+		var err error
+		_, res.Found, err = c.set(op.Key, op.Value, time.Duration(op.TTLSeconds)*time.Second)
+		return err
+	case "delete":
+		res.Found = c.delete(op.Key)
+	default:
+		return errors.New("op must be get, put or delete")
+	}
+	return nil
+}
+
+func newValueResponse(key string, it item) valueResponse {
+	res := valueResponse{Key: key, Value: it.value}
+	if !it.expireAt.IsZero() {
+		// 向上取整，避免还没过期时显示为0
+		res.TTLSeconds = int64((time.Until(it.expireAt) + time.Second - 1) / time.Second)
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Error: apiError{Code: code, Message: message}})
+}
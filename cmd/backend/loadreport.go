@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// inflight 正在处理的请求数，作为上报给代理的负载
+var inflight atomic.Int64
+
+func countInflight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inflight.Add(1)
+		defer inflight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reportLoads 每隔interval向代理上报负载，直到ctx取消；上报失败只记录日志，代理会忽略过期的上报
+func reportLoads(ctx context.Context, host string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := reportLoad(host, inflight.Load()); err != nil {
+			slog.Debug("report load failed", "host", host, "error", err)
+		}
+	}
+}
+
+func reportLoad(host string, load int64) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"load":        load,
+		"reported_at": time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v1/hosts/%s/load", cfg.RegistryURL, host), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := adminDo(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("report load of %s: %s", host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dingqing/consistent-hash/config"
+)
+
+var (
+	cfg *config.Server
+
+	kv *cache
+	// 关闭后停止清理过期的key
+	stopSweep = make(chan struct{})
+)
+
+const sweepInterval = time.Minute
+
+func main() {
+	var err error
+	cfg, err = config.LoadServer(os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	logger, err := cfg.Log.NewLogger()
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+
+	policy, err := newEvictionPolicy(cfg.Cache.Policy)
+	if err != nil {
+		panic(err)
+	}
+	kv = newCache(policy, cfg.Cache.MaxEntries, cfg.Cache.MaxBytes)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hostName := cfg.Advertise
+	if hostName == "" {
+		hostName = fmt.Sprintf("localhost:%s", cfg.Port)
+	}
+	httpServer := start(cfg.Port)
+
+	// 注册和心跳在后台进行，代理暂时不可用时不影响启动
+	reg := &registration{host: hostName, ttl: cfg.HostTTL, heartbeat: cfg.Heartbeat}
+	regCtx, stopHeartbeat := context.WithCancel(ctx)
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		reg.run(regCtx)
+	}()
+	reportDone := make(chan struct{})
+	go func() {
+		defer close(reportDone)
+		if cfg.LoadReportInterval > 0 {
+			reportLoads(regCtx, hostName, cfg.LoadReportInterval)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("shutting down server")
+	stopHeartbeat()
+	<-heartbeatDone
+	<-reportDone
+	shutdown(httpServer, reg)
+}
+
+func start(port string) *http.Server {
+	slog.Info("start server", "port", port)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", countInflight(newKVHandler(kv)))
+	mux.HandleFunc("/healthz", healthHandle)
+	go kv.sweep(sweepInterval, stopSweep)
+	httpServer := &http.Server{Addr: ":" + port, Handler: mux}
+
+	// 先监听再注册，避免代理在端口就绪前转发请求过来
+	lis, err := net.Listen("tcp", httpServer.Addr)
+	if err != nil {
+		panic(err)
+	}
+
+	go func() {
+		if err := httpServer.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic(err)
+		}
+	}()
+	return httpServer
+}
+
+// shutdown 先从代理注销，不再接收新的请求，再等待正在处理的请求完成
+func shutdown(httpServer *http.Server, reg *registration) {
+	if err := reg.unregister(); err != nil {
+		slog.Error("unregister host failed", "host", reg.host, "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Error("shutdown server failed", "error", err)
+	}
+	close(stopSweep)
+}
+
+func healthHandle(w http.ResponseWriter, r *http.Request) {
+	_, _ = fmt.Fprintf(w, "ok")
+}
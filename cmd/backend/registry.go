@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	minRegisterDelay = time.Second
+	maxRegisterDelay = 30 * time.Second
+)
+
+var (
+	errNotRegistered = errors.New("host is not registered")
+
+	// 代理无响应时不至于卡住心跳和退出
+	adminClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+// registration 向代理注册本服务器：ttl大于0时带有效期注册，并每隔heartbeat续期
+// 续期时发现代理上已没有本服务器（过期、代理重启等）会重新注册
+type registration struct {
+	host      string
+	ttl       time.Duration
+	heartbeat time.Duration
+}
+
+// run 注册失败时退避重试，注册成功后发送心跳，直到ctx取消
+func (reg *registration) run(ctx context.Context) {
+	for {
+		reg.registerWithRetry(ctx)
+		if reg.ttl <= 0 || reg.heartbeat <= 0 || ctx.Err() != nil {
+			return
+		}
+
+		err := reg.heartbeats(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("host registration lost, registering again", "host", reg.host, "error", err)
+	}
+}
+
+func (reg *registration) registerWithRetry(ctx context.Context) {
+	delay := minRegisterDelay
+	for {
+		err := reg.register()
+		if err == nil {
+			slog.Info("registered host", "host", reg.host, "registry", cfg.RegistryURL, "ttl", reg.ttl)
+			return
+		}
+		slog.Warn("register host failed", "host", reg.host, "error", err, "retry_in", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(delay*2, maxRegisterDelay)
+	}
+}
+
+// heartbeats 定期续期，续期失败只记录日志，代理上没有本服务器时返回
+func (reg *registration) heartbeats(ctx context.Context) error {
+	ticker := time.NewTicker(reg.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		err := reg.renew()
+		if errors.Is(err, errNotRegistered) {
+			return err
+		}
+		if err != nil {
+			slog.Warn("renew host failed", "host", reg.host, "error", err)
+		}
+	}
+}
+
+func (reg *registration) register() error {
+	body, err := json.Marshal(map[string]interface{}{
+		"host":        reg.host,
+		"ttl_seconds": int64(reg.ttl / time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.RegistryURL+"/v1/hosts", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := adminDo(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 代理从快照恢复时可能已经有这台服务器
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("register host %s: %s", reg.host, resp.Status)
+	}
+	return nil
+}
+
+func (reg *registration) renew() error {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v1/hosts/%s/renew", cfg.RegistryURL, reg.host), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := adminDo(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotRegistered
+	// 409表示代理上的注册没有有效期（如从快照恢复），无需续期
+	case resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusConflict:
+		return fmt.Errorf("renew host %s: %s", reg.host, resp.Status)
+	}
+	return nil
+}
+
+func (reg *registration) unregister() error {
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/v1/hosts/%s", cfg.RegistryURL, reg.host), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := adminDo(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+func adminDo(req *http.Request) (*http.Response, error) {
+	if cfg.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.AdminToken)
+	}
+	return adminClient.Do(req)
+}
@@ -0,0 +1,365 @@
+// chbench 压测代理：启动N个假后端并注册到代理，按指定的QPS发送请求，结束后输出延迟分位数、每台后端的请求占比和并发峰值，以及错误率
+//
+//	go run ./cmd/proxy -admin-token secret
+//	go run ./cmd/chbench -backends 5 -mode capacious -qps 2000 -duration 30s -zipf 1.2 -latency 20ms -token secret
+//
+// 用zipf分布制造热点key，对比hash、capacious、least-loaded和two-choices模式下各后端的并发峰值，可以验证有界负载是否起作用
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+type options struct {
+	proxy       string
+	admin       string
+	token       string
+	backends    int
+	advertise   string
+	mode        string
+	qps         int
+	concurrency int
+	duration    time.Duration
+	keys        int
+	zipf        float64
+	latency     time.Duration
+	jitter      time.Duration
+	keep        bool
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.proxy, "proxy", "http://localhost:18888", "proxy address")
+	flag.StringVar(&o.admin, "admin", "http://localhost:18890", "proxy admin api address")
+	flag.StringVar(&o.token, "token", "", "admin token")
+	flag.IntVar(&o.backends, "backends", 5, "number of fake backends")
+	flag.StringVar(&o.advertise, "advertise", "127.0.0.1", "address the proxy uses to reach the fake backends")
+	flag.StringVar(&o.mode, "mode", "hash", "hash, capacious, least-loaded, two-choices or slots")
+	flag.IntVar(&o.qps, "qps", 1000, "requests per second, 0 sends as fast as -concurrency allows")
+	flag.IntVar(&o.concurrency, "concurrency", 100, "max concurrent requests")
+	flag.DurationVar(&o.duration, "duration", 10*time.Second, "test duration")
+	flag.IntVar(&o.keys, "keys", 10000, "number of distinct keys")
+	flag.Float64Var(&o.zipf, "zipf", 0, "zipf exponent (>1) for skewed keys, 0 picks keys uniformly")
+	flag.DurationVar(&o.latency, "latency", 5*time.Millisecond, "backend response time")
+	flag.DurationVar(&o.jitter, "jitter", 0, "random extra backend response time up to this value")
+	flag.BoolVar(&o.keep, "keep", false, "do not unregister the fake backends when done")
+	flag.Parse()
+
+	if err := run(o); err != nil {
+		fmt.Fprintln(os.Stderr, "chbench:", err)
+		os.Exit(1)
+	}
+}
+
+func run(o options) error {
+	path := "/host"
+	switch o.mode {
+	case "hash":
+	case "capacious":
+		path = "/hostCapacious"
+	case "least-loaded":
+		path = "/hostLeastLoaded"
+	case "two-choices":
+		path = "/hostTwoChoices"
+	case "slots":
+		path = "/hostSlots"
+	default:
+		return fmt.Errorf("-mode must be hash, capacious, least-loaded, two-choices or slots")
+	}
+	if o.backends <= 0 || o.concurrency <= 0 || o.keys <= 0 {
+		return fmt.Errorf("-backends, -concurrency and -keys must be positive")
+	}
+	if o.zipf != 0 && o.zipf <= 1 {
+		return fmt.Errorf("-zipf must be greater than 1")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	backends := make([]*backend, 0, o.backends)
+	defer func() {
+		for _, b := range backends {
+			b.close()
+		}
+	}()
+	for i := 0; i < o.backends; i++ {
+		b, err := startBackend(o.advertise, o.latency, o.jitter)
+		if err != nil {
+			return err
+		}
+		backends = append(backends, b)
+		if err = o.adminRequest(http.MethodPost, "/v1/hosts", map[string]string{"host": b.addr}); err != nil {
+			return fmt.Errorf("register %s: %w", b.addr, err)
+		}
+		if !o.keep {
+			defer func(addr string) {
+				if err := o.adminRequest(http.MethodDelete, "/v1/hosts/"+url.PathEscape(addr), nil); err != nil {
+					fmt.Fprintf(os.Stderr, "chbench: unregister %s: %v\n", addr, err)
+				}
+			}(b.addr)
+		}
+	}
+	fmt.Printf("registered %d backends, %s mode, %d qps for %s\n", len(backends), o.mode, o.qps, o.duration)
+
+	res := drive(ctx, o, o.proxy+path)
+	res.report(os.Stdout, backends)
+	return nil
+}
+
+// backend 假后端：等待latency（加上随机的jitter）后返回200，记录并发峰值
+type backend struct {
+	addr     string
+	server   *http.Server
+	inflight atomic.Int64
+	peak     atomic.Int64
+}
+
+func startBackend(advertise string, latency, jitter time.Duration) (*backend, error) {
+	lis, err := net.Listen("tcp", net.JoinHostPort(advertise, "0"))
+	if err != nil {
+		return nil, err
+	}
+	b := &backend{addr: lis.Addr().String()}
+	b.server = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := b.inflight.Add(1)
+		defer b.inflight.Add(-1)
+		for {
+			peak := b.peak.Load()
+			if n <= peak || b.peak.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+
+		d := latency
+		if jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(jitter)))
+		}
+		time.Sleep(d)
+		w.Header().Set("X-Backend", b.addr)
+		_, _ = io.WriteString(w, "ok")
+	})}
+	go func() { _ = b.server.Serve(lis) }()
+	return b, nil
+}
+
+func (b *backend) close() {
+	_ = b.server.Close()
+}
+
+func (o options) adminRequest(method, path string, body interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, o.admin+path, r)
+	if err != nil {
+		return err
+	}
+	if o.token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+type result struct {
+	latencies []time.Duration
+	// 按响应头X-Backend统计
+	perBackend map[string]int
+	// 状态码或传输错误 -> 次数
+	errors  map[string]int
+	total   int
+	dropped int
+	elapsed time.Duration
+	sync.Mutex
+}
+
+func (res *result) record(backend string, latency time.Duration, failure string) {
+	res.Lock()
+	defer res.Unlock()
+	res.total++
+	res.latencies = append(res.latencies, latency)
+	if failure != "" {
+		res.errors[failure]++
+		return
+	}
+	res.perBackend[backend]++
+}
+
+// drive qps大于0时按固定速率发送（开环），并发已满时丢弃该次请求并计数；否则每个worker发完一个再发下一个
+func drive(ctx context.Context, o options, target string) *result {
+	ctx, cancel := context.WithTimeout(ctx, o.duration)
+	defer cancel()
+
+	res := &result{perBackend: make(map[string]int), errors: make(map[string]int)}
+	client := &http.Client{Transport: &http.Transport{
+		MaxIdleConns:        o.concurrency,
+		MaxIdleConnsPerHost: o.concurrency,
+	}}
+	var zipf *rand.Zipf
+	var rngMu sync.Mutex
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if o.zipf > 1 {
+		zipf = rand.NewZipf(rng, o.zipf, 1, uint64(o.keys-1))
+	}
+	nextKey := func() string {
+		rngMu.Lock()
+		defer rngMu.Unlock()
+		if zipf != nil {
+			return strconv.FormatUint(zipf.Uint64(), 10)
+		}
+		return strconv.Itoa(rng.Intn(o.keys))
+	}
+
+	send := func() {
+		start := time.Now()
+		resp, err := client.Get(target + "?key=" + nextKey())
+		if err != nil {
+			res.record("", time.Since(start), "transport error")
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		failure := ""
+		if resp.StatusCode != http.StatusOK {
+			failure = resp.Status
+		}
+		res.record(resp.Header.Get("X-Backend"), time.Since(start), failure)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	if o.qps <= 0 {
+		for i := 0; i < o.concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for ctx.Err() == nil {
+					send()
+				}
+			}()
+		}
+	} else {
+		slots := make(chan struct{}, o.concurrency)
+		ticker := time.NewTicker(time.Second / time.Duration(o.qps))
+		defer ticker.Stop()
+	loop:
+		for {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-ticker.C:
+			}
+			select {
+			case slots <- struct{}{}:
+			default:
+				res.Lock()
+				res.dropped++
+				res.Unlock()
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				send()
+			}()
+		}
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	return res
+}
+
+func (res *result) report(w io.Writer, backends []*backend) {
+	res.Lock()
+	defer res.Unlock()
+
+	failed := 0
+	for _, n := range res.errors {
+		failed += n
+	}
+	fmt.Fprintf(w, "\nrequests %d in %s (%.0f/s), errors %d (%.2f%%)", res.total, res.elapsed.Round(time.Millisecond),
+		float64(res.total)/res.elapsed.Seconds(), failed, percent(failed, res.total))
+	if res.dropped > 0 {
+		fmt.Fprintf(w, ", %d not sent because -concurrency was reached", res.dropped)
+	}
+	fmt.Fprintln(w)
+
+	if len(res.latencies) > 0 {
+		sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+		fmt.Fprintf(w, "latency p50 %s  p90 %s  p99 %s  p99.9 %s  max %s\n",
+			quantile(res.latencies, 0.5), quantile(res.latencies, 0.9), quantile(res.latencies, 0.99),
+			quantile(res.latencies, 0.999), quantile(res.latencies, 1))
+	}
+
+	fmt.Fprintln(w)
+	out := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "backend\trequests\tshare\tpeak concurrency")
+	for _, b := range backends {
+		n := res.perBackend[b.addr]
+		fmt.Fprintf(out, "%s\t%d\t%.2f%%\t%d\n", b.addr, n, percent(n, res.total-failed), b.peak.Load())
+	}
+	// 代理上原有的服务器
+	if n := res.perBackend[""]; n > 0 {
+		fmt.Fprintf(out, "other\t%d\t%.2f%%\t\n", n, percent(n, res.total-failed))
+	}
+	_ = out.Flush()
+
+	if len(res.errors) > 0 {
+		fmt.Fprintln(w)
+		out = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(out, "error\tcount")
+		for msg, n := range res.errors {
+			fmt.Fprintf(out, "%s\t%d\n", msg, n)
+		}
+		_ = out.Flush()
+	}
+}
+
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	idx := int(float64(len(sorted))*q+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx].Round(10 * time.Microsecond)
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
@@ -0,0 +1,454 @@
+// chctl 代理管理接口的命令行客户端：
+//
+//	chctl -admin http://localhost:18890 -token secret hosts
+//	chctl register -weight 2 -ttl 30s 10.0.0.2:8081
+//	chctl unregister 10.0.0.2:8081
+//	chctl -o json loads
+//	chctl -ring cache route -mode capacious user-1
+//	chctl assign-slots 0 999 10.0.0.2:8081
+//
+// 默认以表格输出，-o json原样输出接口返回的JSON；token默认读取环境变量CH_ADMIN_TOKEN
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+const usage = `usage: chctl [flags] command [args]
+
+commands:
+  register [-weight N] [-ttl D] [-datacenter DC] [-zone Z] HOST
+  unregister HOST
+  hosts
+  loads
+  route [-mode hash|capacious|least-loaded] KEY
+  slots [KEY]
+  assign-slots START END HOST
+
+flags:
+`
+
+type client struct {
+	admin  string
+	token  string
+	ring   string
+	output string
+	http   *http.Client
+}
+
+func main() {
+	var c client
+	var timeout time.Duration
+	flag.StringVar(&c.admin, "admin", "http://localhost:18890", "proxy admin api address")
+	flag.StringVar(&c.token, "token", os.Getenv("CH_ADMIN_TOKEN"), "admin token")
+	flag.StringVar(&c.ring, "ring", "", "named ring, empty for the default ring")
+	flag.StringVar(&c.output, "o", "table", "output format, table or json")
+	flag.DurationVar(&timeout, "timeout", 10*time.Second, "request timeout")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if c.output != "table" && c.output != "json" {
+		fatal(errors.New("-o must be table or json"))
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	c.admin = strings.TrimRight(c.admin, "/")
+	c.http = &http.Client{Timeout: timeout}
+
+	var err error
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "register":
+		err = c.register(args)
+	case "unregister":
+		err = c.unregister(args)
+	case "hosts":
+		err = c.hosts(args)
+	case "loads":
+		err = c.loads(args)
+	case "route":
+		err = c.route(args)
+	case "slots":
+		err = c.slots(args)
+	case "assign-slots":
+		err = c.assignSlots(args)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "chctl:", err)
+	os.Exit(1)
+}
+
+// subcommand 解析子命令的参数，要求正好nargs个位置参数
+func subcommand(name string, args []string, nargs int, usage string, bind func(fs *flag.FlagSet)) []string {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: chctl %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	if bind != nil {
+		bind(fs)
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != nargs {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args()
+}
+
+func (c *client) register(args []string) error {
+	var weight int
+	var ttl time.Duration
+	var datacenter, zone string
+	args = subcommand("register", args, 1, "[flags] HOST", func(fs *flag.FlagSet) {
+		fs.IntVar(&weight, "weight", 1, "host weight")
+		fs.DurationVar(&ttl, "ttl", 0, "registration ttl, 0 registers permanently")
+		fs.StringVar(&datacenter, "datacenter", "", "datacenter of the host")
+		fs.StringVar(&zone, "zone", "", "zone of the host")
+	})
+	if weight <= 0 {
+		return errors.New("-weight must be positive")
+	}
+
+	host := args[0]
+	body := map[string]interface{}{"host": host, "datacenter": datacenter, "zone": zone}
+	if ttl > 0 {
+		body["ttl_seconds"] = int64(ttl.Round(time.Second) / time.Second)
+	}
+	data, err := c.do(http.MethodPost, "hosts", nil, body)
+	if err != nil {
+		return err
+	}
+	// 注册接口不带权重，注册后再修改
+	if weight != 1 {
+		if data, err = c.do(http.MethodPatch, "hosts/"+url.PathEscape(host), nil, map[string]int{"weight": weight}); err != nil {
+			return fmt.Errorf("registered %s but setting weight failed: %w", host, err)
+		}
+	}
+	if c.output == "json" {
+		return printJSON(data)
+	}
+	fmt.Printf("registered %s\n", host)
+	return nil
+}
+
+func (c *client) unregister(args []string) error {
+	args = subcommand("unregister", args, 1, "HOST", nil)
+	if _, err := c.do(http.MethodDelete, "hosts/"+url.PathEscape(args[0]), nil, nil); err != nil {
+		return err
+	}
+	if c.output == "table" {
+		fmt.Printf("unregistered %s\n", args[0])
+	}
+	return nil
+}
+
+type host struct {
+	Host     string        `json:"host"`
+	Weight   int           `json:"weight"`
+	Load     int64         `json:"load"`
+	Meta     core.Metadata `json:"meta"`
+	Draining bool          `json:"draining"`
+}
+
+func (c *client) hosts(args []string) error {
+	subcommand("hosts", args, 0, "", nil)
+	data, err := c.do(http.MethodGet, "hosts", nil, nil)
+	if err != nil || c.output == "json" {
+		return printOr(data, err)
+	}
+
+	var hosts []host
+	if err = json.Unmarshal(data, &hosts); err != nil {
+		return err
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "HOST\tWEIGHT\tLOAD\tCAPACITY\tDATACENTER\tZONE\tDRAINING")
+	for _, h := range hosts {
+		fmt.Fprintf(out, "%s\t%d\t%d\t%s\t%s\t%s\t%t\n", h.Host, h.Weight, h.Load, capacity(h.Meta.Capacity),
+			dash(h.Meta.Datacenter), dash(h.Meta.Zone), h.Draining)
+	}
+	return out.Flush()
+}
+
+type loads struct {
+	TotalLoad  int64   `json:"total_load"`
+	MaxLoad    int64   `json:"max_load"`
+	LoadFactor float64 `json:"load_factor"`
+	Hosts      []struct {
+		Host     string `json:"host"`
+		Load     int64  `json:"load"`
+		MaxLoad  int64  `json:"max_load"`
+		InFlight int64  `json:"in_flight"`
+		Weight   int    `json:"weight"`
+		Draining bool   `json:"draining"`
+	} `json:"hosts"`
+}
+
+func (c *client) loads(args []string) error {
+	subcommand("loads", args, 0, "", nil)
+	data, err := c.do(http.MethodGet, "loads", nil, nil)
+	if err != nil || c.output == "json" {
+		return printOr(data, err)
+	}
+
+	var l loads
+	if err = json.Unmarshal(data, &l); err != nil {
+		return err
+	}
+	fmt.Printf("total load %d, max load %d, load factor %g\n\n", l.TotalLoad, l.MaxLoad, l.LoadFactor)
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "HOST\tLOAD\tMAX LOAD\tIN FLIGHT\tWEIGHT\tDRAINING")
+	for _, h := range l.Hosts {
+		fmt.Fprintf(out, "%s\t%d\t%d\t%d\t%d\t%t\n", h.Host, h.Load, h.MaxLoad, h.InFlight, h.Weight, h.Draining)
+	}
+	return out.Flush()
+}
+
+type explanation struct {
+	Mode string `json:"mode"`
+	core.Explanation
+	Error string `json:"error"`
+}
+
+// route 通过/v1/route/explain查看key会落在哪台服务器，不转发、不增加负载
+func (c *client) route(args []string) error {
+	var mode string
+	args = subcommand("route", args, 1, "[-mode hash|capacious|least-loaded] KEY", func(fs *flag.FlagSet) {
+		fs.StringVar(&mode, "mode", "hash", "hash, capacious or least-loaded")
+	})
+	data, err := c.do(http.MethodGet, "route/explain", url.Values{"key": {args[0]}, "mode": {mode}}, nil)
+	if err != nil || c.output == "json" {
+		return printOr(data, err)
+	}
+
+	var ex explanation
+	if err = json.Unmarshal(data, &ex); err != nil {
+		return err
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(out, "key\t%s\n", ex.Key)
+	fmt.Fprintf(out, "mode\t%s\n", ex.Mode)
+	fmt.Fprintf(out, "hash\t%d\n", ex.Hash)
+	switch {
+	case ex.Pinned:
+		fmt.Fprintln(out, "pinned\ttrue")
+	case ex.VirtualNode != nil:
+		fmt.Fprintf(out, "virtual node\t%s#%d (%d)\n", ex.VirtualNode.Host, ex.VirtualNode.Index, ex.VirtualNode.Hash)
+	}
+	if ex.Host != "" {
+		fmt.Fprintf(out, "host\t%s\n", ex.Host)
+		fmt.Fprintf(out, "load\t%d/%d\n", ex.Load, ex.MaxLoad)
+	}
+	if ex.Fallback {
+		fmt.Fprintln(out, "fallback\tleast loaded")
+	}
+	for _, s := range ex.Skipped {
+		fmt.Fprintf(out, "skipped\t%s (%s, load %d/%d)\n", s.Host, s.Reason, s.Load, s.MaxLoad)
+	}
+	if ex.Error != "" {
+		fmt.Fprintf(out, "error\t%s\n", ex.Error)
+	}
+	return out.Flush()
+}
+
+type slots struct {
+	SlotCount int              `json:"slot_count"`
+	Ranges    []core.SlotRange `json:"ranges"`
+	Hosts     map[string]int   `json:"hosts"`
+	Moved     *int             `json:"moved"`
+}
+
+// slots 查看槽位的分配，给出KEY时查看它所在的槽位和服务器
+func (c *client) slots(args []string) error {
+	if len(args) > 0 {
+		args = subcommand("slots", args, 1, "[KEY]", nil)
+		data, err := c.do(http.MethodGet, "slots", url.Values{"key": {args[0]}}, nil)
+		if err != nil || c.output == "json" {
+			return printOr(data, err)
+		}
+		var res struct {
+			Key  string `json:"key"`
+			Slot int    `json:"slot"`
+			Host string `json:"host"`
+		}
+		if err = json.Unmarshal(data, &res); err != nil {
+			return err
+		}
+		out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(out, "key\t%s\nslot\t%d\nhost\t%s\n", res.Key, res.Slot, res.Host)
+		return out.Flush()
+	}
+
+	data, err := c.do(http.MethodGet, "slots", nil, nil)
+	if err != nil || c.output == "json" {
+		return printOr(data, err)
+	}
+	return printSlots(data)
+}
+
+// assignSlots 把[START, END]内的槽位迁移到HOST，只作用于收到请求的代理实例
+func (c *client) assignSlots(args []string) error {
+	args = subcommand("assign-slots", args, 3, "START END HOST", nil)
+	start, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid START: %w", err)
+	}
+	end, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid END: %w", err)
+	}
+	data, err := c.do(http.MethodPost, "slots", nil, map[string]interface{}{"start": start, "end": end, "host": args[2]})
+	if err != nil || c.output == "json" {
+		return printOr(data, err)
+	}
+	return printSlots(data)
+}
+
+func printSlots(data []byte) error {
+	var s slots
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s.Moved != nil {
+		fmt.Printf("moved %d slots\n\n", *s.Moved)
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "START\tEND\tHOST")
+	for _, r := range s.Ranges {
+		fmt.Fprintf(out, "%d\t%d\t%s\n", r.Start, r.End, r.Host)
+	}
+	fmt.Fprintln(out, "\nHOST\tSLOTS\tSHARE")
+	hosts := make([]string, 0, len(s.Hosts))
+	for host := range s.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		fmt.Fprintf(out, "%s\t%d\t%.1f%%\n", host, s.Hosts[host], float64(s.Hosts[host])*100/float64(s.SlotCount))
+	}
+	return out.Flush()
+}
+
+// do 请求管理接口，path相对于/v1（指定-ring时相对于/v1/rings/{ring}），返回响应体
+func (c *client) do(method, path string, query url.Values, body interface{}) ([]byte, error) {
+	prefix := "/v1/"
+	if c.ring != "" {
+		prefix = "/v1/rings/" + url.PathEscape(c.ring) + "/"
+	}
+	target := c.admin + prefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, target, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, apiError(resp.Status, data)
+	}
+	return data, nil
+}
+
+// apiError 优先使用管理接口的错误格式 {"error": {"code", "message"}}
+func apiError(status string, data []byte) error {
+	var res struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &res) == nil && res.Error.Message != "" {
+		return fmt.Errorf("%s: %s", status, res.Error.Message)
+	}
+	if msg := bytes.TrimSpace(data); len(msg) > 0 {
+		return fmt.Errorf("%s: %s", status, msg)
+	}
+	return errors.New(status)
+}
+
+func printOr(data []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	return printJSON(data)
+}
+
+func printJSON(data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := buf.WriteTo(os.Stdout)
+	return err
+}
+
+func capacity(c int64) string {
+	if c == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(c, 10)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
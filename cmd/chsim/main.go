@@ -0,0 +1,279 @@
+// chsim 模拟key在环上的分布，用于选择合适的虚拟节点数量：
+//
+//	go run ./cmd/chsim -hosts a:1,b:1,c:1 -replicas 10,50,100,200 -random 1000000
+//	go run ./cmd/chsim -hosts a:1,b:1=2 -keys keys.txt -add d:1 -remove a:1 -hist
+//
+// 对每个副本数输出每台服务器分到的key数量和占比、负载与平均值之比的分位数，以及增加、移除一台服务器时移动的key数量
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+type host struct {
+	name   string
+	weight int
+}
+
+func main() {
+	var (
+		hostsFlag    = flag.String("hosts", "", "comma separated hosts, name=weight sets a weight (default host-0..host-{n-1})")
+		n            = flag.Int("n", 10, "number of generated hosts when -hosts is empty")
+		replicasFlag = flag.String("replicas", "10,50,100,200", "comma separated replica counts to compare")
+		keysFile     = flag.String("keys", "", "file with one key per line, - for stdin (default random keys)")
+		random       = flag.Int("random", 100000, "number of random keys when -keys is empty")
+		seed         = flag.Int64("seed", 1, "seed for random keys")
+		hasherName   = flag.String("hasher", "sha512", "sha512, xxhash, murmur3 or fnv1a")
+		add          = flag.String("add", "sim-new", "host to add when counting moved keys, empty to skip")
+		remove       = flag.String("remove", "", "host to remove when counting moved keys (default the first host)")
+		hist         = flag.Bool("hist", false, "print a histogram of keys per host")
+	)
+	flag.Parse()
+
+	hosts, err := parseHosts(*hostsFlag, *n)
+	if err != nil {
+		fatal(err)
+	}
+	replicas, err := parseInts(*replicasFlag)
+	if err != nil {
+		fatal(fmt.Errorf("-replicas: %w", err))
+	}
+	hasher, ok := core.HasherByName(*hasherName)
+	if !ok {
+		fatal(fmt.Errorf("unknown hasher %q", *hasherName))
+	}
+	keys, err := loadKeys(*keysFile, *random, *seed)
+	if err != nil {
+		fatal(err)
+	}
+	if *remove == "" {
+		*remove = hosts[0].name
+	}
+
+	for i, r := range replicas {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err = simulate(os.Stdout, hosts, r, hasher, keys, *add, *remove, *hist); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+func simulate(w io.Writer, hosts []host, replicas int, hasher core.Hasher, keys []string, add, remove string, hist bool) error {
+	c, err := newRing(hosts, replicas, hasher)
+	if err != nil {
+		return err
+	}
+	owners := c.GetHostsBatch(keys)
+	counts := make(map[string]int, len(hosts))
+	for _, owner := range owners {
+		counts[owner]++
+	}
+
+	totalWeight := 0
+	for _, h := range hosts {
+		totalWeight += h.weight
+	}
+	fmt.Fprintf(w, "replicas=%d hosts=%d keys=%d\n", replicas, len(hosts), len(keys))
+	out := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "host\tweight\tkeys\tshare\texpected")
+	// 按权重归一化后与平均值之比，1表示完全均衡
+	ratios := make([]float64, 0, len(hosts))
+	maxCount := 0
+	for _, h := range hosts {
+		share := float64(counts[h.name]) / float64(len(keys))
+		expected := float64(h.weight) / float64(totalWeight)
+		ratios = append(ratios, share/expected)
+		if counts[h.name] > maxCount {
+			maxCount = counts[h.name]
+		}
+		fmt.Fprintf(out, "%s\t%d\t%d\t%.2f%%\t%.2f%%\n", h.name, h.weight, counts[h.name], share*100, expected*100)
+	}
+	if err = out.Flush(); err != nil {
+		return err
+	}
+	sort.Float64s(ratios)
+	var sqSum float64
+	for _, r := range ratios {
+		sqSum += (r - 1) * (r - 1)
+	}
+	fmt.Fprintf(w, "load/mean: min=%.3f p50=%.3f p90=%.3f p99=%.3f max=%.3f stddev=%.3f\n",
+		ratios[0], percentile(ratios, 0.5), percentile(ratios, 0.9), percentile(ratios, 0.99), ratios[len(ratios)-1],
+		math.Sqrt(sqSum/float64(len(ratios))))
+
+	if hist {
+		width := 0
+		for _, h := range hosts {
+			width = max(width, len(h.name))
+		}
+		for _, h := range hosts {
+			bar := 0
+			if maxCount > 0 {
+				bar = counts[h.name] * 50 / maxCount
+			}
+			fmt.Fprintf(w, "%-*s |%s\n", width, h.name, strings.Repeat("#", bar))
+		}
+	}
+
+	if add != "" {
+		next, err := newRing(append(append([]host(nil), hosts...), host{name: add, weight: 1}), replicas, hasher)
+		if err != nil {
+			return err
+		}
+		reportMoved(w, "add "+add, c, next, owners, keys, 1/float64(totalWeight+1))
+	}
+	if remove != "" {
+		rest := make([]host, 0, len(hosts))
+		removedWeight := 0
+		for _, h := range hosts {
+			if h.name == remove {
+				removedWeight = h.weight
+				continue
+			}
+			rest = append(rest, h)
+		}
+		if removedWeight == 0 {
+			return fmt.Errorf("-remove: host %s not found", remove)
+		}
+		if len(rest) > 0 {
+			next, err := newRing(rest, replicas, hasher)
+			if err != nil {
+				return err
+			}
+			reportMoved(w, "remove "+remove, c, next, owners, keys, float64(removedWeight)/float64(totalWeight))
+		}
+	}
+	return nil
+}
+
+// reportMoved 统计拓扑变化后归属改变的key，ideal为按权重最少需要移动的比例
+func reportMoved(out io.Writer, change string, before, after *core.Consistent, owners map[string]string, keys []string, ideal float64) {
+	moved := 0
+	for key, owner := range after.GetHostsBatch(keys) {
+		if owners[key] != owner {
+			moved++
+		}
+	}
+	fmt.Fprintf(out, "%s: moved %d keys (%.2f%%), keyspace %.2f%%, ideal %.2f%%\n", change, moved,
+		float64(moved)/float64(len(keys))*100, core.Diff(before, after).Fraction*100, ideal*100)
+}
+
+func newRing(hosts []host, replicas int, hasher core.Hasher) (*core.Consistent, error) {
+	c := core.New(replicas, hasher, core.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	for _, h := range hosts {
+		if err := c.RegisterHostWithWeight(h.name, h.weight); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+func parseHosts(s string, n int) ([]host, error) {
+	if s == "" {
+		if n <= 0 {
+			return nil, fmt.Errorf("-n must be positive")
+		}
+		hosts := make([]host, n)
+		for i := range hosts {
+			hosts[i] = host{name: fmt.Sprintf("host-%d", i), weight: 1}
+		}
+		return hosts, nil
+	}
+
+	var hosts []host
+	for _, part := range strings.Split(s, ",") {
+		name, weight, found := strings.Cut(strings.TrimSpace(part), "=")
+		h := host{name: name, weight: 1}
+		if found {
+			w, err := strconv.Atoi(weight)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight for host %s: %q", name, weight)
+			}
+			h.weight = w
+		}
+		if h.name != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("-hosts is empty")
+	}
+	return hosts, nil
+}
+
+func parseInts(s string) ([]int, error) {
+	var ints []int
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid value %q", part)
+		}
+		ints = append(ints, v)
+	}
+	return ints, nil
+}
+
+func loadKeys(file string, random int, seed int64) ([]string, error) {
+	if file == "" {
+		if random <= 0 {
+			return nil, fmt.Errorf("-random must be positive")
+		}
+		rng := rand.New(rand.NewSource(seed))
+		keys := make([]string, random)
+		for i := range keys {
+			keys[i] = strconv.FormatUint(rng.Uint64(), 36)
+		}
+		return keys, nil
+	}
+
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var keys []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in %s", file)
+	}
+	return keys, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "chsim:", err)
+	os.Exit(1)
+}
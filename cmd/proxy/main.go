@@ -0,0 +1,805 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
+
+	"github.com/dingqing/consistent-hash/api"
+	"github.com/dingqing/consistent-hash/cluster"
+	"github.com/dingqing/consistent-hash/config"
+	"github.com/dingqing/consistent-hash/core"
+	"github.com/dingqing/consistent-hash/discovery"
+	"github.com/dingqing/consistent-hash/l4"
+	"github.com/dingqing/consistent-hash/memcache"
+	"github.com/dingqing/consistent-hash/metrics"
+	"github.com/dingqing/consistent-hash/proxy"
+	"github.com/dingqing/consistent-hash/resp"
+)
+
+var (
+	cfg *config.Proxy
+
+	ring     *core.Consistent
+	p        *proxy.Proxy
+	rings    *proxy.Rings
+	routes   *proxy.Routes
+	m        *metrics.Prometheus
+	raftNode *cluster.Raft
+	// 为nil时不记录访问日志，所有环共用
+	accessLog io.Writer
+)
+
+func main() {
+	var err error
+	cfg, err = config.LoadProxy(os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	logger, err := cfg.Log.NewLogger()
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+	if accessLog, err = openAccessLog(); err != nil {
+		panic(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		panic(err)
+	}
+	restoreRing()
+	startRings()
+	startRoutes()
+	if err := enableMirror(cfg.Mirror); err != nil {
+		panic(err)
+	}
+	startRaft(ctx)
+	go reloadOnHUP(ctx)
+	startDiscovery(ctx)
+	startL4(ctx)
+	startRedis(ctx)
+	startMemcache(ctx)
+
+	tlsConfig := listenerTLS()
+	grpcServer := startGRPC(cfg.GRPCPort, tlsConfig)
+	admin := startAdmin(cfg.AdminPort, tlsConfig)
+	server := start(cfg.Port, tlsConfig)
+
+	<-ctx.Done()
+	slog.Info("shutting down proxy server")
+	shutdown(grpcServer, server, admin)
+	if err = shutdownTracing(context.Background()); err != nil {
+		slog.Error("flush traces failed", "error", err)
+	}
+	if f, ok := accessLog.(*proxy.RotatingFile); ok {
+		_ = f.Close()
+	}
+}
+
+// openAccessLog 按配置打开访问日志，-写到标准输出
+func openAccessLog() (io.Writer, error) {
+	switch cfg.AccessLog.Path {
+	case "":
+		return nil, nil
+	case "-":
+		return os.Stdout, nil
+	}
+	return proxy.OpenRotatingFile(cfg.AccessLog.Path, cfg.AccessLog.MaxBytes, cfg.AccessLog.MaxBackups)
+}
+
+// setupTracing 设置全局的trace上下文传播方式，配置了OTLP地址时导出span
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	if cfg.Tracing.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Tracing.Endpoint))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "consistent-hash-proxy"))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+func start(port string, tlsConfig *tls.Config) *http.Server {
+	slog.Info("start proxy server", "port", port)
+
+	// 探针不经过路由表和命名的环，按默认环（服务发现同步的环）判断是否就绪；
+	// 不用ServeMux包装，以免它清理转发请求的路径
+	probes, data := p.Probes(), routes.Handler(rings.Handler(dataHandler))
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			probes.ServeHTTP(w, r)
+			return
+		}
+		data.ServeHTTP(w, r)
+	})
+	// 明文监听时支持h2c，TLS监听时通过ALPN协商HTTP/2
+	if tlsConfig == nil {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	server := &http.Server{Addr: ":" + port, Handler: handler, TLSConfig: tlsConfig}
+	go serve(server)
+	return server
+}
+
+// dataHandler 一个环对外的接口，每个命名的环各有一份
+func dataHandler(p *proxy.Proxy) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", p.API())
+	mux.Handle("/host", p.Handler(proxy.ModeHash))
+	mux.Handle("/hostCapacious", p.Handler(proxy.ModeCapacious))
+	mux.Handle("/hostLeastLoaded", p.Handler(proxy.ModeLeastLoaded))
+	mux.Handle("/hostTwoChoices", p.Handler(proxy.ModeTwoChoices))
+	mux.Handle("/hostSlots", p.Handler(proxy.ModeSlots))
+
+	// gRPC调用的路径是服务的方法名，不经过mux，直接按路由key转发
+	grpcHandler := p.Handler(proxy.ModeHash)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if proxy.IsGRPC(r) {
+			grpcHandler.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func serve(server *http.Server) {
+	var err error
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		panic(err)
+	}
+}
+
+// startDiscovery 配置了Consul服务或DNS名称时，按其结果自动注册和注销节点
+func startDiscovery(ctx context.Context) {
+	if c := cfg.Discovery.Consul; c.Service != "" {
+		consul := &discovery.Consul{
+			Address:      c.Address,
+			Service:      c.Service,
+			Tag:          c.Tag,
+			Datacenter:   c.Datacenter,
+			Token:        c.Token,
+			MaxStaleness: cfg.Discovery.MaxStaleness,
+			Logger:       slog.Default(),
+		}
+		p.AddSyncSource("consul", consul)
+		go runDiscovery(ctx, "consul", consul.Run)
+		slog.Info("syncing ring from consul", "address", c.Address, "service", c.Service)
+	}
+
+	if c := cfg.Discovery.DNS; c.Name != "" {
+		dns := &discovery.DNS{
+			Name:         c.Name,
+			Port:         c.Port,
+			Interval:     c.Interval,
+			Jitter:       c.Jitter,
+			MaxStaleness: cfg.Discovery.MaxStaleness,
+			Logger:       slog.Default(),
+		}
+		p.AddSyncSource("dns", dns)
+		go runDiscovery(ctx, "dns", dns.Run)
+		slog.Info("syncing ring from dns", "name", c.Name)
+	}
+}
+
+// startL4 转发TCP/UDP，与HTTP代理共用同一个环
+func startL4(ctx context.Context) {
+	if cfg.L4.TCP == "" && cfg.L4.UDP == "" {
+		return
+	}
+	key, err := l4.ParseKeySource(cfg.L4.Key)
+	if err != nil {
+		panic(err)
+	}
+
+	if cfg.L4.TCP != "" {
+		tcp := &l4.TCP{Addr: cfg.L4.TCP, Ring: ring, Key: key, Capacious: cfg.L4.Capacious, Logger: slog.Default()}
+		go runL4(ctx, "tcp", cfg.L4.TCP, tcp.Run)
+	}
+	if cfg.L4.UDP != "" {
+		udp := &l4.UDP{Addr: cfg.L4.UDP, Ring: ring, Key: key, IdleTimeout: cfg.L4.IdleTimeout, Logger: slog.Default()}
+		go runL4(ctx, "udp", cfg.L4.UDP, udp.Run)
+	}
+}
+
+// startRedis Redis协议的分片代理，与HTTP代理共用同一个环
+func startRedis(ctx context.Context) {
+	if cfg.Redis.Addr == "" {
+		return
+	}
+	server := &resp.Server{Addr: cfg.Redis.Addr, Ring: ring, Password: cfg.Redis.Password, Logger: slog.Default()}
+	go runL4(ctx, "redis", cfg.Redis.Addr, server.Run)
+}
+
+// startMemcache memcached协议的分片代理，与HTTP代理共用同一个环
+func startMemcache(ctx context.Context) {
+	if cfg.Memcache == "" {
+		return
+	}
+	server := &memcache.Server{Addr: cfg.Memcache, Ring: ring, Logger: slog.Default()}
+	go runL4(ctx, "memcache", cfg.Memcache, server.Run)
+}
+
+func runL4(ctx context.Context, network, addr string, run func(context.Context) error) {
+	slog.Info("start l4 proxy", "network", network, "addr", addr)
+	if err := run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		panic(err)
+	}
+}
+
+func runDiscovery(ctx context.Context, name string, run func(context.Context, discovery.Registry) error) {
+	if err := run(ctx, p); err != nil && !errors.Is(err, context.Canceled) {
+		slog.Error("discovery stopped", "discovery", name, "error", err)
+	}
+}
+
+// reloadOnHUP 收到SIGHUP时重新加载配置，应用其中可以热更新的参数
+func reloadOnHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+
+		next, err := cfg.Reload()
+		if err != nil {
+			slog.Error("reload config failed", "error", err)
+			continue
+		}
+		if err = ring.SetLoadFactor(next.LoadFactor); err != nil {
+			slog.Error("reload load factor failed", "error", err)
+		}
+		if next.ReplicaNum != ring.ReplicaCount() {
+			if err = ring.SetReplicaCount(next.ReplicaNum); err != nil {
+				slog.Error("reload replica count failed", "error", err)
+			}
+		}
+		if err = ring.SetSlowStart(next.SlowStart); err != nil {
+			slog.Error("reload slow start failed", "error", err)
+		}
+		// 路由表整体替换，通过管理接口做的修改会被覆盖
+		if err = routes.Set(routeTable(next.Routes)); err != nil {
+			slog.Error("reload routes failed", "error", err)
+		}
+		if err = enableMirror(next.Mirror); err != nil {
+			slog.Error("reload mirror failed", "error", err)
+		}
+		slog.Info("reloaded config", "load_factor", ring.LoadFactor(), "replicas", ring.ReplicaCount(), "slow_start", ring.SlowStart())
+	}
+}
+
+// shutdown 停止接收新连接，等待正在转发的请求完成，超过shutdownTimeout后强制关闭
+func shutdown(grpcServer *grpc.Server, servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Error("shutdown server failed", "addr", server.Addr, "error", err)
+				_ = server.Close()
+			}
+		}(server)
+	}
+
+	// Watch流不会自己结束，超时后强制断开
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		grpcServer.Stop()
+	}
+
+	wg.Wait()
+	rings.Close()
+	p.Close()
+	if raftNode != nil {
+		if err := raftNode.Shutdown(); err != nil {
+			slog.Error("shutdown raft failed", "error", err)
+		}
+	}
+}
+
+// startAdmin 管理接口使用单独的端口，公网客户端无法通过代理端口修改拓扑
+func startAdmin(port string, tlsConfig *tls.Config) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", rings.AdminAPI())
+	mux.Handle("/v1/routes", routes.AdminAPI())
+	mux.Handle("/v1/routes/", routes.AdminAPI())
+	if raftNode != nil {
+		mux.Handle("/v1/raft/", raftNode.Handler())
+	}
+	mux.Handle("/metrics", m.Handler())
+	if cfg.Admin.Debug {
+		mux.Handle("/debug/", rings.DebugHandler())
+		// 采样锁和阻塞，供/debug/pprof/mutex和/debug/pprof/block使用
+		runtime.SetMutexProfileFraction(100)
+		runtime.SetBlockProfileRate(int(time.Millisecond))
+	}
+
+	var handler http.Handler = mux
+	if cfg.Admin.Token != "" {
+		handler = proxy.Chain(handler, proxy.BearerAuth(cfg.Admin.Token))
+	}
+
+	addr := ":" + port
+	if cfg.Admin.Token == "" && cfg.Admin.ClientCA == "" {
+		addr = "127.0.0.1:" + port
+		slog.Warn("admin api has no authentication, listening on loopback only", "addr", addr)
+	}
+
+	server := &http.Server{Addr: addr, Handler: handler}
+	if cfg.Admin.ClientCA != "" {
+		server.TLSConfig = adminTLS(tlsConfig)
+	}
+
+	slog.Info("start admin server", "port", port)
+	go serve(server)
+	return server
+}
+
+// 在代理端口的证书基础上要求客户端证书
+func adminTLS(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil {
+		panic("admin mTLS requires -tls-cert and -tls-key")
+	}
+
+	pem, err := os.ReadFile(cfg.Admin.ClientCA)
+	if err != nil {
+		panic(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		panic("no certificates found in " + cfg.Admin.ClientCA)
+	}
+
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig
+}
+
+func tokens() []string {
+	if cfg.Admin.Token == "" {
+		return nil
+	}
+	return []string{cfg.Admin.Token}
+}
+
+// 未配置证书时返回nil，使用明文
+func listenerTLS() *tls.Config {
+	if cfg.TLS.Autocert != "" {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(cfg.TLS.Autocert, ",")...),
+			Cache:      autocert.DirCache(cfg.TLS.AutocertDir),
+		}
+		return m.TLSConfig()
+	}
+	if cfg.TLS.CertFile == "" && cfg.TLS.KeyFile == "" {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		panic(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+}
+
+func startGRPC(port string, tlsConfig *tls.Config) *grpc.Server {
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		panic(err)
+	}
+
+	// 未配置-admin-token时，无法通过gRPC修改拓扑
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(proxy.UnaryAuth(tokens()...))}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	api.RegisterRegistryServer(server, p.GRPCServer())
+	// 便于grpcurl等工具直接调用
+	reflection.Register(server)
+
+	slog.Info("start grpc server", "port", port)
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			panic(err)
+		}
+	}()
+	return server
+}
+
+// 从快照恢复上次退出前的拓扑
+func restoreRing() {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	m = metrics.NewPrometheus(reg)
+
+	opts := []core.Option{core.WithMetrics(m), core.WithLogger(slog.Default()), core.WithSlowStart(cfg.SlowStart)}
+	ring = core.New(cfg.ReplicaNum, nil, append(opts, core.WithLoadFactor(cfg.LoadFactor))...)
+	// 启用Raft时拓扑由Raft日志恢复
+	data, err := os.ReadFile(cfg.SnapshotFile)
+	if err == nil && cfg.Raft.Addr == "" {
+		ring, err = core.Restore(data, opts...)
+		if err != nil {
+			panic(err)
+		}
+		slog.Info("restored hosts", "snapshot", cfg.SnapshotFile, "hosts", ring.Hosts())
+
+		// 配置优先于快照中的参数
+		if err = ring.SetLoadFactor(cfg.LoadFactor); err != nil {
+			panic(err)
+		}
+		if cfg.ReplicaNum != ring.ReplicaCount() {
+			if err = ring.SetReplicaCount(cfg.ReplicaNum); err != nil {
+				panic(err)
+			}
+		}
+	}
+	p = proxy.New(ring, append(proxyOptions(), proxy.WithPeers(peerConfig()))...)
+	enableChaos(p)
+	// 启用Raft时槽位的分配与拓扑一起由Raft的快照和日志恢复
+	if cfg.Raft.Addr != "" {
+		return
+	}
+	persistRing(p, cfg.SnapshotFile, cfg.WALFile)
+	if err := p.SyncFromPeers(); err != nil {
+		slog.Warn("sync hosts from peers failed", "error", err)
+	}
+	// 环恢复完整之后再恢复槽位，否则文件中还没有重放的服务器会被当作已注销，它们的槽位被重新分配
+	enableSlots(p, slotsFile(cfg.SlotsFile, cfg.SnapshotFile))
+}
+
+// persistRing 在恢复的快照上重放预写日志，之后的拓扑变更写入快照或日志
+func persistRing(p *proxy.Proxy, snapshot, wal string) {
+	if wal != "" {
+		n, err := p.ReplayWAL(wal)
+		if err != nil {
+			panic(err)
+		}
+		if n > 0 {
+			slog.Info("replayed wal", "wal", wal, "changes", n)
+		}
+	}
+	p.EnableSnapshot(snapshot)
+	if wal != "" {
+		if err := p.EnableWAL(wal, cfg.SnapshotInterval); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// slotsFile 没有配置slots_file时把槽位的分配写在快照旁边：快照只包含环，预写日志清空后手动迁移只保存在这里
+func slotsFile(configured, snapshot string) string {
+	if configured != "" || snapshot == "" {
+		return configured
+	}
+	return snapshot + ".slots"
+}
+
+// enableSlots 从文件恢复槽位的分配，之后的变化写回文件；path为空时只在内存中维护
+func enableSlots(p *proxy.Proxy, path string) {
+	if path == "" {
+		return
+	}
+	if err := p.EnableSlots(path); err != nil {
+		panic(err)
+	}
+}
+
+// proxyOptions 默认环和命名的环共用的代理配置
+func proxyOptions() []proxy.Option {
+	routingKey, err := proxy.ParseRoutingKey(cfg.RoutingKey)
+	if err != nil {
+		panic(err)
+	}
+	middlewares := []proxy.Middleware{proxy.RequestID()}
+	if accessLog != nil {
+		format, err := proxy.ParseAccessLogFormat(cfg.AccessLog.Format)
+		if err != nil {
+			panic(err)
+		}
+		middlewares = append(middlewares, proxy.AccessLog(accessLog, format))
+	}
+	middlewares = append(middlewares, proxy.Logging(slog.Default()))
+	proxyOpts := []proxy.Option{
+		proxy.WithRoutingKey(routingKey),
+		proxy.WithTransport(transportConfig()),
+		proxy.WithHealthCheck(proxy.DefaultHealthCheckConfig()),
+		proxy.WithRequestTimeout(cfg.RequestTimeout),
+		proxy.WithBodyLimits(proxy.BodyLimits{MaxRequestBytes: cfg.MaxRequestBodyBytes, MaxResponseBytes: cfg.MaxResponseBodyBytes}),
+		proxy.WithChoices(cfg.Choices),
+		proxy.WithRetry(proxy.RetryPolicy{Attempts: 2, PerTryTimeout: 3 * time.Second, IdempotentOnly: true}),
+		proxy.WithCircuitBreaker(proxy.DefaultBreakerConfig()),
+		proxy.WithMiddleware(middlewares...),
+		proxy.WithLogger(slog.Default()),
+		proxy.WithMetrics(m),
+	}
+	if cfg.StickySession.Enabled {
+		session := proxy.DefaultSessionCookieConfig()
+		session.Name = cfg.StickySession.Cookie
+		session.MaxAge = cfg.StickySession.MaxAge
+		session.Secure = cfg.StickySession.Secure
+		proxyOpts = append(proxyOpts, proxy.WithStickySession(session))
+	}
+	if cfg.ResponseCache.Enabled {
+		cache := proxy.DefaultResponseCacheConfig()
+		cache.TTL = cfg.ResponseCache.TTL
+		cache.MaxEntries = cfg.ResponseCache.MaxEntries
+		cache.MaxBytes = cfg.ResponseCache.MaxBytes
+		proxyOpts = append(proxyOpts, proxy.WithResponseCache(cache))
+	}
+	if cfg.RateLimit.ClientRate > 0 || cfg.RateLimit.KeyRate > 0 {
+		limits := proxy.DefaultRateLimitConfig()
+		limits.PerClient = proxy.Limit{Rate: cfg.RateLimit.ClientRate, Burst: cfg.RateLimit.ClientBurst}
+		limits.PerKey = proxy.Limit{Rate: cfg.RateLimit.KeyRate, Burst: cfg.RateLimit.KeyBurst}
+		limits.TrustForwardedFor = cfg.RateLimit.TrustForwardedFor
+		limits.MaxBuckets = cfg.RateLimit.MaxBuckets
+		proxyOpts = append(proxyOpts, proxy.WithRateLimit(limits))
+	}
+	if cfg.Coalesce {
+		proxyOpts = append(proxyOpts, proxy.WithCoalescing())
+	}
+	if cfg.HotKey.Threshold > 0 {
+		proxyOpts = append(proxyOpts, proxy.WithHotKeySpreading(proxy.HotKeyConfig{
+			Threshold: cfg.HotKey.Threshold,
+			Replicas:  cfg.HotKey.Replicas,
+			Cooldown:  cfg.HotKey.Cooldown,
+		}))
+	}
+	if cfg.Outlier.Enabled {
+		outlier := proxy.DefaultOutlierConfig()
+		outlier.Interval = cfg.Outlier.Interval
+		outlier.BaseEjectionTime = cfg.Outlier.BaseEjectionTime
+		outlier.MaxEjectionPercent = cfg.Outlier.MaxEjectionPercent
+		outlier.MinLatency = cfg.Outlier.MinLatency
+		outlier.RampUp = cfg.Outlier.RampUp
+		proxyOpts = append(proxyOpts, proxy.WithOutlierDetection(outlier))
+	}
+	if cfg.DeadHost.ConsecutiveFailures > 0 {
+		action, err := proxy.ParseDeadHostAction(cfg.DeadHost.Action)
+		if err != nil {
+			panic(err)
+		}
+		proxyOpts = append(proxyOpts, proxy.WithDeadHostEviction(proxy.DeadHostConfig{
+			ConsecutiveFailures: cfg.DeadHost.ConsecutiveFailures,
+			Action:              action,
+			ProbeInterval:       cfg.DeadHost.ProbeInterval,
+			MaxProbeInterval:    cfg.DeadHost.MaxProbeInterval,
+			RampUp:              cfg.DeadHost.RampUp,
+		}))
+	}
+	if cfg.LoadReportMaxAge > 0 {
+		proxyOpts = append(proxyOpts, proxy.WithLoadReports(cfg.LoadReportMaxAge))
+	}
+	return proxyOpts
+}
+
+// enableChaos 按配置开启故障注入，运行时可通过管理接口/v1/chaos修改
+func enableChaos(p *proxy.Proxy) {
+	c := cfg.Chaos
+	err := p.SetChaos(proxy.ChaosConfig{
+		DelayRatio:   c.DelayRatio,
+		Delay:        c.Delay,
+		FailRatio:    c.FailRatio,
+		FlapInterval: c.FlapInterval,
+		FlapDown:     c.FlapDown,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// startRings 创建配置中的命名环，各自保存快照，不参与多实例同步和服务发现
+func startRings() {
+	rings = proxy.NewRings(p, cfg.RingHeader)
+	for _, rc := range cfg.Rings {
+		snapshot := cfg.SnapshotFile + "." + rc.Name
+		c := core.New(rc.ReplicaNum, nil, core.WithLogger(slog.Default()), core.WithLoadFactor(rc.LoadFactor), core.WithSlowStart(rc.SlowStart))
+		if data, err := os.ReadFile(snapshot); err == nil {
+			if c, err = core.Restore(data, core.WithLogger(slog.Default()), core.WithSlowStart(rc.SlowStart)); err != nil {
+				panic(err)
+			}
+			// 配置优先于快照中的参数
+			if rc.LoadFactor > 0 {
+				if err = c.SetLoadFactor(rc.LoadFactor); err != nil {
+					panic(err)
+				}
+			}
+			if rc.ReplicaNum > 0 && rc.ReplicaNum != c.ReplicaCount() {
+				if err = c.SetReplicaCount(rc.ReplicaNum); err != nil {
+					panic(err)
+				}
+			}
+		}
+
+		rp := proxy.New(c, proxyOptions()...)
+		enableChaos(rp)
+		wal := ""
+		if cfg.WALFile != "" {
+			wal = cfg.WALFile + "." + rc.Name
+		}
+		persistRing(rp, snapshot, wal)
+		for _, host := range rc.Hosts {
+			if err := rp.RegisterHost(host); err != nil && !errors.Is(err, core.ErrHostAlreadyExists) {
+				panic(err)
+			}
+		}
+		slots := ""
+		if cfg.SlotsFile != "" {
+			slots = cfg.SlotsFile + "." + rc.Name
+		}
+		enableSlots(rp, slotsFile(slots, snapshot))
+		if err := rings.Add(rc.Name, rc.Prefix, rp); err != nil {
+			panic(err)
+		}
+		slog.Info("ring added", "ring", rc.Name, "prefix", rc.Prefix, "hosts", c.Hosts())
+	}
+}
+
+// enableMirror 把默认环上的部分请求复制到影子环，需要在startRings之后
+func enableMirror(c config.Mirror) error {
+	mirror := proxy.DefaultMirrorConfig()
+	mirror.Ratio = c.Ratio
+	if c.Ring != "" {
+		shadow, ok := rings.Get(c.Ring)
+		if !ok {
+			return fmt.Errorf("mirror: ring %s not found", c.Ring)
+		}
+		mirror.Shadow = shadow
+	}
+	return p.SetMirror(mirror)
+}
+
+// startRoutes 加载配置文件中的路由表，需要在startRings之后
+func startRoutes() {
+	routes = proxy.NewRoutes(rings)
+	if err := routes.Set(routeTable(cfg.Routes)); err != nil {
+		panic(err)
+	}
+}
+
+func routeTable(configs []config.RouteConfig) []proxy.Route {
+	table := make([]proxy.Route, 0, len(configs))
+	for _, rc := range configs {
+		r := proxy.Route{
+			Name:        rc.Name,
+			Pattern:     rc.Pattern,
+			Ring:        rc.Ring,
+			Mode:        rc.Mode,
+			RoutingKey:  rc.RoutingKey,
+			StripPrefix: rc.StripPrefix,
+		}
+		if rc.Retry != nil {
+			r.Retry = &proxy.RouteRetry{Attempts: rc.Retry.Attempts, IdempotentOnly: rc.Retry.IdempotentOnly}
+			if rc.Retry.PerTryTimeout > 0 {
+				r.Retry.PerTryTimeout = rc.Retry.PerTryTimeout.String()
+			}
+		}
+		if rc.Timeout > 0 {
+			r.Timeout = rc.Timeout.String()
+		}
+		table = append(table, r)
+	}
+	return table
+}
+
+// startRaft 配置了Raft地址时，拓扑的写操作都经过Raft日志复制
+func startRaft(ctx context.Context) {
+	if cfg.Raft.Addr == "" {
+		return
+	}
+
+	var err error
+	raftNode, err = cluster.NewRaft(cluster.RaftConfig{
+		ID:        cfg.Raft.ID,
+		BindAddr:  cfg.Raft.Addr,
+		Advertise: cfg.Raft.Advertise,
+		Dir:       cfg.Raft.Dir,
+		Bootstrap: cfg.Raft.Bootstrap,
+		Join:      cfg.Raft.Join,
+		Token:     cfg.Admin.Token,
+		Logger:    slog.Default(),
+	}, p)
+	if err != nil {
+		panic(err)
+	}
+	p.SetReplicator(raftNode)
+	go func() {
+		if err := raftNode.JoinCluster(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("join raft cluster failed", "error", err)
+		}
+	}()
+	slog.Info("raft replication enabled", "id", cfg.Raft.ID, "addr", cfg.Raft.Addr)
+}
+
+// 对等实例的管理接口与本实例使用相同的token
+func peerConfig() proxy.PeerConfig {
+	config := proxy.DefaultPeerConfig()
+	if cfg.Peers != "" {
+		config.Peers = strings.Split(cfg.Peers, ",")
+	}
+	config.Token = cfg.Admin.Token
+	return config
+}
+
+func transportConfig() proxy.TransportConfig {
+	transport := proxy.DefaultTransportConfig()
+	transport.H2C = cfg.BackendH2C
+	if !cfg.BackendTLS.Enabled {
+		return transport
+	}
+
+	var err error
+	transport.TLS, err = proxy.LoadTLSConfig(proxy.TLSFiles{
+		CAFile:   cfg.BackendTLS.CAFile,
+		CertFile: cfg.BackendTLS.CertFile,
+		KeyFile:  cfg.BackendTLS.KeyFile,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return transport
+}
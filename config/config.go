@@ -0,0 +1,642 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 配置的优先级：默认值 < 配置文件 < 环境变量 < 命令行参数
+// 代理和kv服务共用一个YAML文件，分别读取proxy和server两节
+
+type Proxy struct {
+	Port      string `yaml:"port" env:"CH_PORT"`
+	GRPCPort  string `yaml:"grpc_port" env:"CH_GRPC_PORT"`
+	AdminPort string `yaml:"admin_port" env:"CH_ADMIN_PORT"`
+
+	// 以下可通过SIGHUP热加载
+	ReplicaNum int     `yaml:"replica_num" env:"CH_REPLICA_NUM"`
+	LoadFactor float64 `yaml:"load_factor" env:"CH_LOAD_FACTOR"`
+	// 新加入的服务器在此期间逐步增加虚拟节点，0表示立即分到全部的key
+	SlowStart time.Duration `yaml:"slow_start" env:"CH_SLOW_START"`
+	// 服务器上报的负载的有效期，过期后回退到代理自己统计的请求数；0表示不启用上报的有效期，上报直接覆盖计数
+	LoadReportMaxAge time.Duration `yaml:"load_report_max_age" env:"CH_LOAD_REPORT_MAX_AGE"`
+	// least-loaded模式在key之后最近的Choices台服务器中选负载最低的
+	Choices int `yaml:"choices" env:"CH_CHOICES"`
+
+	SnapshotFile string `yaml:"snapshot_file" env:"CH_SNAPSHOT_FILE"`
+	// 槽位模式的槽位分配，为空时不持久化，重启后按服务器重新生成；命名的环使用加上.环名的文件
+	SlotsFile string `yaml:"slots_file" env:"CH_SLOTS_FILE"`
+	// 拓扑变更的预写日志，为空时每次变更重写快照；不为空时每隔SnapshotInterval写一次快照并清空日志
+	WALFile          string        `yaml:"wal_file" env:"CH_WAL_FILE"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval" env:"CH_SNAPSHOT_INTERVAL"`
+	ShutdownTimeout  time.Duration `yaml:"shutdown_timeout" env:"CH_SHUTDOWN_TIMEOUT"`
+
+	TLS        ListenerTLS `yaml:"tls"`
+	BackendTLS BackendTLS  `yaml:"backend_tls"`
+	// 明文后端也使用HTTP/2（h2c）；gRPC请求总是使用HTTP/2
+	BackendH2C bool      `yaml:"backend_h2c" env:"CH_BACKEND_H2C"`
+	Admin      Admin     `yaml:"admin"`
+	Log        Log       `yaml:"log"`
+	AccessLog  AccessLog `yaml:"access_log"`
+	Tracing    Tracing   `yaml:"tracing"`
+	Discovery  Discovery `yaml:"discovery"`
+	// 逗号分隔的其他代理实例管理接口地址，拓扑变更会广播给它们
+	Peers string `yaml:"peers" env:"CH_PEERS"`
+	Raft  Raft   `yaml:"raft"`
+
+	// 路由key的来源，如 query:key、header:X-User-ID、cookie:sid、path:1、json:user.id、ip:remote，逗号分隔时依次尝试
+	RoutingKey string `yaml:"routing_key" env:"CH_ROUTING_KEY"`
+	// 请求（包括响应体）的默认超时，0表示不限制；路由规则的timeout覆盖它，客户端的X-Request-Timeout更短时使用客户端的
+	RequestTimeout time.Duration `yaml:"request_timeout" env:"CH_REQUEST_TIMEOUT"`
+	// 请求体、响应体的大小上限（字节），0表示不限制；请求体和响应体都是边读边转发的
+	MaxRequestBodyBytes  int64         `yaml:"max_request_body_bytes" env:"CH_MAX_REQUEST_BODY_BYTES"`
+	MaxResponseBodyBytes int64         `yaml:"max_response_body_bytes" env:"CH_MAX_RESPONSE_BODY_BYTES"`
+	StickySession        StickySession `yaml:"sticky_session"`
+	ResponseCache        ResponseCache `yaml:"response_cache"`
+	RateLimit            RateLimit     `yaml:"rate_limit"`
+	HotKey               HotKey        `yaml:"hot_key"`
+	Outlier              Outlier       `yaml:"outlier_detection"`
+	DeadHost             DeadHost      `yaml:"dead_host"`
+	// 可通过SIGHUP热加载
+	Mirror Mirror `yaml:"mirror"`
+	// 合并同一key的并发GET请求，只向后端转发一次
+	Coalesce bool  `yaml:"coalesce" env:"CH_COALESCE"`
+	L4       L4    `yaml:"l4"`
+	Redis    Redis `yaml:"redis"`
+	// memcached文本协议的分片代理监听地址，为空时不启用
+	Memcache string `yaml:"memcache" env:"CH_MEMCACHE_ADDR"`
+
+	// 命名的环，按路径前缀或RingHeader请求头选择，都不匹配时使用默认环
+	Rings      []RingConfig `yaml:"rings"`
+	RingHeader string       `yaml:"ring_header" env:"CH_RING_HEADER"`
+	// 路由表，按顺序匹配请求路径，优先于环的前缀和请求头；运行时可通过管理接口/v1/routes修改
+	Routes []RouteConfig `yaml:"routes"`
+	// 故障注入，只用于测试
+	Chaos Chaos `yaml:"chaos"`
+
+	args []string
+}
+
+// Log 日志级别（debug、info、warn、error）和格式（text、json）
+type Log struct {
+	Level  string `yaml:"level" env:"CH_LOG_LEVEL"`
+	Format string `yaml:"format" env:"CH_LOG_FORMAT"`
+}
+
+// AccessLog 访问日志，Path为空时不记录，为-时写到标准输出；文件超过MaxBytes时轮转，保留MaxBackups个旧文件
+type AccessLog struct {
+	Path string `yaml:"path" env:"CH_ACCESS_LOG"`
+	// common、combined或json
+	Format     string `yaml:"format" env:"CH_ACCESS_LOG_FORMAT"`
+	MaxBytes   int64  `yaml:"max_bytes" env:"CH_ACCESS_LOG_MAX_BYTES"`
+	MaxBackups int    `yaml:"max_backups" env:"CH_ACCESS_LOG_MAX_BACKUPS"`
+}
+
+// NewLogger 按配置创建输出到标准错误的日志
+func (l Log) NewLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch l.Format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", l.Format)
+}
+
+func (l *Log) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&l.Level, "log-level", l.Level, "log level: debug, info, warn or error")
+	fs.StringVar(&l.Format, "log-format", l.Format, "log format: text or json")
+}
+
+func defaultLog() Log {
+	return Log{Level: "info", Format: "text"}
+}
+
+// Tracing 将OpenTelemetry的span通过OTLP/HTTP导出，Endpoint为空时不导出
+type Tracing struct {
+	// 如 http://localhost:4318
+	Endpoint string `yaml:"endpoint" env:"CH_OTLP_ENDPOINT"`
+	// 采样比例，0~1
+	SampleRatio float64 `yaml:"sample_ratio" env:"CH_TRACE_SAMPLE_RATIO"`
+}
+
+// ResponseCache 代理侧的GET响应缓存
+type ResponseCache struct {
+	Enabled    bool          `yaml:"enabled" env:"CH_RESPONSE_CACHE"`
+	TTL        time.Duration `yaml:"ttl" env:"CH_RESPONSE_CACHE_TTL"`
+	MaxEntries int           `yaml:"max_entries" env:"CH_RESPONSE_CACHE_MAX_ENTRIES"`
+	MaxBytes   int64         `yaml:"max_bytes" env:"CH_RESPONSE_CACHE_MAX_BYTES"`
+}
+
+// StickySession 按会话cookie路由，开启后忽略RoutingKey
+type StickySession struct {
+	Enabled bool   `yaml:"enabled" env:"CH_STICKY_SESSION"`
+	Cookie  string `yaml:"cookie" env:"CH_SESSION_COOKIE"`
+	// 为0时为会话cookie
+	MaxAge time.Duration `yaml:"max_age" env:"CH_SESSION_MAX_AGE"`
+	Secure bool          `yaml:"secure" env:"CH_SESSION_SECURE"`
+}
+
+// RingConfig 一个命名的环，有独立的服务器、副本数和容量系数
+type RingConfig struct {
+	Name string `yaml:"name"`
+	// 如 /cache，转发时去掉前缀；为空时只能通过请求头选择
+	Prefix     string        `yaml:"prefix"`
+	ReplicaNum int           `yaml:"replica_num"`
+	LoadFactor float64       `yaml:"load_factor"`
+	SlowStart  time.Duration `yaml:"slow_start"`
+	Hosts      []string      `yaml:"hosts"`
+}
+
+// RouteConfig 路由表中的一条规则
+type RouteConfig struct {
+	Name string `yaml:"name"`
+	// 以/结尾时匹配前缀，*匹配任意一个路径段，如 /users/*/orders/
+	Pattern string `yaml:"pattern"`
+	// 为空时使用默认环
+	Ring string `yaml:"ring"`
+	// hash或capacious
+	Mode string `yaml:"mode"`
+	// 为空时使用routing_key
+	RoutingKey  string `yaml:"routing_key"`
+	StripPrefix bool   `yaml:"strip_prefix"`
+	// 为nil时使用环的重试策略
+	Retry   *RouteRetry   `yaml:"retry"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type RouteRetry struct {
+	Attempts       int           `yaml:"attempts"`
+	PerTryTimeout  time.Duration `yaml:"per_try_timeout"`
+	IdempotentOnly bool          `yaml:"idempotent_only"`
+}
+
+// L4 TCP/UDP转发，监听地址为空时不启用
+type L4 struct {
+	TCP string `yaml:"tcp" env:"CH_L4_TCP"`
+	UDP string `yaml:"udp" env:"CH_L4_UDP"`
+	// 路由key来源：ip、addr（IP:端口）或preamble（连接开头的2字节长度加key，只支持TCP）
+	Key string `yaml:"key" env:"CH_L4_KEY"`
+	// TCP连接按负载选择服务器
+	Capacious   bool          `yaml:"capacious" env:"CH_L4_CAPACIOUS"`
+	IdleTimeout time.Duration `yaml:"idle_timeout" env:"CH_L4_IDLE_TIMEOUT"`
+}
+
+// Redis Redis协议的分片代理，监听地址为空时不启用，环中的服务器为Redis实例的地址
+type Redis struct {
+	Addr string `yaml:"addr" env:"CH_REDIS_ADDR"`
+	// 连接Redis实例时使用的AUTH密码
+	Password string `yaml:"password" env:"CH_REDIS_PASSWORD"`
+}
+
+// RateLimit 按客户端IP和路由key的令牌桶限流，速率为0时不限流
+type RateLimit struct {
+	ClientRate  float64 `yaml:"client_rate" env:"CH_RATE_LIMIT_CLIENT"`
+	ClientBurst int     `yaml:"client_burst" env:"CH_RATE_LIMIT_CLIENT_BURST"`
+	KeyRate     float64 `yaml:"key_rate" env:"CH_RATE_LIMIT_KEY"`
+	KeyBurst    int     `yaml:"key_burst" env:"CH_RATE_LIMIT_KEY_BURST"`
+	// 按X-Forwarded-For识别客户端，代理前有可信的负载均衡时开启
+	TrustForwardedFor bool `yaml:"trust_forwarded_for" env:"CH_TRUST_FORWARDED_FOR"`
+	// 客户端和key各自最多保留的令牌桶数量，超出时淘汰最久未使用的
+	MaxBuckets int `yaml:"max_buckets" env:"CH_RATE_LIMIT_MAX_BUCKETS"`
+}
+
+// HotKey 每秒读请求数超过Threshold的key分散到Replicas台服务器，Threshold为0时不开启
+type HotKey struct {
+	Threshold float64       `yaml:"threshold" env:"CH_HOT_KEY_THRESHOLD"`
+	Replicas  int           `yaml:"replicas" env:"CH_HOT_KEY_REPLICAS"`
+	Cooldown  time.Duration `yaml:"cooldown" env:"CH_HOT_KEY_COOLDOWN"`
+}
+
+// Outlier 每个Interval比较各服务器的错误率和平均延迟，摘除明显偏高的服务器，到期后在RampUp内逐步恢复
+type Outlier struct {
+	Enabled            bool          `yaml:"enabled" env:"CH_OUTLIER_DETECTION"`
+	Interval           time.Duration `yaml:"interval" env:"CH_OUTLIER_INTERVAL"`
+	BaseEjectionTime   time.Duration `yaml:"base_ejection_time" env:"CH_OUTLIER_BASE_EJECTION_TIME"`
+	MaxEjectionPercent int           `yaml:"max_ejection_percent" env:"CH_OUTLIER_MAX_EJECTION_PERCENT"`
+	// 平均延迟至少达到该值才按延迟摘除，0表示不按延迟检测
+	MinLatency time.Duration `yaml:"min_latency" env:"CH_OUTLIER_MIN_LATENCY"`
+	RampUp     time.Duration `yaml:"ramp_up" env:"CH_OUTLIER_RAMP_UP"`
+}
+
+// DeadHost 转发时连续ConsecutiveFailures次在连接层失败的服务器按Action（remove或drain）注销或摘除，0表示不开启
+// 之后从ProbeInterval开始按指数退避探测，连通后在RampUp内逐步放回环上
+type DeadHost struct {
+	ConsecutiveFailures int           `yaml:"consecutive_failures" env:"CH_DEAD_HOST_FAILURES"`
+	Action              string        `yaml:"action" env:"CH_DEAD_HOST_ACTION"`
+	ProbeInterval       time.Duration `yaml:"probe_interval" env:"CH_DEAD_HOST_PROBE_INTERVAL"`
+	MaxProbeInterval    time.Duration `yaml:"max_probe_interval" env:"CH_DEAD_HOST_MAX_PROBE_INTERVAL"`
+	RampUp              time.Duration `yaml:"ramp_up" env:"CH_DEAD_HOST_RAMP_UP"`
+}
+
+// Mirror 把默认环上Ratio比例的请求复制一份发往影子环Ring（为空时发往key的下一台服务器），影子的响应被丢弃
+type Mirror struct {
+	Ratio float64 `yaml:"ratio" env:"CH_MIRROR_RATIO"`
+	Ring  string  `yaml:"ring" env:"CH_MIRROR_RING"`
+}
+
+// Chaos 按比例延迟或失败后端调用，并随机移除、重新注册服务器，用于验证重试、健康检查和重新平衡
+type Chaos struct {
+	DelayRatio   float64       `yaml:"delay_ratio" env:"CH_CHAOS_DELAY_RATIO"`
+	Delay        time.Duration `yaml:"delay" env:"CH_CHAOS_DELAY"`
+	FailRatio    float64       `yaml:"fail_ratio" env:"CH_CHAOS_FAIL_RATIO"`
+	FlapInterval time.Duration `yaml:"flap_interval" env:"CH_CHAOS_FLAP_INTERVAL"`
+	FlapDown     time.Duration `yaml:"flap_down" env:"CH_CHAOS_FLAP_DOWN"`
+}
+
+// Raft 通过Raft日志在多个代理实例间复制拓扑，Addr为空时不启用
+// 启用后忽略peers和快照文件，拓扑保存在Dir中
+type Raft struct {
+	// 本实例管理接口的地址，如 http://proxy-1:18890
+	ID        string `yaml:"id" env:"CH_RAFT_ID"`
+	Addr      string `yaml:"addr" env:"CH_RAFT_ADDR"`
+	Advertise string `yaml:"advertise" env:"CH_RAFT_ADVERTISE"`
+	Dir       string `yaml:"dir" env:"CH_RAFT_DIR"`
+	Bootstrap bool   `yaml:"bootstrap" env:"CH_RAFT_BOOTSTRAP"`
+	// 已有实例管理接口的地址，第一次启动时通过它加入集群
+	Join string `yaml:"join" env:"CH_RAFT_JOIN"`
+}
+
+// Discovery 从服务注册中心同步环上的节点，未配置时只能通过管理接口手动注册
+type Discovery struct {
+	Consul Consul `yaml:"consul"`
+	DNS    DNS    `yaml:"dns"`
+	// 超过该时长没有同步成功时/readyz返回503，0表示按来源自动决定（Consul为两倍的阻塞查询时长，DNS为三个解析周期）
+	MaxStaleness time.Duration `yaml:"max_staleness" env:"CH_DISCOVERY_MAX_STALENESS"`
+}
+
+// Consul 按服务名和标签同步通过健康检查的实例，Service为空时不启用
+type Consul struct {
+	Address    string `yaml:"address" env:"CH_CONSUL_ADDR"`
+	Service    string `yaml:"service" env:"CH_CONSUL_SERVICE"`
+	Tag        string `yaml:"tag" env:"CH_CONSUL_TAG"`
+	Datacenter string `yaml:"datacenter" env:"CH_CONSUL_DC"`
+	Token      string `yaml:"token" env:"CH_CONSUL_TOKEN"`
+}
+
+// DNS 定期解析记录同步节点，Name为空时不启用；Port为空时解析SRV记录，否则解析A/AAAA记录
+type DNS struct {
+	Name     string        `yaml:"name" env:"CH_DNS_NAME"`
+	Port     string        `yaml:"port" env:"CH_DNS_PORT"`
+	Interval time.Duration `yaml:"interval" env:"CH_DNS_INTERVAL"`
+	Jitter   time.Duration `yaml:"jitter" env:"CH_DNS_JITTER"`
+}
+
+// ListenerTLS 对外提供HTTPS：指定证书和私钥，或通过autocert自动申请证书
+type ListenerTLS struct {
+	CertFile string `yaml:"cert_file" env:"CH_TLS_CERT"`
+	KeyFile  string `yaml:"key_file" env:"CH_TLS_KEY"`
+	// 逗号分隔的域名
+	Autocert    string `yaml:"autocert" env:"CH_AUTOCERT"`
+	AutocertDir string `yaml:"autocert_dir" env:"CH_AUTOCERT_DIR"`
+}
+
+// BackendTLS 以TLS连接后端，指定CA时只信任该CA，指定证书时使用mTLS
+type BackendTLS struct {
+	Enabled  bool   `yaml:"enabled" env:"CH_BACKEND_TLS"`
+	CAFile   string `yaml:"ca_file" env:"CH_BACKEND_CA"`
+	CertFile string `yaml:"cert_file" env:"CH_BACKEND_CERT"`
+	KeyFile  string `yaml:"key_file" env:"CH_BACKEND_KEY"`
+}
+
+// Admin 管理端口的鉴权：token或mTLS，都未配置时只监听本机
+type Admin struct {
+	Token    string `yaml:"token" env:"CH_ADMIN_TOKEN"`
+	ClientCA string `yaml:"client_ca" env:"CH_ADMIN_CLIENT_CA"`
+	// 在管理端口提供/debug/pprof和/debug/stats
+	Debug bool `yaml:"debug" env:"CH_ADMIN_DEBUG"`
+}
+
+func DefaultProxy() *Proxy {
+	return &Proxy{
+		Port:             "18888",
+		GRPCPort:         "18889",
+		AdminPort:        "18890",
+		ReplicaNum:       10,
+		LoadFactor:       0.25,
+		Choices:          2,
+		SnapshotFile:     "ring.snapshot",
+		SnapshotInterval: time.Minute,
+		ShutdownTimeout:  15 * time.Second,
+		TLS:              ListenerTLS{AutocertDir: "certs"},
+		Log:              defaultLog(),
+		AccessLog:        AccessLog{Format: "combined", MaxBytes: 100 << 20, MaxBackups: 5},
+		Tracing:          Tracing{SampleRatio: 1},
+		Discovery: Discovery{
+			Consul: Consul{Address: "http://127.0.0.1:8500"},
+			DNS:    DNS{Interval: 30 * time.Second, Jitter: 5 * time.Second},
+		},
+		Raft: Raft{Dir: "raft"},
+		ResponseCache: ResponseCache{
+			TTL:        10 * time.Second,
+			MaxEntries: 10000,
+			MaxBytes:   64 << 20,
+		},
+		RoutingKey:    "query:key",
+		RingHeader:    "X-Ring",
+		L4:            L4{Key: "ip", IdleTimeout: time.Minute},
+		StickySession: StickySession{Cookie: "CHSESSION"},
+		RateLimit:     RateLimit{ClientBurst: 200, KeyBurst: 100, MaxBuckets: 100000},
+		Outlier: Outlier{
+			Interval:           10 * time.Second,
+			BaseEjectionTime:   30 * time.Second,
+			MaxEjectionPercent: 10,
+			MinLatency:         time.Second,
+			RampUp:             30 * time.Second,
+		},
+		HotKey: HotKey{Replicas: 3, Cooldown: 10 * time.Second},
+		DeadHost: DeadHost{
+			Action:           "remove",
+			ProbeInterval:    time.Second,
+			MaxProbeInterval: 2 * time.Minute,
+			RampUp:           30 * time.Second,
+		},
+	}
+}
+
+func (c *Proxy) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Port, "port", c.Port, "port of the proxy listener")
+	fs.StringVar(&c.GRPCPort, "grpc-port", c.GRPCPort, "port of the gRPC listener")
+	fs.StringVar(&c.AdminPort, "admin-port", c.AdminPort, "port of the admin listener")
+	fs.IntVar(&c.ReplicaNum, "replicas", c.ReplicaNum, "virtual nodes per host")
+	fs.Float64Var(&c.LoadFactor, "load-factor", c.LoadFactor, "load factor of bounded-load lookups")
+	fs.DurationVar(&c.SlowStart, "slow-start", c.SlowStart, "window over which a new host ramps up to its full virtual nodes, 0 to disable")
+	fs.IntVar(&c.Choices, "choices", c.Choices, "number of closest hosts the least-loaded mode picks from")
+	fs.DurationVar(&c.LoadReportMaxAge, "load-report-max-age", c.LoadReportMaxAge, "age after which a backend-reported load is ignored, 0 lets reports overwrite the in-flight counter")
+	fs.StringVar(&c.SnapshotFile, "snapshot", c.SnapshotFile, "file to persist the ring topology")
+	fs.StringVar(&c.SlotsFile, "slots-file", c.SlotsFile, "file to persist the slot assignments of the slots mode, empty to use <snapshot>.slots")
+	fs.StringVar(&c.WALFile, "wal", c.WALFile, "write-ahead log of topology changes, empty rewrites the snapshot on every change")
+	fs.DurationVar(&c.SnapshotInterval, "snapshot-interval", c.SnapshotInterval, "interval to snapshot the ring and truncate the write-ahead log")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "time to wait for in-flight requests on shutdown")
+	fs.StringVar(&c.AccessLog.Path, "access-log", c.AccessLog.Path, "file to write the access log to, - for stdout, empty to disable")
+	fs.StringVar(&c.AccessLog.Format, "access-log-format", c.AccessLog.Format, "format of the access log: common, combined or json")
+
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "TLS certificate file of the proxy listener")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "TLS key file of the proxy listener")
+	fs.StringVar(&c.TLS.Autocert, "autocert", c.TLS.Autocert, "comma-separated domains to obtain certificates for via ACME")
+	fs.StringVar(&c.TLS.AutocertDir, "autocert-dir", c.TLS.AutocertDir, "directory to cache ACME certificates")
+
+	fs.BoolVar(&c.BackendTLS.Enabled, "backend-tls", c.BackendTLS.Enabled, "connect to backends over TLS")
+	fs.StringVar(&c.BackendTLS.CAFile, "backend-ca", c.BackendTLS.CAFile, "CA file to verify backend certificates")
+	fs.StringVar(&c.BackendTLS.CertFile, "backend-cert", c.BackendTLS.CertFile, "client certificate file for backend mTLS")
+	fs.StringVar(&c.BackendTLS.KeyFile, "backend-key", c.BackendTLS.KeyFile, "client key file for backend mTLS")
+	fs.BoolVar(&c.BackendH2C, "backend-h2c", c.BackendH2C, "speak HTTP/2 without TLS (h2c) to plaintext backends")
+
+	fs.StringVar(&c.Admin.Token, "admin-token", c.Admin.Token, "bearer token required by the admin API")
+	fs.StringVar(&c.Admin.ClientCA, "admin-client-ca", c.Admin.ClientCA, "CA file to verify admin client certificates (mTLS)")
+	fs.BoolVar(&c.Admin.Debug, "admin-debug", c.Admin.Debug, "serve /debug/pprof and /debug/stats on the admin listener")
+	c.Log.bindFlags(fs)
+	fs.StringVar(&c.Tracing.Endpoint, "otlp-endpoint", c.Tracing.Endpoint, "OTLP/HTTP endpoint to export traces to")
+	fs.Float64Var(&c.Tracing.SampleRatio, "trace-sample-ratio", c.Tracing.SampleRatio, "fraction of traces to sample")
+
+	fs.StringVar(&c.Peers, "peers", c.Peers, "comma-separated admin URLs of other proxy instances to sync topology with")
+	fs.BoolVar(&c.ResponseCache.Enabled, "response-cache", c.ResponseCache.Enabled, "cache GET responses in the proxy")
+	fs.DurationVar(&c.ResponseCache.TTL, "response-cache-ttl", c.ResponseCache.TTL, "time to cache a response")
+	fs.IntVar(&c.ResponseCache.MaxEntries, "response-cache-max-entries", c.ResponseCache.MaxEntries, "maximum number of cached responses")
+	fs.Int64Var(&c.ResponseCache.MaxBytes, "response-cache-max-bytes", c.ResponseCache.MaxBytes, "maximum total size of cached responses")
+	fs.StringVar(&c.RoutingKey, "routing-key", c.RoutingKey, "where to read the routing key from: query:NAME, header:NAME, cookie:NAME, path:INDEX or json:FIELD, comma-separated fallbacks")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", c.RequestTimeout, "default timeout of a proxied request including its body, 0 for none")
+	fs.Int64Var(&c.MaxRequestBodyBytes, "max-request-body", c.MaxRequestBodyBytes, "max bytes of a proxied request body, 0 for no limit")
+	fs.Int64Var(&c.MaxResponseBodyBytes, "max-response-body", c.MaxResponseBodyBytes, "max bytes of a proxied response body, 0 for no limit")
+	fs.BoolVar(&c.StickySession.Enabled, "sticky-session", c.StickySession.Enabled, "route by a session cookie, issuing one when absent")
+	fs.StringVar(&c.StickySession.Cookie, "session-cookie", c.StickySession.Cookie, "name of the session cookie")
+	fs.DurationVar(&c.StickySession.MaxAge, "session-max-age", c.StickySession.MaxAge, "max age of the session cookie, 0 for a browser session cookie")
+	fs.BoolVar(&c.StickySession.Secure, "session-secure", c.StickySession.Secure, "mark the session cookie as Secure")
+	fs.Float64Var(&c.RateLimit.ClientRate, "rate-limit-client", c.RateLimit.ClientRate, "requests per second allowed per client IP, 0 to disable")
+	fs.IntVar(&c.RateLimit.ClientBurst, "rate-limit-client-burst", c.RateLimit.ClientBurst, "burst size per client IP")
+	fs.Float64Var(&c.RateLimit.KeyRate, "rate-limit-key", c.RateLimit.KeyRate, "requests per second allowed per routing key, 0 to disable")
+	fs.IntVar(&c.RateLimit.KeyBurst, "rate-limit-key-burst", c.RateLimit.KeyBurst, "burst size per routing key")
+	fs.BoolVar(&c.RateLimit.TrustForwardedFor, "trust-forwarded-for", c.RateLimit.TrustForwardedFor, "identify clients by X-Forwarded-For")
+	fs.IntVar(&c.RateLimit.MaxBuckets, "rate-limit-max-buckets", c.RateLimit.MaxBuckets, "max token buckets kept per client IP and per routing key, least recently used are evicted")
+	fs.Float64Var(&c.HotKey.Threshold, "hot-key-threshold", c.HotKey.Threshold, "reads per second after which a key is spread across replicas, 0 to disable")
+	fs.IntVar(&c.HotKey.Replicas, "hot-key-replicas", c.HotKey.Replicas, "number of hosts a hot key is spread across")
+	fs.BoolVar(&c.Outlier.Enabled, "outlier-detection", c.Outlier.Enabled, "drain hosts whose error rate or latency is far above the others")
+	fs.DurationVar(&c.Outlier.BaseEjectionTime, "outlier-ejection-time", c.Outlier.BaseEjectionTime, "base time an outlier host is drained, multiplied by its ejection count")
+	fs.IntVar(&c.Outlier.MaxEjectionPercent, "outlier-max-ejection-percent", c.Outlier.MaxEjectionPercent, "max percent of hosts drained as outliers at once")
+	fs.IntVar(&c.DeadHost.ConsecutiveFailures, "dead-host-failures", c.DeadHost.ConsecutiveFailures, "evict a host after this many consecutive connection failures (0: disabled)")
+	fs.StringVar(&c.DeadHost.Action, "dead-host-action", c.DeadHost.Action, "how to evict a dead host: remove or drain")
+	fs.Float64Var(&c.Mirror.Ratio, "mirror-ratio", c.Mirror.Ratio, "ratio (0-1) of requests also sent to a shadow host, 0 to disable")
+	fs.StringVar(&c.Mirror.Ring, "mirror-ring", c.Mirror.Ring, "named ring to mirror requests to, empty mirrors to the next host on the ring")
+	fs.StringVar(&c.L4.TCP, "l4-tcp", c.L4.TCP, "address to accept TCP connections on, routed by the ring")
+	fs.StringVar(&c.L4.UDP, "l4-udp", c.L4.UDP, "address to accept UDP datagrams on, routed by the ring")
+	fs.StringVar(&c.L4.Key, "l4-key", c.L4.Key, "routing key of TCP/UDP clients: ip, addr or preamble")
+	fs.BoolVar(&c.L4.Capacious, "l4-capacious", c.L4.Capacious, "route TCP connections with bounded loads")
+	fs.DurationVar(&c.L4.IdleTimeout, "l4-idle-timeout", c.L4.IdleTimeout, "close UDP sessions idle for this long")
+	fs.StringVar(&c.Redis.Addr, "redis-listen", c.Redis.Addr, "address to accept Redis protocol connections on")
+	fs.StringVar(&c.Redis.Password, "redis-password", c.Redis.Password, "password used to AUTH against backend Redis instances")
+	fs.StringVar(&c.Memcache, "memcache-listen", c.Memcache, "address to accept memcached text protocol connections on")
+	fs.StringVar(&c.RingHeader, "ring-header", c.RingHeader, "request header naming the ring to route to")
+	fs.BoolVar(&c.Coalesce, "coalesce", c.Coalesce, "coalesce concurrent GET requests for the same key into one backend request")
+
+	fs.StringVar(&c.Raft.ID, "raft-id", c.Raft.ID, "admin URL of this instance, used as its raft node id")
+	fs.StringVar(&c.Raft.Addr, "raft-addr", c.Raft.Addr, "address to bind raft to; enables raft replication")
+	fs.StringVar(&c.Raft.Advertise, "raft-advertise", c.Raft.Advertise, "raft address advertised to other nodes")
+	fs.StringVar(&c.Raft.Dir, "raft-dir", c.Raft.Dir, "directory to store the raft log and snapshots")
+	fs.BoolVar(&c.Raft.Bootstrap, "raft-bootstrap", c.Raft.Bootstrap, "bootstrap a new raft cluster with this node")
+	fs.StringVar(&c.Raft.Join, "raft-join", c.Raft.Join, "admin URL of an existing node to join the raft cluster through")
+
+	fs.StringVar(&c.Discovery.Consul.Address, "consul-addr", c.Discovery.Consul.Address, "HTTP address of the Consul agent")
+	fs.StringVar(&c.Discovery.Consul.Service, "consul-service", c.Discovery.Consul.Service, "Consul service to sync the ring from")
+	fs.StringVar(&c.Discovery.Consul.Tag, "consul-tag", c.Discovery.Consul.Tag, "only sync Consul instances with this tag")
+	fs.StringVar(&c.Discovery.Consul.Datacenter, "consul-dc", c.Discovery.Consul.Datacenter, "Consul datacenter to query")
+	fs.StringVar(&c.Discovery.Consul.Token, "consul-token", c.Discovery.Consul.Token, "Consul ACL token")
+	fs.StringVar(&c.Discovery.DNS.Name, "dns-name", c.Discovery.DNS.Name, "DNS name to sync the ring from")
+	fs.StringVar(&c.Discovery.DNS.Port, "dns-port", c.Discovery.DNS.Port, "backend port of A/AAAA records; SRV records are resolved when empty")
+	fs.DurationVar(&c.Discovery.DNS.Interval, "dns-interval", c.Discovery.DNS.Interval, "interval between DNS resolutions")
+	fs.DurationVar(&c.Discovery.DNS.Jitter, "dns-jitter", c.Discovery.DNS.Jitter, "maximum random delay added to the DNS interval")
+	fs.DurationVar(&c.Discovery.MaxStaleness, "discovery-max-staleness", c.Discovery.MaxStaleness, "mark the proxy unready when discovery has not synced for this long (0: automatic)")
+}
+
+// LoadProxy 从命令行参数args（不含程序名）、-config指定的文件和环境变量加载代理的配置
+func LoadProxy(args []string) (*Proxy, error) {
+	c := DefaultProxy()
+	c.args = args
+	if err := load(args, "proxy", c, c.bindFlags); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload 用启动时的参数重新加载配置
+func (c *Proxy) Reload() (*Proxy, error) {
+	return LoadProxy(c.args)
+}
+
+type Server struct {
+	Port string `yaml:"port" env:"CH_SERVER_PORT"`
+	// 代理的管理接口
+	RegistryURL string `yaml:"registry_url" env:"CH_REGISTRY_URL"`
+	AdminToken  string `yaml:"admin_token" env:"CH_ADMIN_TOKEN"`
+	// 注册到代理的地址，为空时使用localhost:Port
+	Advertise string `yaml:"advertise" env:"CH_ADVERTISE"`
+	// 注册的有效期和续期间隔，HostTTL为0时永久注册、不发送心跳
+	HostTTL   time.Duration `yaml:"host_ttl" env:"CH_HOST_TTL"`
+	Heartbeat time.Duration `yaml:"heartbeat" env:"CH_HEARTBEAT"`
+	// 向代理上报负载（正在处理的请求数）的间隔，0表示不上报
+	LoadReportInterval time.Duration `yaml:"load_report_interval" env:"CH_LOAD_REPORT_INTERVAL"`
+	Cache              Cache         `yaml:"cache"`
+	ShutdownTimeout    time.Duration `yaml:"shutdown_timeout" env:"CH_SHUTDOWN_TIMEOUT"`
+	Log                Log           `yaml:"log"`
+}
+
+// Cache kv服务的缓存：淘汰策略（lru、lfu）及条目数、字节数上限，上限为0时不限制
+type Cache struct {
+	Policy     string `yaml:"policy" env:"CH_CACHE_POLICY"`
+	MaxEntries int    `yaml:"max_entries" env:"CH_CACHE_MAX_ENTRIES"`
+	MaxBytes   int64  `yaml:"max_bytes" env:"CH_CACHE_MAX_BYTES"`
+}
+
+func DefaultServer() *Server {
+	return &Server{
+		Port:            "8081",
+		RegistryURL:     "http://localhost:18890",
+		HostTTL:         30 * time.Second,
+		Heartbeat:       10 * time.Second,
+		Cache:           Cache{Policy: "lru"},
+		ShutdownTimeout: 15 * time.Second,
+		Log:             defaultLog(),
+	}
+}
+
+func (c *Server) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Port, "p", c.Port, "port")
+	fs.StringVar(&c.RegistryURL, "registry", c.RegistryURL, "URL of the proxy admin API")
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "bearer token of the proxy admin API")
+	fs.StringVar(&c.Advertise, "advertise", c.Advertise, "address to register with the proxy (default localhost:<port>)")
+	fs.DurationVar(&c.HostTTL, "host-ttl", c.HostTTL, "ttl of the registration; 0 registers permanently without heartbeats")
+	fs.DurationVar(&c.Heartbeat, "heartbeat", c.Heartbeat, "interval between registration renewals")
+	fs.DurationVar(&c.LoadReportInterval, "load-report-interval", c.LoadReportInterval, "interval to report the in-flight requests to the proxy, 0 to disable")
+	fs.StringVar(&c.Cache.Policy, "cache-policy", c.Cache.Policy, "cache eviction policy: lru or lfu")
+	fs.IntVar(&c.Cache.MaxEntries, "cache-max-entries", c.Cache.MaxEntries, "maximum number of cached keys, 0 for unlimited")
+	fs.Int64Var(&c.Cache.MaxBytes, "cache-max-bytes", c.Cache.MaxBytes, "maximum total size of cached keys and values, 0 for unlimited")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "time to wait for in-flight requests on shutdown")
+	c.Log.bindFlags(fs)
+}
+
+// LoadServer 从命令行参数args（不含程序名）、-config指定的文件和环境变量加载kv服务的配置
+func LoadServer(args []string) (*Server, error) {
+	c := DefaultServer()
+	if err := load(args, "server", c, c.bindFlags); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 先解析一次命令行取得配置文件路径，依次应用文件和环境变量后再解析一次，让命令行参数覆盖前两者
+func load(args []string, section string, c interface{}, bindFlags func(*flag.FlagSet)) error {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	path := fs.String("config", os.Getenv("CH_CONFIG"), "YAML config file")
+	bindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *path != "" {
+		if err := readFile(*path, section, c); err != nil {
+			return err
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(c).Elem()); err != nil {
+		return err
+	}
+	return fs.Parse(args)
+}
+
+func readFile(path, section string, c interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file map[string]yaml.Node
+	if err = yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	node, ok := file[section]
+	if !ok {
+		return nil
+	}
+	if err = node.Decode(c); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// 按字段的env标签读取环境变量，嵌套的结构体递归处理
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := t.Field(i).Tag.Get("env")
+		value, ok := os.LookupEnv(name)
+		if name == "" || !ok {
+			continue
+		}
+		if err := setValue(field, value); err != nil {
+			return fmt.Errorf("env %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
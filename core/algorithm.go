@@ -1,104 +1,228 @@
 package core
 
 import (
-	"crypto/sha512"
-	"encoding/binary"
-	"fmt"
+	"context"
+	"errors"
 	"math"
+	"slices"
 	"sort"
 	"sync"
 	"sync/atomic"
-)
-
-const (
-	hostReplicaFormat = `%s%d`
+	"time"
 )
 
 var (
-	defaultReplicaNum = 10
-	LoadBoundFactor   = 0.25
-	defaultHashFunc   = func(key string) uint64 {
-		out := sha512.Sum512([]byte(key))
-		return binary.LittleEndian.Uint64(out[:])
-	}
+	defaultReplicaNum             = 10
+	defaultLoadBoundFactor        = 0.25
+	defaultFallbackTimeout        = time.Second
+	defaultHasher          Hasher = SHA512Hasher{}
 )
 
 type Consistent struct {
 	replicaNum int
-	totalLoad  int64
-	hashFunc   func(key string) uint64
-	hosts      map[string]*Host
-	virt2host  map[uint64]string
-	ring       []uint64
-	sync.RWMutex
+	vnodeLabel VNodeLabel
+	// 所有服务器的负载之和，原子更新
+	totalLoad int64
+	placement Placement
+	// 所有服务器都超出容量时的处理策略
+	fallback        FallbackPolicy
+	fallbackTimeout time.Duration
+	releaseMu       sync.Mutex
+	released        chan struct{}
+	hasher          Hasher
+	hosts           map[string]*Host
+	state           atomic.Pointer[ringState]
+	migrateFns      []MigrationFunc
+	trackedKeys     map[string]uint64
+	subscribers     []chan TopologyEvent
+	// 拓扑版本号、最近的事件，以及在下一次拓扑变化时关闭的channel
+	version uint64
+	history []TopologyEvent
+	changed chan struct{}
+	ttls    map[string]*hostTTL
+	// 有效期到期时的处理，为空时直接注销
+	expireFn ExpireFunc
+	// 新加入的服务器逐步增加虚拟节点的时长，0表示不慢启动
+	slowStart time.Duration
+	warmups   map[string]*hostWarmup
+	metrics   Metrics
+	logger    Logger
+	countingRWMutex
 }
 
-func New(replicaNum int, hashFunc func(key string) uint64) *Consistent {
+func New(replicaNum int, hasher Hasher, opts ...Option) *Consistent {
 	if replicaNum <= 0 {
 		replicaNum = defaultReplicaNum
 	}
 
-	if hashFunc == nil {
-		hashFunc = defaultHashFunc
+	if hasher == nil {
+		hasher = defaultHasher
 	}
 
-	return &Consistent{
-		replicaNum: replicaNum,
-		totalLoad:  0,
-		hashFunc:   hashFunc,
-		hosts:      make(map[string]*Host),
-		virt2host:  make(map[uint64]string),
-		ring:       make([]uint64, 0),
+	c := &Consistent{
+		replicaNum:      replicaNum,
+		totalLoad:       0,
+		fallback:        FallbackError,
+		fallbackTimeout: defaultFallbackTimeout,
+		released:        make(chan struct{}),
+		changed:         make(chan struct{}),
+		hasher:          hasher,
+		hosts:           make(map[string]*Host),
+		trackedKeys:     make(map[string]uint64),
+		ttls:            make(map[string]*hostTTL),
+		warmups:         make(map[string]*hostWarmup),
+		metrics:         nopMetrics{},
+		logger:          defaultLogger(),
 	}
+	c.state.Store(newRingState())
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 func (c *Consistent) RegisterHost(hostName string) error {
+	return c.RegisterHostWithWeight(hostName, 1)
+}
+func (c *Consistent) RegisterHostWithWeight(hostName string, weight int) error {
+	return c.RegisterHostWithMeta(hostName, weight, Metadata{})
+}
+func (c *Consistent) RegisterHostWithMeta(hostName string, weight int, meta Metadata) error {
+	if weight <= 0 {
+		return ErrInvalidWeight
+	}
+
+	var moved []Migration
+	defer func() { c.fireMigrate(moved) }()
+
 	c.Lock()
 	defer c.Unlock()
 
 	if _, ok := c.hosts[hostName]; ok {
-		return ErrHostAlreadyExists
+		return hostError(hostName, ErrHostAlreadyExists)
 	}
+	before := c.state.Load()
+
 	c.hosts[hostName] = &Host{
 		Name:      hostName,
+		Weight:    weight,
 		LoadBound: 0,
+		Meta:      meta.clone(),
 	}
 
-	for i := 0; i < c.replicaNum; i++ {
-		hashedIdx := c.hashFunc(fmt.Sprintf(hostReplicaFormat, hostName, i))
-		c.virt2host[hashedIdx] = hostName
-		c.ring = append(c.ring, hashedIdx)
-	}
-	sort.Slice(c.ring, func(i, j int) bool {
-		if c.ring[i] < c.ring[j] {
-			return true
-		}
-		return false
-	})
+	c.startWarmup(hostName)
+	next := before.clone()
+	c.addReplicas(next, c.hosts[hostName])
+	next.sortRing()
+	c.state.Store(next)
+	moved = c.migrations(before)
+	c.publish(TopologyEvent{Type: HostAdded, Host: hostName, Weight: weight})
 	return nil
 }
 func (c *Consistent) UnregisterHost(hostName string) error {
+	var moved []Migration
+	defer func() { c.fireMigrate(moved) }()
+
 	c.Lock()
 	defer c.Unlock()
 
-	if _, ok := c.hosts[hostName]; !ok {
-		return ErrHostNotFound
+	host, ok := c.hosts[hostName]
+	if !ok {
+		return hostError(hostName, ErrHostNotFound)
 	}
+	before := c.state.Load()
 	delete(c.hosts, hostName)
+	c.stopTTL(hostName)
+	c.stopWarmup(hostName)
+	atomic.AddInt64(&c.totalLoad, -atomic.LoadInt64(&host.LoadBound))
+	if reported, ok := before.loadProvider.(*ReportedLoads); ok {
+		reported.Remove(hostName)
+	}
 
-	for i := 0; i < c.replicaNum; i++ {
-		hashedIdx := c.hashFunc(fmt.Sprintf(hostReplicaFormat, hostName, i))
-		c.delHashIndex(hashedIdx)
+	next := before.clone()
+	c.removeReplicas(next, host)
+	for key, pinned := range next.pins {
+		if pinned == hostName {
+			delete(next.pins, key)
+		}
 	}
+	c.state.Store(next)
+	moved = c.migrations(before)
+	c.publish(TopologyEvent{Type: HostRemoved, Host: hostName, Weight: host.Weight})
 	return nil
 }
-func (c *Consistent) UpdateLoad(host string, load int64) {
+
+// SetHostWeight 调整服务器的权重，按新权重重新分配其虚拟节点
+func (c *Consistent) SetHostWeight(hostName string, weight int) error {
+	if weight <= 0 {
+		return ErrInvalidWeight
+	}
+
+	var moved []Migration
+	defer func() { c.fireMigrate(moved) }()
+
+	c.Lock()
+	defer c.Unlock()
+
+	host, ok := c.hosts[hostName]
+	if !ok {
+		return hostError(hostName, ErrHostNotFound)
+	}
+	if host.Weight == weight {
+		return nil
+	}
+	before := c.state.Load()
+
+	next := before.clone()
+	c.removeReplicas(next, host)
+	host.Weight = weight
+	c.addReplicas(next, host)
+	next.sortRing()
+	c.state.Store(next)
+	moved = c.migrations(before)
+	c.publish(TopologyEvent{Type: WeightChanged, Host: hostName, Weight: weight})
+	return nil
+}
+
+// SetReplicaCount 以新的虚拟节点数量重建环，保留已注册的服务器和负载计数
+func (c *Consistent) SetReplicaCount(replicaNum int) error {
+	if replicaNum <= 0 {
+		return ErrInvalidReplicaCount
+	}
+
+	var moved []Migration
+	defer func() { c.fireMigrate(moved) }()
+
+	c.Lock()
+	defer c.Unlock()
+
+	before := c.state.Load()
+	c.replicaNum = replicaNum
+	c.state.Store(c.buildState())
+	moved = c.migrations(before)
+	return nil
+}
+func (c *Consistent) ReplicaCount() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.replicaNum
+}
+
+// UpdateLoad 替换服务器的负载；LoadProvider为*ReportedLoads时作为当前时间的上报交给它，见UpdateLoadAt
+func (c *Consistent) UpdateLoad(host string, load int64) error {
+	if _, ok := c.state.Load().loadProvider.(*ReportedLoads); ok {
+		return c.UpdateLoadAt(host, load, time.Now())
+	}
+
 	c.Lock()
 	defer c.Unlock()
 	if _, ok := c.hosts[host]; !ok {
-		return
+		return hostError(host, ErrHostNotFound)
 	}
-	c.totalLoad = c.totalLoad - c.hosts[host].LoadBound + load
-	c.hosts[host].LoadBound = load
+	old := atomic.SwapInt64(&c.hosts[host].LoadBound, load)
+	atomic.AddInt64(&c.totalLoad, load-old)
+	c.metrics.ObserveLoad(host, load)
+	return nil
 }
 func (c *Consistent) Hosts() []string {
 	c.RLock()
@@ -110,134 +234,412 @@ func (c *Consistent) Hosts() []string {
 	}
 	return hosts
 }
+func (c *Consistent) SetLoadFactor(f float64) error {
+	if f <= 0 {
+		return ErrInvalidLoadFactor
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	next := c.state.Load().clone()
+	next.loadFactor = f
+	c.state.Store(next)
+	return nil
+}
+func (c *Consistent) LoadFactor() float64 {
+	return c.state.Load().loadFactor
+}
+func (c *Consistent) Size() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	return len(c.hosts)
+}
+func (c *Consistent) IsEmpty() bool {
+	return c.Size() == 0
+}
 func (c *Consistent) GetHost(key string) (string, error) {
-	hashedKey := c.hashFunc(key)
-	idx := c.searchKey(hashedKey)
-	return c.virt2host[c.ring[idx]], nil
+	return c.GetHostCtx(context.Background(), key)
 }
-func (c *Consistent) GetHostCapacious(key string) (string, error) {
+func (c *Consistent) GetHostCtx(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	// 读取环的快照，不需要加锁
+	state := c.state.Load()
+	if len(state.ring) == 0 {
+		c.metrics.ObserveLookup("", ErrNoHosts)
+		return "", ErrNoHosts
+	}
+	host := state.lookup(key, c.hash(key))
+	c.metrics.ObserveLookup(host, nil)
+	return host, nil
+}
+
+// GetHostsBatch 在同一个环快照上解析一批key，环为空时返回空map
+func (c *Consistent) GetHostsBatch(keys []string) map[string]string {
+	state := c.state.Load()
+	result := make(map[string]string, len(keys))
+	if len(state.ring) == 0 {
+		return result
+	}
+
+	for _, key := range keys {
+		result[key] = state.lookup(key, c.hash(key))
+	}
+	return result
+}
+func (c *Consistent) GetHostInfo(hostName string) (Host, error) {
 	c.RLock()
 	defer c.RUnlock()
-	if len(c.virt2host) == 0 {
-		return "", ErrHostNotFound
+
+	host, ok := c.hosts[hostName]
+	if !ok {
+		return Host{}, hostError(hostName, ErrHostNotFound)
 	}
+	return host.info(), nil
+}
 
-	hashedKey := c.hashFunc(key)
-	idx := c.searchKey(hashedKey)
+// GetHostWithInfo 与GetHost相同，同时返回服务器的元数据
+func (c *Consistent) GetHostWithInfo(key string) (Host, error) {
+	hostName, err := c.GetHost(key)
+	if err != nil {
+		return Host{}, err
+	}
+	return c.GetHostInfo(hostName)
+}
+func (c *Consistent) GetHosts(key string, n int) ([]string, error) {
+	if n <= 0 {
+		return []string{}, nil
+	}
 
-	i := idx
+	state := c.state.Load()
+	if len(state.ring) == 0 {
+		return nil, ErrNoHosts
+	}
+	hashedKey := c.hash(key)
+	idx := state.search(hashedKey)
+
+	// 按可用区分散时需要先取出环上所有服务器的顺序
+	limit := n
+	if c.placement == PlacementZoneAware {
+		limit = len(state.hosts)
+	}
+
+	hosts := make([]string, 0, limit)
+	seen := make(map[string]struct{}, limit)
+	for i := 0; i < len(state.ring) && len(hosts) < limit; i++ {
+		// 跳过同一物理服务器的其他虚拟节点
+		host := state.virt2host[state.ring[(idx+i)%len(state.ring)]]
+		if _, ok := seen[host]; ok {
+			continue
+		}
+		seen[host] = struct{}{}
+		hosts = append(hosts, host)
+	}
+	if c.placement == PlacementZoneAware {
+		hosts = state.spreadZones(hosts, n)
+	}
+	if len(hosts) < n {
+		return nil, ErrInsufficientHosts
+	}
+	return hosts, nil
+}
+func (c *Consistent) GetHostCapacious(key string) (string, error) {
+	return c.GetHostCapaciousCtx(context.Background(), key)
+}
+
+// GetHostCapaciousCtx 在负载竞争激烈、需要沿环走很远时，可以通过ctx取消查找
+func (c *Consistent) GetHostCapaciousCtx(ctx context.Context, key string) (string, error) {
+	host, err := c.waitHostCapacious(ctx, key)
+	c.metrics.ObserveLookup(host, err)
+	return host, err
+}
+
+// FallbackWait策略下，所有服务器都超载时等待负载释放后重新查找
+func (c *Consistent) waitHostCapacious(ctx context.Context, key string) (string, error) {
+	var deadline <-chan time.Time
 	for {
-		host := c.virt2host[c.ring[i]]
-		loadChecked, err := c.checkLoadCapacity(host)
+		host, released, err := c.getHostCapacious(ctx, key)
+		if !errors.Is(err, ErrAllHostsOverloaded) || c.fallback != FallbackWait {
+			return host, err
+		}
+
+		// 等待有负载被释放后重新查找
+		if deadline == nil {
+			timer := time.NewTimer(c.fallbackTimeout)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		select {
+		case <-released:
+		case <-deadline:
+			return "", ErrAllHostsOverloaded
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// 只读取环的快照和原子计数，不需要加锁
+func (c *Consistent) getHostCapacious(ctx context.Context, key string) (string, <-chan struct{}, error) {
+	state := c.state.Load()
+	if len(state.virt2host) == 0 {
+		return "", nil, ErrNoHosts
+	}
+	released := c.loadReleased()
+	if host, ok := state.pins[key]; ok {
+		return host, nil, nil
+	}
+
+	loads := c.loadSnapshot(state)
+	hashedKey := c.hash(key)
+	idx := state.search(hashedKey)
+
+	// 最多绕环一周，记录沿途负载率最低的服务器
+	var leastLoaded string
+	leastRatio := math.Inf(1)
+	i := idx
+	for step := 0; step < len(state.ring); step++ {
+		if err := ctx.Err(); err != nil {
+			return "", nil, err
+		}
+
+		host := state.virt2host[state.ring[i]]
+		loadChecked, err := state.checkLoadCapacity(host, loads)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 		if loadChecked {
-			return host, err
+			return host, nil, err
+		}
+		view := state.hosts[host]
+		if ratio := float64(loads.of(view)) / float64(view.weight); !view.draining && ratio < leastRatio {
+			leastLoaded, leastRatio = host, ratio
 		}
 		i++
 
-		if i >= len(c.virt2host) {
+		if i >= len(state.virt2host) {
 			i = 0
 		}
 	}
+
+	if c.fallback == FallbackLeastLoaded && leastLoaded != "" {
+		return leastLoaded, nil, nil
+	}
+	return "", released, ErrAllHostsOverloaded
 }
-func (c *Consistent) Inc(hostName string) {
-	c.Lock()
-	defer c.Unlock()
 
-	atomic.AddInt64(&c.hosts[hostName].LoadBound, 1)
+// Inc/Done只通过环的快照找到服务器，计数使用原子操作，不需要加锁
+func (c *Consistent) Inc(hostName string) error {
+	// 查找不加锁，服务器可能在查找之后被移除
+	host, ok := c.state.Load().hosts[hostName]
+	if !ok {
+		return hostError(hostName, ErrHostNotFound)
+	}
+	load := atomic.AddInt64(host.load, 1)
 	atomic.AddInt64(&c.totalLoad, 1)
+	c.metrics.ObserveLoad(hostName, load)
+	return nil
 }
-func (c *Consistent) Done(host string) {
-	c.Lock()
-	defer c.Unlock()
-
-	if _, ok := c.hosts[host]; !ok {
-		return
+func (c *Consistent) Done(hostName string) error {
+	host, ok := c.state.Load().hosts[hostName]
+	if !ok {
+		return hostError(hostName, ErrHostNotFound)
 	}
-	atomic.AddInt64(&c.hosts[host].LoadBound, -1)
+	load := atomic.AddInt64(host.load, -1)
 	atomic.AddInt64(&c.totalLoad, -1)
+	c.metrics.ObserveLoad(hostName, load)
+	if c.fallback == FallbackWait {
+		c.notifyReleased()
+	}
+	return nil
 }
-func (c *Consistent) GetLoads() map[string]int64 {
-	c.RLock()
-	defer c.RUnlock()
 
-	loads := make(map[string]int64)
-	for k, v := range c.hosts {
-		loads[k] = atomic.LoadInt64(&v.LoadBound)
+// GetLoads 有界负载使用的每台服务器的负载，设置了LoadProvider时为它提供的负载
+func (c *Consistent) GetLoads() map[string]int64 {
+	state := c.state.Load()
+	snapshot := c.loadSnapshot(state)
+	loads := make(map[string]int64, len(state.hosts))
+	for k, v := range state.hosts {
+		loads[k] = snapshot.of(v)
 	}
 	return loads
 }
-func (c *Consistent) MaxLoad() int64 {
-	if c.totalLoad == 0 {
-		c.totalLoad = 1
+
+// TotalLoad 所有服务器的负载之和，MaxLoad按它计算
+func (c *Consistent) TotalLoad() int64 {
+	if c.state.Load().loadProvider == nil {
+		return atomic.LoadInt64(&c.totalLoad)
 	}
+	return c.loadSnapshot(c.state.Load()).total
+}
 
-	var avgLoadPerNode float64
-	avgLoadPerNode = float64(c.totalLoad / int64(len(c.hosts)))
-	if avgLoadPerNode == 0 {
-		avgLoadPerNode = 1
+// SetHostCapacity 设置服务器的绝对负载上限，capacity为0表示不限制
+func (c *Consistent) SetHostCapacity(hostName string, capacity int64) error {
+	if capacity < 0 {
+		return ErrInvalidCapacity
 	}
-	avgLoadPerNode = math.Ceil(avgLoadPerNode * (1 + LoadBoundFactor))
-	return int64(avgLoadPerNode)
+
+	c.Lock()
+	defer c.Unlock()
+
+	host, ok := c.hosts[hostName]
+	if !ok {
+		return hostError(hostName, ErrHostNotFound)
+	}
+	host.Meta.Capacity = capacity
+
+	next := c.state.Load().clone()
+	next.hosts[hostName] = newHostView(host)
+	c.state.Store(next)
+	return nil
+}
+
+// DrainHost 保留服务器在环上，但不再为其分配新的请求，等待已有负载逐渐释放
+func (c *Consistent) DrainHost(hostName string) error {
+	return c.setDraining(hostName, true)
+}
+
+// Undrain 撤销DrainHost的摘除，以其他来源名义的摘除仍然有效
+func (c *Consistent) Undrain(hostName string) error {
+	return c.setDraining(hostName, false)
+}
+
+// IsDraining 服务器是否被管理接口或任一来源摘除
+func (c *Consistent) IsDraining(hostName string) bool {
+	host, ok := c.state.Load().hosts[hostName]
+	return ok && host.draining
+}
+
+// DrainHostBy 以source（如健康检查、异常检测）的名义摘除服务器，与DrainHost及其他来源的摘除分开记录，
+// 只要还有一个来源在摘除服务器就不接收新的请求
+func (c *Consistent) DrainHostBy(hostName, source string) error {
+	return c.updateDraining(hostName, func(host *Host) {
+		if !slices.Contains(host.DrainedBy, source) {
+			host.DrainedBy = append(host.DrainedBy, source)
+			sort.Strings(host.DrainedBy)
+		}
+	})
 }
 
-func (c *Consistent) searchKey(key uint64) int {
-	idx := sort.Search(len(c.ring), func(i int) bool {
-		return c.ring[i] >= key
+// UndrainBy 撤销source的摘除，不影响管理接口和其他来源的摘除
+func (c *Consistent) UndrainBy(hostName, source string) error {
+	return c.updateDraining(hostName, func(host *Host) {
+		if i := slices.Index(host.DrainedBy, source); i >= 0 {
+			host.DrainedBy = slices.Delete(slices.Clone(host.DrainedBy), i, i+1)
+		}
 	})
+}
 
-	if idx >= len(c.ring) {
-		// make search as a ring
-		idx = 0
-	}
+// IsDrainedBy 服务器是否被source摘除
+func (c *Consistent) IsDrainedBy(hostName, source string) bool {
+	c.RLock()
+	defer c.RUnlock()
 
-	return idx
+	host, ok := c.hosts[hostName]
+	return ok && slices.Contains(host.DrainedBy, source)
 }
-func (c *Consistent) checkLoadCapacity(host string) (bool, error) {
 
-	// a safety check if someone performed c.Done more than needed
-	if c.totalLoad < 0 {
-		c.totalLoad = 0
+func (c *Consistent) setDraining(hostName string, draining bool) error {
+	return c.updateDraining(hostName, func(host *Host) { host.Draining = draining })
+}
+
+func (c *Consistent) updateDraining(hostName string, update func(host *Host)) error {
+	c.Lock()
+	defer c.Unlock()
+
+	host, ok := c.hosts[hostName]
+	if !ok {
+		return hostError(hostName, ErrHostNotFound)
 	}
+	update(host)
 
-	var avgLoadPerNode float64
-	avgLoadPerNode = float64((c.totalLoad + 1) / int64(len(c.hosts)))
-	if avgLoadPerNode == 0 {
-		avgLoadPerNode = 1
+	next := c.state.Load().clone()
+	next.hosts[hostName] = newHostView(host)
+	c.state.Store(next)
+	return nil
+}
+func (c *Consistent) GetWeights() map[string]int {
+	c.RLock()
+	defer c.RUnlock()
+
+	weights := make(map[string]int)
+	for k, v := range c.hosts {
+		weights[k] = v.Weight
+	}
+	return weights
+}
+func (c *Consistent) MaxLoad() int64 {
+	state := c.state.Load()
+	if len(state.hosts) == 0 {
+		return 0
 	}
-	avgLoadPerNode = math.Ceil(avgLoadPerNode * (1 + LoadBoundFactor))
 
-	candidateHost, ok := c.hosts[host]
+	totalLoad := max(c.loadSnapshot(state).total, 1)
+	avgLoadPerNode := float64(totalLoad) / float64(len(state.hosts))
+	return int64(math.Ceil(avgLoadPerNode * (1 + state.loadFactor)))
+}
+func (c *Consistent) MaxLoadOf(hostName string) (int64, error) {
+	state := c.state.Load()
+	host, ok := state.hosts[hostName]
 	if !ok {
-		return false, ErrHostNotFound
+		return 0, hostError(hostName, ErrHostNotFound)
 	}
+	return int64(state.loadBound(host, c.loadSnapshot(state).total)), nil
+}
 
-	if float64(candidateHost.LoadBound)+1 <= avgLoadPerNode {
-		return true, nil
-	}
+func (c *Consistent) hash(key string) uint64 {
+	return c.hasher.Hash64([]byte(key))
+}
 
-	return false, nil
+// 权重越大，虚拟节点越多
+func (c *Consistent) addReplicas(s *ringState, host *Host) {
+	s.hosts[host.Name] = newHostView(host)
+	s.totalWeight += int64(host.Weight)
+	for i := 0; i < c.vnodeCount(host); i++ {
+		hashedIdx := c.hasher.Hash64(c.vnodeLabel.label(host.Name, i))
+		// 与其他虚拟节点哈希冲突时跳过，避免覆盖virt2host导致归属错乱
+		if _, ok := s.virt2host[hashedIdx]; ok {
+			continue
+		}
+		s.virt2host[hashedIdx] = host.Name
+		s.ring = append(s.ring, hashedIdx)
+	}
 }
 
-// 在环中移除某个虚拟服务器的id
-func (c *Consistent) delHashIndex(val uint64) {
-	idx := -1
-	l := 0
-	r := len(c.ring) - 1
-	for l <= r {
-		m := (l + r) / 2
-		if c.ring[m] == val {
-			idx = m
-			break
-		} else if c.ring[m] < val {
-			l = m + 1
-		} else if c.ring[m] > val {
-			r = m - 1
+// 慢启动期间没有放到环上的虚拟节点也按不属于该服务器跳过
+func (c *Consistent) removeReplicas(s *ringState, host *Host) {
+	delete(s.hosts, host.Name)
+	s.totalWeight -= int64(host.Weight)
+	for i := 0; i < c.replicaNum*host.Weight; i++ {
+		hashedIdx := c.hasher.Hash64(c.vnodeLabel.label(host.Name, i))
+		// 冲突时被跳过的虚拟节点属于其他服务器，不能删除
+		if s.virt2host[hashedIdx] != host.Name {
+			continue
 		}
+		delete(s.virt2host, hashedIdx)
+		s.delHashIndex(hashedIdx)
 	}
-	if idx != -1 {
-		c.ring = append(c.ring[:idx], c.ring[idx+1:]...)
+}
+
+// 根据已注册的服务器从头构建环，结果只取决于服务器集合与虚拟节点数量
+func (c *Consistent) buildState() *ringState {
+	names := make([]string, 0, len(c.hosts))
+	for name := range c.hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	next := newRingState()
+	next.loadFactor = c.state.Load().loadFactor
+	next.loadProvider = c.state.Load().loadProvider
+	next.pins = c.state.Load().clone().pins
+	for _, name := range names {
+		c.addReplicas(next, c.hosts[name])
 	}
+	next.sortRing()
+	return next
 }
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func newBenchRing(b testing.TB, hosts int) *Consistent {
+	c := New(100, nil)
+	for i := 0; i < hosts; i++ {
+		if err := c.RegisterHost(fmt.Sprintf("host-%d:80", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestIncDoneConcurrent(t *testing.T) {
+	c := newBenchRing(t, 4)
+	hosts := c.Hosts()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				host := hosts[(g+i)%len(hosts)]
+				_ = c.Inc(host)
+				_ = c.Done(host)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for host, load := range c.GetLoads() {
+		if load != 0 {
+			t.Fatalf("load of %s = %d, want 0", host, load)
+		}
+	}
+	if total := atomic.LoadInt64(&c.totalLoad); total != 0 {
+		t.Fatalf("total load = %d, want 0", total)
+	}
+}
+
+// 所有goroutine争用同一台服务器的计数
+func BenchmarkIncDone(b *testing.B) {
+	c := newBenchRing(b, 8)
+	host := c.Hosts()[0]
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = c.Inc(host)
+			_ = c.Done(host)
+		}
+	})
+}
+
+// 并发地查找、计数，所有goroutine共享环的快照和负载计数
+func BenchmarkGetHostCapaciousParallel(b *testing.B) {
+	c := newBenchRing(b, 8)
+	var n atomic.Int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			host, err := c.GetHostCapacious(strconv.FormatInt(n.Add(1), 10))
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			_ = c.Inc(host)
+			_ = c.Done(host)
+		}
+	})
+}
+
+// 查找的同时不断注册、注销服务器，环的写锁不应阻塞查找和计数
+func BenchmarkGetHostCapaciousWithTopologyChanges(b *testing.B) {
+	c := newBenchRing(b, 8)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_ = c.RegisterHost("flapping:80")
+			_ = c.UnregisterHost("flapping:80")
+		}
+	}()
+	var n atomic.Int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			host, err := c.GetHostCapacious(strconv.FormatInt(n.Add(1), 10))
+			if err != nil {
+				continue
+			}
+			_ = c.Inc(host)
+			_ = c.Done(host)
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+func TestGetHosts(t *testing.T) {
+	c := newBenchRing(t, 3)
+	tests := []struct {
+		name string
+		n    int
+		want int
+		err  error
+	}{
+		{"negative", -1, 0, nil},
+		{"zero", 0, 0, nil},
+		{"one", 1, 1, nil},
+		{"all hosts", 3, 3, nil},
+		{"more than hosts", 4, 0, ErrInsufficientHosts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				key := strconv.Itoa(i)
+				hosts, err := c.GetHosts(key, tt.n)
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("GetHosts(%q, %d) error = %v, want %v", key, tt.n, err, tt.err)
+				}
+				if err != nil {
+					continue
+				}
+				if len(hosts) != tt.want {
+					t.Fatalf("GetHosts(%q, %d) = %v, want %d hosts", key, tt.n, hosts, tt.want)
+				}
+				seen := make(map[string]bool)
+				for _, host := range hosts {
+					if seen[host] {
+						t.Fatalf("GetHosts(%q, %d) = %v has duplicates", key, tt.n, hosts)
+					}
+					seen[host] = true
+				}
+				if len(hosts) > 0 {
+					if owner, _ := c.GetHost(key); hosts[0] != owner {
+						t.Fatalf("GetHosts(%q, %d)[0] = %s, GetHost = %s", key, tt.n, hosts[0], owner)
+					}
+				}
+				// 更少的副本是更多副本的前缀，增加副本数不会改变已有副本的位置
+				if fewer, _ := c.GetHosts(key, tt.n-1); tt.n > 1 && !slices.Equal(fewer, hosts[:tt.n-1]) {
+					t.Fatalf("GetHosts(%q, %d) = %v is not a prefix of %v", key, tt.n-1, fewer, hosts)
+				}
+			}
+		})
+	}
+
+	if _, err := New(10, nil).GetHosts("k", 1); !errors.Is(err, ErrNoHosts) {
+		t.Fatalf("GetHosts on empty ring = %v, want ErrNoHosts", err)
+	}
+}
+
+func TestWeightedVirtualNodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+	}{
+		{"equal", map[string]int{"a": 1, "b": 1}},
+		{"one heavy", map[string]int{"a": 1, "b": 3}},
+		{"mixed", map[string]int{"a": 2, "b": 5, "c": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(200, nil)
+			total := 0
+			for host, weight := range tt.weights {
+				if err := c.RegisterHostWithWeight(host, weight); err != nil {
+					t.Fatal(err)
+				}
+				total += weight
+			}
+			// 总负载为总权重的100倍，每台服务器的有界负载上限按权重分配
+			for i := 0; i < total*100; i++ {
+				if err := c.Inc("a"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, hs := range c.Stats().Hosts {
+				weight := tt.weights[hs.Name]
+				if hs.VirtualNodes != 200*weight {
+					t.Errorf("%s: %d virtual nodes, want %d", hs.Name, hs.VirtualNodes, 200*weight)
+				}
+				want := float64(weight) / float64(total)
+				if hs.Ownership < want*0.7 || hs.Ownership > want*1.3 {
+					t.Errorf("%s: ownership %.3f, want about %.3f", hs.Name, hs.Ownership, want)
+				}
+				bound, err := c.MaxLoadOf(hs.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := int64(math.Ceil(float64(weight*100) * (1 + c.LoadFactor()))); bound != want {
+					t.Errorf("%s: max load %d, want %d", hs.Name, bound, want)
+				}
+			}
+		})
+	}
+
+	c := New(10, nil)
+	for _, weight := range []int{0, -1} {
+		if err := c.RegisterHostWithWeight("a", weight); !errors.Is(err, ErrInvalidWeight) {
+			t.Fatalf("RegisterHostWithWeight(%d) = %v, want ErrInvalidWeight", weight, err)
+		}
+	}
+}
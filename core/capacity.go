@@ -0,0 +1,46 @@
+package core
+
+type HostCapacity struct {
+	Load    int64 `json:"load"`
+	MaxLoad int64 `json:"max_load"`
+	// 还能接受的请求数，大于0时GetHostCapacious才会选中该服务器
+	Headroom int64 `json:"headroom"`
+}
+
+type Capacity struct {
+	TotalLoad int64                   `json:"total_load"`
+	MaxLoad   int64                   `json:"max_load"`
+	Hosts     map[string]HostCapacity `json:"hosts"`
+}
+
+// Capacity 返回整体与每台服务器的容量上限和剩余空间，可用于准入控制
+func (c *Consistent) Capacity() Capacity {
+	maxLoad := c.MaxLoad()
+
+	c.RLock()
+	defer c.RUnlock()
+
+	state := c.state.Load()
+	loads := c.loadSnapshot(state)
+	totalLoad := loads.total
+	capacity := Capacity{
+		TotalLoad: totalLoad,
+		MaxLoad:   maxLoad,
+		Hosts:     make(map[string]HostCapacity, len(state.hosts)),
+	}
+	for name, h := range state.hosts {
+		// 与checkLoadCapacity一致，按再接受一个请求后的总负载计算上限
+		load := loads.of(h)
+		bound := int64(state.loadBound(h, totalLoad+1))
+		headroom := bound - load
+		if headroom < 0 {
+			headroom = 0
+		}
+		capacity.Hosts[name] = HostCapacity{
+			Load:     load,
+			MaxLoad:  bound,
+			Headroom: headroom,
+		}
+	}
+	return capacity
+}
@@ -0,0 +1,171 @@
+package core
+
+import "math/rand"
+
+// SkipMoreLoaded 有界选择中负载率高于被选中服务器的候选
+const SkipMoreLoaded = "more_loaded"
+
+// choice 有界选择沿环遇到的一台服务器，以及key最先遇到的它的虚拟节点
+type choice struct {
+	host     string
+	point    uint64
+	draining bool
+}
+
+// GetHostLeastLoaded 有界选择（bounded choices）：沿环取key之后最近的k台未被摘除的服务器，选出其中按权重的负载率最低的一台，
+// 相同时选离key最近的；达到绝对容量的服务器排在最后。k为1时与跳过摘除服务器的GetHost相同，k越大负载越均衡、key越分散。
+// 不受负载上限约束，只要有未被摘除的服务器就能选出；请求前后仍需调用Inc、Done
+func (c *Consistent) GetHostLeastLoaded(key string, k int) (string, error) {
+	host, err := c.getHostLeastLoaded(key, k)
+	c.metrics.ObserveLookup(host, err)
+	return host, err
+}
+
+func (c *Consistent) getHostLeastLoaded(key string, k int) (string, error) {
+	if k <= 0 {
+		return "", ErrInvalidChoices
+	}
+	state := c.state.Load()
+	if len(state.ring) == 0 {
+		return "", ErrNoHosts
+	}
+	if host, ok := state.pins[key]; ok {
+		return host, nil
+	}
+
+	visited := state.choices(c.hash(key), k)
+	best := state.leastLoaded(visited, c.loadSnapshot(state))
+	if best < 0 {
+		return "", ErrAllHostsOverloaded
+	}
+	return visited[best].host, nil
+}
+
+// choices 从hashedKey开始沿环按顺序记录遇到的服务器，直到有k台未被摘除的服务器
+func (s *ringState) choices(hashedKey uint64, k int) []choice {
+	visited := make([]choice, 0, k)
+	seen := make(map[string]struct{}, k)
+	i := s.search(hashedKey)
+	for step, n := 0, 0; step < len(s.ring) && n < k; step++ {
+		point := s.ring[i]
+		host := s.virt2host[point]
+		if _, ok := seen[host]; !ok {
+			seen[host] = struct{}{}
+			draining := s.hosts[host].draining
+			if !draining {
+				n++
+			}
+			visited = append(visited, choice{host: host, point: point, draining: draining})
+		}
+		if i++; i >= len(s.ring) {
+			i = 0
+		}
+	}
+	return visited
+}
+
+// leastLoaded 未被摘除的服务器中负载率最低的下标，靠前的优先；都被摘除时返回-1
+func (s *ringState) leastLoaded(visited []choice, loads loadSnapshot) int {
+	best := -1
+	var (
+		bestFull  bool
+		bestRatio float64
+	)
+	for i, ch := range visited {
+		if ch.draining {
+			continue
+		}
+		full, ratio := s.choiceLoad(ch.host, loads)
+		if best < 0 || (!full && bestFull) || (full == bestFull && ratio < bestRatio) {
+			best, bestFull, bestRatio = i, full, ratio
+		}
+	}
+	return best
+}
+
+// GetHostTwoChoices 不按key选择（power of two random choices）：随机取两台未被摘除的服务器，选负载率较低的一台，
+// 比较规则与GetHostLeastLoaded相同。按虚拟节点随机，权重大的服务器被选中的机会更多；请求前后仍需调用Inc、Done
+func (c *Consistent) GetHostTwoChoices() (string, error) {
+	host, err := c.getHostTwoChoices()
+	c.metrics.ObserveLookup(host, err)
+	return host, err
+}
+
+func (c *Consistent) getHostTwoChoices() (string, error) {
+	state := c.state.Load()
+	if len(state.ring) == 0 {
+		return "", ErrNoHosts
+	}
+	first, ok := state.randomHost("")
+	if !ok {
+		return "", ErrAllHostsOverloaded
+	}
+	second, ok := state.randomHost(first)
+	if !ok {
+		return first, nil
+	}
+	picked := []choice{{host: first}, {host: second}}
+	return picked[state.leastLoaded(picked, c.loadSnapshot(state))].host, nil
+}
+
+// randomHost 从环上随机的位置开始，沿环找到第一台未被摘除、且不是except的服务器
+func (s *ringState) randomHost(except string) (string, bool) {
+	i := rand.Intn(len(s.ring))
+	for step := 0; step < len(s.ring); step++ {
+		host := s.virt2host[s.ring[i]]
+		if host != except && !s.hosts[host].draining {
+			return host, true
+		}
+		if i++; i >= len(s.ring) {
+			i = 0
+		}
+	}
+	return "", false
+}
+
+// choiceLoad 服务器是否达到绝对容量，以及按权重的负载率
+func (s *ringState) choiceLoad(host string, loads loadSnapshot) (bool, float64) {
+	view := s.hosts[host]
+	load := loads.of(view)
+	return view.capacity > 0 && load >= view.capacity, float64(load) / float64(view.weight)
+}
+
+// ExplainLeastLoaded 不增加负载地重演一次GetHostLeastLoaded：Skipped中为沿途摘除中的服务器和负载率更高的候选
+func (c *Consistent) ExplainLeastLoaded(key string, k int) (Explanation, error) {
+	if k <= 0 {
+		return Explanation{}, ErrInvalidChoices
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	state := c.state.Load()
+	ex := Explanation{Key: key, Hash: c.hash(key)}
+	if len(state.ring) == 0 {
+		return ex, ErrNoHosts
+	}
+	loads := c.loadSnapshot(state)
+
+	if host, ok := state.pins[key]; ok {
+		ex.Pinned = true
+		c.explainHost(&ex, state, host, loads)
+		return ex, nil
+	}
+
+	visited := state.choices(ex.Hash, k)
+	best := state.leastLoaded(visited, loads)
+	for i, ch := range visited {
+		switch {
+		case ch.draining:
+			ex.Skipped = append(ex.Skipped, c.skippedHost(state, ch.host, SkipDraining, loads))
+		case i != best:
+			ex.Skipped = append(ex.Skipped, c.skippedHost(state, ch.host, SkipMoreLoaded, loads))
+		}
+	}
+	if best < 0 {
+		return ex, ErrAllHostsOverloaded
+	}
+	chosen := visited[best]
+	ex.VirtualNode = c.virtualNode(chosen.point, chosen.host, state.hosts[chosen.host].weight)
+	c.explainHost(&ex, state, chosen.host, loads)
+	return ex, nil
+}
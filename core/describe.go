@@ -0,0 +1,50 @@
+package core
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+type describePoint struct {
+	Hash  uint64 `json:"hash"`
+	Host  string `json:"host"`
+	Range Range  `json:"range"`
+}
+
+type describeHost struct {
+	Name   string  `json:"name"`
+	Ranges []Range `json:"ranges"`
+}
+
+type description struct {
+	Points []describePoint `json:"points"`
+	Hosts  []describeHost  `json:"hosts"`
+}
+
+// Describe 以JSON描述整个环：按顺序排列的虚拟节点、归属服务器及其负责的区间，便于可视化
+func (c *Consistent) Describe() ([]byte, error) {
+	state := c.state.Load()
+
+	d := description{
+		Points: make([]describePoint, 0, len(state.ring)),
+		Hosts:  make([]describeHost, 0, len(state.hosts)),
+	}
+	for i, point := range state.ring {
+		prev := state.ring[(i+len(state.ring)-1)%len(state.ring)]
+		d.Points = append(d.Points, describePoint{
+			Hash:  point,
+			Host:  state.virt2host[point],
+			Range: Range{Start: prev, End: point},
+		})
+	}
+	for name := range state.hosts {
+		d.Hosts = append(d.Hosts, describeHost{
+			Name:   name,
+			Ranges: state.ownedRanges(name),
+		})
+	}
+	sort.Slice(d.Hosts, func(i, j int) bool {
+		return d.Hosts[i].Name < d.Hosts[j].Name
+	})
+	return json.Marshal(d)
+}
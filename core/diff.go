@@ -0,0 +1,19 @@
+package core
+
+// MovedKeyspace 两个环状态之间归属发生变化的哈希区间
+type MovedKeyspace struct {
+	Moves []Migration
+	// 理论上需要迁移的key占比
+	Fraction float64
+}
+
+// Diff 比较拓扑变化前后的两个环，用于验证计划中的扩缩容是否只移动了最少的key
+func Diff(before, after *Consistent) MovedKeyspace {
+	moves := diffRing(before.state.Load(), after.state.Load())
+
+	moved := MovedKeyspace{Moves: moves}
+	for _, m := range moves {
+		moved.Fraction += m.Range.Fraction()
+	}
+	return moved
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func ringOf(t *testing.T, weights map[string]int) *Consistent {
+	t.Helper()
+	c := New(100, nil)
+	for host, weight := range weights {
+		must(t, c.RegisterHostWithWeight(host, weight))
+	}
+	return c
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after map[string]int
+		// 理论上迁移的key占比
+		fraction float64
+	}{
+		{"unchanged", map[string]int{"a": 1, "b": 1}, map[string]int{"a": 1, "b": 1}, 0},
+		{"add one of four", map[string]int{"a": 1, "b": 1, "c": 1}, map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}, 0.25},
+		{"remove one of four", map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}, map[string]int{"a": 1, "b": 1, "c": 1}, 0.25},
+		{"double a weight", map[string]int{"a": 1, "b": 1}, map[string]int{"a": 2, "b": 1}, 1.0 / 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := ringOf(t, tt.before), ringOf(t, tt.after)
+			moved := Diff(before, after)
+			if math.Abs(moved.Fraction-tt.fraction) > 0.08 {
+				t.Fatalf("Fraction = %.3f, want about %.3f", moved.Fraction, tt.fraction)
+			}
+
+			// 归属变化的key正好落在迁移的区间内，且From、To与两个环的结果一致
+			changed := 0
+			const keys = 10000
+			for i := 0; i < keys; i++ {
+				key := strconv.Itoa(i)
+				from, _ := before.GetHost(key)
+				to, _ := after.GetHost(key)
+				h := before.hash(key)
+				var found *Migration
+				for j := range moved.Moves {
+					if moved.Moves[j].Range.Contains(h) {
+						found = &moved.Moves[j]
+						break
+					}
+				}
+				if (found != nil) != (from != to) {
+					t.Fatalf("key %s: %s -> %s, in moved range %v", key, from, to, found != nil)
+				}
+				if found != nil {
+					changed++
+					if found.From != from || found.To != to {
+						t.Fatalf("key %s in range %s -> %s, actually %s -> %s", key, found.From, found.To, from, to)
+					}
+				}
+			}
+			if got := float64(changed) / keys; math.Abs(got-moved.Fraction) > 0.03 {
+				t.Fatalf("%.3f of keys moved, Fraction = %.3f", got, moved.Fraction)
+			}
+		})
+	}
+}
+
+func TestDiffEmptyRing(t *testing.T) {
+	empty, ring := New(10, nil), ringOf(t, map[string]int{"a": 1})
+	for _, moved := range []MovedKeyspace{Diff(empty, ring), Diff(ring, empty), Diff(empty, empty)} {
+		if len(moved.Moves) != 0 || moved.Fraction != 0 {
+			t.Fatalf("Diff with an empty ring = %+v", moved)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// Package core 一致性哈希算法库：带虚拟节点和权重的哈希环、有界负载查找、副本放置，以及Maglev、跳跃哈希、固定槽位等其他算法
+//
+// 对外稳定的接口是Ring，由New返回的*Consistent实现：
+//
+//	var ring core.Ring = core.New(100, core.XXHasher{})
+//	_ = ring.RegisterHost("10.0.0.1:8080")
+//	host, err := ring.Get("user-1")
+//
+// core只依赖哈希函数，不包含HTTP服务等代码
+package core
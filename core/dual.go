@@ -0,0 +1,92 @@
+package core
+
+import "sync"
+
+// DualRing 计划迁移期间同时查询当前拓扑与目标拓扑，按哈希区间逐步切换到目标拓扑
+type DualRing struct {
+	current *Consistent
+	target  *Consistent
+	// 已经切换到目标拓扑的区间
+	cutover []Range
+	sync.RWMutex
+}
+
+// DualOwners key在两个拓扑中的归属，Primary为当前应该读取的服务器
+type DualOwners struct {
+	Current string
+	Target  string
+	Primary string
+}
+
+// NewDualRing 两个环需使用相同的Hasher
+func NewDualRing(current, target *Consistent) *DualRing {
+	return &DualRing{
+		current: current,
+		target:  target,
+		cutover: make([]Range, 0),
+	}
+}
+
+// Lookup 返回key在两个拓扑中的归属，两者不同时调用方应双写
+func (d *DualRing) Lookup(key string) (DualOwners, error) {
+	current, err := d.current.GetHost(key)
+	if err != nil {
+		return DualOwners{}, err
+	}
+	target, err := d.target.GetHost(key)
+	if err != nil {
+		return DualOwners{}, err
+	}
+
+	owners := DualOwners{
+		Current: current,
+		Target:  target,
+		Primary: current,
+	}
+	if d.isCutover(d.current.hash(key)) {
+		owners.Primary = target
+	}
+	return owners, nil
+}
+
+func (d *DualRing) GetHost(key string) (string, error) {
+	owners, err := d.Lookup(key)
+	if err != nil {
+		return "", err
+	}
+	return owners.Primary, nil
+}
+
+// Plan 返回两个拓扑之间需要迁移的全部区间
+func (d *DualRing) Plan() MovedKeyspace {
+	return Diff(d.current, d.target)
+}
+
+// CutOver 将区间r内的key切换为从目标拓扑读取
+func (d *DualRing) CutOver(r Range) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.cutover = append(d.cutover, r)
+}
+
+// Complete 迁移完成，返回目标拓扑，之后应直接使用它
+func (d *DualRing) Complete() *Consistent {
+	d.Lock()
+	defer d.Unlock()
+
+	d.cutover = []Range{{Start: 0, End: 0}}
+	return d.target
+}
+
+func (d *DualRing) isCutover(h uint64) bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	for _, r := range d.cutover {
+		if r.Contains(h) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDualRingCutOver(t *testing.T) {
+	current := ringOf(t, map[string]int{"a": 1, "b": 1, "c": 1})
+	target := ringOf(t, map[string]int{"a": 1, "b": 1, "c": 1, "d": 1})
+	d := NewDualRing(current, target)
+	plan := d.Plan()
+	if len(plan.Moves) < 2 {
+		t.Fatalf("plan has %d moves, want several", len(plan.Moves))
+	}
+	half := plan.Moves[:len(plan.Moves)/2]
+
+	tests := []struct {
+		name string
+		// 在该步骤之前切换的区间
+		cutover  []Migration
+		complete bool
+		// key是否应从目标拓扑读取
+		fromTarget func(h uint64) bool
+	}{
+		{"before cutover", nil, false, func(uint64) bool { return false }},
+		{"half cut over", half, false, func(h uint64) bool {
+			for _, m := range half {
+				if m.Range.Contains(h) {
+					return true
+				}
+			}
+			return false
+		}},
+		{"complete", nil, true, func(uint64) bool { return true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, m := range tt.cutover {
+				d.CutOver(m.Range)
+			}
+			if tt.complete && d.Complete() != target {
+				t.Fatal("Complete did not return the target ring")
+			}
+			for i := 0; i < 2000; i++ {
+				key := strconv.Itoa(i)
+				owners, err := d.Lookup(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				wantCurrent, _ := current.GetHost(key)
+				wantTarget, _ := target.GetHost(key)
+				if owners.Current != wantCurrent || owners.Target != wantTarget {
+					t.Fatalf("Lookup(%q) = %+v, want current %s, target %s", key, owners, wantCurrent, wantTarget)
+				}
+				want := wantCurrent
+				if tt.fromTarget(current.hash(key)) {
+					want = wantTarget
+				}
+				if host, _ := d.GetHost(key); owners.Primary != want || host != want {
+					t.Fatalf("Lookup(%q).Primary = %s, GetHost = %s, want %s", key, owners.Primary, host, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDualRingEmpty(t *testing.T) {
+	d := NewDualRing(New(10, nil), ringOf(t, map[string]int{"a": 1}))
+	if _, err := d.Lookup("k"); err == nil {
+		t.Fatal("Lookup succeeded with an empty current ring")
+	}
+}
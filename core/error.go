@@ -1,8 +1,54 @@
 package core
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrHostAlreadyExists   = errors.New("host already exists")
+	ErrHostNotFound        = errors.New("host not found")
+	ErrNoHosts             = errors.New("no hosts registered")
+	ErrAllHostsOverloaded  = errors.New("all hosts are overloaded")
+	ErrInsufficientHosts   = errors.New("not enough hosts")
+	ErrInvalidWeight       = errors.New("invalid host weight")
+	ErrInvalidLoadFactor   = errors.New("load factor must be positive")
+	ErrInvalidReplicaCount = errors.New("replica count must be positive")
+	ErrInvalidCapacity     = errors.New("capacity must not be negative")
+	ErrInvalidTTL          = errors.New("ttl must not be negative")
+	ErrInvalidSlowStart    = errors.New("slow start window must not be negative")
+	ErrNoTTL               = errors.New("host has no ttl")
+	ErrUnknownHasher       = errors.New("unknown hasher")
+	ErrSnapshotVersion     = errors.New("unsupported snapshot version")
+	ErrStaleVersion        = errors.New("topology version is no longer available")
+	ErrInvalidLoad         = errors.New("load must not be negative")
+	ErrStaleLoad           = errors.New("load report is too old")
+	ErrInvalidChoices      = errors.New("number of choices must be positive")
+	ErrInvalidSlotRange    = errors.New("invalid slot range")
+)
 
+// 同一错误的别名，errors.Is(err, ErrRingEmpty)与errors.Is(err, ErrNoHosts)等价
 var (
-	ErrHostAlreadyExists = errors.New("host already exists")
-	ErrHostNotFound      = errors.New("host not found")
+	// ErrRingEmpty 环上没有可用的服务器
+	ErrRingEmpty = ErrNoHosts
+	// ErrHostOverloaded 有界负载查找时所有候选服务器都已超载
+	ErrHostOverloaded = ErrAllHostsOverloaded
 )
+
+// HostError 与具体服务器相关的错误，可以用 errors.Is 判断其中的哨兵错误
+type HostError struct {
+	Host string
+	Err  error
+}
+
+func (e *HostError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Host, e.Err)
+}
+
+func (e *HostError) Unwrap() error {
+	return e.Err
+}
+
+func hostError(host string, err error) error {
+	return &HostError{Host: host, Err: err}
+}
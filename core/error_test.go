@@ -0,0 +1,26 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorAliases(t *testing.T) {
+	c := New(10, nil)
+	if _, err := c.GetHost("k"); !errors.Is(err, ErrRingEmpty) {
+		t.Fatalf("GetHost on empty ring = %v, want ErrRingEmpty", err)
+	}
+
+	if err := c.RegisterHost("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetHostCapacity("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Inc("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetHostCapacious("k"); !errors.Is(err, ErrHostOverloaded) {
+		t.Fatalf("GetHostCapacious on overloaded ring = %v, want ErrHostOverloaded", err)
+	}
+}
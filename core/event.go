@@ -0,0 +1,73 @@
+package core
+
+type EventType int
+
+const (
+	HostAdded EventType = iota
+	HostRemoved
+	WeightChanged
+	// 慢启动中的服务器增加了虚拟节点，权重不变；key会在服务器之间迁移
+	WarmupProgress
+)
+
+func (t EventType) String() string {
+	switch t {
+	case HostAdded:
+		return "HostAdded"
+	case HostRemoved:
+		return "HostRemoved"
+	case WeightChanged:
+		return "WeightChanged"
+	case WarmupProgress:
+		return "WarmupProgress"
+	}
+	return "Unknown"
+}
+
+type TopologyEvent struct {
+	Type   EventType
+	Host   string
+	Weight int
+	// WarmupProgress事件中放到环上的虚拟节点比例，慢启动完成时为1
+	Warmup float64
+	// 事件发生后拓扑的版本号
+	Version uint64
+}
+
+const subscriberBufferSize = 64
+
+// Subscribe 订阅拓扑变化事件。订阅者消费过慢、缓冲区已满时事件会被丢弃，不会阻塞环的写操作
+func (c *Consistent) Subscribe() <-chan TopologyEvent {
+	c.Lock()
+	defer c.Unlock()
+
+	ch := make(chan TopologyEvent, subscriberBufferSize)
+	c.subscribers = append(c.subscribers, ch)
+	return ch
+}
+
+func (c *Consistent) Unsubscribe(sub <-chan TopologyEvent) {
+	c.Lock()
+	defer c.Unlock()
+
+	for i, ch := range c.subscribers {
+		if ch == sub {
+			close(ch)
+			c.subscribers = append(c.subscribers[:i], c.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// 需要持有写锁
+func (c *Consistent) publish(ev TopologyEvent) {
+	ev = c.recordLocked(ev)
+	c.metrics.ObserveTopology(ev, len(c.hosts))
+	c.logger.Debug("topology changed", "event", ev.Type.String(), "host", ev.Host, "weight", ev.Weight, "hosts", len(c.hosts), "version", ev.Version)
+	for _, ch := range c.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
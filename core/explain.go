@@ -0,0 +1,137 @@
+package core
+
+import "math"
+
+// 查找时跳过服务器的原因
+const (
+	SkipDraining   = "draining"
+	SkipOverloaded = "overloaded"
+)
+
+// SkippedHost 沿环查找时跳过的服务器
+type SkippedHost struct {
+	Host    string `json:"host"`
+	Reason  string `json:"reason"`
+	Load    int64  `json:"load"`
+	MaxLoad int64  `json:"max_load"`
+}
+
+// VirtualNode 环上的一个虚拟节点：服务器的第Index个副本，哈希值为Hash
+type VirtualNode struct {
+	Hash  uint64 `json:"hash"`
+	Host  string `json:"host"`
+	Index int    `json:"index"`
+}
+
+// Explanation 一次查找的过程，用于排查key为什么落在某台服务器
+type Explanation struct {
+	Key  string `json:"key"`
+	Hash uint64 `json:"hash"`
+	// 手动固定的key不经过环，VirtualNode为nil
+	Pinned      bool         `json:"pinned,omitempty"`
+	VirtualNode *VirtualNode `json:"virtual_node,omitempty"`
+	Host        string       `json:"host"`
+	// 服务器当前的负载和再接受一个请求时的上限，与Capacity一致
+	Load     int64 `json:"load"`
+	MaxLoad  int64 `json:"max_load"`
+	Draining bool  `json:"draining,omitempty"`
+	// 按遇到的顺序记录跳过的服务器，同一台服务器只记录一次
+	Skipped []SkippedHost `json:"skipped,omitempty"`
+	// 所有服务器都满时按FallbackLeastLoaded选择了负载率最低的服务器
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// Explain 不增加负载地重演一次查找。capacious为false时与代理的普通模式一致，只跳过摘除中的服务器
+// （GetHost本身不跳过）；为true时与GetHostCapacious一致，还会跳过负载达到上限的服务器
+// 所有服务器都不可用时返回ErrAllHostsOverloaded，此时Explanation中仍有跳过的服务器
+func (c *Consistent) Explain(key string, capacious bool) (Explanation, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	state := c.state.Load()
+	ex := Explanation{Key: key, Hash: c.hash(key)}
+	if len(state.ring) == 0 {
+		return ex, ErrNoHosts
+	}
+	loads := c.loadSnapshot(state)
+
+	if host, ok := state.pins[key]; ok {
+		ex.Pinned = true
+		c.explainHost(&ex, state, host, loads)
+		return ex, nil
+	}
+
+	var leastLoaded string
+	leastRatio := math.Inf(1)
+	skipped := make(map[string]bool)
+	i := state.search(ex.Hash)
+	for step := 0; step < len(state.ring); step++ {
+		point := state.ring[i]
+		host := state.virt2host[point]
+		view := state.hosts[host]
+
+		reason := ""
+		switch {
+		case view.draining:
+			reason = SkipDraining
+		case capacious:
+			if ok, _ := state.checkLoadCapacity(host, loads); !ok {
+				reason = SkipOverloaded
+			}
+		}
+		if reason == "" {
+			ex.VirtualNode = c.virtualNode(point, host, view.weight)
+			c.explainHost(&ex, state, host, loads)
+			return ex, nil
+		}
+
+		if !skipped[host] {
+			skipped[host] = true
+			ex.Skipped = append(ex.Skipped, c.skippedHost(state, host, reason, loads))
+		}
+		if ratio := float64(loads.of(view)) / float64(view.weight); !view.draining && ratio < leastRatio {
+			leastLoaded, leastRatio = host, ratio
+		}
+		if i++; i >= len(state.ring) {
+			i = 0
+		}
+	}
+
+	if capacious && c.fallback == FallbackLeastLoaded && leastLoaded != "" {
+		ex.Fallback = true
+		c.explainHost(&ex, state, leastLoaded, loads)
+		return ex, nil
+	}
+	return ex, ErrAllHostsOverloaded
+}
+
+func (c *Consistent) explainHost(ex *Explanation, state *ringState, host string, loads loadSnapshot) {
+	ex.Host = host
+	if view, ok := state.hosts[host]; ok {
+		ex.Load = loads.of(view)
+		ex.MaxLoad = int64(state.loadBound(view, loads.total+1))
+		ex.Draining = view.draining
+	}
+}
+
+func (c *Consistent) skippedHost(state *ringState, host, reason string, loads loadSnapshot) SkippedHost {
+	view := state.hosts[host]
+	return SkippedHost{
+		Host:    host,
+		Reason:  reason,
+		Load:    loads.of(view),
+		MaxLoad: int64(state.loadBound(view, loads.total+1)),
+	}
+}
+
+// virtualNode 按虚拟节点的命名方式找出哈希值为point的副本序号
+func (c *Consistent) virtualNode(point uint64, host string, weight int) *VirtualNode {
+	vn := &VirtualNode{Hash: point, Host: host, Index: -1}
+	for i := 0; i < c.replicaNum*weight; i++ {
+		if c.hasher.Hash64(c.vnodeLabel.label(host, i)) == point {
+			vn.Index = i
+			break
+		}
+	}
+	return vn
+}
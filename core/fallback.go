@@ -0,0 +1,29 @@
+package core
+
+// FallbackPolicy 所有服务器都超出有界负载时GetHostCapacious的行为
+type FallbackPolicy int
+
+const (
+	// FallbackError 返回ErrAllHostsOverloaded
+	FallbackError FallbackPolicy = iota
+	// FallbackLeastLoaded 返回负载率（负载/权重）最低的服务器
+	FallbackLeastLoaded
+	// FallbackWait 等待负载释放，超时后返回ErrAllHostsOverloaded
+	FallbackWait
+)
+
+// 返回的channel会在下一次释放负载时被关闭
+func (c *Consistent) loadReleased() <-chan struct{} {
+	c.releaseMu.Lock()
+	defer c.releaseMu.Unlock()
+
+	return c.released
+}
+
+func (c *Consistent) notifyReleased() {
+	c.releaseMu.Lock()
+	defer c.releaseMu.Unlock()
+
+	close(c.released)
+	c.released = make(chan struct{})
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/spaolacci/murmur3"
+)
+
+// Hasher 将key映射到哈希环上的位置
+type Hasher interface {
+	Hash64(key []byte) uint64
+}
+
+// HasherFunc 让普通函数也可以作为Hasher使用
+type HasherFunc func(key []byte) uint64
+
+func (f HasherFunc) Hash64(key []byte) uint64 {
+	return f(key)
+}
+
+type SHA512Hasher struct{}
+
+func (SHA512Hasher) Hash64(key []byte) uint64 {
+	out := sha512.Sum512(key)
+	return binary.LittleEndian.Uint64(out[:])
+}
+
+type XXHasher struct{}
+
+func (XXHasher) Hash64(key []byte) uint64 {
+	return xxhash.Sum64(key)
+}
+
+type Murmur3Hasher struct{}
+
+func (Murmur3Hasher) Hash64(key []byte) uint64 {
+	return murmur3.Sum64(key)
+}
+
+// FNV1aHasher 速度快，但相似的key（如同一服务器的虚拟节点标签）散列得不够均匀，分布不如其他Hasher
+type FNV1aHasher struct{}
+
+func (FNV1aHasher) Hash64(key []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return h.Sum64()
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHashers(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher Hasher
+		// 空key的哈希值，与各算法的参考实现一致
+		empty uint64
+		// fnv1a对只有末尾不同的虚拟节点标签散列得不够均匀，不检查分布
+		balanced bool
+	}{
+		{"sha512", SHA512Hasher{}, 0xbdb8ef7e35e183cf, true},
+		{"xxhash", XXHasher{}, 0xef46db3751d8e999, true},
+		{"murmur3", Murmur3Hasher{}, 0, true},
+		{"fnv1a", FNV1aHasher{}, 0xcbf29ce484222325, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if h := tt.hasher.Hash64(nil); h != tt.empty {
+				t.Fatalf("Hash64(\"\") = %#x, want %#x", h, tt.empty)
+			}
+			byName, ok := HasherByName(tt.name)
+			if !ok || byName != tt.hasher {
+				t.Fatalf("HasherByName(%q) = %v, %v", tt.name, byName, ok)
+			}
+			if !tt.balanced {
+				return
+			}
+
+			// 每台服务器分到的key不应偏离平均值太多
+			c := New(100, tt.hasher)
+			for i := 0; i < 4; i++ {
+				if err := c.RegisterHost("10.0.0." + strconv.Itoa(i) + ":80"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			counts := make(map[string]int)
+			for i := 0; i < 10000; i++ {
+				host, err := c.GetHost("key-" + strconv.Itoa(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				counts[host]++
+			}
+			for host, n := range counts {
+				if n < 1500 || n > 3500 {
+					t.Errorf("%s got %d of 10000 keys", host, n)
+				}
+			}
+		})
+	}
+
+	if _, ok := HasherByName("md5"); ok {
+		t.Fatal("HasherByName accepted an unknown name")
+	}
+	f := HasherFunc(func(key []byte) uint64 { return uint64(len(key)) })
+	if h := f.Hash64([]byte("abc")); h != 3 {
+		t.Fatalf("HasherFunc.Hash64 = %d, want 3", h)
+	}
+}
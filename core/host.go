@@ -1,8 +1,54 @@
 package core
 
+import "sync/atomic"
+
 type Host struct {
 	// host id: ip:port
 	Name string
+	// 权重，决定虚拟节点数量与容量
+	Weight int
 	// 服务器容量限制
 	LoadBound int64
+	// 服务器所在机房、可用区等信息
+	Meta Metadata
+	// 摘除中：仍在环上，但GetHostCapacious不再把新的key路由过来；通过DrainHost（管理接口）设置，会写入快照
+	Draining bool
+	// 以各自名义摘除服务器的来源（健康检查、异常检测等），只有发起摘除的来源才能撤销，不写入快照
+	DrainedBy []string
+}
+
+// draining 管理接口或任一来源的摘除都生效
+func (h *Host) draining() bool {
+	return h.Draining || len(h.DrainedBy) > 0
+}
+
+type Metadata struct {
+	Datacenter string `json:"datacenter,omitempty"`
+	Zone       string `json:"zone,omitempty"`
+	// 绝对负载上限，0表示只受平均负载限制
+	Capacity int64             `json:"capacity,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+func (m Metadata) clone() Metadata {
+	if m.Tags != nil {
+		tags := make(map[string]string, len(m.Tags))
+		for k, v := range m.Tags {
+			tags[k] = v
+		}
+		m.Tags = tags
+	}
+	return m
+}
+
+// 返回副本，调用方修改不会影响环内的状态
+func (h *Host) info() Host {
+	return Host{
+		Name:      h.Name,
+		Weight:    h.Weight,
+		LoadBound: atomic.LoadInt64(&h.LoadBound),
+		Meta:      h.Meta.clone(),
+		Draining:  h.Draining,
+		DrainedBy: append([]string(nil), h.DrainedBy...),
+	}
 }
@@ -0,0 +1,114 @@
+package core
+
+import "sync"
+
+// Jump 跳跃一致性哈希，适用于编号连续的桶，无需维护哈希环，内存占用极小
+type Jump struct {
+	buckets int
+	hasher  Hasher
+}
+
+func NewJump(buckets int) *Jump {
+	if buckets <= 0 {
+		buckets = 1
+	}
+
+	return &Jump{
+		buckets: buckets,
+		hasher:  defaultHasher,
+	}
+}
+
+func (j *Jump) Buckets() int {
+	return j.buckets
+}
+
+func (j *Jump) GetBucket(key string) int {
+	return int(jumpHash(j.hasher.Hash64([]byte(key)), j.buckets))
+}
+
+// Lamping & Veach, "A Fast, Minimal Memory, Consistent Hash Algorithm"
+func jumpHash(key uint64, buckets int) int32 {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
+
+// JumpHosts 将跳跃哈希的桶编号映射为已注册的服务器
+type JumpHosts struct {
+	hasher Hasher
+	// 桶编号 -> 服务器
+	hosts []string
+	sync.RWMutex
+}
+
+var _ Picker = (*JumpHosts)(nil)
+
+func NewJumpHosts(hasher Hasher) *JumpHosts {
+	if hasher == nil {
+		hasher = defaultHasher
+	}
+
+	return &JumpHosts{
+		hasher: hasher,
+		hosts:  make([]string, 0),
+	}
+}
+
+func (j *JumpHosts) RegisterHost(hostName string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.indexOf(hostName) != -1 {
+		return hostError(hostName, ErrHostAlreadyExists)
+	}
+	j.hosts = append(j.hosts, hostName)
+	return nil
+}
+
+// 跳跃哈希只能平滑地移除最后一个桶，移除中间的服务器时用最后一个服务器填补其编号
+func (j *JumpHosts) UnregisterHost(hostName string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	idx := j.indexOf(hostName)
+	if idx == -1 {
+		return hostError(hostName, ErrHostNotFound)
+	}
+	last := len(j.hosts) - 1
+	j.hosts[idx] = j.hosts[last]
+	j.hosts = j.hosts[:last]
+	return nil
+}
+
+func (j *JumpHosts) GetHost(key string) (string, error) {
+	j.RLock()
+	defer j.RUnlock()
+
+	if len(j.hosts) == 0 {
+		return "", ErrNoHosts
+	}
+	return j.hosts[jumpHash(j.hasher.Hash64([]byte(key)), len(j.hosts))], nil
+}
+
+func (j *JumpHosts) Hosts() []string {
+	j.RLock()
+	defer j.RUnlock()
+
+	hosts := make([]string, len(j.hosts))
+	copy(hosts, j.hosts)
+	return hosts
+}
+
+func (j *JumpHosts) indexOf(hostName string) int {
+	for i, h := range j.hosts {
+		if h == hostName {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// 与论文的参考实现一致的结果
+func TestJumpHashVectors(t *testing.T) {
+	tests := []struct {
+		key     uint64
+		buckets int
+		want    int32
+	}{
+		{1, 1, 0},
+		{42, 57, 43},
+		{0xDEAD10CC, 1, 0},
+		{0xDEAD10CC, 666, 361},
+		{256, 1024, 520},
+	}
+	for _, tt := range tests {
+		if got := jumpHash(tt.key, tt.buckets); got != tt.want {
+			t.Errorf("jumpHash(%d, %d) = %d, want %d", tt.key, tt.buckets, got, tt.want)
+		}
+	}
+}
+
+// 桶数从n增加到n+1时，key只会移动到新增的桶
+func TestJumpGrowthMovesOnlyToNewBucket(t *testing.T) {
+	for _, buckets := range []int{1, 2, 7, 64} {
+		before, after := NewJump(buckets), NewJump(buckets+1)
+		moved := 0
+		for i := 0; i < 10000; i++ {
+			key := strconv.Itoa(i)
+			b := before.GetBucket(key)
+			if b < 0 || b >= buckets {
+				t.Fatalf("GetBucket(%q) = %d, out of [0, %d)", key, b, buckets)
+			}
+			if a := after.GetBucket(key); a != b {
+				if a != buckets {
+					t.Fatalf("key %q moved from bucket %d to %d with %d buckets", key, b, a, buckets+1)
+				}
+				moved++
+			}
+		}
+		// 新桶大约分到1/(n+1)的key
+		if want := 10000 / (buckets + 1); moved < want*8/10 || moved > want*12/10 {
+			t.Errorf("%d -> %d buckets moved %d keys, want about %d", buckets, buckets+1, moved, want)
+		}
+	}
+
+	if got := NewJump(0).Buckets(); got != 1 {
+		t.Fatalf("NewJump(0).Buckets() = %d, want 1", got)
+	}
+}
+
+func TestJumpHostsUnregister(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove string
+		// 只有被移除的服务器和补位的最后一台服务器的key会移动
+		changed map[string]bool
+	}{
+		{"last host", "host-3", map[string]bool{"host-3": true}},
+		{"middle host", "host-1", map[string]bool{"host-1": true, "host-3": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := NewJumpHosts(nil)
+			for i := 0; i < 4; i++ {
+				if err := j.RegisterHost("host-" + strconv.Itoa(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := make(map[string]string)
+			for i := 0; i < 5000; i++ {
+				key := strconv.Itoa(i)
+				before[key], _ = j.GetHost(key)
+			}
+			if err := j.UnregisterHost(tt.remove); err != nil {
+				t.Fatal(err)
+			}
+			for key, owner := range before {
+				host, _ := j.GetHost(key)
+				if host == tt.remove {
+					t.Fatalf("key %s still maps to the removed host", key)
+				}
+				if host != owner && !tt.changed[owner] {
+					t.Fatalf("key %s moved from %s to %s", key, owner, host)
+				}
+			}
+		})
+	}
+
+	j := NewJumpHosts(nil)
+	if _, err := j.GetHost("k"); !errors.Is(err, ErrNoHosts) {
+		t.Fatalf("GetHost on empty = %v, want ErrNoHosts", err)
+	}
+	_ = j.RegisterHost("a")
+	if err := j.RegisterHost("a"); !errors.Is(err, ErrHostAlreadyExists) {
+		t.Fatalf("RegisterHost twice = %v, want ErrHostAlreadyExists", err)
+	}
+	if err := j.UnregisterHost("b"); !errors.Is(err, ErrHostNotFound) {
+		t.Fatalf("UnregisterHost unknown = %v, want ErrHostNotFound", err)
+	}
+}
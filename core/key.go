@@ -0,0 +1,38 @@
+package core
+
+// KeyBytes 由二进制key（UUID、结构体等）实现，直接提供参与哈希的字节，避免转换成string
+type KeyBytes interface {
+	KeyBytes() []byte
+}
+
+// GetHostBytes 以[]byte作为key查找服务器，不会产生额外的内存分配
+func (c *Consistent) GetHostBytes(key []byte) (string, error) {
+	state := c.state.Load()
+	if len(state.ring) == 0 {
+		return "", ErrNoHosts
+	}
+	// m[string(b)]形式的map查找不会分配内存
+	if host, ok := state.pins[string(key)]; ok {
+		return host, nil
+	}
+	return state.owner(c.hasher.Hash64(key)), nil
+}
+
+// GetHostByHash 直接用已经算好的64位哈希值查找，调用方需保证与环使用相同的Hasher
+func (c *Consistent) GetHostByHash(h uint64) (string, error) {
+	state := c.state.Load()
+	if len(state.ring) == 0 {
+		return "", ErrNoHosts
+	}
+	return state.owner(h), nil
+}
+
+// Locate 对任意实现了KeyBytes的key类型查找服务器，泛型参数避免了接口装箱
+func Locate[K KeyBytes](c *Consistent, key K) (string, error) {
+	return c.GetHostBytes(key.KeyBytes())
+}
+
+// HashKey 返回key在环上的哈希值
+func (c *Consistent) HashKey(key string) uint64 {
+	return c.hash(key)
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "regenerate testdata")
+
+// hashVector 固定的key、哈希值以及它在vectorRing上的服务器
+type hashVector struct {
+	Key  string `json:"key"`
+	Hash uint64 `json:"hash"`
+	Host string `json:"host"`
+}
+
+var vectorKeys = []string{"", "a", "123", "user:42", "{user:1}:profile", "键", strings.Repeat("k", 1024)}
+
+// vectorRing 与生成testdata时相同的环
+func vectorRing(t *testing.T, hasher Hasher) *Consistent {
+	t.Helper()
+	c := New(20, hasher)
+	for _, host := range []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080"} {
+		if err := c.RegisterHost(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+// 固定每个内置Hasher的哈希值和选出的服务器：任何变化都会让已有的key迁移到其他服务器，GetHostByHash的调用方也会与环不一致
+// 有意修改时用 go test -run TestHashVectors -update 重新生成
+func TestHashVectors(t *testing.T) {
+	path := filepath.Join("testdata", "hash_vectors.json")
+	names := []string{"sha512", "xxhash", "murmur3", "fnv1a"}
+
+	if *update {
+		vectors := make(map[string][]hashVector, len(names))
+		for _, name := range names {
+			hasher, _ := HasherByName(name)
+			c := vectorRing(t, hasher)
+			for _, key := range vectorKeys {
+				host, err := c.GetHost(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				vectors[name] = append(vectors[name], hashVector{Key: key, Hash: hasher.Hash64([]byte(key)), Host: host})
+			}
+		}
+		data, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors map[string][]hashVector
+	if err = json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			hasher, _ := HasherByName(name)
+			c := vectorRing(t, hasher)
+			if len(vectors[name]) == 0 {
+				t.Fatal("no vectors")
+			}
+			for _, v := range vectors[name] {
+				if h := hasher.Hash64([]byte(v.Key)); h != v.Hash {
+					t.Errorf("Hash64(%.16q) = %d, want %d", v.Key, h, v.Hash)
+				}
+				if h := c.HashKey(v.Key); h != v.Hash {
+					t.Errorf("HashKey(%.16q) = %d, want %d", v.Key, h, v.Hash)
+				}
+				if host, err := c.GetHostByHash(v.Hash); err != nil || host != v.Host {
+					t.Errorf("GetHostByHash(%d) = %q, %v; want %q", v.Hash, host, err, v.Host)
+				}
+				if host, err := c.GetHost(v.Key); err != nil || host != v.Host {
+					t.Errorf("GetHost(%.16q) = %q, %v; want %q", v.Key, host, err, v.Host)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package core
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// VNodeLabel 虚拟节点的命名方式：Salt + 服务器名 + Separator + 序号。
+// 零值与原来的`%s%d`命名一致
+type VNodeLabel struct {
+	Salt      string `json:"salt,omitempty"`
+	Separator string `json:"separator,omitempty"`
+	// 序号按8字节大端编码，而不是十进制字符串
+	BinaryIndex bool `json:"binary_index,omitempty"`
+}
+
+func (l VNodeLabel) label(hostName string, i int) []byte {
+	buf := make([]byte, 0, len(l.Salt)+len(hostName)+len(l.Separator)+8)
+	buf = append(buf, l.Salt...)
+	buf = append(buf, hostName...)
+	buf = append(buf, l.Separator...)
+	if l.BinaryIndex {
+		return binary.BigEndian.AppendUint64(buf, uint64(i))
+	}
+	return strconv.AppendInt(buf, int64(i), 10)
+}
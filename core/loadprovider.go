@@ -0,0 +1,82 @@
+package core
+
+import "sync/atomic"
+
+// LoadProvider 自定义服务器负载的来源，如后端通过心跳或主动上报的CPU使用率、队列长度、内存占用
+// 设置后有界负载按它判断服务器是否超过上限，而不是按Inc/Done记录的正在处理的请求数；
+// 负载之间只需可比，SetHostCapacity设置的绝对容量使用同样的单位。每次查找都会对所有服务器调用Load，实现应当只读取缓存的值
+type LoadProvider interface {
+	// Load 服务器当前的负载，没有数据时ok为false
+	Load(host string) (load int64, ok bool)
+}
+
+// LoadFunc 把函数用作LoadProvider
+type LoadFunc func(host string) (int64, bool)
+
+func (f LoadFunc) Load(host string) (int64, bool) {
+	return f(host)
+}
+
+// SetLoadProvider 设置负载的来源，nil表示恢复为按Inc/Done记录的请求数
+func (c *Consistent) SetLoadProvider(p LoadProvider) {
+	c.Lock()
+	defer c.Unlock()
+
+	next := c.state.Load().clone()
+	next.loadProvider = p
+	c.state.Store(next)
+}
+
+// LoadProvider 返回SetLoadProvider设置的负载来源，未设置时为nil
+func (c *Consistent) LoadProvider() LoadProvider {
+	return c.state.Load().loadProvider
+}
+
+// loadSnapshot 一次查找使用的负载
+// 设置了LoadProvider时为它提供的负载，没有数据的服务器按有数据的服务器的平均值计算，
+// 所有服务器都没有数据时与未设置一样，使用Inc/Done记录的请求数
+type loadSnapshot struct {
+	// 为nil时读取hostView.load
+	reported map[string]int64
+	total    int64
+}
+
+func (c *Consistent) loadSnapshot(state *ringState) loadSnapshot {
+	// a safety check if someone performed c.Done more than needed
+	counted := max(atomic.LoadInt64(&c.totalLoad), 0)
+	if state.loadProvider == nil {
+		return loadSnapshot{total: counted}
+	}
+
+	reported := make(map[string]int64, len(state.hosts))
+	var (
+		total   int64
+		missing []string
+	)
+	for name := range state.hosts {
+		load, ok := state.loadProvider.Load(name)
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		load = max(load, 0)
+		reported[name] = load
+		total += load
+	}
+	if len(reported) == 0 {
+		return loadSnapshot{total: counted}
+	}
+	mean := total / int64(len(reported))
+	for _, name := range missing {
+		reported[name] = mean
+		total += mean
+	}
+	return loadSnapshot{reported: reported, total: total}
+}
+
+func (l loadSnapshot) of(host *hostView) int64 {
+	if l.reported == nil {
+		return atomic.LoadInt64(host.load)
+	}
+	return l.reported[host.name]
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// ReportedLoads 保存后端上报的负载，用作LoadProvider：超过maxAge没有更新的服务器视为没有数据，
+// 按其他服务器的平均值计算，避免停止上报的服务器一直按过时的负载被选中或被跳过
+type ReportedLoads struct {
+	maxAge time.Duration
+	loads  map[string]reportedLoad
+	sync.RWMutex
+}
+
+type reportedLoad struct {
+	load int64
+	at   time.Time
+}
+
+// NewReportedLoads maxAge为0时上报的负载不会过期
+func NewReportedLoads(maxAge time.Duration) *ReportedLoads {
+	return &ReportedLoads{maxAge: maxAge, loads: make(map[string]reportedLoad)}
+}
+
+// MaxAge 上报的负载的有效期
+func (r *ReportedLoads) MaxAge() time.Duration {
+	return r.maxAge
+}
+
+// Report 记录服务器在at时的负载；at已经超过有效期时返回ErrStaleLoad，早于已记录的上报（乱序到达）时忽略
+func (r *ReportedLoads) Report(host string, load int64, at time.Time) error {
+	if load < 0 {
+		return ErrInvalidLoad
+	}
+	if r.expired(at) {
+		return ErrStaleLoad
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if old, ok := r.loads[host]; ok && at.Before(old.at) {
+		return nil
+	}
+	r.loads[host] = reportedLoad{load: load, at: at}
+	return nil
+}
+
+func (r *ReportedLoads) Load(host string) (int64, bool) {
+	r.RLock()
+	l, ok := r.loads[host]
+	r.RUnlock()
+	if !ok || r.expired(l.at) {
+		return 0, false
+	}
+	return l.load, true
+}
+
+// ReportedAt 服务器最近一次上报的时间，没有上报过时ok为false
+func (r *ReportedLoads) ReportedAt(host string) (time.Time, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	l, ok := r.loads[host]
+	return l.at, ok
+}
+
+func (r *ReportedLoads) Remove(host string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.loads, host)
+}
+
+func (r *ReportedLoads) expired(at time.Time) bool {
+	return r.maxAge > 0 && time.Since(at) > r.maxAge
+}
+
+// UpdateLoadAt 记录服务器在at时的负载：LoadProvider为*ReportedLoads时交给它（过期的上报返回ErrStaleLoad），
+// 否则直接替换Inc/Done记录的请求数
+func (c *Consistent) UpdateLoadAt(host string, load int64, at time.Time) error {
+	if load < 0 {
+		return ErrInvalidLoad
+	}
+	reported, ok := c.state.Load().loadProvider.(*ReportedLoads)
+	if !ok {
+		return c.UpdateLoad(host, load)
+	}
+	if _, ok := c.state.Load().hosts[host]; !ok {
+		return hostError(host, ErrHostNotFound)
+	}
+	if err := reported.Report(host, load, at); err != nil {
+		return hostError(host, err)
+	}
+	c.metrics.ObserveLoad(host, load)
+	return nil
+}
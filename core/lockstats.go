@@ -0,0 +1,53 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LockStats 环的读写锁的争用统计，用于排查写操作（注册、调整权重、更新负载）是否拖慢了查找
+type LockStats struct {
+	// 获取写锁、读锁时需要等待的次数
+	WriteContended int64 `json:"write_contended"`
+	ReadContended  int64 `json:"read_contended"`
+	// 等待的总时长（纳秒）
+	WaitTime time.Duration `json:"wait_ns"`
+}
+
+// countingRWMutex 先TryLock，拿不到时才计一次争用并统计等待时长，不争用时几乎没有额外开销
+type countingRWMutex struct {
+	sync.RWMutex
+	writeContended atomic.Int64
+	readContended  atomic.Int64
+	waitNanos      atomic.Int64
+}
+
+func (m *countingRWMutex) Lock() {
+	if m.RWMutex.TryLock() {
+		return
+	}
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.writeContended.Add(1)
+	m.waitNanos.Add(int64(time.Since(start)))
+}
+
+func (m *countingRWMutex) RLock() {
+	if m.RWMutex.TryRLock() {
+		return
+	}
+	start := time.Now()
+	m.RWMutex.RLock()
+	m.readContended.Add(1)
+	m.waitNanos.Add(int64(time.Since(start)))
+}
+
+// LockStats 返回环创建以来锁的争用统计
+func (c *Consistent) LockStats() LockStats {
+	return LockStats{
+		WriteContended: c.countingRWMutex.writeContended.Load(),
+		ReadContended:  c.countingRWMutex.readContended.Load(),
+		WaitTime:       time.Duration(c.countingRWMutex.waitNanos.Load()),
+	}
+}
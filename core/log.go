@@ -0,0 +1,15 @@
+package core
+
+import "log/slog"
+
+// Logger 结构化日志接口，args为交替的键值对，*slog.Logger满足该接口
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+func defaultLogger() Logger {
+	return slog.Default()
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// 查找表大小需为质数
+	defaultMaglevTableSize = 65537
+	maglevSkipSuffix       = "#skip"
+)
+
+// Maglev 基于查找表的一致性哈希，查询复杂度O(1)，负载更均衡
+type Maglev struct {
+	tableSize int
+	hasher    Hasher
+	hosts     map[string]struct{}
+	// 查找表：槽位 -> 服务器
+	table []string
+	sync.RWMutex
+}
+
+func NewMaglev(tableSize int, hasher Hasher) *Maglev {
+	if tableSize <= 0 {
+		tableSize = defaultMaglevTableSize
+	}
+	tableSize = nextPrime(tableSize)
+
+	if hasher == nil {
+		hasher = defaultHasher
+	}
+
+	return &Maglev{
+		tableSize: tableSize,
+		hasher:    hasher,
+		hosts:     make(map[string]struct{}),
+		table:     make([]string, 0),
+	}
+}
+
+func (m *Maglev) RegisterHost(hostName string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.hosts[hostName]; ok {
+		return hostError(hostName, ErrHostAlreadyExists)
+	}
+	m.hosts[hostName] = struct{}{}
+
+	m.rebuild()
+	return nil
+}
+
+func (m *Maglev) UnregisterHost(hostName string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.hosts[hostName]; !ok {
+		return hostError(hostName, ErrHostNotFound)
+	}
+	delete(m.hosts, hostName)
+
+	m.rebuild()
+	return nil
+}
+
+func (m *Maglev) GetHost(key string) (string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if len(m.table) == 0 {
+		return "", ErrNoHosts
+	}
+	return m.table[m.hasher.Hash64([]byte(key))%uint64(m.tableSize)], nil
+}
+
+func (m *Maglev) Hosts() []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	hosts := make([]string, 0)
+	for k := range m.hosts {
+		hosts = append(hosts, k)
+	}
+	return hosts
+}
+
+func (m *Maglev) TableSize() int {
+	return m.tableSize
+}
+
+// 按照每台服务器的偏好序列轮流填充查找表
+func (m *Maglev) rebuild() {
+	if len(m.hosts) == 0 {
+		m.table = make([]string, 0)
+		return
+	}
+
+	names := make([]string, 0, len(m.hosts))
+	for k := range m.hosts {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	size := uint64(m.tableSize)
+	offsets := make([]uint64, len(names))
+	skips := make([]uint64, len(names))
+	for i, name := range names {
+		offsets[i] = m.hasher.Hash64([]byte(name)) % size
+		skips[i] = m.hasher.Hash64([]byte(name+maglevSkipSuffix))%(size-1) + 1
+	}
+
+	table := make([]string, m.tableSize)
+	next := make([]uint64, len(names))
+	filled := 0
+	for {
+		for i, name := range names {
+			slot := (offsets[i] + next[i]*skips[i]) % size
+			for table[slot] != "" {
+				next[i]++
+				slot = (offsets[i] + next[i]*skips[i]) % size
+			}
+			table[slot] = name
+			next[i]++
+			filled++
+			if filled == m.tableSize {
+				m.table = table
+				return
+			}
+		}
+	}
+}
+
+func nextPrime(n int) int {
+	if n <= 2 {
+		return 2
+	}
+	for ; ; n++ {
+		isPrime := true
+		for i := 2; i*i <= n; i++ {
+			if n%i == 0 {
+				isPrime = false
+				break
+			}
+		}
+		if isPrime {
+			return n
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestMaglevTableSize(t *testing.T) {
+	tests := []struct {
+		size, want int
+	}{
+		{0, defaultMaglevTableSize},
+		{-1, defaultMaglevTableSize},
+		{1, 2},
+		{10, 11},
+		{101, 101},
+		{1000, 1009},
+	}
+	for _, tt := range tests {
+		if got := NewMaglev(tt.size, nil).TableSize(); got != tt.want {
+			t.Errorf("NewMaglev(%d).TableSize() = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestMaglevBalanceAndDisruption(t *testing.T) {
+	tests := []struct {
+		name      string
+		tableSize int
+		hosts     int
+	}{
+		{"small table", 101, 3},
+		{"default table", 0, 5},
+		{"many hosts", 1009, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMaglev(tt.tableSize, nil)
+			for i := 0; i < tt.hosts; i++ {
+				if err := m.RegisterHost("host-" + strconv.Itoa(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// 轮流填表，每台服务器的槽位数最多相差一个表大小/服务器数的零头
+			counts := make(map[string]int)
+			for _, host := range m.table {
+				counts[host]++
+			}
+			want := m.TableSize() / tt.hosts
+			for host, n := range counts {
+				if n < want || n > want+1 {
+					t.Errorf("%s owns %d slots, want %d or %d", host, n, want, want+1)
+				}
+			}
+
+			before := make(map[string]string)
+			for i := 0; i < 10000; i++ {
+				key := strconv.Itoa(i)
+				before[key], _ = m.GetHost(key)
+			}
+			if err := m.UnregisterHost("host-0"); err != nil {
+				t.Fatal(err)
+			}
+			moved := 0
+			for key, owner := range before {
+				host, err := m.GetHost(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if host == "host-0" {
+					t.Fatalf("key %s still maps to the removed host", key)
+				}
+				if owner != "host-0" && host != owner {
+					moved++
+				}
+			}
+			// Maglev不保证完全不移动，但其他服务器的key只有少量受影响
+			if moved > len(before)/5 {
+				t.Errorf("%d of %d keys on remaining hosts moved", moved, len(before))
+			}
+		})
+	}
+}
+
+func TestMaglevErrors(t *testing.T) {
+	m := NewMaglev(11, nil)
+	if _, err := m.GetHost("k"); !errors.Is(err, ErrNoHosts) {
+		t.Fatalf("GetHost on empty table = %v, want ErrNoHosts", err)
+	}
+	if err := m.RegisterHost("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RegisterHost("a"); !errors.Is(err, ErrHostAlreadyExists) {
+		t.Fatalf("RegisterHost twice = %v, want ErrHostAlreadyExists", err)
+	}
+	if err := m.UnregisterHost("b"); !errors.Is(err, ErrHostNotFound) {
+		t.Fatalf("UnregisterHost unknown = %v, want ErrHostNotFound", err)
+	}
+	if err := m.UnregisterHost("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetHost("k"); !errors.Is(err, ErrNoHosts) {
+		t.Fatalf("GetHost after removing every host = %v, want ErrNoHosts", err)
+	}
+}
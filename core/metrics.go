@@ -0,0 +1,17 @@
+package core
+
+// Metrics 接收环的运行指标，可以对接Prometheus等监控系统。实现需要并发安全，且不能阻塞
+type Metrics interface {
+	// ObserveLookup 每次查找key之后调用，失败时host为空
+	ObserveLookup(host string, err error)
+	// ObserveTopology 拓扑变化之后调用，size为变化后的服务器数量
+	ObserveTopology(ev TopologyEvent, size int)
+	// ObserveLoad 服务器的负载变化之后调用
+	ObserveLoad(host string, load int64)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveLookup(string, error)        {}
+func (nopMetrics) ObserveTopology(TopologyEvent, int) {}
+func (nopMetrics) ObserveLoad(string, int64)          {}
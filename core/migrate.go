@@ -0,0 +1,102 @@
+package core
+
+import "sort"
+
+// Migration 拓扑变化后从From迁移到To的哈希区间，以及落在该区间内的被跟踪的key
+type Migration struct {
+	Range Range
+	From  string
+	To    string
+	Keys  []string
+}
+
+type MigrationFunc func(migrations []Migration)
+
+// OnMigrate 注册回调，在RegisterHost/UnregisterHost改变环的归属后触发
+func (c *Consistent) OnMigrate(fn MigrationFunc) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.migrateFns = append(c.migrateFns, fn)
+}
+
+// TrackKey 跟踪key，迁移回调中会带上归属发生变化的key
+func (c *Consistent) TrackKey(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.trackedKeys[key] = c.hash(key)
+}
+
+func (c *Consistent) UntrackKey(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.trackedKeys, key)
+}
+
+func (c *Consistent) migrations(before *ringState) []Migration {
+	if len(c.migrateFns) == 0 {
+		return nil
+	}
+
+	moved := diffRing(before, c.state.Load())
+	for i := range moved {
+		for key, h := range c.trackedKeys {
+			if moved[i].Range.Contains(h) {
+				moved[i].Keys = append(moved[i].Keys, key)
+			}
+		}
+		sort.Strings(moved[i].Keys)
+	}
+	return moved
+}
+
+func (c *Consistent) fireMigrate(moved []Migration) {
+	if len(moved) == 0 {
+		return
+	}
+
+	c.RLock()
+	fns := make([]MigrationFunc, len(c.migrateFns))
+	copy(fns, c.migrateFns)
+	c.RUnlock()
+
+	for _, fn := range fns {
+		fn(moved)
+	}
+}
+
+// 合并两个环的虚拟节点，逐个比较相邻节点之间区间的归属
+func diffRing(before, after *ringState) []Migration {
+	if len(before.ring) == 0 || len(after.ring) == 0 {
+		return nil
+	}
+
+	points := make([]uint64, 0, len(before.ring)+len(after.ring))
+	points = append(points, before.ring...)
+	points = append(points, after.ring...)
+	sort.Slice(points, func(i, j int) bool {
+		return points[i] < points[j]
+	})
+
+	moved := make([]Migration, 0)
+	prev := points[len(points)-1]
+	for i, p := range points {
+		if i > 0 && p == points[i-1] {
+			continue
+		}
+
+		from, to := before.owner(p), after.owner(p)
+		if from != to {
+			last := len(moved) - 1
+			if last >= 0 && moved[last].Range.End == prev && moved[last].From == from && moved[last].To == to {
+				moved[last].Range.End = p
+			} else {
+				moved = append(moved, Migration{Range: Range{Start: prev, End: p}, From: from, To: to})
+			}
+		}
+		prev = p
+	}
+	return moved
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestOnMigrate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Consistent) error
+		// 所有迁移都应来自或去往该服务器
+		from, to string
+	}{
+		{"register", func(c *Consistent) error { return c.RegisterHost("d") }, "", "d"},
+		{"unregister", func(c *Consistent) error { return c.UnregisterHost("a") }, "a", ""},
+		{"raise weight", func(c *Consistent) error { return c.SetHostWeight("b", 3) }, "", "b"},
+		{"lower weight", func(c *Consistent) error { return c.SetHostWeight("c", 1) }, "c", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(50, nil)
+			for host, weight := range map[string]int{"a": 1, "b": 1, "c": 2} {
+				if err := c.RegisterHostWithWeight(host, weight); err != nil {
+					t.Fatal(err)
+				}
+			}
+			owners := make(map[string]string)
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i)
+				c.TrackKey(key)
+				owners[key], _ = c.GetHost(key)
+			}
+			var got []Migration
+			c.OnMigrate(func(m []Migration) { got = append(got, m...) })
+
+			if err := tt.change(c); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) == 0 {
+				t.Fatal("no migrations reported")
+			}
+
+			reported := make(map[string]Migration)
+			for _, m := range got {
+				if (tt.from != "" && m.From != tt.from) || (tt.to != "" && m.To != tt.to) || m.From == m.To {
+					t.Fatalf("unexpected migration %s -> %s", m.From, m.To)
+				}
+				for _, key := range m.Keys {
+					reported[key] = m
+				}
+			}
+			// 回调中的key正好是归属发生变化的被跟踪的key，且From、To与实际的归属一致
+			for key, before := range owners {
+				after, _ := c.GetHost(key)
+				m, ok := reported[key]
+				if ok != (before != after) {
+					t.Fatalf("key %s: owner %s -> %s, reported %v", key, before, after, ok)
+				}
+				if ok && (m.From != before || m.To != after) {
+					t.Fatalf("key %s reported as %s -> %s, actually %s -> %s", key, m.From, m.To, before, after)
+				}
+			}
+		})
+	}
+}
+
+func TestOnMigrateUntrackedKeys(t *testing.T) {
+	c := newBenchRing(t, 3)
+	c.TrackKey("k")
+	c.UntrackKey("k")
+	var got []Migration
+	c.OnMigrate(func(m []Migration) { got = append(got, m...) })
+	if err := c.RegisterHost("new:80"); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range got {
+		if len(m.Keys) != 0 {
+			t.Fatalf("untracked keys reported: %v", m.Keys)
+		}
+	}
+}
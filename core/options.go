@@ -0,0 +1,75 @@
+package core
+
+import "time"
+
+type Option func(c *Consistent)
+
+// WithLoadFactor 设置有界负载的容量系数，f越小负载越均衡、但沿环查找的距离越长
+func WithLoadFactor(f float64) Option {
+	return func(c *Consistent) {
+		if f > 0 {
+			c.state.Load().loadFactor = f
+		}
+	}
+}
+
+func WithPlacement(p Placement) Option {
+	return func(c *Consistent) {
+		c.placement = p
+	}
+}
+
+func WithFallback(policy FallbackPolicy) Option {
+	return func(c *Consistent) {
+		c.fallback = policy
+	}
+}
+
+// WithFallbackTimeout FallbackWait策略下最长的等待时间
+func WithFallbackTimeout(d time.Duration) Option {
+	return func(c *Consistent) {
+		if d > 0 {
+			c.fallbackTimeout = d
+		}
+	}
+}
+
+// WithVNodeLabel 设置虚拟节点的命名方式，加入分隔符可以避免"a1"+"1"与"a"+"11"这样的标签冲突
+func WithVNodeLabel(l VNodeLabel) Option {
+	return func(c *Consistent) {
+		c.vnodeLabel = l
+	}
+}
+
+// WithSlowStart 新加入的服务器在window内逐步增加虚拟节点，见SetSlowStart
+func WithSlowStart(window time.Duration) Option {
+	return func(c *Consistent) {
+		if window > 0 {
+			c.slowStart = window
+		}
+	}
+}
+
+// WithLoadProvider 按p提供的负载（如后端上报的指标）做有界负载，见SetLoadProvider
+func WithLoadProvider(p LoadProvider) Option {
+	return func(c *Consistent) {
+		c.state.Load().loadProvider = p
+	}
+}
+
+// WithMetrics 采集查找、拓扑变化和负载的指标
+func WithMetrics(m Metrics) Option {
+	return func(c *Consistent) {
+		if m != nil {
+			c.metrics = m
+		}
+	}
+}
+
+func WithLogger(l Logger) Option {
+	return func(c *Consistent) {
+		if l != nil {
+			c.logger = l
+		}
+	}
+}
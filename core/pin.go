@@ -0,0 +1,39 @@
+package core
+
+// PinKey 把key固定到指定服务器，查找时优先于哈希环，不改变环的拓扑
+func (c *Consistent) PinKey(key, hostName string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.hosts[hostName]; !ok {
+		return hostError(hostName, ErrHostNotFound)
+	}
+
+	next := c.state.Load().clone()
+	next.pins[key] = hostName
+	c.state.Store(next)
+	return nil
+}
+
+func (c *Consistent) UnpinKey(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.state.Load().pins[key]; !ok {
+		return
+	}
+
+	next := c.state.Load().clone()
+	delete(next.pins, key)
+	c.state.Store(next)
+}
+
+// Pins 返回当前所有固定的key
+func (c *Consistent) Pins() map[string]string {
+	state := c.state.Load()
+	pins := make(map[string]string, len(state.pins))
+	for k, v := range state.pins {
+		pins[k] = v
+	}
+	return pins
+}
@@ -0,0 +1,38 @@
+package core
+
+// Placement GetHosts选择副本的策略
+type Placement int
+
+const (
+	// PlacementRing 沿环顺时针选择前N个不同的服务器
+	PlacementRing Placement = iota
+	// PlacementZoneAware 优先把副本分散到不同的可用区，类似Cassandra的NetworkTopologyStrategy
+	PlacementZoneAware
+)
+
+// 按环上的顺序，先从每个尚未使用的可用区各取一台，可用区不够时再按顺序补齐
+func (s *ringState) spreadZones(candidates []string, n int) []string {
+	hosts := make([]string, 0, n)
+	skipped := make([]string, 0)
+	usedZones := make(map[string]struct{})
+	for _, host := range candidates {
+		if len(hosts) == n {
+			break
+		}
+		zone := s.hosts[host].zone
+		if _, ok := usedZones[zone]; ok {
+			skipped = append(skipped, host)
+			continue
+		}
+		usedZones[zone] = struct{}{}
+		hosts = append(hosts, host)
+	}
+
+	for _, host := range skipped {
+		if len(hosts) == n {
+			break
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
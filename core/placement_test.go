@@ -0,0 +1,55 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestZoneAwarePlacement(t *testing.T) {
+	tests := []struct {
+		name  string
+		zones map[string]string
+		n     int
+		// 副本中应有的不同可用区数量
+		distinct int
+	}{
+		{"one per zone", map[string]string{"a1": "a", "a2": "a", "b1": "b", "b2": "b", "c1": "c", "c2": "c"}, 3, 3},
+		{"fewer zones than replicas", map[string]string{"a1": "a", "a2": "a", "a3": "a", "b1": "b"}, 3, 2},
+		{"single zone", map[string]string{"a1": "a", "a2": "a", "a3": "a"}, 2, 1},
+		{"hosts without zone", map[string]string{"x1": "", "x2": "", "b1": "b"}, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(50, nil, WithPlacement(PlacementZoneAware))
+			for host, zone := range tt.zones {
+				must(t, c.RegisterHostWithMeta(host, 1, Metadata{Zone: zone}))
+			}
+			for i := 0; i < 500; i++ {
+				key := strconv.Itoa(i)
+				hosts, err := c.GetHosts(key, tt.n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(hosts) != tt.n {
+					t.Fatalf("GetHosts(%q, %d) = %v", key, tt.n, hosts)
+				}
+				// 第一个副本仍是key在环上的归属
+				if owner, _ := c.GetHost(key); hosts[0] != owner {
+					t.Fatalf("GetHosts(%q)[0] = %s, GetHost = %s", key, hosts[0], owner)
+				}
+				zones := make(map[string]bool)
+				seen := make(map[string]bool)
+				for _, host := range hosts {
+					if seen[host] {
+						t.Fatalf("GetHosts(%q, %d) = %v has duplicates", key, tt.n, hosts)
+					}
+					seen[host] = true
+					zones[tt.zones[host]] = true
+				}
+				if len(zones) != tt.distinct {
+					t.Fatalf("GetHosts(%q, %d) = %v spans %d zones, want %d", key, tt.n, hosts, len(zones), tt.distinct)
+				}
+			}
+		})
+	}
+}
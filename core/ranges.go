@@ -0,0 +1,63 @@
+package core
+
+import "math"
+
+// Range 哈希环上的区间 (Start, End]，Start >= End 时表示跨越环的零点
+type Range struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+func (r Range) Contains(h uint64) bool {
+	if r.Start < r.End {
+		return h > r.Start && h <= r.End
+	}
+	return h > r.Start || h <= r.End
+}
+
+// Fraction 区间占整个哈希空间的比例
+func (r Range) Fraction() float64 {
+	if r.Start == r.End {
+		return 1
+	}
+	// 跨越零点时利用无符号溢出计算长度
+	return float64(r.End-r.Start) / math.Pow(2, 64)
+}
+
+// OwnerOf 返回哈希值h在环上的归属服务器，环为空时返回空字符串
+func (c *Consistent) OwnerOf(h uint64) string {
+	state := c.state.Load()
+	if len(state.ring) == 0 {
+		return ""
+	}
+	return state.owner(h)
+}
+
+// OwnedRanges 返回服务器拥有的所有哈希区间，相邻的区间会被合并
+func (c *Consistent) OwnedRanges(hostName string) []Range {
+	return c.state.Load().ownedRanges(hostName)
+}
+
+func (s *ringState) ownedRanges(hostName string) []Range {
+	ranges := make([]Range, 0)
+	for i, point := range s.ring {
+		if s.virt2host[point] != hostName {
+			continue
+		}
+
+		prev := s.ring[(i+len(s.ring)-1)%len(s.ring)]
+		last := len(ranges) - 1
+		if last >= 0 && ranges[last].End == prev {
+			ranges[last].End = point
+		} else {
+			ranges = append(ranges, Range{Start: prev, End: point})
+		}
+	}
+
+	// 首尾两个区间在零点处相接时合并为一个跨零点的区间
+	if n := len(ranges); n > 1 && ranges[n-1].End == ranges[0].Start {
+		ranges[0].Start = ranges[n-1].Start
+		ranges = ranges[:n-1]
+	}
+	return ranges
+}
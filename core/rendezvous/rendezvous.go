@@ -0,0 +1,100 @@
+package rendezvous
+
+import (
+	"math"
+	"sync"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// Rendezvous 最高随机权重（HRW）哈希：对每个key计算所有服务器的得分，取得分最高者
+type Rendezvous struct {
+	hasher core.Hasher
+	// 服务器 -> 权重
+	hosts map[string]int
+	sync.RWMutex
+}
+
+var _ core.Picker = (*Rendezvous)(nil)
+
+func New(hasher core.Hasher) *Rendezvous {
+	if hasher == nil {
+		hasher = core.SHA512Hasher{}
+	}
+
+	return &Rendezvous{
+		hasher: hasher,
+		hosts:  make(map[string]int),
+	}
+}
+
+func (r *Rendezvous) RegisterHost(hostName string) error {
+	return r.RegisterHostWithWeight(hostName, 1)
+}
+
+func (r *Rendezvous) RegisterHostWithWeight(hostName string, weight int) error {
+	if weight <= 0 {
+		return core.ErrInvalidWeight
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.hosts[hostName]; ok {
+		return &core.HostError{Host: hostName, Err: core.ErrHostAlreadyExists}
+	}
+	r.hosts[hostName] = weight
+	return nil
+}
+
+func (r *Rendezvous) UnregisterHost(hostName string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.hosts[hostName]; !ok {
+		return &core.HostError{Host: hostName, Err: core.ErrHostNotFound}
+	}
+	delete(r.hosts, hostName)
+	return nil
+}
+
+func (r *Rendezvous) GetHost(key string) (string, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.hosts) == 0 {
+		return "", core.ErrNoHosts
+	}
+
+	var (
+		best      string
+		bestScore = math.Inf(-1)
+	)
+	for host, weight := range r.hosts {
+		score := r.score(key, host, weight)
+		// 得分相同时按名称决胜，保证结果与map遍历顺序无关
+		if score > bestScore || (score == bestScore && host < best) {
+			best = host
+			bestScore = score
+		}
+	}
+	return best, nil
+}
+
+func (r *Rendezvous) Hosts() []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	hosts := make([]string, 0)
+	for k := range r.hosts {
+		hosts = append(hosts, k)
+	}
+	return hosts
+}
+
+// 加权得分：-w / ln(h)，h为(0,1)上均匀分布的哈希值
+func (r *Rendezvous) score(key, host string, weight int) float64 {
+	h := r.hasher.Hash64([]byte(key + host))
+	f := (float64(h>>11) + 0.5) / (1 << 53)
+	return -float64(weight) / math.Log(f)
+}
@@ -0,0 +1,96 @@
+package rendezvous
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+func TestWeightedDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		hasher  core.Hasher
+		weights map[string]int
+	}{
+		{"equal", nil, map[string]int{"a": 1, "b": 1, "c": 1}},
+		{"weighted", nil, map[string]int{"a": 1, "b": 2, "c": 5}},
+		{"xxhash", core.XXHasher{}, map[string]int{"a": 3, "b": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(tt.hasher)
+			total := 0
+			for host, weight := range tt.weights {
+				if err := r.RegisterHostWithWeight(host, weight); err != nil {
+					t.Fatal(err)
+				}
+				total += weight
+			}
+
+			const keys = 20000
+			counts := make(map[string]int)
+			for i := 0; i < keys; i++ {
+				host, err := r.GetHost(strconv.Itoa(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				counts[host]++
+			}
+			for host, weight := range tt.weights {
+				want := float64(keys) * float64(weight) / float64(total)
+				if got := float64(counts[host]); got < want*0.9 || got > want*1.1 {
+					t.Errorf("%s got %d keys, want about %.0f", host, counts[host], want)
+				}
+			}
+		})
+	}
+}
+
+// 移除一台服务器只会移动它自己的key
+func TestRemovalMovesOnlyOwnKeys(t *testing.T) {
+	r := New(nil)
+	for i := 0; i < 5; i++ {
+		if err := r.RegisterHost("host-" + strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := make(map[string]string)
+	for i := 0; i < 5000; i++ {
+		key := strconv.Itoa(i)
+		before[key], _ = r.GetHost(key)
+	}
+	if err := r.UnregisterHost("host-0"); err != nil {
+		t.Fatal(err)
+	}
+	for key, owner := range before {
+		host, _ := r.GetHost(key)
+		if owner != "host-0" && host != owner {
+			t.Fatalf("key %s moved from %s to %s", key, owner, host)
+		}
+		if host == "host-0" {
+			t.Fatalf("key %s still maps to the removed host", key)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	r := New(nil)
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"get on empty", func() error { _, err := r.GetHost("k"); return err }(), core.ErrNoHosts},
+		{"zero weight", r.RegisterHostWithWeight("a", 0), core.ErrInvalidWeight},
+		{"register", r.RegisterHost("a"), nil},
+		{"register twice", r.RegisterHost("a"), core.ErrHostAlreadyExists},
+		{"unregister unknown", r.UnregisterHost("b"), core.ErrHostNotFound},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package core
+
+// Ring 是对外稳定的一致性哈希接口，由*Consistent实现，其他项目只依赖这个接口即可替换实现或在测试中模拟
+// 所有方法都可以并发调用
+type Ring interface {
+	// RegisterHost 以权重1加入服务器，已存在时返回ErrHostAlreadyExists
+	RegisterHost(hostName string) error
+	// UnregisterHost 移除服务器，不存在时返回ErrHostNotFound
+	UnregisterHost(hostName string) error
+	// Get 返回key在环上顺时针遇到的第一台服务器，环为空时返回ErrNoHosts
+	Get(key string) (string, error)
+	// GetLeast 与Get相同，但跳过负载达到有界负载上限的服务器；不增加负载，转发前后需调用Inc和Done
+	GetLeast(key string) (string, error)
+	// GetN 返回key之后的n台不同服务器，用于副本放置；服务器不足时返回ErrInsufficientHosts
+	GetN(key string, n int) ([]string, error)
+	// Inc、Done 增加、减少服务器的负载
+	Inc(hostName string) error
+	Done(hostName string) error
+	// Hosts 返回所有服务器，顺序不固定
+	Hosts() []string
+	// Stats 返回每台服务器的虚拟节点数量、哈希空间占比和负载
+	Stats() Stats
+}
+
+// Picker 是不同一致性哈希算法共有的最小接口，只支持加入、移除服务器和查找
+// 它原来的名字是Ring，Ring改为*Consistent的完整接口后更名，之前把Maglev等赋给core.Ring的代码需要改用Picker
+type Picker interface {
+	RegisterHost(hostName string) error
+	UnregisterHost(hostName string) error
+	GetHost(key string) (string, error)
+	Hosts() []string
+}
+
+var (
+	_ Ring   = (*Consistent)(nil)
+	_ Picker = (*Consistent)(nil)
+	_ Picker = (*Maglev)(nil)
+)
+
+// Get 等同于GetHost
+func (c *Consistent) Get(key string) (string, error) {
+	return c.GetHost(key)
+}
+
+// GetLeast 等同于GetHostCapacious
+func (c *Consistent) GetLeast(key string) (string, error) {
+	return c.GetHostCapacious(key)
+}
+
+// GetN 等同于GetHosts
+func (c *Consistent) GetN(key string, n int) ([]string, error) {
+	return c.GetHosts(key, n)
+}
@@ -0,0 +1,316 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SlotCount 槽位的数量，与Redis Cluster相同
+const SlotCount = 16384
+
+// Slots 固定槽位的一致性哈希（类似Redis Cluster）：key按KeySlot映射到16384个槽位之一，槽位再分配给服务器
+// 加入服务器时从槽位最多的服务器各取一部分，移除时把它的槽位分给槽位最少的服务器，只有这些槽位上的key会移动；
+// 还可以通过AssignSlots手动迁移槽位，由运维控制再平衡的节奏
+type Slots struct {
+	// 槽位 -> 服务器，没有服务器时为空
+	table []string
+	// 服务器 -> 槽位数量
+	hosts map[string]int
+	sync.RWMutex
+}
+
+// SlotRange 连续分配给同一台服务器的槽位，包含Start和End
+type SlotRange struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Host  string `json:"host"`
+}
+
+var _ Picker = (*Slots)(nil)
+
+func NewSlots() *Slots {
+	return &Slots{
+		table: make([]string, SlotCount),
+		hosts: make(map[string]int),
+	}
+}
+
+// RestoreSlots 按之前Ranges的结果恢复槽位，范围中出现的服务器都会加入；没有覆盖到的槽位分给槽位最少的服务器
+func RestoreSlots(ranges []SlotRange) (*Slots, error) {
+	s := NewSlots()
+	for _, r := range ranges {
+		if err := checkSlotRange(r.Start, r.End); err != nil {
+			return nil, err
+		}
+		if r.Host == "" {
+			return nil, ErrInvalidSlotRange
+		}
+		if _, ok := s.hosts[r.Host]; !ok {
+			s.hosts[r.Host] = 0
+		}
+		s.assign(r.Start, r.End, r.Host)
+	}
+
+	var unassigned []int
+	for slot, host := range s.table {
+		if host == "" {
+			unassigned = append(unassigned, slot)
+		}
+	}
+	s.distribute(unassigned)
+	return s, nil
+}
+
+// KeySlot key所在的槽位：CRC16(key) mod 16384。key中含有非空的{tag}时只对第一个tag计算，
+// 与Redis Cluster相同，可以让相关的key（如{user:1}:profile和{user:1}:orders）落在同一个槽位
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % SlotCount)
+}
+
+func (s *Slots) RegisterHost(hostName string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.hosts[hostName]; ok {
+		return hostError(hostName, ErrHostAlreadyExists)
+	}
+	s.hosts[hostName] = 0
+	if len(s.hosts) == 1 {
+		s.assign(0, SlotCount-1, hostName)
+		return nil
+	}
+
+	// 从槽位最多的服务器依次取出编号最大的槽位，直到新服务器分到平均数
+	owned := s.slotsByHost()
+	for moved, target := 0, SlotCount/len(s.hosts); moved < target; moved++ {
+		donor := s.extreme(hostName, func(n, best int) bool { return n > best })
+		slots := owned[donor]
+		slot := slots[len(slots)-1]
+		owned[donor] = slots[:len(slots)-1]
+		s.move(slot, hostName)
+	}
+	return nil
+}
+
+func (s *Slots) UnregisterHost(hostName string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.hosts[hostName]; !ok {
+		return hostError(hostName, ErrHostNotFound)
+	}
+	slots := s.slotsByHost()[hostName]
+	delete(s.hosts, hostName)
+	for _, slot := range slots {
+		s.table[slot] = ""
+	}
+	if len(s.hosts) > 0 {
+		s.distribute(slots)
+	}
+	return nil
+}
+
+func (s *Slots) GetHost(key string) (string, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if len(s.hosts) == 0 {
+		return "", ErrNoHosts
+	}
+	return s.table[KeySlot(key)], nil
+}
+
+func (s *Slots) Hosts() []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	hosts := make([]string, 0, len(s.hosts))
+	for k := range s.hosts {
+		hosts = append(hosts, k)
+	}
+	return hosts
+}
+
+// HostOf 槽位所属的服务器
+func (s *Slots) HostOf(slot int) (string, error) {
+	if err := checkSlotRange(slot, slot); err != nil {
+		return "", err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	if len(s.hosts) == 0 {
+		return "", ErrNoHosts
+	}
+	return s.table[slot], nil
+}
+
+// Counts 每台服务器的槽位数量，手动迁移后可能有服务器没有槽位
+func (s *Slots) Counts() map[string]int {
+	s.RLock()
+	defer s.RUnlock()
+
+	counts := make(map[string]int, len(s.hosts))
+	for host, n := range s.hosts {
+		counts[host] = n
+	}
+	return counts
+}
+
+// Ranges 按槽位顺序合并出的连续区间，没有服务器时为空
+func (s *Slots) Ranges() []SlotRange {
+	s.RLock()
+	defer s.RUnlock()
+
+	ranges := make([]SlotRange, 0)
+	if len(s.hosts) == 0 {
+		return ranges
+	}
+	for slot, host := range s.table {
+		if n := len(ranges); n > 0 && ranges[n-1].Host == host {
+			ranges[n-1].End = slot
+			continue
+		}
+		ranges = append(ranges, SlotRange{Start: slot, End: slot, Host: host})
+	}
+	return ranges
+}
+
+// AssignSlots 把[start, end]内的槽位迁移到已加入的服务器，返回实际移动的槽位数量
+func (s *Slots) AssignSlots(start, end int, hostName string) (int, error) {
+	if err := checkSlotRange(start, end); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.hosts[hostName]; !ok {
+		return 0, hostError(hostName, ErrHostNotFound)
+	}
+	return s.assign(start, end, hostName), nil
+}
+
+// CountMoves AssignSlots会移动的槽位数量，不做修改
+func (s *Slots) CountMoves(start, end int, hostName string) (int, error) {
+	if err := checkSlotRange(start, end); err != nil {
+		return 0, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, ok := s.hosts[hostName]; !ok {
+		return 0, hostError(hostName, ErrHostNotFound)
+	}
+	moved := 0
+	for slot := start; slot <= end; slot++ {
+		if s.table[slot] != hostName {
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+func (s *Slots) assign(start, end int, hostName string) int {
+	moved := 0
+	for slot := start; slot <= end; slot++ {
+		if s.table[slot] != hostName {
+			s.move(slot, hostName)
+			moved++
+		}
+	}
+	return moved
+}
+
+func (s *Slots) move(slot int, hostName string) {
+	if old := s.table[slot]; old != "" {
+		s.hosts[old]--
+	}
+	s.table[slot] = hostName
+	s.hosts[hostName]++
+}
+
+// distribute 把未分配的槽位分给槽位最少的服务器，直到尽量平均；每台服务器分到连续的一段，避免区间过于零碎
+func (s *Slots) distribute(slots []int) {
+	// 还没有服务器时保持未分配，第一台加入的服务器会得到全部槽位
+	if len(s.hosts) == 0 {
+		return
+	}
+	shares := make(map[string]int)
+	for range slots {
+		host := s.extreme("", func(n, best int) bool { return n < best })
+		s.hosts[host]++
+		shares[host]++
+	}
+
+	names := make([]string, 0, len(shares))
+	for host := range shares {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+	i := 0
+	for _, host := range names {
+		for n := 0; n < shares[host]; n++ {
+			s.table[slots[i]] = host
+			i++
+		}
+	}
+}
+
+// extreme 按better比较槽位数量，选出except之外最好的服务器；数量相同时选名字最小的，保证各实例的结果一致
+func (s *Slots) extreme(except string, better func(n, best int) bool) string {
+	names := make([]string, 0, len(s.hosts))
+	for host := range s.hosts {
+		if host != except {
+			names = append(names, host)
+		}
+	}
+	sort.Strings(names)
+
+	best := names[0]
+	for _, host := range names[1:] {
+		if better(s.hosts[host], s.hosts[best]) {
+			best = host
+		}
+	}
+	return best
+}
+
+// slotsByHost 每台服务器的槽位，按编号从小到大
+func (s *Slots) slotsByHost() map[string][]int {
+	owned := make(map[string][]int, len(s.hosts))
+	for slot, host := range s.table {
+		if host != "" {
+			owned[host] = append(owned[host], slot)
+		}
+	}
+	return owned
+}
+
+func checkSlotRange(start, end int) error {
+	if start < 0 || end >= SlotCount || start > end {
+		return ErrInvalidSlotRange
+	}
+	return nil
+}
+
+// crc16 CRC16-CCITT（XMODEM），与Redis Cluster计算槽位时使用的算法相同
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,29 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRestoreEmptySlots(t *testing.T) {
+	for _, ranges := range [][]SlotRange{nil, {}} {
+		s, err := RestoreSlots(ranges)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := s.Ranges(); len(r) != 0 {
+			t.Fatalf("Ranges() = %v, want empty", r)
+		}
+		if _, err = s.GetHost("k"); !errors.Is(err, ErrNoHosts) {
+			t.Fatalf("GetHost error = %v, want ErrNoHosts", err)
+		}
+
+		// 第一台加入的服务器得到全部槽位
+		if err = s.RegisterHost("a:80"); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Counts()["a:80"]; got != SlotCount {
+			t.Fatalf("a:80 owns %d slots, want %d", got, SlotCount)
+		}
+	}
+}
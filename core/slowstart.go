@@ -0,0 +1,144 @@
+package core
+
+import "time"
+
+// 慢启动期间虚拟节点分多少步增加到全部数量
+const slowStartSteps = 10
+
+type hostWarmup struct {
+	start  time.Time
+	window time.Duration
+	// 当前放到环上的虚拟节点比例，(0, 1)
+	fraction float64
+	timer    *time.Timer
+}
+
+// SetSlowStart 设置慢启动的时长，之后加入的服务器在window内从1/10的虚拟节点逐步增加到按权重的全部数量，
+// 避免缓存是空的新服务器一下子接收全部的key；0表示关闭，已经开始慢启动的服务器照常完成
+func (c *Consistent) SetSlowStart(window time.Duration) error {
+	if window < 0 {
+		return ErrInvalidSlowStart
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.slowStart = window
+	return nil
+}
+
+// SlowStart 返回慢启动的时长
+func (c *Consistent) SlowStart() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.slowStart
+}
+
+// WarmingHosts 返回正在慢启动的服务器
+func (c *Consistent) WarmingHosts() []string {
+	c.RLock()
+	defer c.RUnlock()
+	hosts := make([]string, 0, len(c.warmups))
+	for name := range c.warmups {
+		hosts = append(hosts, name)
+	}
+	return hosts
+}
+
+// vnodeCount 服务器放到环上的虚拟节点数量，慢启动期间按比例减少，至少为1
+func (c *Consistent) vnodeCount(host *Host) int {
+	n := c.replicaNum * host.Weight
+	if w, ok := c.warmups[host.Name]; ok {
+		n = max(1, int(float64(n)*w.fraction))
+	}
+	return n
+}
+
+// WarmUp 让已在环上的服务器重新慢启动：立即减少到1/10的虚拟节点，在window内逐步恢复，
+// 用于把故障恢复的服务器逐步放回环上，不受SetSlowStart影响
+func (c *Consistent) WarmUp(hostName string, window time.Duration) error {
+	if window <= 0 {
+		return ErrInvalidSlowStart
+	}
+
+	var moved []Migration
+	defer func() { c.fireMigrate(moved) }()
+
+	c.Lock()
+	defer c.Unlock()
+
+	host, ok := c.hosts[hostName]
+	if !ok {
+		return hostError(hostName, ErrHostNotFound)
+	}
+	before := c.state.Load()
+	next := before.clone()
+	c.removeReplicas(next, host)
+	c.stopWarmup(hostName)
+	c.warmUpLocked(hostName, window)
+	c.addReplicas(next, host)
+	next.sortRing()
+	c.state.Store(next)
+	moved = c.migrations(before)
+	c.publish(TopologyEvent{Type: WarmupProgress, Host: hostName, Weight: host.Weight, Warmup: 1.0 / slowStartSteps})
+	return nil
+}
+
+// startWarmup 环上已有其他服务器时开始慢启动，需要持有写锁并在addReplicas之前调用
+func (c *Consistent) startWarmup(hostName string) {
+	if c.slowStart <= 0 || len(c.hosts) <= 1 {
+		return
+	}
+	c.warmUpLocked(hostName, c.slowStart)
+}
+
+// 需要持有写锁
+func (c *Consistent) warmUpLocked(hostName string, window time.Duration) {
+	w := &hostWarmup{start: time.Now(), window: window, fraction: 1.0 / slowStartSteps}
+	w.timer = time.AfterFunc(window/slowStartSteps, func() {
+		c.warmStep(hostName, w)
+	})
+	c.warmups[hostName] = w
+}
+
+// warmStep 按已经过去的时间增加服务器的虚拟节点，到期后恢复全部数量
+func (c *Consistent) warmStep(hostName string, w *hostWarmup) {
+	var moved []Migration
+	defer func() { c.fireMigrate(moved) }()
+
+	c.Lock()
+	defer c.Unlock()
+
+	host, ok := c.hosts[hostName]
+	// 期间服务器被注销，或者重新注册后开始了新的慢启动
+	if !ok || c.warmups[hostName] != w {
+		return
+	}
+	before := c.state.Load()
+	next := before.clone()
+	c.removeReplicas(next, host)
+
+	fraction := 1.0
+	elapsed := time.Since(w.start)
+	if elapsed >= w.window {
+		delete(c.warmups, hostName)
+		c.logger.Info("host warmed up", "host", hostName)
+	} else {
+		fraction = float64(elapsed) / float64(w.window)
+		w.fraction = fraction
+		w.timer.Reset(w.window / slowStartSteps)
+	}
+	c.addReplicas(next, host)
+	next.sortRing()
+	c.state.Store(next)
+	moved = c.migrations(before)
+	// 权重没有变化，不发布WeightChanged
+	c.publish(TopologyEvent{Type: WarmupProgress, Host: hostName, Weight: host.Weight, Warmup: fraction})
+}
+
+// 需要持有写锁
+func (c *Consistent) stopWarmup(hostName string) {
+	if w, ok := c.warmups[hostName]; ok {
+		w.timer.Stop()
+		delete(c.warmups, hostName)
+	}
+}
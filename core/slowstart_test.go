@@ -0,0 +1,57 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmUpPublishesProgressNotWeightChanged(t *testing.T) {
+	c := newBenchRing(t, 3)
+	host := c.Hosts()[0]
+	events := c.Subscribe()
+
+	if err := c.WarmUp(host, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type != WarmupProgress || ev.Host != host || ev.Weight != 1 {
+				t.Fatalf("event during warm-up = %+v, want WarmupProgress of %s with weight 1", ev, host)
+			}
+			if ev.Warmup == 1 {
+				if len(c.WarmingHosts()) != 0 {
+					t.Fatal("host still warming after the final progress event")
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("warm-up did not complete")
+		}
+	}
+}
+
+func TestWeightChangedOnlyWhenWeightChanges(t *testing.T) {
+	c := newBenchRing(t, 3)
+	host := c.Hosts()[0]
+	events := c.Subscribe()
+
+	same, changed := 1, 2
+	if err := c.UpdateHost(host, HostUpdate{Weight: &same}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateHost(host, HostUpdate{Weight: &changed}); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := <-events
+	if ev.Type != WeightChanged || ev.Weight != changed {
+		t.Fatalf("event = %+v, want WeightChanged to %d", ev, changed)
+	}
+	select {
+	case ev = <-events:
+		t.Fatalf("unexpected event %+v", ev)
+	default:
+	}
+}
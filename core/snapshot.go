@@ -0,0 +1,143 @@
+package core
+
+import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version    int               `json:"version"`
+	ReplicaNum int               `json:"replica_num"`
+	LoadFactor float64           `json:"load_factor"`
+	VNodeLabel VNodeLabel        `json:"vnode_label"`
+	Hasher     string            `json:"hasher"`
+	TotalLoad  int64             `json:"total_load"`
+	Hosts      []snapshotHost    `json:"hosts"`
+	Pins       map[string]string `json:"pins,omitempty"`
+}
+
+type snapshotHost struct {
+	Name      string   `json:"name"`
+	Weight    int      `json:"weight"`
+	LoadBound int64    `json:"load_bound"`
+	Meta      Metadata `json:"meta"`
+	Draining  bool     `json:"draining,omitempty"`
+	// 有效期，恢复时重新开始计时
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// Snapshot 将环的完整状态序列化为JSON，服务器按名称排序以保证输出稳定
+func (c *Consistent) Snapshot() ([]byte, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	name, ok := hasherName(c.hasher)
+	if !ok {
+		return nil, ErrUnknownHasher
+	}
+
+	s := snapshot{
+		Version:    snapshotVersion,
+		ReplicaNum: c.replicaNum,
+		LoadFactor: c.state.Load().loadFactor,
+		VNodeLabel: c.vnodeLabel,
+		Hasher:     name,
+		TotalLoad:  atomic.LoadInt64(&c.totalLoad),
+		Hosts:      make([]snapshotHost, 0, len(c.hosts)),
+		Pins:       c.state.Load().pins,
+	}
+	for _, h := range c.hosts {
+		sh := snapshotHost{
+			Name:      h.Name,
+			Weight:    h.Weight,
+			LoadBound: atomic.LoadInt64(&h.LoadBound),
+			Meta:      h.Meta,
+			Draining:  h.Draining,
+		}
+		if t, ok := c.ttls[h.Name]; ok {
+			sh.TTL = t.ttl
+		}
+		s.Hosts = append(s.Hosts, sh)
+	}
+	sort.Slice(s.Hosts, func(i, j int) bool {
+		return s.Hosts[i].Name < s.Hosts[j].Name
+	})
+	return json.Marshal(s)
+}
+
+// Restore 从Snapshot的输出重建环，opts在快照中的参数之后应用
+func Restore(data []byte, opts ...Option) (*Consistent, error) {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Version != snapshotVersion {
+		return nil, ErrSnapshotVersion
+	}
+
+	hasher, ok := HasherByName(s.Hasher)
+	if !ok {
+		return nil, ErrUnknownHasher
+	}
+
+	opts = append([]Option{WithLoadFactor(s.LoadFactor), WithVNodeLabel(s.VNodeLabel)}, opts...)
+	c := New(s.ReplicaNum, hasher, opts...)
+	// 恢复的服务器不慢启动
+	slowStart := c.slowStart
+	c.slowStart = 0
+	defer func() { c.slowStart = slowStart }()
+	for _, h := range s.Hosts {
+		if err := c.RegisterHostWithMeta(h.Name, h.Weight, h.Meta); err != nil {
+			return nil, err
+		}
+		c.hosts[h.Name].LoadBound = h.LoadBound
+		if h.Draining {
+			if err := c.DrainHost(h.Name); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.SetHostTTL(h.Name, h.TTL); err != nil {
+			return nil, err
+		}
+	}
+	for key, host := range s.Pins {
+		if err := c.PinKey(key, host); err != nil {
+			return nil, err
+		}
+	}
+	c.totalLoad = s.TotalLoad
+	return c, nil
+}
+
+func hasherName(h Hasher) (string, bool) {
+	switch h.(type) {
+	case SHA512Hasher:
+		return "sha512", true
+	case XXHasher:
+		return "xxhash", true
+	case Murmur3Hasher:
+		return "murmur3", true
+	case FNV1aHasher:
+		return "fnv1a", true
+	}
+	return "", false
+}
+
+// HasherByName 按名称（sha512、xxhash、murmur3、fnv1a）取得内置的哈希函数
+func HasherByName(name string) (Hasher, bool) {
+	switch name {
+	case "sha512":
+		return SHA512Hasher{}, true
+	case "xxhash":
+		return XXHasher{}, true
+	case "murmur3":
+		return Murmur3Hasher{}, true
+	case "fnv1a":
+		return FNV1aHasher{}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(t *testing.T) *Consistent
+	}{
+		{"empty", func(t *testing.T) *Consistent { return New(10, nil) }},
+		{"weights and meta", func(t *testing.T) *Consistent {
+			c := New(20, XXHasher{}, WithLoadFactor(0.5))
+			must(t, c.RegisterHostWithWeight("a", 3))
+			must(t, c.RegisterHostWithMeta("b", 1, Metadata{Zone: "z1", Capacity: 7, Tags: map[string]string{"rack": "r1"}}))
+			return c
+		}},
+		{"vnode label and murmur3", func(t *testing.T) *Consistent {
+			c := New(10, Murmur3Hasher{}, WithVNodeLabel(VNodeLabel{Salt: "s", Separator: "#", BinaryIndex: true}))
+			must(t, c.RegisterHost("a"))
+			must(t, c.RegisterHost("b"))
+			return c
+		}},
+		{"loads, drains, ttl and pins", func(t *testing.T) *Consistent {
+			c := New(10, nil)
+			for _, host := range []string{"a", "b", "c"} {
+				must(t, c.RegisterHost(host))
+			}
+			must(t, c.Inc("a"))
+			must(t, c.Inc("a"))
+			must(t, c.Inc("b"))
+			must(t, c.DrainHost("c"))
+			must(t, c.SetHostTTL("b", time.Hour))
+			must(t, c.PinKey("pinned", "c"))
+			return c
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.build(t)
+			data, err := c.Snapshot()
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := Restore(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 再次快照的结果完全相同
+			again, err := r.Snapshot()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, again) {
+				t.Fatalf("snapshot changed after restore:\n%s\n%s", data, again)
+			}
+			if !reflect.DeepEqual(c.GetWeights(), r.GetWeights()) || !reflect.DeepEqual(c.GetLoads(), r.GetLoads()) {
+				t.Fatalf("weights or loads differ: %v %v, %v %v", c.GetWeights(), r.GetWeights(), c.GetLoads(), r.GetLoads())
+			}
+			for _, host := range c.Hosts() {
+				if c.IsDraining(host) != r.IsDraining(host) {
+					t.Fatalf("%s draining = %v after restore", host, r.IsDraining(host))
+				}
+			}
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i)
+				want, wantErr := c.GetHost(key)
+				got, err := r.GetHost(key)
+				if got != want || !errors.Is(err, wantErr) {
+					t.Fatalf("GetHost(%q) = %q, %v after restore, want %q, %v", key, got, err, want, wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"future version", `{"version":2,"hasher":"sha512","replica_num":10}`, ErrSnapshotVersion},
+		{"unknown hasher", `{"version":1,"hasher":"md5","replica_num":10}`, ErrUnknownHasher},
+		{"invalid weight", `{"version":1,"hasher":"sha512","replica_num":10,"hosts":[{"name":"a","weight":0}]}`, ErrInvalidWeight},
+	}
+	for _, tt := range tests {
+		if _, err := Restore([]byte(tt.data)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Restore = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err := Restore([]byte("{")); err == nil {
+		t.Error("Restore accepted invalid JSON")
+	}
+
+	c := New(10, HasherFunc(func([]byte) uint64 { return 0 }))
+	if _, err := c.Snapshot(); !errors.Is(err, ErrUnknownHasher) {
+		t.Errorf("Snapshot with a custom hasher = %v, want ErrUnknownHasher", err)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
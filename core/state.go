@@ -0,0 +1,166 @@
+package core
+
+import (
+	"math"
+	"sort"
+)
+
+// ringState 环的不可变快照，写操作复制后整体替换（copy-on-write），读操作无需加锁
+type ringState struct {
+	ring      []uint64
+	virt2host map[uint64]string
+	hosts     map[string]*hostView
+	// 手动固定的key -> 服务器
+	pins map[string]string
+	// 所有服务器的权重之和
+	totalWeight int64
+	loadFactor  float64
+	// 为nil时按Inc/Done记录的请求数计算负载
+	loadProvider LoadProvider
+}
+
+// hostView 查找时需要的服务器属性，随快照一起替换；负载计数指向Host.LoadBound，原子更新
+type hostView struct {
+	name     string
+	zone     string
+	weight   int
+	capacity int64
+	draining bool
+	load     *int64
+}
+
+func newHostView(h *Host) *hostView {
+	return &hostView{
+		name:     h.Name,
+		zone:     h.Meta.Zone,
+		weight:   h.Weight,
+		capacity: h.Meta.Capacity,
+		draining: h.draining(),
+		load:     &h.LoadBound,
+	}
+}
+
+func newRingState() *ringState {
+	return &ringState{
+		ring:       make([]uint64, 0),
+		virt2host:  make(map[uint64]string),
+		hosts:      make(map[string]*hostView),
+		pins:       make(map[string]string),
+		loadFactor: defaultLoadBoundFactor,
+	}
+}
+
+func (s *ringState) clone() *ringState {
+	next := &ringState{
+		ring:         make([]uint64, len(s.ring)),
+		virt2host:    make(map[uint64]string, len(s.virt2host)),
+		hosts:        make(map[string]*hostView, len(s.hosts)),
+		pins:         make(map[string]string, len(s.pins)),
+		totalWeight:  s.totalWeight,
+		loadFactor:   s.loadFactor,
+		loadProvider: s.loadProvider,
+	}
+	copy(next.ring, s.ring)
+	for k, v := range s.virt2host {
+		next.virt2host[k] = v
+	}
+	for k, v := range s.hosts {
+		next.hosts[k] = v
+	}
+	for k, v := range s.pins {
+		next.pins[k] = v
+	}
+	return next
+}
+
+func (s *ringState) sortRing() {
+	sort.Slice(s.ring, func(i, j int) bool {
+		if s.ring[i] < s.ring[j] {
+			return true
+		}
+		return false
+	})
+}
+
+func (s *ringState) search(key uint64) int {
+	idx := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i] >= key
+	})
+
+	if idx >= len(s.ring) {
+		// make search as a ring
+		idx = 0
+	}
+
+	return idx
+}
+
+// 固定的key直接返回对应服务器，否则按哈希值在环上查找
+func (s *ringState) lookup(key string, h uint64) string {
+	if host, ok := s.pins[key]; ok {
+		return host
+	}
+	return s.owner(h)
+}
+
+func (s *ringState) owner(h uint64) string {
+	return s.virt2host[s.ring[s.search(h)]]
+}
+
+// 在环中移除某个虚拟服务器的id
+func (s *ringState) delHashIndex(val uint64) {
+	idx := -1
+	l := 0
+	r := len(s.ring) - 1
+	for l <= r {
+		m := (l + r) / 2
+		if s.ring[m] == val {
+			idx = m
+			break
+		} else if s.ring[m] < val {
+			l = m + 1
+		} else if s.ring[m] > val {
+			r = m - 1
+		}
+	}
+	if idx != -1 {
+		s.ring = append(s.ring[:idx], s.ring[idx+1:]...)
+	}
+}
+
+// 服务器是否还能再接受一个请求
+func (s *ringState) checkLoadCapacity(host string, loads loadSnapshot) (bool, error) {
+	candidateHost, ok := s.hosts[host]
+	if !ok {
+		return false, hostError(host, ErrHostNotFound)
+	}
+	if candidateHost.draining {
+		return false, nil
+	}
+
+	if float64(loads.of(candidateHost))+1 <= s.loadBound(candidateHost, loads.total+1) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// 按权重计算服务器的容量上限：平均负载 * 权重 * (1 + loadFactor)，且不超过其绝对容量
+func (s *ringState) loadBound(host *hostView, totalLoad int64) float64 {
+	if s.totalWeight == 0 {
+		return 0
+	}
+
+	// 先转换为浮点数再相除，避免整数除法低估容量
+	avgLoadPerNode := float64(totalLoad) * float64(host.weight) / float64(s.totalWeight)
+	if avgLoadPerNode == 0 {
+		avgLoadPerNode = 1
+	}
+	bound := math.Ceil(avgLoadPerNode * (1 + s.loadFactor))
+
+	// 设置了绝对容量时，无论集群平均负载多高都不能超过
+	if host.capacity > 0 && float64(host.capacity) < bound {
+		bound = float64(host.capacity)
+	}
+	return bound
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+type HostStats struct {
+	Name string `json:"name"`
+	// 虚拟节点数量
+	VirtualNodes int `json:"virtual_nodes"`
+	// 拥有的哈希空间占比
+	Ownership float64 `json:"ownership"`
+	// 按权重应拥有的占比
+	ExpectedOwnership float64 `json:"expected_ownership"`
+	Load              int64   `json:"load"`
+}
+
+type Stats struct {
+	Hosts []HostStats `json:"hosts"`
+	// 各服务器哈希空间占比的标准差
+	OwnershipStdDev float64 `json:"ownership_std_dev"`
+	TotalLoad       int64   `json:"total_load"`
+}
+
+// Stats 统计环上的分布情况，用于上线前验证虚拟节点数量能否带来足够的均衡度
+func (c *Consistent) Stats() Stats {
+	c.RLock()
+	defer c.RUnlock()
+
+	state := c.state.Load()
+	vnodes := make(map[string]int)
+	ownership := make(map[string]float64)
+	for i, point := range state.ring {
+		host := state.virt2host[point]
+		vnodes[host]++
+		if len(state.ring) == 1 {
+			ownership[host] = 1
+			break
+		}
+		// 每个虚拟节点拥有 (前一个节点, 当前节点] 区间，i为0时利用无符号溢出跨越零点
+		prev := state.ring[(i+len(state.ring)-1)%len(state.ring)]
+		ownership[host] += float64(point-prev) / math.Pow(2, 64)
+	}
+
+	stats := Stats{
+		Hosts:     make([]HostStats, 0, len(c.hosts)),
+		TotalLoad: atomic.LoadInt64(&c.totalLoad),
+	}
+	var sum, sqSum float64
+	for name, h := range c.hosts {
+		hs := HostStats{
+			Name:         name,
+			VirtualNodes: vnodes[name],
+			Ownership:    ownership[name],
+			Load:         atomic.LoadInt64(&h.LoadBound),
+		}
+		if state.totalWeight > 0 {
+			hs.ExpectedOwnership = float64(h.Weight) / float64(state.totalWeight)
+		}
+		stats.Hosts = append(stats.Hosts, hs)
+		sum += hs.Ownership
+		sqSum += hs.Ownership * hs.Ownership
+	}
+	sort.Slice(stats.Hosts, func(i, j int) bool {
+		return stats.Hosts[i].Name < stats.Hosts[j].Name
+	})
+
+	if n := float64(len(stats.Hosts)); n > 0 {
+		mean := sum / n
+		stats.OwnershipStdDev = math.Sqrt(math.Max(sqSum/n-mean*mean, 0))
+	}
+	return stats
+}
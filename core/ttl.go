@@ -0,0 +1,118 @@
+package core
+
+import "time"
+
+// ExpireFunc 有效期到期时移除服务器，返回错误时在一个ttl后重试
+type ExpireFunc func(hostName string) error
+
+type hostTTL struct {
+	ttl      time.Duration
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// RegisterHostTTL 注册带有效期的服务器，超过ttl没有调用Renew时自动从环中移除
+func (c *Consistent) RegisterHostTTL(hostName string, ttl time.Duration) error {
+	if err := c.RegisterHost(hostName); err != nil {
+		return err
+	}
+	return c.SetHostTTL(hostName, ttl)
+}
+
+// SetHostTTL 为已注册的服务器设置有效期，ttl为0时取消
+func (c *Consistent) SetHostTTL(hostName string, ttl time.Duration) error {
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.hosts[hostName]; !ok {
+		return hostError(hostName, ErrHostNotFound)
+	}
+
+	c.stopTTL(hostName)
+	if ttl == 0 {
+		return nil
+	}
+
+	t := &hostTTL{
+		ttl:      ttl,
+		deadline: time.Now().Add(ttl),
+	}
+	t.timer = time.AfterFunc(ttl, func() {
+		c.expire(hostName)
+	})
+	c.ttls[hostName] = t
+	return nil
+}
+
+// HostTTL 返回服务器的有效期，永久注册或服务器不存在时为0
+func (c *Consistent) HostTTL(hostName string) time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	if t, ok := c.ttls[hostName]; ok {
+		return t.ttl
+	}
+	return 0
+}
+
+// Renew 心跳续期
+func (c *Consistent) Renew(hostName string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.hosts[hostName]; !ok {
+		return hostError(hostName, ErrHostNotFound)
+	}
+	t, ok := c.ttls[hostName]
+	if !ok {
+		return ErrNoTTL
+	}
+	t.deadline = time.Now().Add(t.ttl)
+	t.timer.Reset(t.ttl)
+	return nil
+}
+
+// OnExpire 设置后有效期到期的服务器交给fn移除，而不是直接注销，例如经过复制层注销以便写入日志、同步给其他实例
+func (c *Consistent) OnExpire(fn ExpireFunc) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.expireFn = fn
+}
+
+func (c *Consistent) expire(hostName string) {
+	c.Lock()
+	t, ok := c.ttls[hostName]
+	// 定时器触发的同时可能刚刚续期
+	expired := ok && !time.Now().Before(t.deadline)
+	fn := c.expireFn
+	c.Unlock()
+
+	if !expired {
+		return
+	}
+	c.logger.Info("host ttl expired", "host", hostName)
+	if fn == nil {
+		_ = c.UnregisterHost(hostName)
+		return
+	}
+	if err := fn(hostName); err != nil {
+		c.logger.Warn("remove expired host failed, retrying", "host", hostName, "error", err, "retry_in", t.ttl)
+		c.Lock()
+		if c.ttls[hostName] == t {
+			t.timer.Reset(t.ttl)
+		}
+		c.Unlock()
+	}
+}
+
+// 需要持有写锁
+func (c *Consistent) stopTTL(hostName string) {
+	if t, ok := c.ttls[hostName]; ok {
+		t.timer.Stop()
+		delete(c.ttls, hostName)
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExpireWithoutHandlerUnregisters(t *testing.T) {
+	c := New(10, nil)
+	if err := c.RegisterHostTTL("a:80", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return c.Size() == 0 })
+}
+
+func TestExpireHandedToOnExpire(t *testing.T) {
+	c := New(10, nil)
+	expired := make(chan string, 4)
+	calls := 0
+	c.OnExpire(func(host string) error {
+		calls++
+		expired <- host
+		// 第一次失败，到期后应当重试
+		if calls == 1 {
+			return errors.New("replicator unavailable")
+		}
+		return c.UnregisterHost(host)
+	})
+	if err := c.RegisterHostTTL("a:80", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case host := <-expired:
+			if host != "a:80" {
+				t.Fatalf("expired host = %s, want a:80", host)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("OnExpire called %d times, want 2", i)
+		}
+	}
+	waitFor(t, func() bool { return c.Size() == 0 })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,55 @@
+package core
+
+// HostUpdate 要修改的服务器属性，nil字段保持不变
+type HostUpdate struct {
+	Weight *int `json:"weight,omitempty"`
+	// 绝对负载上限，0表示不限制
+	Capacity *int64 `json:"capacity,omitempty"`
+	Draining *bool  `json:"draining,omitempty"`
+}
+
+// UpdateHost 一次修改服务器的权重、容量上限和摘除状态，参数无效时不做任何修改
+// 用于把流量从性能下降的服务器上移走，而不必注销它
+func (c *Consistent) UpdateHost(hostName string, u HostUpdate) error {
+	if u.Weight != nil && *u.Weight <= 0 {
+		return ErrInvalidWeight
+	}
+	if u.Capacity != nil && *u.Capacity < 0 {
+		return ErrInvalidCapacity
+	}
+
+	var moved []Migration
+	defer func() { c.fireMigrate(moved) }()
+
+	c.Lock()
+	defer c.Unlock()
+
+	host, ok := c.hosts[hostName]
+	if !ok {
+		return hostError(hostName, ErrHostNotFound)
+	}
+	before := c.state.Load()
+	next := before.clone()
+
+	weightChanged := u.Weight != nil && *u.Weight != host.Weight
+	if weightChanged {
+		c.removeReplicas(next, host)
+		host.Weight = *u.Weight
+		c.addReplicas(next, host)
+		next.sortRing()
+	}
+	if u.Capacity != nil {
+		host.Meta.Capacity = *u.Capacity
+	}
+	if u.Draining != nil {
+		host.Draining = *u.Draining
+	}
+	next.hosts[hostName] = newHostView(host)
+	c.state.Store(next)
+
+	if weightChanged {
+		moved = c.migrations(before)
+		c.publish(TopologyEvent{Type: WeightChanged, Host: hostName, Weight: host.Weight})
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"sort"
+)
+
+// 保留最近的拓扑事件数，更早的版本只能重新获取完整拓扑
+const historySize = 1024
+
+// Topology 某一版本的完整拓扑
+type Topology struct {
+	Version uint64
+	Hosts   []Host
+}
+
+// Topology 返回当前的服务器列表及其版本号，服务器按名称排序
+func (c *Consistent) Topology() Topology {
+	c.RLock()
+	defer c.RUnlock()
+
+	t := Topology{Version: c.version, Hosts: make([]Host, 0, len(c.hosts))}
+	for _, h := range c.hosts {
+		t.Hosts = append(t.Hosts, h.info())
+	}
+	sort.Slice(t.Hosts, func(i, j int) bool {
+		return t.Hosts[i].Name < t.Hosts[j].Name
+	})
+	return t
+}
+
+// Version 拓扑的版本号，每次拓扑变化加一；进程重启后从0开始
+func (c *Consistent) Version() uint64 {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.version
+}
+
+// WaitChanges 返回版本号大于since的事件及当前版本号，没有新事件时阻塞到出现变化或ctx结束
+// since已不在保留的历史中，或大于当前版本（如代理重启过）时返回ErrStaleVersion，调用方应重新获取完整拓扑
+func (c *Consistent) WaitChanges(ctx context.Context, since uint64) ([]TopologyEvent, uint64, error) {
+	for {
+		c.RLock()
+		events, err := c.changesLocked(since)
+		version, changed := c.version, c.changed
+		c.RUnlock()
+
+		if err != nil || len(events) > 0 {
+			return events, version, err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, version, ctx.Err()
+		}
+	}
+}
+
+// 需要持有读锁
+func (c *Consistent) changesLocked(since uint64) ([]TopologyEvent, error) {
+	if since > c.version {
+		return nil, ErrStaleVersion
+	}
+	if since == c.version {
+		return nil, nil
+	}
+	if len(c.history) == 0 || c.history[0].Version > since+1 {
+		return nil, ErrStaleVersion
+	}
+
+	// history中的版本号连续递增
+	start := int(since + 1 - c.history[0].Version)
+	return append([]TopologyEvent(nil), c.history[start:]...), nil
+}
+
+// 需要持有写锁
+func (c *Consistent) recordLocked(ev TopologyEvent) TopologyEvent {
+	c.version++
+	ev.Version = c.version
+	if len(c.history) == historySize {
+		c.history = append(c.history[:0], c.history[1:]...)
+	}
+	c.history = append(c.history, ev)
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return ev
+}
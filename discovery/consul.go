@@ -0,0 +1,171 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// Consul 通过阻塞查询监听Consul中服务的健康实例，只有通过全部健康检查的实例会进入环
+type Consul struct {
+	// Consul agent的HTTP地址，如 http://127.0.0.1:8500
+	Address string
+	Service string
+	// 只选择带有该标签的实例，为空时不过滤
+	Tag        string
+	Datacenter string
+	Token      string
+	// 阻塞查询的最长等待时间
+	WaitTime time.Duration
+	// 超过该时长没有同步成功时认为拓扑已经过期，默认为两倍的WaitTime
+	MaxStaleness time.Duration
+	Client       *http.Client
+	Logger       core.Logger
+	syncClock
+}
+
+const (
+	defaultConsulWait = 5 * time.Minute
+	minRetryDelay     = time.Second
+	maxRetryDelay     = 30 * time.Second
+)
+
+type consulEntry struct {
+	Node struct {
+		Address    string
+		Datacenter string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Meta    map[string]string
+	}
+}
+
+// Run 持续将服务的健康实例同步到registry，直到ctx取消
+func (c *Consul) Run(ctx context.Context, registry Registry) error {
+	logger := c.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	r := newReconciler(registry, logger)
+
+	var (
+		index uint64
+		delay = minRetryDelay
+	)
+	for {
+		targets, next, err := c.query(ctx, index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warn("query consul failed", "service", c.Service, "error", err, "retry_in", delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			delay = min(delay*2, maxRetryDelay)
+			continue
+		}
+		delay = minRetryDelay
+
+		// 索引变小说明Consul重置了状态，需要重新做一次完整查询
+		if next < index {
+			index = 0
+			continue
+		}
+		index = next
+		r.reconcile(targets)
+		c.synced()
+	}
+}
+
+// StaleAfter 阻塞查询在没有变化时也会在WaitTime后返回，超过两倍的WaitTime没有成功返回说明同步已经中断
+func (c *Consul) StaleAfter() time.Duration {
+	if c.MaxStaleness > 0 {
+		return c.MaxStaleness
+	}
+	return 2 * c.wait()
+}
+
+func (c *Consul) wait() time.Duration {
+	if c.WaitTime <= 0 {
+		return defaultConsulWait
+	}
+	return c.WaitTime
+}
+
+func (c *Consul) query(ctx context.Context, index uint64) ([]Target, uint64, error) {
+	wait := c.wait()
+
+	q := url.Values{}
+	q.Set("passing", "1")
+	q.Set("index", strconv.FormatUint(index, 10))
+	q.Set("wait", wait.String())
+	if c.Tag != "" {
+		q.Set("tag", c.Tag)
+	}
+	if c.Datacenter != "" {
+		q.Set("dc", c.Datacenter)
+	}
+	u := fmt.Sprintf("%s/v1/health/service/%s?%s", c.Address, url.PathEscape(c.Service), q.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+
+	client := c.Client
+	if client == nil {
+		// 阻塞查询最长等待wait，Consul还会额外加上最多wait/16的随机时间
+		client = &http.Client{Timeout: wait + wait/16 + 10*time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returned %s", resp.Status)
+	}
+	next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid X-Consul-Index: %w", err)
+	}
+
+	var entries []consulEntry
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+	targets := make([]Target, 0, len(entries))
+	for _, e := range entries {
+		// 服务没有单独设置地址时使用节点地址
+		addr := e.Service.Address
+		if addr == "" {
+			addr = e.Node.Address
+		}
+		targets = append(targets, Target{
+			Host: net.JoinHostPort(addr, strconv.Itoa(e.Service.Port)),
+			Meta: core.Metadata{
+				Datacenter: e.Node.Datacenter,
+				Zone:       e.Service.Meta["zone"],
+				Tags:       e.Service.Meta,
+			},
+		})
+	}
+	return targets, next, nil
+}
@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// Registry 发现的服务器注册到这里，*proxy.Proxy满足该接口
+type Registry interface {
+	RegisterHostWithMeta(host string, meta core.Metadata) error
+	UnregisterHost(host string) error
+}
+
+// Target 服务注册中心返回的一个后端
+type Target struct {
+	Host string
+	Meta core.Metadata
+}
+
+// reconciler 让环中由服务发现添加的服务器与注册中心一致
+// 手动注册的服务器不受影响：只移除自己添加过的服务器
+type reconciler struct {
+	registry Registry
+	logger   core.Logger
+	managed  map[string]bool
+	sync.Mutex
+}
+
+func newReconciler(registry Registry, logger core.Logger) *reconciler {
+	return &reconciler{
+		registry: registry,
+		logger:   logger,
+		managed:  make(map[string]bool),
+	}
+}
+
+func (r *reconciler) reconcile(targets []Target) {
+	r.Lock()
+	defer r.Unlock()
+
+	desired := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		desired[t.Host] = true
+		if r.managed[t.Host] {
+			continue
+		}
+
+		err := r.registry.RegisterHostWithMeta(t.Host, t.Meta)
+		switch {
+		case err == nil:
+			r.managed[t.Host] = true
+		case errors.Is(err, core.ErrHostAlreadyExists):
+			// 已经手动注册过，不接管
+		default:
+			r.logger.Error("register discovered host failed", "host", t.Host, "error", err)
+		}
+	}
+
+	for host := range r.managed {
+		if desired[host] {
+			continue
+		}
+		err := r.registry.UnregisterHost(host)
+		if err != nil && !errors.Is(err, core.ErrHostNotFound) {
+			r.logger.Error("unregister discovered host failed", "host", host, "error", err)
+			continue
+		}
+		delete(r.managed, host)
+	}
+}
+
+// syncClock 记录最近一次成功同步的时间，供代理的就绪检查判断拓扑是否过期
+type syncClock struct {
+	last atomic.Int64
+}
+
+func (s *syncClock) synced() {
+	s.last.Store(time.Now().UnixNano())
+}
+
+// LastSync 最近一次成功同步的时间，还没有同步成功过时为零值
+func (s *syncClock) LastSync() time.Time {
+	if n := s.last.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,112 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// DNS 定期解析DNS记录并同步环上的节点
+// Port为空时解析SRV记录（如 _kv._tcp.example.com），否则解析A/AAAA记录并使用该端口
+type DNS struct {
+	Name string
+	Port string
+	// 两次解析的间隔，再加上[0, Jitter)的随机时间，避免多个代理同时解析
+	Interval time.Duration
+	Jitter   time.Duration
+	Resolver *net.Resolver
+	Logger   core.Logger
+	// 超过该时长没有解析成功时认为拓扑已经过期，默认为三个解析周期
+	MaxStaleness time.Duration
+	syncClock
+}
+
+const defaultDNSInterval = 30 * time.Second
+
+// Run 持续将解析结果同步到registry，直到ctx取消
+// 解析失败时保持环不变，等待下一次解析
+func (d *DNS) Run(ctx context.Context, registry Registry) error {
+	logger := d.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	r := newReconciler(registry, logger)
+
+	for {
+		targets, err := d.resolve(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warn("resolve dns failed", "name", d.Name, "error", err)
+		} else {
+			r.reconcile(targets)
+			d.synced()
+		}
+
+		select {
+		case <-time.After(d.next()):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// StaleAfter 允许连续两次解析失败
+func (d *DNS) StaleAfter() time.Duration {
+	if d.MaxStaleness > 0 {
+		return d.MaxStaleness
+	}
+	interval := d.Interval
+	if interval <= 0 {
+		interval = defaultDNSInterval
+	}
+	return 3 * (interval + d.Jitter)
+}
+
+func (d *DNS) next() time.Duration {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = defaultDNSInterval
+	}
+	if d.Jitter > 0 {
+		interval += time.Duration(rand.Int63n(int64(d.Jitter)))
+	}
+	return interval
+}
+
+func (d *DNS) resolve(ctx context.Context) ([]Target, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	if d.Port != "" {
+		addrs, err := resolver.LookupHost(ctx, d.Name)
+		if err != nil {
+			return nil, err
+		}
+		targets := make([]Target, 0, len(addrs))
+		for _, addr := range addrs {
+			targets = append(targets, Target{Host: net.JoinHostPort(addr, d.Port)})
+		}
+		return targets, nil
+	}
+
+	_, records, err := resolver.LookupSRV(ctx, "", "", d.Name)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]Target, 0, len(records))
+	for _, srv := range records {
+		host := strings.TrimSuffix(srv.Target, ".")
+		targets = append(targets, Target{Host: net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))})
+	}
+	return targets, nil
+}
@@ -0,0 +1,142 @@
+// Package grpcbalancer 为gRPC客户端提供一致性哈希的负载均衡：按请求携带的路由key选择后端连接
+//
+//	conn, err := grpc.Dial("chash:///backends",
+//		grpc.WithResolvers(grpcbalancer.NewResolverBuilder(ring)),
+//		grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	resp, err := client.Get(grpcbalancer.WithKey(ctx, "user-1"), req)
+//
+// 也可以搭配其他resolver，在服务配置中指定 {"loadBalancingConfig": [{"consistent_hash": {}}]}
+package grpcbalancer
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+const (
+	// Name 默认注册的负载均衡策略名
+	Name = "consistent_hash"
+	// MetadataKey 未通过WithKey设置路由key时，从该outgoing metadata中读取
+	MetadataKey = "x-routing-key"
+)
+
+// Config 负载均衡的参数，Replicas和Hasher与代理的环一致时，选出的后端也与代理一致
+type Config struct {
+	Name        string
+	Replicas    int
+	Hasher      core.Hasher
+	MetadataKey string
+}
+
+func init() {
+	balancer.Register(NewBuilder(Config{}))
+}
+
+// NewBuilder 创建负载均衡策略，使用非默认参数时需要以新的Name通过balancer.Register注册
+// 只在就绪的连接之间选择，连接断开后其上的key沿环转到下一台
+func NewBuilder(config Config) balancer.Builder {
+	if config.Name == "" {
+		config.Name = Name
+	}
+	if config.MetadataKey == "" {
+		config.MetadataKey = MetadataKey
+	}
+	return base.NewBalancerBuilder(config.Name, &pickerBuilder{config: config}, base.Config{HealthCheck: true})
+}
+
+type keyContextKey struct{}
+
+// WithKey 设置本次调用的路由key
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+func keyFrom(ctx context.Context, metadataKey string) string {
+	if key, ok := ctx.Value(keyContextKey{}).(string); ok && key != "" {
+		return key
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if values := md.Get(metadataKey); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+type weightKey struct{}
+
+// WithWeight 在地址上记录服务器的权重，权重越大分到的key越多；只在建立连接时读取
+func WithWeight(addr resolver.Address, weight int) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(weightKey{}, weight)
+	return addr
+}
+
+func weightOf(addr resolver.Address) int {
+	weight, _ := addr.BalancerAttributes.Value(weightKey{}).(int)
+	return weight
+}
+
+type pickerBuilder struct {
+	config Config
+}
+
+// Build 就绪的连接变化时，以这些连接的地址重建一个环
+func (b *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &picker{
+		ring:        core.New(b.config.Replicas, b.config.Hasher),
+		subConns:    make(map[string]balancer.SubConn, len(info.ReadySCs)),
+		metadataKey: b.config.MetadataKey,
+	}
+	for sc, sci := range info.ReadySCs {
+		addr := sci.Address.Addr
+		if _, ok := p.subConns[addr]; ok {
+			continue
+		}
+		weight := weightOf(sci.Address)
+		if weight <= 0 {
+			weight = 1
+		}
+		if err := p.ring.RegisterHostWithWeight(addr, weight); err != nil {
+			continue
+		}
+		p.subConns[addr] = sc
+		p.list = append(p.list, sc)
+	}
+	return p
+}
+
+type picker struct {
+	ring        *core.Consistent
+	subConns    map[string]balancer.SubConn
+	list        []balancer.SubConn
+	metadataKey string
+	next        atomic.Uint32
+}
+
+// Pick 没有路由key的调用在就绪的连接之间轮询
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	key := keyFrom(info.Ctx, p.metadataKey)
+	if key == "" {
+		i := p.next.Add(1)
+		return balancer.PickResult{SubConn: p.list[int(i)%len(p.list)]}, nil
+	}
+
+	host, err := p.ring.GetHost(key)
+	if err != nil {
+		return balancer.PickResult{}, status.Error(codes.Unavailable, err.Error())
+	}
+	return balancer.PickResult{SubConn: p.subConns[host]}, nil
+}
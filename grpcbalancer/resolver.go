@@ -0,0 +1,65 @@
+package grpcbalancer
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/resolver"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// Scheme NewResolverBuilder解析的target前缀，如 chash:///backends
+const Scheme = "chash"
+
+// NewResolverBuilder 以环上的服务器作为后端地址，环变化时更新，并选择consistent_hash负载均衡
+// 环上的服务器地址需要是后端的gRPC地址
+func NewResolverBuilder(ring *core.Consistent) resolver.Builder {
+	return &resolverBuilder{ring: ring}
+}
+
+type resolverBuilder struct {
+	ring *core.Consistent
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return Scheme
+}
+
+func (b *resolverBuilder) Build(_ resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	r := &ringResolver{
+		ring:   b.ring,
+		cc:     cc,
+		events: b.ring.Subscribe(),
+	}
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	go r.watch()
+	return r, nil
+}
+
+type ringResolver struct {
+	ring   *core.Consistent
+	cc     resolver.ClientConn
+	events <-chan core.TopologyEvent
+}
+
+// 事件可能因缓冲区满而丢失，每次收到事件都按环的当前状态全量更新
+func (r *ringResolver) watch() {
+	for range r.events {
+		r.ResolveNow(resolver.ResolveNowOptions{})
+	}
+}
+
+func (r *ringResolver) ResolveNow(resolver.ResolveNowOptions) {
+	weights := r.ring.GetWeights()
+	addrs := make([]resolver.Address, 0, len(weights))
+	for host, weight := range weights {
+		addrs = append(addrs, WithWeight(resolver.Address{Addr: host}, weight))
+	}
+
+	serviceConfig := r.cc.ParseServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{%q: {}}]}`, Name))
+	_ = r.cc.UpdateState(resolver.State{Addresses: addrs, ServiceConfig: serviceConfig})
+}
+
+func (r *ringResolver) Close() {
+	r.ring.Unsubscribe(r.events)
+}
@@ -0,0 +1,79 @@
+// Package l4 在TCP/UDP层按一致性哈希转发连接，用于Redis、MQTT等非HTTP协议
+package l4
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// KeySource 连接的路由key来源
+type KeySource int
+
+const (
+	// KeySourceIP 以客户端IP为key，同一客户端的连接总是转发到同一台服务器
+	KeySourceIP KeySource = iota
+	// KeySourceAddr 以客户端IP:端口为key
+	KeySourceAddr
+	// KeySourcePreamble 连接开头是2字节大端长度加key，转发前去掉，只支持TCP
+	KeySourcePreamble
+)
+
+func (s KeySource) String() string {
+	switch s {
+	case KeySourceIP:
+		return "ip"
+	case KeySourceAddr:
+		return "addr"
+	case KeySourcePreamble:
+		return "preamble"
+	}
+	return "unknown"
+}
+
+// ParseKeySource 解析ip、addr或preamble
+func ParseKeySource(s string) (KeySource, error) {
+	for _, source := range []KeySource{KeySourceIP, KeySourceAddr, KeySourcePreamble} {
+		if source.String() == s {
+			return source, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key source %q", s)
+}
+
+var errInvalidPreamble = errors.New("invalid key preamble")
+
+func addrKey(addr net.Addr, source KeySource) string {
+	if source != KeySourceIP {
+		return addr.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// pick 与HTTP代理一致，跳过正在摘除的服务器，沿环选择下一台
+func pick(ring *core.Consistent, key string, capacious bool) (string, error) {
+	if capacious {
+		return ring.GetHostCapacious(key)
+	}
+
+	host, err := ring.GetHost(key)
+	if err != nil || !ring.IsDraining(host) {
+		return host, err
+	}
+	hosts, err := ring.GetHosts(key, ring.Size())
+	if err != nil {
+		return "", err
+	}
+	for _, h := range hosts {
+		if !ring.IsDraining(h) {
+			return h, nil
+		}
+	}
+	return "", core.ErrAllHostsOverloaded
+}
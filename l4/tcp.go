@@ -0,0 +1,158 @@
+package l4
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// TCP 监听Addr，按路由key在环上选出服务器后，在客户端和服务器之间双向转发字节
+type TCP struct {
+	Addr string
+	Ring *core.Consistent
+	Key  KeySource
+	// 为true时选择服务器时考虑负载，每个连接计为一个负载
+	Capacious       bool
+	DialTimeout     time.Duration
+	PreambleTimeout time.Duration
+	Logger          core.Logger
+}
+
+const (
+	defaultDialTimeout     = 3 * time.Second
+	defaultPreambleTimeout = 5 * time.Second
+)
+
+// Run 监听并转发连接，直到ctx取消；取消后关闭所有正在转发的连接
+func (t *TCP) Run(ctx context.Context) error {
+	lis, err := net.Listen("tcp", t.Addr)
+	if err != nil {
+		return err
+	}
+	return t.Serve(ctx, lis)
+}
+
+func (t *TCP) Serve(ctx context.Context, lis net.Listener) error {
+	logger := t.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = lis.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := t.handle(ctx, conn); err != nil {
+				logger.Warn("tcp proxy connection failed", "client", conn.RemoteAddr().String(), "error", err)
+			}
+		}()
+	}
+}
+
+func (t *TCP) handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	key, err := t.key(conn)
+	if err != nil {
+		return err
+	}
+	host, err := pick(t.Ring, key, t.Capacious)
+	if err != nil {
+		return err
+	}
+	if t.Capacious && t.Ring.Inc(host) == nil {
+		defer t.Ring.Done(host)
+	}
+
+	dialTimeout := t.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	backend, err := (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	// ctx取消时关闭两端，让io.Copy返回
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+		_ = backend.Close()
+	})
+	defer stop()
+	splice(conn, backend)
+	return nil
+}
+
+// key 从连接中取出路由key，preamble方式会读掉连接开头的key
+func (t *TCP) key(conn net.Conn) (string, error) {
+	if t.Key != KeySourcePreamble {
+		return addrKey(conn.RemoteAddr(), t.Key), nil
+	}
+
+	timeout := t.PreambleTimeout
+	if timeout <= 0 {
+		timeout = defaultPreambleTimeout
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return "", err
+	}
+	n := binary.BigEndian.Uint16(size[:])
+	if n == 0 {
+		return "", errInvalidPreamble
+	}
+	key := make([]byte, n)
+	if _, err := io.ReadFull(conn, key); err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// splice 双向复制直到两个方向都结束，一个方向结束时半关闭另一端的写
+func splice(client, backend net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+	go copyHalf(backend, client)
+	go copyHalf(client, backend)
+	wg.Wait()
+}
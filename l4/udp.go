@@ -0,0 +1,169 @@
+package l4
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// UDP 监听Addr，按客户端地址在环上选出服务器，为每个客户端维护一个到服务器的会话，转发双向的数据报
+type UDP struct {
+	Addr string
+	Ring *core.Consistent
+	// 只支持KeySourceIP和KeySourceAddr
+	Key KeySource
+	// 会话空闲超过该时间后关闭，之后的数据报重新选择服务器
+	IdleTimeout time.Duration
+	Logger      core.Logger
+}
+
+const (
+	defaultUDPIdleTimeout = time.Minute
+	maxDatagramSize       = 64 << 10
+)
+
+type udpSession struct {
+	backend *net.UDPConn
+	host    string
+	last    time.Time
+}
+
+// Run 监听并转发数据报，直到ctx取消
+func (u *UDP) Run(ctx context.Context) error {
+	if u.Key == KeySourcePreamble {
+		return errors.New("udp proxy does not support key preamble")
+	}
+	lis, err := net.ListenPacket("udp", u.Addr)
+	if err != nil {
+		return err
+	}
+	return u.Serve(ctx, lis)
+}
+
+func (u *UDP) Serve(ctx context.Context, lis net.PacketConn) error {
+	logger := u.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	idle := u.IdleTimeout
+	if idle <= 0 {
+		idle = defaultUDPIdleTimeout
+	}
+
+	var mu sync.Mutex
+	sessions := make(map[string]*udpSession)
+	closeSession := func(client string, s *udpSession) {
+		mu.Lock()
+		if sessions[client] == s {
+			delete(sessions, client)
+		}
+		mu.Unlock()
+		_ = s.backend.Close()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = lis.Close()
+		mu.Lock()
+		for _, s := range sessions {
+			_ = s.backend.Close()
+		}
+		mu.Unlock()
+	}()
+	go u.sweep(ctx, idle, &mu, sessions)
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := lis.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		mu.Lock()
+		s, ok := sessions[client.String()]
+		if ok {
+			s.last = time.Now()
+		}
+		mu.Unlock()
+		if !ok {
+			if s, err = u.dial(client); err != nil {
+				logger.Warn("udp proxy session failed", "client", client.String(), "error", err)
+				continue
+			}
+			mu.Lock()
+			sessions[client.String()] = s
+			mu.Unlock()
+			go func(client net.Addr, s *udpSession) {
+				defer closeSession(client.String(), s)
+				u.reply(lis, client, s, &mu)
+			}(client, s)
+		}
+
+		if _, err = s.backend.Write(buf[:n]); err != nil {
+			logger.Warn("udp proxy write failed", "client", client.String(), "host", s.host, "error", err)
+		}
+	}
+}
+
+func (u *UDP) dial(client net.Addr) (*udpSession, error) {
+	host, err := pick(u.Ring, addrKey(client, u.Key), false)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpSession{backend: backend, host: host, last: time.Now()}, nil
+}
+
+// reply 将服务器的响应转发回客户端，会话被关闭时返回
+func (u *UDP) reply(lis net.PacketConn, client net.Addr, s *udpSession, mu *sync.Mutex) {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := s.backend.Read(buf)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		s.last = time.Now()
+		mu.Unlock()
+		if _, err = lis.WriteTo(buf[:n], client); err != nil {
+			return
+		}
+	}
+}
+
+// sweep 关闭空闲的会话，reply随之返回并把会话从表中删除
+func (u *UDP) sweep(ctx context.Context, idle time.Duration, mu *sync.Mutex, sessions map[string]*udpSession) {
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mu.Lock()
+			for _, s := range sessions {
+				if time.Since(s.last) > idle {
+					_ = s.backend.Close()
+				}
+			}
+			mu.Unlock()
+		}
+	}
+}
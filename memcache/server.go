@@ -0,0 +1,409 @@
+// Package memcache 实现memcached文本协议的分片代理：按key在环上选出memcached实例后转发
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// Server 监听Addr，接受memcached客户端连接
+// get、gets、gat、gats的多个key按服务器拆分后按请求的顺序合并结果
+type Server struct {
+	Addr string
+	Ring *core.Consistent
+	// 连接后端以及每次读写后端的超时
+	DialTimeout time.Duration
+	Logger      core.Logger
+}
+
+const (
+	defaultDialTimeout = 3 * time.Second
+	maxKeyLength       = 250
+	// 单个值的大小上限，与memcached默认的item_size_max一致
+	maxValueSize = 1 << 20
+)
+
+var errBadCommand = errors.New("bad command line format")
+
+// Run 监听并处理连接，直到ctx取消；取消后关闭所有连接
+func (s *Server) Run(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, lis)
+}
+
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = lis.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+			sess := &session{server: s, backends: make(map[string]*backend)}
+			if err := sess.serve(conn); err != nil && ctx.Err() == nil {
+				logger.Warn("memcache proxy connection failed", "client", conn.RemoteAddr().String(), "error", err)
+			}
+		}()
+	}
+}
+
+// session 一个客户端连接，按需建立到各台服务器的连接，命令按顺序执行
+type session struct {
+	server   *Server
+	backends map[string]*backend
+}
+
+type backend struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+// item get类命令返回的一项，raw为VALUE行和数据块
+type item struct {
+	key string
+	raw []byte
+}
+
+func (sess *session) serve(conn net.Conn) error {
+	defer conn.Close()
+	defer sess.close()
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			_, _ = w.WriteString("ERROR\r\n")
+		} else if fields[0] == "quit" {
+			return w.Flush()
+		} else if err = sess.exec(r, w, fields); err != nil {
+			return err
+		}
+
+		// 客户端批量发送时，读完缓冲的命令再一起写出
+		if r.Buffered() == 0 {
+			if err = w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// exec 执行一条命令并写出响应；只有读写客户端连接失败时返回错误
+func (sess *session) exec(r *bufio.Reader, w *bufio.Writer, fields []string) error {
+	switch fields[0] {
+	case "get", "gets":
+		return sess.get(w, fields[0], nil, fields[1:])
+	case "gat", "gats":
+		if len(fields) < 3 {
+			return clientError(w, errBadCommand)
+		}
+		return sess.get(w, fields[0], fields[1:2], fields[2:])
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return sess.store(r, w, fields)
+	case "delete", "incr", "decr", "touch":
+		if len(fields) < 2 {
+			return clientError(w, errBadCommand)
+		}
+		return sess.simple(w, fields)
+	case "version":
+		_, err := w.WriteString("VERSION consistent-hash\r\n")
+		return err
+	}
+	_, err := w.WriteString("ERROR\r\n")
+	return err
+}
+
+func (sess *session) get(w *bufio.Writer, cmd string, args, keys []string) error {
+	if len(keys) == 0 {
+		_, err := w.WriteString("ERROR\r\n")
+		return err
+	}
+	groups := make(map[string][]string)
+	for _, key := range keys {
+		if err := validKey(key); err != nil {
+			return clientError(w, err)
+		}
+		host, err := sess.server.Ring.GetHost(key)
+		if err != nil {
+			return serverError(w, err)
+		}
+		groups[host] = append(groups[host], key)
+	}
+
+	found := make(map[string][]byte, len(keys))
+	for host, hostKeys := range groups {
+		line := strings.Join(append(append([]string{cmd}, args...), hostKeys...), " ")
+		items, err := sess.fetch(host, line)
+		if err != nil {
+			return serverError(w, err)
+		}
+		for _, it := range items {
+			found[it.key] = it.raw
+		}
+	}
+
+	// 按请求中key的顺序返回，重复的key只返回一次
+	for _, key := range keys {
+		if raw, ok := found[key]; ok {
+			_, _ = w.Write(raw)
+			delete(found, key)
+		}
+	}
+	_, err := w.WriteString("END\r\n")
+	return err
+}
+
+// fetch 在host上执行get类命令，读取所有VALUE直到END
+func (sess *session) fetch(host, line string) ([]item, error) {
+	b, err := sess.backend(host)
+	if err != nil {
+		return nil, err
+	}
+	items, err := b.fetch(line)
+	if err != nil {
+		sess.drop(host)
+		return nil, fmt.Errorf("backend %s: %w", host, err)
+	}
+	return items, nil
+}
+
+func (b *backend) fetch(line string) ([]item, error) {
+	if err := b.conn.SetDeadline(time.Now().Add(b.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := b.w.WriteString(line + "\r\n"); err != nil {
+		return nil, err
+	}
+	if err := b.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	var items []item
+	for {
+		header, err := readLine(b.r)
+		if err != nil {
+			return nil, err
+		}
+		if header == "END" {
+			return items, nil
+		}
+		fields := strings.Fields(header)
+		if len(fields) < 4 || fields[0] != "VALUE" {
+			return nil, fmt.Errorf("unexpected reply %q", header)
+		}
+		size, err := strconv.Atoi(fields[3])
+		if err != nil || size < 0 || size > maxValueSize {
+			return nil, fmt.Errorf("unexpected reply %q", header)
+		}
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(b.r, data); err != nil {
+			return nil, err
+		}
+		items = append(items, item{key: fields[1], raw: append([]byte(header+"\r\n"), data...)})
+	}
+}
+
+// store 转发set类命令及其数据块，格式为 <cmd> <key> <flags> <exptime> <bytes> [<cas>] [noreply]
+func (sess *session) store(r *bufio.Reader, w *bufio.Writer, fields []string) error {
+	n := 5
+	if fields[0] == "cas" {
+		n = 6
+	}
+	if len(fields) < n || len(fields) > n+1 {
+		return clientError(w, errBadCommand)
+	}
+	size, err := strconv.Atoi(fields[4])
+	if err != nil || size < 0 {
+		return clientError(w, errBadCommand)
+	}
+	if size > maxValueSize {
+		// 丢弃数据块，保持与客户端的协议同步
+		if _, err = io.CopyN(io.Discard, r, int64(size)+2); err != nil {
+			return err
+		}
+		_, err = w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return err
+	}
+	data := make([]byte, size+2)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return err
+	}
+	if string(data[size:]) != "\r\n" {
+		return clientError(w, errors.New("bad data chunk"))
+	}
+	if err = validKey(fields[1]); err != nil {
+		return clientError(w, err)
+	}
+
+	fields, noreply := cutNoreply(fields, n)
+	return sess.forward(w, fields, data, noreply)
+}
+
+// simple 转发delete、incr、decr、touch等单行响应的命令
+func (sess *session) simple(w *bufio.Writer, fields []string) error {
+	if err := validKey(fields[1]); err != nil {
+		return clientError(w, err)
+	}
+	n := 3
+	if fields[0] == "delete" {
+		n = 2
+	}
+	fields, noreply := cutNoreply(fields, n)
+	return sess.forward(w, fields, nil, noreply)
+}
+
+// cutNoreply 去掉第n个参数处的noreply
+// 转发给后端时总是等待响应，避免后端返回的错误与后续命令的响应错位
+func cutNoreply(fields []string, n int) ([]string, bool) {
+	if len(fields) > n && fields[n] == "noreply" {
+		return fields[:n], true
+	}
+	return fields, false
+}
+
+func (sess *session) forward(w *bufio.Writer, fields []string, data []byte, noreply bool) error {
+	host, err := sess.server.Ring.GetHost(fields[1])
+	if err != nil {
+		return sess.reply(w, "SERVER_ERROR "+err.Error(), noreply)
+	}
+	b, err := sess.backend(host)
+	if err != nil {
+		return sess.reply(w, "SERVER_ERROR "+err.Error(), noreply)
+	}
+
+	reply, err := b.roundTrip(strings.Join(fields, " "), data)
+	if err != nil {
+		sess.drop(host)
+		return sess.reply(w, fmt.Sprintf("SERVER_ERROR backend %s: %v", host, err), noreply)
+	}
+	return sess.reply(w, reply, noreply)
+}
+
+func (sess *session) reply(w *bufio.Writer, line string, noreply bool) error {
+	if noreply {
+		return nil
+	}
+	_, err := w.WriteString(line + "\r\n")
+	return err
+}
+
+// roundTrip 发送命令并读取一行响应，整个过程不超过timeout
+func (b *backend) roundTrip(line string, data []byte) (string, error) {
+	if err := b.conn.SetDeadline(time.Now().Add(b.timeout)); err != nil {
+		return "", err
+	}
+	_, _ = b.w.WriteString(line + "\r\n")
+	_, _ = b.w.Write(data)
+	if err := b.w.Flush(); err != nil {
+		return "", err
+	}
+	return readLine(b.r)
+}
+
+func (sess *session) backend(host string) (*backend, error) {
+	if b, ok := sess.backends[host]; ok {
+		return b, nil
+	}
+
+	timeout := sess.server.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	b := &backend{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), timeout: timeout}
+	sess.backends[host] = b
+	return b, nil
+}
+
+func (sess *session) drop(host string) {
+	if b, ok := sess.backends[host]; ok {
+		_ = b.conn.Close()
+		delete(sess.backends, host)
+	}
+}
+
+func (sess *session) close() {
+	for host := range sess.backends {
+		sess.drop(host)
+	}
+}
+
+func validKey(key string) error {
+	if len(key) > maxKeyLength {
+		return errors.New("key too long")
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return errBadCommand
+		}
+	}
+	return nil
+}
+
+func clientError(w *bufio.Writer, err error) error {
+	_, werr := fmt.Fprintf(w, "CLIENT_ERROR %v\r\n", err)
+	return werr
+}
+
+func serverError(w *bufio.Writer, err error) error {
+	_, werr := fmt.Fprintf(w, "SERVER_ERROR %v\r\n", err)
+	return werr
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
@@ -0,0 +1,210 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// fakeMemcache 内存中的memcached，只实现get、set、delete；stuck不为nil时收到命令后等待它关闭
+type fakeMemcache struct {
+	addr  string
+	data  map[string]string
+	stuck chan struct{}
+	sync.Mutex
+}
+
+func newFakeMemcache(t *testing.T) *fakeMemcache {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	f := &fakeMemcache{addr: lis.Addr().String(), data: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeMemcache) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		if f.stuck != nil {
+			<-f.stuck
+			return
+		}
+		fields := strings.Fields(line)
+		f.Lock()
+		switch fields[0] {
+		case "get":
+			for _, key := range fields[1:] {
+				if v, ok := f.data[key]; ok {
+					w.WriteString("VALUE " + key + " 0 " + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+				}
+			}
+			w.WriteString("END\r\n")
+		case "set":
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			_, _ = io.ReadFull(r, data)
+			f.data[fields[1]] = string(data[:size])
+			w.WriteString("STORED\r\n")
+		case "delete":
+			if _, ok := f.data[fields[1]]; ok {
+				delete(f.data, fields[1])
+				w.WriteString("DELETED\r\n")
+			} else {
+				w.WriteString("NOT_FOUND\r\n")
+			}
+		default:
+			w.WriteString("ERROR\r\n")
+		}
+		f.Unlock()
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// startServer 启动代理，ring上是backends的地址；返回的函数发送原始的请求并读取n行响应
+func startServer(t *testing.T, backends ...*fakeMemcache) (*core.Consistent, func(req string, lines int) []string) {
+	t.Helper()
+	ring := core.New(50, nil)
+	for _, b := range backends {
+		if err := ring.RegisterHost(b.addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Ring: ring, DialTimeout: 200 * time.Millisecond, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = s.Serve(ctx, lis)
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		cancel()
+		<-done
+	})
+	r := bufio.NewReader(conn)
+	return ring, func(req string, lines int) []string {
+		t.Helper()
+		if _, err := conn.Write([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]string, 0, lines)
+		for i := 0; i < lines; i++ {
+			line, err := readLine(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply = append(reply, line)
+		}
+		return reply
+	}
+}
+
+func TestBackendDeadline(t *testing.T) {
+	stuck := newFakeMemcache(t)
+	stuck.stuck = make(chan struct{})
+	defer close(stuck.stuck)
+	_, call := startServer(t, stuck)
+
+	tests := []struct {
+		name string
+		req  string
+	}{
+		{"get", "get k\r\n"},
+		{"set", "set k 0 0 1\r\nv\r\n"},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		reply := call(tt.req, 1)
+		if !strings.HasPrefix(reply[0], "SERVER_ERROR") || !strings.Contains(reply[0], "timeout") {
+			t.Fatalf("%s on a stuck backend = %q, want a timeout error", tt.name, reply[0])
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("%s took %v", tt.name, elapsed)
+		}
+	}
+}
+
+func TestMultiGetSplitting(t *testing.T) {
+	backends := []*fakeMemcache{newFakeMemcache(t), newFakeMemcache(t), newFakeMemcache(t)}
+	ring, call := startServer(t, backends...)
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, key := range keys {
+		if reply := call("set "+key+" 0 0 "+strconv.Itoa(len(key)+2)+"\r\nv-"+key+"\r\n", 1); reply[0] != "STORED" {
+			t.Fatalf("set %s = %q", key, reply)
+		}
+	}
+	// 每个key都保存在它在环上的服务器
+	hosts := make(map[string]bool)
+	for _, b := range backends {
+		b.Lock()
+		for key := range b.data {
+			if owner, _ := ring.GetHost(key); owner != b.addr {
+				t.Errorf("%s stored on %s, owner is %s", key, b.addr, owner)
+			}
+			hosts[b.addr] = true
+		}
+		b.Unlock()
+	}
+	if len(hosts) < 2 {
+		t.Fatalf("keys were stored on %d hosts, want several", len(hosts))
+	}
+
+	tests := []struct {
+		name string
+		req  string
+		want []string
+	}{
+		{"request order", "get h a missing c\r\n", []string{"VALUE h 0 3", "v-h", "VALUE a 0 3", "v-a", "VALUE c 0 3", "v-c", "END"}},
+		{"duplicate keys", "get b b\r\n", []string{"VALUE b 0 3", "v-b", "END"}},
+		{"all missing", "get x y\r\n", []string{"END"}},
+		{"delete", "delete d\r\n", []string{"DELETED"}},
+		{"get after delete", "get d e\r\n", []string{"VALUE e 0 3", "v-e", "END"}},
+		{"no keys", "get\r\n", []string{"ERROR"}},
+		{"key too long", "get " + strings.Repeat("k", maxKeyLength+1) + "\r\n", []string{"CLIENT_ERROR key too long"}},
+		{"noreply", "delete e noreply\r\nget e\r\n", []string{"END"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := call(tt.req, len(tt.want)); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("%q = %q, want %q", tt.req, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/dingqing/consistent-hash/core"
+	"github.com/dingqing/consistent-hash/proxy"
+)
+
+const namespace = "consistent_hash"
+
+// Prometheus 同时实现core.Metrics和proxy.Metrics，将指标导出给Prometheus
+type Prometheus struct {
+	gatherer prometheus.Gatherer
+
+	lookups        *prometheus.CounterVec
+	lookupErrors   *prometheus.CounterVec
+	ringSize       prometheus.Gauge
+	topology       *prometheus.CounterVec
+	loads          *prometheus.GaugeVec
+	routes         *prometheus.CounterVec
+	routeErrors    *prometheus.CounterVec
+	backendLatency *prometheus.HistogramVec
+	backendErrors  *prometheus.CounterVec
+	cache          *prometheus.CounterVec
+	rateLimited    *prometheus.CounterVec
+	evictions      *prometheus.CounterVec
+}
+
+var (
+	_ core.Metrics  = (*Prometheus)(nil)
+	_ proxy.Metrics = (*Prometheus)(nil)
+)
+
+// NewPrometheus 在reg中注册所有指标，reg为nil时使用独立的registry
+func NewPrometheus(reg *prometheus.Registry) *Prometheus {
+	if reg == nil {
+		reg = prometheus.NewRegistry()
+	}
+
+	m := &Prometheus{
+		gatherer: reg,
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "lookups_total",
+			Help:      "Number of successful key lookups by chosen host.",
+		}, []string{"host"}),
+		lookupErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "lookup_errors_total",
+			Help:      "Number of failed key lookups by error.",
+		}, []string{"error"}),
+		ringSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ring_hosts",
+			Help:      "Number of hosts in the ring.",
+		}),
+		topology: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "topology_events_total",
+			Help:      "Number of ring topology changes by type.",
+		}, []string{"type"}),
+		loads: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "host_load",
+			Help:      "Current load (LoadBound) of each host.",
+		}, []string{"host"}),
+		routes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_requests_total",
+			Help:      "Number of proxied requests by routed host.",
+		}, []string{"host"}),
+		routeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_route_errors_total",
+			Help:      "Number of requests that could not be routed by error.",
+		}, []string{"error"}),
+		backendLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_latency_seconds",
+			Help:      "Time until response headers from the backend.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"host", "code"}),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_errors_total",
+			Help:      "Number of backend requests that failed before a response.",
+		}, []string{"host"}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_cache_requests_total",
+			Help:      "Number of GET requests checked against the response cache by result (hit, miss, bypass).",
+		}, []string{"result"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_rate_limited_total",
+			Help:      "Number of requests rejected by the rate limiter by scope (client, key).",
+		}, []string{"scope"}),
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_host_evictions_total",
+			Help:      "Number of hosts removed or drained after consecutive connection failures by action.",
+		}, []string{"action"}),
+	}
+	reg.MustRegister(
+		m.lookups, m.lookupErrors, m.ringSize, m.topology, m.loads,
+		m.routes, m.routeErrors, m.backendLatency, m.backendErrors, m.cache,
+		m.rateLimited, m.evictions,
+	)
+	return m
+}
+
+// Handler 以Prometheus的文本格式输出指标，挂在/metrics上
+func (m *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{})
+}
+
+func (m *Prometheus) ObserveLookup(host string, err error) {
+	if err != nil {
+		m.lookupErrors.WithLabelValues(errorLabel(err)).Inc()
+		return
+	}
+	m.lookups.WithLabelValues(host).Inc()
+}
+
+func (m *Prometheus) ObserveTopology(ev core.TopologyEvent, size int) {
+	m.ringSize.Set(float64(size))
+	m.topology.WithLabelValues(ev.Type.String()).Inc()
+
+	// 下线的服务器不再保留按服务器统计的序列
+	if ev.Type == core.HostRemoved {
+		m.lookups.DeleteLabelValues(ev.Host)
+		m.loads.DeleteLabelValues(ev.Host)
+		m.routes.DeleteLabelValues(ev.Host)
+		m.backendErrors.DeleteLabelValues(ev.Host)
+		m.backendLatency.DeletePartialMatch(prometheus.Labels{"host": ev.Host})
+	}
+}
+
+func (m *Prometheus) ObserveLoad(host string, load int64) {
+	m.loads.WithLabelValues(host).Set(float64(load))
+}
+
+func (m *Prometheus) ObserveRoute(host string, err error) {
+	if err != nil {
+		m.routeErrors.WithLabelValues(errorLabel(err)).Inc()
+		return
+	}
+	m.routes.WithLabelValues(host).Inc()
+}
+
+func (m *Prometheus) ObserveBackend(host string, status int, latency time.Duration, err error) {
+	if err != nil {
+		m.backendErrors.WithLabelValues(host).Inc()
+		return
+	}
+	m.backendLatency.WithLabelValues(host, strconv.Itoa(status)).Observe(latency.Seconds())
+}
+
+func (m *Prometheus) ObserveCache(result string) {
+	m.cache.WithLabelValues(result).Inc()
+}
+
+func (m *Prometheus) ObserveRateLimited(scope string) {
+	m.rateLimited.WithLabelValues(scope).Inc()
+}
+
+// 被注销的服务器不会再出现在其他指标中，不按服务器区分
+func (m *Prometheus) ObserveHostEvicted(_, action string) {
+	m.evictions.WithLabelValues(action).Inc()
+}
+
+// 错误信息可能带有服务器名等变化的内容，只保留已知的错误类型，避免标签基数膨胀
+func errorLabel(err error) string {
+	switch {
+	case errors.Is(err, core.ErrNoHosts):
+		return "no_hosts"
+	case errors.Is(err, core.ErrAllHostsOverloaded):
+		return "all_hosts_overloaded"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	}
+	return "other"
+}
@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat 访问日志的格式
+type AccessLogFormat string
+
+const (
+	// AccessLogCommon NCSA通用格式，其后追加路由信息
+	AccessLogCommon AccessLogFormat = "common"
+	// AccessLogCombined 通用格式加Referer和User-Agent，其后追加路由信息
+	AccessLogCombined AccessLogFormat = "combined"
+	// AccessLogJSON 每行一个JSON对象
+	AccessLogJSON AccessLogFormat = "json"
+)
+
+// ParseAccessLogFormat 解析common、combined或json
+func ParseAccessLogFormat(s string) (AccessLogFormat, error) {
+	switch f := AccessLogFormat(s); f {
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown access log format %q, must be common, combined or json", s)
+}
+
+// accessLogEntry 一条访问日志，也是JSON格式的字段
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	RemoteIP  string    `json:"remote_ip"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	KeyHash   uint64    `json:"key_hash,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	// 失败后换服务器重试的次数
+	Retries int `json:"retries"`
+	// 从第一次尝试到收到后端响应头的耗时（秒）
+	UpstreamLatency float64 `json:"upstream_latency"`
+	// 整个请求的耗时（秒），包括响应体
+	Duration float64 `json:"duration"`
+}
+
+// AccessLog 每个请求在w中写一行访问日志，包括路由key、哈希值、选中的服务器、重试次数、后端耗时和响应体大小
+// 放在RequestID之后才能记录请求ID和路由结果；w的写入已经加锁
+func AccessLog(w io.Writer, format AccessLogFormat) Middleware {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			cw := &countingWriter{statusWriter: &statusWriter{ResponseWriter: rw, status: http.StatusOK}}
+			next.ServeHTTP(cw, r)
+
+			e := accessLogEntry{
+				Time:      start,
+				RemoteIP:  remoteIP(r),
+				Method:    r.Method,
+				URI:       r.URL.RequestURI(),
+				Proto:     r.Proto,
+				Status:    cw.status,
+				Bytes:     cw.bytes,
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+				Duration:  time.Since(start).Seconds(),
+			}
+			if info := requestInfoFrom(r.Context()); info != nil {
+				e.RequestID, e.Key, e.KeyHash, e.Host = info.id, info.key, info.hash, info.host
+				e.UpstreamLatency = info.upstreamLatency.Seconds()
+				e.Retries = max(info.attempts-1, 0)
+			}
+
+			line := e.format(format)
+			mu.Lock()
+			_, _ = w.Write(line)
+			mu.Unlock()
+		})
+	}
+}
+
+func (e *accessLogEntry) format(format AccessLogFormat) []byte {
+	if format == AccessLogJSON {
+		data, _ := json.Marshal(e)
+		return append(data, '\n')
+	}
+
+	b := make([]byte, 0, 256)
+	b = append(b, dash(e.RemoteIP)...)
+	b = append(b, " - - ["...)
+	b = e.Time.AppendFormat(b, "02/Jan/2006:15:04:05 -0700")
+	b = append(b, "] "...)
+	b = strconv.AppendQuote(b, e.Method+" "+e.URI+" "+e.Proto)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(e.Status), 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, e.Bytes, 10)
+	if format == AccessLogCombined {
+		b = append(b, ' ')
+		b = strconv.AppendQuote(b, dash(e.Referer))
+		b = append(b, ' ')
+		b = strconv.AppendQuote(b, dash(e.UserAgent))
+	}
+	b = append(b, " request_id="...)
+	b = append(b, dash(e.RequestID)...)
+	b = append(b, " key="...)
+	b = strconv.AppendQuote(b, e.Key)
+	b = append(b, " key_hash="...)
+	b = strconv.AppendUint(b, e.KeyHash, 10)
+	b = append(b, " host="...)
+	b = append(b, dash(e.Host)...)
+	b = append(b, " retries="...)
+	b = strconv.AppendInt(b, int64(e.Retries), 10)
+	b = append(b, " upstream_latency="...)
+	b = strconv.AppendFloat(b, e.UpstreamLatency, 'f', 3, 64)
+	b = append(b, " duration="...)
+	b = strconv.AppendFloat(b, e.Duration, 'f', 3, 64)
+	return append(b, '\n')
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// countingWriter 记录写出的状态码和响应体字节数
+type countingWriter struct {
+	*statusWriter
+	bytes int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.statusWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// RotatingFile 按大小轮转的日志文件：超过maxBytes时把path改名为path.1（原来的path.1改为path.2，依此类推），
+// 最多保留maxBackups个旧文件
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	sync.Mutex
+}
+
+// OpenRotatingFile 以追加方式打开path，maxBytes为0时不轮转
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxBackups <= 0 {
+		_ = os.Remove(f.path)
+	} else {
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,376 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// API 返回面向客户端的只读v1接口：
+//
+//	GET    /v1/route?key=          查询key对应的服务器
+//	GET    /v1/topology            服务器列表及拓扑版本号
+//	GET    /v1/topology/watch?since=N&timeout=30s
+//	                               长轮询版本号大于N的拓扑事件
+//	GET    /v1/events              以SSE推送拓扑和负载变化
+func (p *Proxy) API() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/route", p.handleRoute)
+	mux.HandleFunc("/v1/topology", p.handleTopology)
+	mux.HandleFunc("/v1/topology/watch", p.handleTopologyWatch)
+	mux.HandleFunc("/v1/events", p.handleEvents)
+	return mux
+}
+
+// AdminAPI 返回可以修改拓扑的v1管理接口，应挂在单独的、带鉴权的监听端口上：
+//
+//	GET    /v1/hosts               列出服务器
+//	POST   /v1/hosts               注册服务器
+//	GET    /v1/hosts/{host}        查看服务器
+//	PATCH  /v1/hosts/{host}        修改服务器的权重、容量上限和摘除状态
+//	DELETE /v1/hosts/{host}        注销服务器
+//	POST   /v1/hosts/{host}/renew  为带ttl的服务器续期
+//	POST   /v1/hosts/{host}/load   后端上报自己的负载
+//	GET    /v1/route?key=          查询key对应的服务器
+//	GET    /v1/route/explain?key=&mode=
+//	                               解释key为什么落在该服务器
+//	GET    /v1/ring                环的结构
+//	GET    /v1/loads               总负载、负载上限和每台服务器的负载、正在转发的请求数
+//	GET    /v1/topology            服务器列表及拓扑版本号
+//	GET    /v1/topology/watch      长轮询拓扑事件
+//	GET    /v1/events              以SSE推送拓扑和负载变化
+//	POST   /v1/peers/sync          应用其他代理实例广播的拓扑变更
+//	GET    /v1/chaos               故障注入的配置
+//	PUT    /v1/chaos               开启或修改故障注入
+//	DELETE /v1/chaos               关闭故障注入
+//	GET    /v1/outliers            被异常检测摘除的服务器
+//	GET    /v1/dead-hosts          因连续的连接失败被注销或摘除的服务器
+//	GET    /v1/slots[?key=]        槽位的分配，或key所在的槽位及服务器
+//	POST   /v1/slots               把一段槽位迁移到指定的服务器
+func (p *Proxy) AdminAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/hosts", p.handleHosts)
+	mux.HandleFunc("/v1/hosts/", p.handleHost)
+	mux.HandleFunc("/v1/route", p.handleRoute)
+	mux.HandleFunc("/v1/route/explain", p.handleRouteExplain)
+	mux.HandleFunc("/v1/ring", p.handleRing)
+	mux.HandleFunc("/v1/loads", p.handleLoads)
+	mux.HandleFunc("/v1/topology", p.handleTopology)
+	mux.HandleFunc("/v1/topology/watch", p.handleTopologyWatch)
+	mux.HandleFunc("/v1/events", p.handleEvents)
+	mux.HandleFunc("/v1/peers/sync", p.handlePeerSync)
+	mux.HandleFunc("/v1/chaos", p.handleChaos)
+	mux.HandleFunc("/v1/outliers", p.handleOutliers)
+	mux.HandleFunc("/v1/dead-hosts", p.handleDeadHosts)
+	mux.HandleFunc("/v1/slots", p.handleSlots)
+	return mux
+}
+
+type hostRequest struct {
+	Host       string `json:"host"`
+	Datacenter string `json:"datacenter,omitempty"`
+	Zone       string `json:"zone,omitempty"`
+	// 有效期（秒），0表示永久有效
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+	// 不填时为1
+	Weight *int `json:"weight,omitempty"`
+	// 绝对负载上限，0表示不限制
+	Capacity *int64            `json:"capacity,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+type hostResponse struct {
+	Host   string        `json:"host"`
+	Weight int           `json:"weight"`
+	Load   int64         `json:"load"`
+	Meta   core.Metadata `json:"meta"`
+	// 管理接口或任一来源的摘除
+	Draining bool `json:"draining"`
+	// 健康检查（health）、异常检测（outlier）、驱逐失联的服务器（dead_host）等自动摘除的来源
+	DrainedBy []string `json:"drained_by,omitempty"`
+}
+
+type routeResponse struct {
+	Key  string `json:"key"`
+	Host string `json:"host"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (p *Proxy) handleHosts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hosts := make([]hostResponse, 0, p.consistent.Size())
+		for _, name := range p.consistent.Hosts() {
+			// 并发注销的服务器直接跳过
+			if info, err := p.consistent.GetHostInfo(name); err == nil {
+				hosts = append(hosts, newHostResponse(info))
+			}
+		}
+		writeJSON(w, http.StatusOK, hosts)
+
+	case http.MethodPost:
+		var req hostRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		if req.Host == "" {
+			writeError(w, http.StatusBadRequest, "missing_param", "missing host")
+			return
+		}
+
+		meta := core.Metadata{
+			Datacenter: req.Datacenter,
+			Zone:       req.Zone,
+			Tags:       req.Tags,
+		}
+		if req.Capacity != nil {
+			meta.Capacity = *req.Capacity
+		}
+		weight := 1
+		if req.Weight != nil {
+			weight = *req.Weight
+		}
+		err := p.RegisterHostWeighted(req.Host, weight, meta, time.Duration(req.TTLSeconds)*time.Second)
+		if err != nil {
+			writeCoreError(w, err)
+			return
+		}
+		p.writeHost(w, http.StatusCreated, req.Host)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (p *Proxy) handleHost(w http.ResponseWriter, r *http.Request) {
+	host := strings.TrimPrefix(r.URL.Path, "/v1/hosts/")
+	host, renew := strings.CutSuffix(host, "/renew")
+	host, load := strings.CutSuffix(host, "/load")
+	if host == "" || strings.Contains(host, "/") {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no route for %s", r.URL.Path))
+		return
+	}
+
+	switch {
+	case renew && r.Method == http.MethodPost:
+		if err := p.Renew(host); err != nil {
+			writeCoreError(w, err)
+			return
+		}
+		p.writeHost(w, http.StatusOK, host)
+
+	case renew:
+		methodNotAllowed(w, http.MethodPost)
+
+	case load && r.Method == http.MethodPost:
+		p.handleLoadReport(w, r, host)
+
+	case load:
+		methodNotAllowed(w, http.MethodPost)
+
+	case r.Method == http.MethodGet:
+		p.writeHost(w, http.StatusOK, host)
+
+	case r.Method == http.MethodPatch:
+		var u core.HostUpdate
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		if u.Weight == nil && u.Capacity == nil && u.Draining == nil {
+			writeError(w, http.StatusBadRequest, "missing_param", "nothing to update")
+			return
+		}
+		if err := p.UpdateHost(host, u); err != nil {
+			writeCoreError(w, err)
+			return
+		}
+		p.writeHost(w, http.StatusOK, host)
+
+	case r.Method == http.MethodDelete:
+		if err := p.UnregisterHost(host); err != nil {
+			writeCoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPatch, http.MethodDelete)
+	}
+}
+
+func (p *Proxy) handleRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	mode, ok := parseMode(w, r)
+	if !ok {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if key == "" && mode != ModeTwoChoices {
+		writeError(w, http.StatusBadRequest, "missing_param", "missing key")
+		return
+	}
+
+	picker := p
+	if green := p.greenFor(key); key != "" && green != nil {
+		picker = green
+	}
+	host, err := picker.pick(r.Context(), key, mode)
+	if err != nil {
+		writeCoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, routeResponse{Key: key, Host: host})
+}
+
+type explainResponse struct {
+	Mode string `json:"mode"`
+	core.Explanation
+	// 所有服务器都不可用时的错误，Skipped中为跳过的服务器
+	Error string `json:"error,omitempty"`
+}
+
+// handleRouteExplain 不转发、不增加负载地重演一次选择：key的哈希值、匹配的虚拟节点、服务器的负载与上限，以及跳过的服务器和原因
+func (p *Proxy) handleRouteExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "missing_param", "missing key")
+		return
+	}
+	mode, ok := parseMode(w, r)
+	if !ok {
+		return
+	}
+	switch mode {
+	case ModeTwoChoices:
+		writeError(w, http.StatusBadRequest, "invalid_param", "two-choices mode picks hosts at random and cannot be explained")
+		return
+	case ModeSlots:
+		writeError(w, http.StatusBadRequest, "invalid_param", "use /v1/slots?key= to look up the slot of a key")
+		return
+	}
+
+	var ex core.Explanation
+	var err error
+	if mode == ModeLeastLoaded {
+		ex, err = p.consistent.ExplainLeastLoaded(key, p.choices)
+	} else {
+		ex, err = p.consistent.Explain(key, mode == ModeCapacious)
+	}
+	res := explainResponse{Mode: mode.String(), Explanation: ex}
+	switch {
+	case errors.Is(err, core.ErrAllHostsOverloaded):
+		res.Error = err.Error()
+	case err != nil:
+		writeCoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func parseMode(w http.ResponseWriter, r *http.Request) (Mode, bool) {
+	mode, err := ParseMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+		return 0, false
+	}
+	return mode, true
+}
+
+func (p *Proxy) handleRing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	data, err := p.DescribeRing()
+	if err != nil {
+		writeCoreError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func (p *Proxy) writeHost(w http.ResponseWriter, status int, host string) {
+	info, err := p.consistent.GetHostInfo(host)
+	if err != nil {
+		writeCoreError(w, err)
+		return
+	}
+	writeJSON(w, status, newHostResponse(info))
+}
+
+func newHostResponse(info core.Host) hostResponse {
+	return hostResponse{
+		Host:      info.Name,
+		Weight:    info.Weight,
+		Load:      info.LoadBound,
+		Meta:      info.Meta,
+		Draining:  info.Draining || len(info.DrainedBy) > 0,
+		DrainedBy: info.DrainedBy,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Error: apiError{Code: code, Message: message}})
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+}
+
+// 将core的错误映射为状态码和错误码
+func writeCoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, core.ErrHostAlreadyExists):
+		writeError(w, http.StatusConflict, "host_already_exists", err.Error())
+	case errors.Is(err, core.ErrHostNotFound):
+		writeError(w, http.StatusNotFound, "host_not_found", err.Error())
+	case errors.Is(err, core.ErrInvalidTTL), errors.Is(err, core.ErrInvalidWeight), errors.Is(err, core.ErrInvalidCapacity),
+		errors.Is(err, core.ErrInvalidLoad), errors.Is(err, core.ErrInvalidSlotRange):
+		writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+	case errors.Is(err, core.ErrNoTTL):
+		writeError(w, http.StatusConflict, "no_ttl", err.Error())
+	case errors.Is(err, core.ErrNoHosts):
+		writeError(w, http.StatusServiceUnavailable, "no_hosts", err.Error())
+	case errors.Is(err, core.ErrStaleVersion):
+		writeError(w, http.StatusGone, "stale_version", err.Error())
+	case errors.Is(err, core.ErrStaleLoad):
+		writeError(w, http.StatusConflict, "stale_load", err.Error())
+	case errors.Is(err, core.ErrAllHostsOverloaded):
+		writeError(w, http.StatusServiceUnavailable, "all_hosts_overloaded", err.Error())
+	default:
+		writeError(w, errStatus(err), "internal", err.Error())
+	}
+}
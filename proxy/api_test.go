@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterHostWithWeightCapacityAndTags(t *testing.T) {
+	p := newTestProxy(t, nil)
+	api := p.AdminAPI()
+
+	body := `{"host": "a:80", "weight": 3, "capacity": 10, "tags": {"version": "v2"}}`
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/hosts", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+
+	info, err := p.consistent.GetHostInfo("a:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Weight != 3 || info.Meta.Capacity != 10 || info.Meta.Tags["version"] != "v2" {
+		t.Fatalf("registered host = %+v, want weight 3, capacity 10 and tag version=v2", info)
+	}
+}
+
+func TestRegisterHostDefaultsToWeightOne(t *testing.T) {
+	p := newTestProxy(t, nil)
+
+	rec := httptest.NewRecorder()
+	p.AdminAPI().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/hosts", strings.NewReader(`{"host": "a:80"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	if info, err := p.consistent.GetHostInfo("a:80"); err != nil || info.Weight != 1 {
+		t.Fatalf("registered host = %+v, %v; want weight 1", info, err)
+	}
+}
+
+func TestRegisterHostRejectsInvalidParams(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"zero weight", `{"host": "a:80", "weight": 0}`},
+		{"negative weight", `{"host": "a:80", "weight": -1}`},
+		{"negative capacity", `{"host": "a:80", "capacity": -1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, nil)
+			rec := httptest.NewRecorder()
+			p.AdminAPI().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/hosts", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+			}
+			if p.consistent.Size() != 0 {
+				t.Fatal("invalid host was registered")
+			}
+		})
+	}
+}
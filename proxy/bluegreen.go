@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+)
+
+// BlueGreen 环的蓝绿部署设置，也是管理接口/v1/rings/{name}/bluegreen的JSON格式
+// 环本身的服务器为blue，另一个命名的环的服务器为green；升级后端时先把新版本注册到green，
+// 再逐步调高Ratio或直接设为1整体切换，不必逐台注册、注销服务器
+type BlueGreen struct {
+	// green所在的命名环
+	Green string `json:"green"`
+	// 发往green的流量比例（0~1），按路由key划分，同一个key总是发往同一组；调高比例时只有新增的那部分key改发green
+	Ratio float64 `json:"ratio"`
+}
+
+// ErrBlueGreenNotSet 环没有蓝绿部署设置
+var ErrBlueGreenNotSet = errors.New("blue/green not set")
+
+type blueGreen struct {
+	BlueGreen
+	green *Proxy
+	// 路由key的哈希值小于threshold时发往green
+	threshold uint64
+}
+
+// SetBlueGreen 设置名为name的环的蓝绿部署，原子地替换之前的设置；只作用于本实例
+func (rs *Rings) SetBlueGreen(name string, bg BlueGreen) error {
+	if bg.Ratio < 0 || bg.Ratio > 1 {
+		return errors.New("blue/green: ratio must be between 0 and 1")
+	}
+	blue, ok := rs.Get(name)
+	if !ok {
+		return fmt.Errorf("blue/green: ring %s not found", name)
+	}
+	green, ok := rs.Get(bg.Green)
+	if !ok {
+		return fmt.Errorf("blue/green: ring %s not found", bg.Green)
+	}
+	if green == blue {
+		return errors.New("blue/green: green ring must not be the ring itself")
+	}
+	// 只切换一层，避免两个环互相转发
+	if green.blueGreen.Load() != nil {
+		return fmt.Errorf("blue/green: ring %s has its own green ring", bg.Green)
+	}
+
+	state := &blueGreen{BlueGreen: bg, green: green, threshold: uint64(bg.Ratio * math.MaxUint64)}
+	if bg.Ratio == 1 {
+		state.threshold = math.MaxUint64
+	}
+	blue.blueGreen.Store(state)
+	blue.logger.Info("blue/green updated", "green", bg.Green, "ratio", bg.Ratio)
+	return nil
+}
+
+// BlueGreen 返回名为name的环的蓝绿部署设置，没有设置时返回ErrBlueGreenNotSet
+func (rs *Rings) BlueGreen(name string) (BlueGreen, error) {
+	p, ok := rs.Get(name)
+	if !ok {
+		return BlueGreen{}, fmt.Errorf("blue/green: ring %s not found", name)
+	}
+	state := p.blueGreen.Load()
+	if state == nil {
+		return BlueGreen{}, ErrBlueGreenNotSet
+	}
+	return state.BlueGreen, nil
+}
+
+// ClearBlueGreen 取消名为name的环的蓝绿部署，所有流量回到环本身的服务器
+func (rs *Rings) ClearBlueGreen(name string) error {
+	p, ok := rs.Get(name)
+	if !ok {
+		return fmt.Errorf("blue/green: ring %s not found", name)
+	}
+	if p.blueGreen.Swap(nil) == nil {
+		return ErrBlueGreenNotSet
+	}
+	p.logger.Info("blue/green cleared")
+	return nil
+}
+
+// greenFor 返回key应该发往的green环，key留在本环时返回nil
+func (p *Proxy) greenFor(key string) *Proxy {
+	state := p.blueGreen.Load()
+	if state == nil || state.Ratio == 0 {
+		return nil
+	}
+	// 不用环的哈希函数，否则发往green的key集中在环上的一段区间，只落在少数几台服务器上
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	if state.Ratio < 1 && h.Sum64() >= state.threshold {
+		return nil
+	}
+	return state.green
+}
+
+func (rs *Rings) handleBlueGreen(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		bg, err := rs.BlueGreen(name)
+		if err != nil {
+			writeBlueGreenError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, bg)
+
+	case http.MethodPut:
+		var bg BlueGreen
+		if err := json.NewDecoder(r.Body).Decode(&bg); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		if bg.Green == "" {
+			writeError(w, http.StatusBadRequest, "missing_param", "missing green")
+			return
+		}
+		if err := rs.SetBlueGreen(name, bg); err != nil {
+			writeBlueGreenError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, bg)
+
+	case http.MethodDelete:
+		if err := rs.ClearBlueGreen(name); err != nil {
+			writeBlueGreenError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func writeBlueGreenError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrBlueGreenNotSet) {
+		writeError(w, http.StatusNotFound, "blue_green_not_set", err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, "invalid_blue_green", err.Error())
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// BodyLimits 请求体和响应体的大小上限（字节），0表示不限制
+// 请求体和响应体都是边读边转发的，上限只用于拒绝异常的大请求，不影响内存占用
+type BodyLimits struct {
+	MaxRequestBytes  int64
+	MaxResponseBytes int64
+}
+
+// ErrResponseTooLarge 后端的响应体超过MaxResponseBytes
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// limitRequestBody 声明的长度超过上限时返回false并响应413；长度未知（chunked）的请求体读到超过上限时转发失败，同样返回413
+func (p *Proxy) limitRequestBody(w http.ResponseWriter, r *http.Request) bool {
+	limit := p.bodyLimits.MaxRequestBytes
+	if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > limit {
+		http.Error(w, fmt.Sprintf("request body exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// limitResponseBody 声明的长度超过上限时返回ErrResponseTooLarge，由ErrorHandler响应502；
+// 长度未知的响应体读到超过上限时中断转发，客户端收到不完整的响应
+func limitResponseBody(resp *http.Response, limit int64) error {
+	// 升级后的Body是双向的连接，不做限制
+	if limit <= 0 || resp.StatusCode == http.StatusSwitchingProtocols {
+		return nil
+	}
+	// 返回错误后ReverseProxy会关闭Body
+	if resp.ContentLength > limit {
+		return fmt.Errorf("%w: %d bytes exceeds %d", ErrResponseTooLarge, resp.ContentLength, limit)
+	}
+	if resp.ContentLength < 0 {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit}
+	}
+	return nil
+}
+
+// limitedBody 与io.LimitReader不同，超过上限时返回错误而不是EOF，ReverseProxy据此中断响应而不是当作完整的响应
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), ErrResponseTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// patternReader 按需生成n个字节，不在内存中保留整个body
+type patternReader struct {
+	n int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	for i := range p {
+		p[i] = byte(i)
+	}
+	r.n -= int64(len(p))
+	return len(p), nil
+}
+
+// newBodyTestProxy 只有backend一台服务器的代理，返回代理的地址
+func newBodyTestProxy(t *testing.T, backend http.Handler, limits BodyLimits) string {
+	t.Helper()
+	upstream := httptest.NewServer(backend)
+	t.Cleanup(upstream.Close)
+	p := newTestProxy(t, []string{upstream.Listener.Addr().String()}, WithBodyLimits(limits))
+	front := httptest.NewServer(p.Handler(ModeHash))
+	t.Cleanup(front.Close)
+	return front.URL + "/?key=k"
+}
+
+func allocated() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.TotalAlloc
+}
+
+func TestBodyStreamsWithConstantMemory(t *testing.T) {
+	const size = 64 << 20
+	const maxAlloc = 8 << 20
+	before := allocated()
+	streamBody(t, size)
+	if alloc := allocated() - before; alloc > maxAlloc {
+		t.Fatalf("allocated %d bytes to stream %d bytes each way, want at most %d", alloc, size, maxAlloc)
+	}
+}
+
+// 超过4GiB的body，检查长度计数没有32位溢出，且堆的大小不随body增长；go test -short时跳过
+func TestBodyStreamsMultiGB(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams several GiB each way")
+	}
+	const maxHeap = 64 << 20
+	var peak atomic.Uint64
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			if m.HeapAlloc > peak.Load() {
+				peak.Store(m.HeapAlloc)
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}()
+
+	streamBody(t, 5<<30)
+	close(stop)
+	<-done
+	if peak.Load() > maxHeap {
+		t.Fatalf("heap reached %d bytes while streaming, want at most %d", peak.Load(), maxHeap)
+	}
+}
+
+// streamBody 经过代理双向传输size字节的body
+func streamBody(t *testing.T, size int64) {
+	t.Helper()
+	url := newBodyTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil || n != size {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = io.Copy(w, &patternReader{n: size})
+	}), BodyLimits{MaxRequestBytes: 2 * size, MaxResponseBytes: 2 * size})
+
+	resp, err := http.Post(url, "application/octet-stream", &patternReader{n: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil || n != size {
+		t.Fatalf("response body = %d bytes, %v; want %d bytes", n, err, size)
+	}
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	const limit = 1 << 20
+	var reached int
+	url := newBodyTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+		_, _ = io.Copy(io.Discard, r.Body)
+	}), BodyLimits{MaxRequestBytes: limit})
+
+	tests := []struct {
+		name          string
+		contentLength int64
+	}{
+		// 声明的长度超过上限，不转发
+		{"declared", limit + 1},
+		// chunked，读到超过上限时中断
+		{"chunked", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, url, &patternReader{n: 4 * limit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.ContentLength = tt.contentLength
+			if tt.contentLength > 0 {
+				req.Body = io.NopCloser(&patternReader{n: tt.contentLength})
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusRequestEntityTooLarge {
+				t.Fatalf("status = %d, want 413", resp.StatusCode)
+			}
+		})
+	}
+	if reached > 1 {
+		t.Fatalf("backend reached %d times, want only the chunked request forwarded", reached)
+	}
+}
+
+func TestResponseBodyLimits(t *testing.T) {
+	const limit = 1 << 20
+	url := newBodyTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+		if r.URL.Query().Get("declare") != "" {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		_, _ = io.Copy(w, &patternReader{n: size})
+	}), BodyLimits{MaxResponseBytes: limit})
+
+	get := func(t *testing.T, query string) (*http.Response, int64, error) {
+		t.Helper()
+		resp, err := http.Get(url + "&" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		n, err := io.Copy(io.Discard, resp.Body)
+		return resp, n, err
+	}
+
+	t.Run("declared too large", func(t *testing.T) {
+		resp, _, _ := get(t, fmt.Sprintf("size=%d&declare=1", limit+1))
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("status = %d, want 502", resp.StatusCode)
+		}
+	})
+	t.Run("declared within limit", func(t *testing.T) {
+		resp, n, err := get(t, fmt.Sprintf("size=%d&declare=1", limit))
+		if err != nil || n != limit || resp.ContentLength != limit {
+			t.Fatalf("got %d bytes (Content-Length %d), %v; want %d", n, resp.ContentLength, err, limit)
+		}
+	})
+	// 长度未知的响应超过上限时被截断，客户端必须能发现响应不完整，而不是当作较短的完整响应
+	t.Run("chunked truncated", func(t *testing.T) {
+		resp, n, err := get(t, fmt.Sprintf("size=%d", 4*limit))
+		if resp.StatusCode != http.StatusOK || resp.ContentLength != -1 {
+			t.Fatalf("status = %d, Content-Length = %d; want 200 with unknown length", resp.StatusCode, resp.ContentLength)
+		}
+		if err == nil || n > limit {
+			t.Fatalf("got %d bytes, %v; want a truncated body of at most %d bytes and an error", n, err, limit)
+		}
+	})
+}
+
+func TestUpstreamAborts(t *testing.T) {
+	const size = 1 << 20
+	url := newBodyTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("after_headers") != "" {
+			// 声明了长度，只写出一半就断开
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			w.WriteHeader(http.StatusOK)
+			_, _ = io.Copy(w, &patternReader{n: size / 2})
+			w.(http.Flusher).Flush()
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}), BodyLimits{})
+
+	t.Run("before headers", func(t *testing.T) {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("status = %d, want 502", resp.StatusCode)
+		}
+	})
+	t.Run("after headers", func(t *testing.T) {
+		resp, err := http.Get(url + "&after_headers=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.ContentLength != size {
+			t.Fatalf("Content-Length = %d, want %d", resp.ContentLength, size)
+		}
+		n, err := io.Copy(io.Discard, resp.Body)
+		if !errors.Is(err, io.ErrUnexpectedEOF) || n >= size {
+			t.Fatalf("got %d bytes, %v; want a short body and io.ErrUnexpectedEOF", n, err)
+		}
+	})
+}
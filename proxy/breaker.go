@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type BreakerConfig struct {
+	// 连续失败多少次后熔断，0表示不按连续失败熔断
+	ConsecutiveFailures int
+	// 错误率达到该值后熔断，0表示不按错误率熔断
+	ErrorRate float64
+	// 至少有多少个请求才计算错误率
+	MinRequests int
+	// 错误率的统计窗口，按breakerBuckets个时间片滚动，只统计最近这段时间的请求，0表示10s
+	Window time.Duration
+	// 熔断后多久进入半开状态
+	OpenTimeout time.Duration
+	// 半开状态下允许的探测请求数
+	HalfOpenRequests int
+}
+
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		ConsecutiveFailures: 5,
+		ErrorRate:           0.5,
+		MinRequests:         20,
+		Window:              10 * time.Second,
+		OpenTimeout:         10 * time.Second,
+		HalfOpenRequests:    1,
+	}
+}
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// 统计窗口划分的时间片数
+const breakerBuckets = 10
+
+// breakerBucket 一个时间片内的请求数，epoch为时间片的序号，过期的时间片在复用时清零
+type breakerBucket struct {
+	epoch    int64
+	requests int
+	failures int
+}
+
+type breaker struct {
+	state       breakerState
+	buckets     [breakerBuckets]breakerBucket
+	consecutive int
+	openedAt    time.Time
+	probes      int
+}
+
+// observe 把一次请求计入当前时间片，返回窗口内的请求数和失败数
+func (br *breaker) observe(window time.Duration, success bool) (requests, failures int) {
+	if window <= 0 {
+		window = 10 * time.Second
+	}
+	width := max(int64(window/breakerBuckets), 1)
+	epoch := time.Now().UnixNano() / width
+	bucket := &br.buckets[epoch%breakerBuckets]
+	if bucket.epoch != epoch {
+		*bucket = breakerBucket{epoch: epoch}
+	}
+	bucket.requests++
+	if !success {
+		bucket.failures++
+	}
+
+	for _, b := range br.buckets {
+		if epoch-b.epoch < breakerBuckets {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+// breakers 每个后端一个熔断器，熔断的服务器在转发时被跳过，请求沿环转移到下一台
+type breakers struct {
+	config BreakerConfig
+	hosts  map[string]*breaker
+	logger Logger
+	sync.Mutex
+}
+
+func newBreakers(config BreakerConfig) *breakers {
+	return &breakers{
+		config: config,
+		hosts:  make(map[string]*breaker),
+		logger: defaultLogger(),
+	}
+}
+
+// 未开启熔断（nil）时总是放行
+func (b *breakers) allow(host string) bool {
+	if b == nil {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	br := b.get(host)
+	switch br.state {
+	case breakerOpen:
+		if time.Since(br.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		br.state = breakerHalfOpen
+		br.probes = 0
+		fallthrough
+	case breakerHalfOpen:
+		if br.probes >= b.config.HalfOpenRequests {
+			return false
+		}
+		br.probes++
+	}
+	return true
+}
+
+func (b *breakers) record(host string, success bool) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	br := b.get(host)
+	if br.state == breakerHalfOpen {
+		if success {
+			b.logger.Info("circuit breaker closed", "host", host)
+			*br = breaker{}
+		} else {
+			b.trip(host, br)
+		}
+		return
+	}
+
+	requests, failures := br.observe(b.config.Window, success)
+	if success {
+		br.consecutive = 0
+		return
+	}
+	br.consecutive++
+
+	if b.config.ConsecutiveFailures > 0 && br.consecutive >= b.config.ConsecutiveFailures {
+		b.trip(host, br)
+		return
+	}
+	if b.config.ErrorRate > 0 && requests >= b.config.MinRequests &&
+		float64(failures)/float64(requests) >= b.config.ErrorRate {
+		b.trip(host, br)
+	}
+}
+
+func (b *breakers) remove(host string) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.hosts, host)
+}
+
+func (b *breakers) get(host string) *breaker {
+	br, ok := b.hosts[host]
+	if !ok {
+		br = &breaker{}
+		b.hosts[host] = br
+	}
+	return br
+}
+
+func (b *breakers) trip(host string, br *breaker) {
+	b.logger.Warn("circuit breaker opened", "host", host)
+	*br = breaker{state: breakerOpen, openedAt: time.Now()}
+}
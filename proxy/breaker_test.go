@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestBreakerErrorRate(t *testing.T) {
+	tests := []struct {
+		name string
+		// 依次记录的结果，true为成功
+		results []bool
+		// 在第几个结果之前等待窗口过期，-1表示不等待
+		expireBefore int
+		open         bool
+	}{
+		{"min requests not reached", []bool{false}, -1, false},
+		{"error rate reached", []bool{true, true, false, false}, -1, true},
+		{"error rate not reached", []bool{true, true, true, false}, -1, false},
+		{"old successes expire", []bool{true, true, true, true, true, true, false, false}, 6, true},
+		{"old failures expire", []bool{false, true, false, true, true, true, true, false}, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreakers(BreakerConfig{
+				ErrorRate:        0.5,
+				MinRequests:      2,
+				Window:           50 * time.Millisecond,
+				OpenTimeout:      time.Hour,
+				HalfOpenRequests: 1,
+			})
+			b.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+			for i, success := range tt.results {
+				if i == tt.expireBefore {
+					time.Sleep(60 * time.Millisecond)
+				}
+				b.record("a:80", success)
+			}
+			if open := !b.allow("a:80"); open != tt.open {
+				t.Fatalf("open = %v, want %v", open, tt.open)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCacheConfig 代理侧GET响应缓存，按路由key和请求URI缓存后端的200响应
+type ResponseCacheConfig struct {
+	MaxEntries int
+	MaxBytes   int64
+	// 超过该大小的响应不缓存
+	MaxEntryBytes int64
+	// 缓存时间，后端返回的Cache-Control: max-age更短时以后者为准
+	TTL time.Duration
+}
+
+func DefaultResponseCacheConfig() ResponseCacheConfig {
+	return ResponseCacheConfig{
+		MaxEntries:    10000,
+		MaxBytes:      64 << 20,
+		MaxEntryBytes: 1 << 20,
+		TTL:           10 * time.Second,
+	}
+}
+
+// 缓存查询的结果，用于指标
+const (
+	CacheHit    = "hit"
+	CacheMiss   = "miss"
+	CacheBypass = "bypass"
+)
+
+type cachedResponse struct {
+	cacheKey   string
+	routingKey string
+	status     int
+	header     http.Header
+	body       []byte
+	storedAt   time.Time
+	expireAt   time.Time
+	// 响应允许共享缓存（public或s-maxage），带凭证的请求只能使用这样的响应
+	shared bool
+	// Vary列出的请求头和保存时请求中的值，值不同的请求不命中
+	vary map[string]string
+}
+
+func (e *cachedResponse) size() int64 {
+	return int64(len(e.cacheKey) + len(e.body))
+}
+
+// responseCache LRU淘汰；同一路由key的非GET请求（如PUT、DELETE）会使该key的缓存失效
+type responseCache struct {
+	config  ResponseCacheConfig
+	order   *list.List
+	entries map[string]*list.Element
+	// 路由key到缓存key的索引，用于按路由key失效
+	byKey   map[string]map[string]struct{}
+	bytes   int64
+	metrics Metrics
+	sync.Mutex
+}
+
+func newResponseCache(config ResponseCacheConfig) *responseCache {
+	return &responseCache{
+		config:  config,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		byKey:   make(map[string]map[string]struct{}),
+	}
+}
+
+// lookup 命中时直接写出缓存的响应并返回hit为true
+// 否则返回包装后的ResponseWriter，转发结束后调用finish保存响应或使缓存失效
+// 请求头Cache-Control: no-cache跳过缓存读取但保存新的响应，no-store既不读取也不保存
+// 带Authorization或Cookie的请求只读取和保存public或带s-maxage的响应
+func (c *responseCache) lookup(w http.ResponseWriter, r *http.Request, key string) (http.ResponseWriter, func(), bool) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodHead, http.MethodOptions:
+		return w, func() {}, false
+	default:
+		// 写请求使该key的缓存失效
+		return w, func() { c.invalidate(key) }, false
+	}
+
+	directives := cacheDirectives(r.Header)
+	cacheKey := key + "\x00" + r.URL.RequestURI()
+	if directives["no-store"] {
+		c.metrics.ObserveCache(CacheBypass)
+		w.Header().Set("X-Cache", "BYPASS")
+		return w, func() {}, false
+	}
+
+	result := CacheBypass
+	if !directives["no-cache"] && r.Header.Get("Pragma") != "no-cache" {
+		if e, ok := c.get(cacheKey, r); ok {
+			c.metrics.ObserveCache(CacheHit)
+			writeCached(w, e)
+			return w, nil, true
+		}
+		result = CacheMiss
+	}
+	c.metrics.ObserveCache(result)
+	w.Header().Set("X-Cache", strings.ToUpper(result))
+
+	rec := &cacheRecorder{ResponseWriter: w, status: http.StatusOK, limit: c.config.MaxEntryBytes}
+	return rec, func() { c.store(cacheKey, key, r, rec) }, false
+}
+
+// credentialed 请求带有用户凭证，响应可能因用户而不同
+func credentialed(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// varyValues 按响应的Vary取出请求头的值，Vary: *时返回false
+func varyValues(respHeader, reqHeader http.Header) (map[string]string, bool) {
+	var vary map[string]string
+	for _, v := range respHeader.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, false
+			}
+			if vary == nil {
+				vary = make(map[string]string)
+			}
+			vary[name] = strings.Join(reqHeader.Values(name), ",")
+		}
+	}
+	return vary, true
+}
+
+func writeCached(w http.ResponseWriter, e *cachedResponse) {
+	h := w.Header()
+	for k, v := range e.header {
+		h[k] = v
+	}
+	h.Set("X-Cache", "HIT")
+	h.Set("Age", strconv.Itoa(int(time.Since(e.storedAt)/time.Second)))
+	w.WriteHeader(e.status)
+	_, _ = w.Write(e.body)
+}
+
+func (c *responseCache) store(cacheKey, routingKey string, r *http.Request, rec *cacheRecorder) {
+	if rec.status != http.StatusOK || rec.overflow {
+		return
+	}
+	ttl, ok := responseTTL(rec.Header(), c.config.TTL)
+	if !ok {
+		return
+	}
+	directives := cacheDirectives(rec.Header())
+	shared := directives["public"] || hasDirective(directives, "s-maxage=")
+	if credentialed(r) && !shared {
+		return
+	}
+	vary, ok := varyValues(rec.Header(), r.Header)
+	if !ok {
+		return
+	}
+
+	header := rec.Header().Clone()
+	header.Del("X-Cache")
+	now := time.Now()
+	c.set(&cachedResponse{
+		cacheKey:   cacheKey,
+		routingKey: routingKey,
+		status:     rec.status,
+		header:     header,
+		body:       rec.buf.Bytes(),
+		storedAt:   now,
+		expireAt:   now.Add(ttl),
+		shared:     shared,
+		vary:       vary,
+	})
+}
+
+func hasDirective(directives map[string]bool, prefix string) bool {
+	for d := range directives {
+		if strings.HasPrefix(d, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseTTL 后端要求不缓存或设置了cookie时返回false
+func responseTTL(h http.Header, ttl time.Duration) (time.Duration, bool) {
+	if h.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	directives := cacheDirectives(h)
+	if directives["no-store"] || directives["no-cache"] || directives["private"] {
+		return 0, false
+	}
+	// 代理是共享缓存，s-maxage优先于max-age
+	prefix := "max-age="
+	if hasDirective(directives, "s-maxage=") {
+		prefix = "s-maxage="
+	}
+	for d := range directives {
+		if v, ok := strings.CutPrefix(d, prefix); ok {
+			if secs, err := strconv.Atoi(v); err == nil {
+				ttl = min(ttl, time.Duration(secs)*time.Second)
+			}
+		}
+	}
+	return ttl, ttl > 0
+}
+
+func cacheDirectives(h http.Header) map[string]bool {
+	directives := make(map[string]bool)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			directives[strings.ToLower(strings.TrimSpace(d))] = true
+		}
+	}
+	return directives
+}
+
+func (c *responseCache) get(cacheKey string, r *http.Request) (*cachedResponse, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.entries[cacheKey]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*cachedResponse)
+	if time.Now().After(e.expireAt) {
+		c.removeLocked(elem)
+		return nil, false
+	}
+	if credentialed(r) && !e.shared {
+		return nil, false
+	}
+	for name, value := range e.vary {
+		if strings.Join(r.Header.Values(name), ",") != value {
+			return nil, false
+		}
+	}
+	c.order.MoveToFront(elem)
+	return e, true
+}
+
+func (c *responseCache) set(e *cachedResponse) {
+	if c.config.MaxBytes > 0 && e.size() > c.config.MaxBytes {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.entries[e.cacheKey]; ok {
+		c.removeLocked(elem)
+	}
+	c.entries[e.cacheKey] = c.order.PushFront(e)
+	c.bytes += e.size()
+	keys, ok := c.byKey[e.routingKey]
+	if !ok {
+		keys = make(map[string]struct{})
+		c.byKey[e.routingKey] = keys
+	}
+	keys[e.cacheKey] = struct{}{}
+
+	for (c.config.MaxEntries > 0 && c.order.Len() > c.config.MaxEntries) ||
+		(c.config.MaxBytes > 0 && c.bytes > c.config.MaxBytes) {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+func (c *responseCache) invalidate(routingKey string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for cacheKey := range c.byKey[routingKey] {
+		c.removeLocked(c.entries[cacheKey])
+	}
+}
+
+// 需要持有锁
+func (c *responseCache) removeLocked(elem *list.Element) {
+	e := c.order.Remove(elem).(*cachedResponse)
+	delete(c.entries, e.cacheKey)
+	c.bytes -= e.size()
+
+	keys := c.byKey[e.routingKey]
+	delete(keys, e.cacheKey)
+	if len(keys) == 0 {
+		delete(c.byKey, e.routingKey)
+	}
+}
+
+// cacheRecorder 在写出响应的同时保存一份，超过limit后不再保存
+type cacheRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+	limit       int64
+	overflow    bool
+}
+
+func (w *cacheRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	if !w.overflow {
+		if w.limit > 0 && int64(w.buf.Len()+len(b)) > w.limit {
+			w.overflow = true
+			w.buf = bytes.Buffer{}
+		} else {
+			w.buf.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *cacheRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveCached 经过缓存转发一次请求，缓存未命中时由backend生成响应
+func serveCached(c *responseCache, r *http.Request, backend http.HandlerFunc) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	w, finish, hit := c.lookup(rec, r, "k")
+	if !hit {
+		backend(w, r)
+		finish()
+	}
+	return rec
+}
+
+func TestResponseCacheCredentialsAndVary(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		vary         string
+		first        http.Header
+		second       http.Header
+		hit          bool
+	}{
+		{"plain", "max-age=60", "", nil, nil, true},
+		{"authorization private", "max-age=60", "", http.Header{"Authorization": {"a"}}, http.Header{"Authorization": {"a"}}, false},
+		{"cookie private", "max-age=60", "", http.Header{"Cookie": {"s=1"}}, http.Header{"Cookie": {"s=1"}}, false},
+		{"authorization public", "public, max-age=60", "", http.Header{"Authorization": {"a"}}, http.Header{"Authorization": {"b"}}, true},
+		{"authorization s-maxage", "s-maxage=60", "", http.Header{"Authorization": {"a"}}, http.Header{"Authorization": {"b"}}, true},
+		{"anonymous entry for credentialed request", "max-age=60", "", nil, http.Header{"Cookie": {"s=1"}}, false},
+		{"vary same value", "max-age=60", "Accept-Encoding", http.Header{"Accept-Encoding": {"gzip"}}, http.Header{"Accept-Encoding": {"gzip"}}, true},
+		{"vary different value", "max-age=60", "Accept-Encoding", http.Header{"Accept-Encoding": {"gzip"}}, http.Header{"Accept-Encoding": {"br"}}, false},
+		{"vary star", "max-age=60", "*", nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newResponseCache(DefaultResponseCacheConfig())
+			c.metrics = nopMetrics{}
+			backend := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				if tt.vary != "" {
+					w.Header().Set("Vary", tt.vary)
+				}
+				_, _ = w.Write([]byte("body"))
+			}
+			for i, h := range []http.Header{tt.first, tt.second} {
+				r := httptest.NewRequest(http.MethodGet, "/obj", nil)
+				for k, v := range h {
+					r.Header[k] = v
+				}
+				got := serveCached(c, r, backend).Header().Get("X-Cache")
+				if i == 1 && (got == "HIT") != tt.hit {
+					t.Fatalf("X-Cache = %q, want hit %v", got, tt.hit)
+				}
+			}
+		})
+	}
+}
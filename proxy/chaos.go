@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// ChaosConfig 故障注入，用于端到端验证重试、健康检查和重新平衡，不要在生产环境开启
+type ChaosConfig struct {
+	// 每次后端调用以DelayRatio（0~1）的概率先等待Delay
+	DelayRatio float64
+	Delay      time.Duration
+	// 每次后端调用以FailRatio的概率直接失败，与连接失败一样触发重试和熔断
+	FailRatio float64
+	// 平均每隔FlapInterval随机摘除本实例环上的一台服务器，FlapDown后撤销；不同步给其他实例
+	FlapInterval time.Duration
+	FlapDown     time.Duration
+}
+
+var errChaos = errors.New("chaos: injected failure")
+
+// 故障注入以这个名义摘除服务器，到期后只撤销自己的摘除
+const drainSourceChaos = "chaos"
+
+func (c ChaosConfig) validate() error {
+	if c.DelayRatio < 0 || c.DelayRatio > 1 || c.FailRatio < 0 || c.FailRatio > 1 {
+		return fmt.Errorf("chaos: ratio must be between 0 and 1")
+	}
+	if c.Delay < 0 || c.FlapInterval < 0 || c.FlapDown < 0 {
+		return fmt.Errorf("chaos: durations must not be negative")
+	}
+	return nil
+}
+
+func (c ChaosConfig) enabled() bool {
+	return c.DelayRatio > 0 || c.FailRatio > 0 || c.FlapInterval > 0
+}
+
+type chaos struct {
+	config atomic.Pointer[ChaosConfig]
+	// 配置变化后重新安排下一次flap
+	reset chan struct{}
+}
+
+func newChaos() *chaos {
+	return &chaos{reset: make(chan struct{}, 1)}
+}
+
+// SetChaos 替换故障注入的配置，零值关闭故障注入；已摘除的服务器仍会按时撤销摘除
+func (p *Proxy) SetChaos(config ChaosConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if config.enabled() {
+		p.logger.Warn("chaos enabled", "delay_ratio", config.DelayRatio, "delay", config.Delay,
+			"fail_ratio", config.FailRatio, "flap_interval", config.FlapInterval, "flap_down", config.FlapDown)
+		p.chaos.config.Store(&config)
+	} else {
+		p.chaos.config.Store(nil)
+	}
+	select {
+	case p.chaos.reset <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Chaos 返回当前的故障注入配置
+func (p *Proxy) Chaos() ChaosConfig {
+	if c := p.chaos.config.Load(); c != nil {
+		return *c
+	}
+	return ChaosConfig{}
+}
+
+// chaosTransport 在每次尝试（包括重试）前按配置注入延迟或失败
+type chaosTransport struct {
+	next  http.RoundTripper
+	chaos *chaos
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.chaos.config.Load()
+	if c == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	if c.DelayRatio > 0 && rand.Float64() < c.DelayRatio {
+		timer := time.NewTimer(c.Delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	if c.FailRatio > 0 && rand.Float64() < c.FailRatio {
+		return nil, errChaos
+	}
+	return t.next.RoundTrip(req)
+}
+
+func (p *Proxy) runChaos() {
+	var timer *time.Timer
+	for {
+		var tick <-chan time.Time
+		if c := p.chaos.config.Load(); c != nil && c.FlapInterval > 0 {
+			// 在[0.5, 1.5)倍间隔内随机，避免多个实例同时移除
+			timer = time.NewTimer(c.FlapInterval/2 + time.Duration(rand.Int63n(int64(c.FlapInterval))))
+			tick = timer.C
+		}
+
+		select {
+		case <-p.stop:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-p.chaos.reset:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-tick:
+			if c := p.chaos.config.Load(); c != nil {
+				p.flapHost(c.FlapDown)
+			}
+		}
+	}
+}
+
+// flapHost 以故障注入的名义随机摘除一台服务器，down后撤销摘除
+// 摘除只改变本实例的环，不写入预写日志和快照，也不影响其他来源的摘除
+func (p *Proxy) flapHost(down time.Duration) {
+	hosts := p.consistent.Hosts()
+	if len(hosts) == 0 {
+		return
+	}
+	host := hosts[rand.Intn(len(hosts))]
+	if p.consistent.IsDrainedBy(host, drainSourceChaos) {
+		return
+	}
+	if err := p.consistent.DrainHostBy(host, drainSourceChaos); err != nil {
+		return
+	}
+	p.logger.Warn("chaos: host drained", "host", host, "down", down)
+
+	time.AfterFunc(down, func() {
+		// 期间服务器可能已经注销
+		err := p.consistent.UndrainBy(host, drainSourceChaos)
+		if errors.Is(err, core.ErrHostNotFound) {
+			return
+		}
+		if err != nil {
+			p.logger.Error("chaos: undrain host failed", "host", host, "error", err)
+			return
+		}
+		p.logger.Warn("chaos: host undrained", "host", host)
+	})
+}
+
+// chaosJSON 管理接口/v1/chaos的格式，时长为 500ms 这样的字符串
+type chaosJSON struct {
+	DelayRatio   float64 `json:"delay_ratio"`
+	Delay        string  `json:"delay,omitempty"`
+	FailRatio    float64 `json:"fail_ratio"`
+	FlapInterval string  `json:"flap_interval,omitempty"`
+	FlapDown     string  `json:"flap_down,omitempty"`
+}
+
+func newChaosJSON(c ChaosConfig) chaosJSON {
+	format := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+	return chaosJSON{
+		DelayRatio:   c.DelayRatio,
+		Delay:        format(c.Delay),
+		FailRatio:    c.FailRatio,
+		FlapInterval: format(c.FlapInterval),
+		FlapDown:     format(c.FlapDown),
+	}
+}
+
+func (j chaosJSON) config() (ChaosConfig, error) {
+	c := ChaosConfig{DelayRatio: j.DelayRatio, FailRatio: j.FailRatio}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"delay", j.Delay, &c.Delay},
+		{"flap_interval", j.FlapInterval, &c.FlapInterval},
+		{"flap_down", j.FlapDown, &c.FlapDown},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return ChaosConfig{}, fmt.Errorf("%s: %w", d.name, err)
+		}
+		*d.dst = v
+	}
+	return c, nil
+}
+
+func (p *Proxy) handleChaos(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, newChaosJSON(p.Chaos()))
+
+	case http.MethodPut:
+		var j chaosJSON
+		if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		config, err := j.config()
+		if err == nil {
+			err = p.SetChaos(config)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, newChaosJSON(p.Chaos()))
+
+	case http.MethodDelete:
+		_ = p.SetChaos(ChaosConfig{})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
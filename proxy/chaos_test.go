@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFlapHostDrainsWithoutPersisting(t *testing.T) {
+	p := newTestProxy(t, []string{"a:80"})
+	wal := filepath.Join(t.TempDir(), "wal")
+	p.EnableSnapshot(filepath.Join(t.TempDir(), "snapshot"))
+	if err := p.EnableWAL(wal, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.consistent.DrainHost("a:80"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.flapHost(50 * time.Millisecond)
+	if !p.consistent.IsDrainedBy("a:80", drainSourceChaos) {
+		t.Fatal("flapped host was not drained by chaos")
+	}
+	if hosts := p.consistent.Hosts(); len(hosts) != 1 {
+		t.Fatalf("flapped host was removed from the ring: %v", hosts)
+	}
+	if fi, err := os.Stat(wal); err != nil || fi.Size() != 0 {
+		t.Fatalf("flapping wrote to the WAL: %v, %v", fi, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for p.consistent.IsDrainedBy("a:80", drainSourceChaos) {
+		if time.Now().After(deadline) {
+			t.Fatal("chaos drain was not undone")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !p.consistent.IsDraining("a:80") {
+		t.Fatal("undoing the chaos drain removed the operator drain")
+	}
+}
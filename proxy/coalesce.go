@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// 超过该大小的响应无法分发给等待的请求，等待的请求会各自转发
+const coalesceMaxResponseBytes = 1 << 20
+
+// flightGroup 合并同一key、同一URI的并发GET请求：第一个请求转发给后端，其余请求等待并复用它的响应
+type flightGroup struct {
+	calls map[string]*flight
+	sync.Mutex
+}
+
+type flight struct {
+	done chan struct{}
+	// 为false时响应不完整（过大、客户端中途断开等），等待的请求需要自己转发
+	ok     bool
+	status int
+	header http.Header
+	body   []byte
+	// 第一个请求的请求头，用于按响应的Vary判断等待的请求能否复用
+	reqHeader http.Header
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flight)}
+}
+
+// do 作为第一个请求时返回包装后的ResponseWriter和转发结束后需调用的finish
+// 否则等待第一个请求完成，成功复用其响应时返回served为true
+func (g *flightGroup) do(w http.ResponseWriter, r *http.Request, key string) (http.ResponseWriter, func(), bool) {
+	if r.Method != http.MethodGet {
+		return w, func() {}, false
+	}
+	// 带凭证的请求响应可能因用户而不同，不参与合并
+	directives := cacheDirectives(r.Header)
+	if directives["no-cache"] || directives["no-store"] || credentialed(r) {
+		return w, func() {}, false
+	}
+
+	flightKey := key + "\x00" + r.URL.RequestURI()
+	g.Lock()
+	if f, ok := g.calls[flightKey]; ok {
+		g.Unlock()
+		select {
+		case <-f.done:
+		case <-r.Context().Done():
+			return w, nil, true
+		}
+		if !f.ok || !sameVariant(f, r) {
+			return w, func() {}, false
+		}
+		writeFlight(w, f)
+		return w, nil, true
+	}
+
+	f := &flight{done: make(chan struct{}), reqHeader: r.Header.Clone()}
+	g.calls[flightKey] = f
+	g.Unlock()
+
+	rec := &cacheRecorder{ResponseWriter: w, status: http.StatusOK, limit: coalesceMaxResponseBytes}
+	return rec, func() {
+		g.Lock()
+		delete(g.calls, flightKey)
+		g.Unlock()
+
+		if !rec.overflow && r.Context().Err() == nil {
+			f.ok, f.status, f.header, f.body = true, rec.status, rec.Header().Clone(), rec.buf.Bytes()
+		}
+		close(f.done)
+	}, false
+}
+
+// sameVariant 响应的Vary列出的请求头在两个请求中相同，Vary: *时不复用
+func sameVariant(f *flight, r *http.Request) bool {
+	vary, ok := varyValues(f.header, f.reqHeader)
+	if !ok {
+		return false
+	}
+	for name, value := range vary {
+		if strings.Join(r.Header.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func writeFlight(w http.ResponseWriter, f *flight) {
+	h := w.Header()
+	for k, v := range f.header {
+		h[k] = v
+	}
+	w.WriteHeader(f.status)
+	_, _ = w.Write(f.body)
+}
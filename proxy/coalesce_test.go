@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFlightGroupSkipsCredentialedRequests(t *testing.T) {
+	g := newFlightGroup()
+	for _, h := range []string{"Authorization", "Cookie"} {
+		r := httptest.NewRequest(http.MethodGet, "/obj", nil)
+		r.Header.Set(h, "secret")
+		_, finish, served := g.do(httptest.NewRecorder(), r, "k")
+		if served || len(g.calls) != 0 {
+			t.Fatalf("request with %s joined a flight", h)
+		}
+		finish()
+	}
+}
+
+func TestFlightSameVariant(t *testing.T) {
+	tests := []struct {
+		name   string
+		vary   string
+		leader string
+		waiter string
+		want   bool
+	}{
+		{"no vary", "", "gzip", "br", true},
+		{"same value", "Accept-Encoding", "gzip", "gzip", true},
+		{"different value", "Accept-Encoding", "gzip", "br", false},
+		{"star", "*", "gzip", "gzip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &flight{header: http.Header{}, reqHeader: http.Header{"Accept-Encoding": {tt.leader}}}
+			if tt.vary != "" {
+				f.header.Set("Vary", tt.vary)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/obj", nil)
+			r.Header.Set("Accept-Encoding", tt.waiter)
+			if got := sameVariant(f, r); got != tt.want {
+				t.Fatalf("sameVariant = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DeadHostAction 服务器被判定为不可用后的处理方式
+type DeadHostAction string
+
+const (
+	// DeadHostRemove 注销服务器，它负责的key立即转移到其他服务器；与管理接口的注销一样会写入预写日志并同步给其他实例
+	DeadHostRemove DeadHostAction = "remove"
+	// DeadHostDrain 只在本实例摘除服务器，保留它在环上的位置
+	DeadHostDrain DeadHostAction = "drain"
+)
+
+// ParseDeadHostAction 解析remove或drain
+func ParseDeadHostAction(s string) (DeadHostAction, error) {
+	switch a := DeadHostAction(s); a {
+	case DeadHostRemove, DeadHostDrain:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown dead host action %q, must be remove or drain", s)
+}
+
+// 驱逐以这个名义摘除服务器，探测成功后只撤销自己的摘除
+const drainSourceDeadHost = "dead_host"
+
+// DeadHostConfig 转发时连续ConsecutiveFailures次在连接层失败（拒绝连接、连接重置、建立连接超时等）的服务器被判定为不可用，
+// 不依赖主动健康检查；收到任何响应（包括5xx）都会清零计数
+//
+// 被驱逐的服务器按指数退避探测（建立TCP连接），连通后先只保留部分虚拟节点，在RampUp内恢复全部的key；
+// 被注销的服务器重新注册后同样先摘除，等到探测成功再放回环上。反复被驱逐的服务器第n次的首次探测间隔为ProbeInterval*2^(n-1)，
+// 恢复后稳定超过MaxProbeInterval才重新计数，避免不稳定的服务器让key频繁地迁移
+type DeadHostConfig struct {
+	ConsecutiveFailures int
+	Action              DeadHostAction
+	// 首次探测的间隔，每次探测失败翻倍，最长为MaxProbeInterval
+	ProbeInterval    time.Duration
+	MaxProbeInterval time.Duration
+	// 探测成功后逐步恢复虚拟节点的时长，0表示立即全部恢复
+	RampUp time.Duration
+}
+
+func DefaultDeadHostConfig() DeadHostConfig {
+	return DeadHostConfig{
+		ConsecutiveFailures: 5,
+		Action:              DeadHostRemove,
+		ProbeInterval:       time.Second,
+		MaxProbeInterval:    2 * time.Minute,
+		RampUp:              30 * time.Second,
+	}
+}
+
+const deadHostProbeTimeout = time.Second
+
+// DeadHost 被判定为不可用而注销或摘除的服务器
+type DeadHost struct {
+	Host     string         `json:"host"`
+	Action   DeadHostAction `json:"action"`
+	Failures int            `json:"failures"`
+	// 最后一次失败的错误
+	Error     string    `json:"error"`
+	EvictedAt time.Time `json:"evicted_at"`
+	// 近期被驱逐的次数，决定探测的退避时间
+	Evictions int `json:"evictions"`
+	// 下一次探测的时间，被注销、尚未重新注册的服务器为空
+	NextProbe *time.Time `json:"next_probe,omitempty"`
+
+	backoff time.Duration
+}
+
+// deadStrike 服务器近期被驱逐的次数，恢复后稳定超过MaxProbeInterval时清零
+type deadStrike struct {
+	count    int
+	restored time.Time
+}
+
+// deadHosts 统计每台服务器连续的连接失败次数，驱逐后探测并逐步恢复
+// 注销的服务器记录到重新注册并探测成功为止，摘除的服务器记录到恢复或注销为止
+type deadHosts struct {
+	proxy    *Proxy
+	config   DeadHostConfig
+	failures map[string]int
+	evicted  map[string]*DeadHost
+	strikes  map[string]*deadStrike
+	dialer   net.Dialer
+	sync.Mutex
+}
+
+func newDeadHosts(p *Proxy, config DeadHostConfig) *deadHosts {
+	defaults := DefaultDeadHostConfig()
+	if config.ProbeInterval <= 0 {
+		config.ProbeInterval = defaults.ProbeInterval
+	}
+	if config.MaxProbeInterval < config.ProbeInterval {
+		config.MaxProbeInterval = max(defaults.MaxProbeInterval, config.ProbeInterval)
+	}
+	return &deadHosts{
+		proxy:    p,
+		config:   config,
+		failures: make(map[string]int),
+		evicted:  make(map[string]*DeadHost),
+		strikes:  make(map[string]*deadStrike),
+		dialer:   net.Dialer{Timeout: deadHostProbeTimeout},
+	}
+}
+
+// 未开启（nil）时不统计；客户端取消、超时等不是连接层的失败不计数，也不清零
+func (d *deadHosts) record(host string, err error) {
+	if d == nil {
+		return
+	}
+	if err != nil && !connectionFailure(err) {
+		return
+	}
+
+	d.Lock()
+	if err == nil {
+		delete(d.failures, host)
+		d.Unlock()
+		return
+	}
+	d.failures[host]++
+	n := d.failures[host]
+	if n < d.config.ConsecutiveFailures || d.evicted[host] != nil {
+		d.Unlock()
+		return
+	}
+	delete(d.failures, host)
+	now := time.Now()
+	strike := d.strikes[host]
+	if strike == nil || (!strike.restored.IsZero() && now.Sub(strike.restored) > d.config.MaxProbeInterval) {
+		strike = &deadStrike{}
+		d.strikes[host] = strike
+	}
+	strike.count++
+	d.evicted[host] = &DeadHost{
+		Host:      host,
+		Action:    d.config.Action,
+		Failures:  n,
+		Error:     err.Error(),
+		EvictedAt: now,
+		Evictions: strike.count,
+		backoff:   d.backoff(strike.count),
+	}
+	d.Unlock()
+
+	// 注销需要写预写日志、同步给其他实例，不阻塞转发
+	go d.evict(host, n, err)
+}
+
+func (d *deadHosts) evict(host string, failures int, cause error) {
+	p := d.proxy
+	if !d.canEvict(host) {
+		d.Lock()
+		delete(d.evicted, host)
+		d.Unlock()
+		p.logger.Warn("host looks dead but is the last available one, keeping it", "host", host, "failures", failures, "error", cause)
+		return
+	}
+
+	var err error
+	if d.config.Action == DeadHostDrain {
+		err = p.consistent.DrainHostBy(host, drainSourceDeadHost)
+	} else {
+		err = p.UnregisterHost(host)
+	}
+	if err != nil {
+		d.Lock()
+		delete(d.evicted, host)
+		d.Unlock()
+		p.logger.Error("evict dead host failed", "host", host, "action", d.config.Action, "error", err)
+		return
+	}
+	p.logger.Warn("host is dead, evicted", "host", host, "action", d.config.Action, "failures", failures, "error", cause)
+	p.metrics.ObserveHostEvicted(host, string(d.config.Action))
+
+	if d.config.Action == DeadHostDrain {
+		d.Lock()
+		if e := d.evicted[host]; e != nil {
+			d.scheduleProbe(e, e.EvictedAt.Add(e.backoff))
+		}
+		d.Unlock()
+	}
+}
+
+// backoff 第n次被驱逐后首次探测的间隔
+func (d *deadHosts) backoff(evictions int) time.Duration {
+	b := d.config.ProbeInterval
+	for i := 1; i < evictions && b < d.config.MaxProbeInterval; i++ {
+		b *= 2
+	}
+	return min(b, d.config.MaxProbeInterval)
+}
+
+// scheduleProbe 在at时探测服务器，调用时需持有锁
+func (d *deadHosts) scheduleProbe(e *DeadHost, at time.Time) {
+	e.NextProbe = &at
+	host := e.Host
+	time.AfterFunc(time.Until(at), func() { d.probe(host, e) })
+}
+
+// probe 连通时把服务器逐步放回环上，否则翻倍退避后再次探测
+func (d *deadHosts) probe(host string, e *DeadHost) {
+	p := d.proxy
+	select {
+	case <-p.stop:
+		return
+	default:
+	}
+	// 记录已被清除（注销）或被新的驱逐替换时不再探测
+	d.Lock()
+	current := d.evicted[host] == e && e.NextProbe != nil
+	d.Unlock()
+	if !current {
+		return
+	}
+	if !p.consistent.IsDrainedBy(host, drainSourceDeadHost) {
+		d.forget(host, e)
+		return
+	}
+
+	conn, err := d.dialer.Dial("tcp", host)
+	if err != nil {
+		d.Lock()
+		if d.evicted[host] == e {
+			e.backoff = min(e.backoff*2, d.config.MaxProbeInterval)
+			d.scheduleProbe(e, time.Now().Add(e.backoff))
+			p.logger.Debug("dead host still unreachable", "host", host, "error", err, "retry_in", e.backoff)
+		}
+		d.Unlock()
+		return
+	}
+	_ = conn.Close()
+
+	d.Lock()
+	if d.evicted[host] != e {
+		d.Unlock()
+		return
+	}
+	delete(d.evicted, host)
+	if strike := d.strikes[host]; strike != nil {
+		strike.restored = time.Now()
+	}
+	d.Unlock()
+
+	// 先减少虚拟节点再恢复，它原来负责的key分批迁回
+	if d.config.RampUp > 0 {
+		_ = p.consistent.WarmUp(host, d.config.RampUp)
+	}
+	_ = p.consistent.UndrainBy(host, drainSourceDeadHost)
+	p.logger.Info("dead host recovered", "host", host, "evictions", e.Evictions, "ramp_up", d.config.RampUp)
+}
+
+func (d *deadHosts) forget(host string, e *DeadHost) {
+	d.Lock()
+	defer d.Unlock()
+	if d.evicted[host] == e {
+		delete(d.evicted, host)
+	}
+}
+
+// canEvict 不驱逐最后一台未被摘除的服务器：所有服务器同时连接失败更可能是代理自己的网络出了问题
+func (d *deadHosts) canEvict(host string) bool {
+	c := d.proxy.consistent
+	for _, h := range c.Hosts() {
+		if h != host && !c.IsDraining(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// added 被注销的服务器重新注册后先摘除，退避时间到了并且探测成功才放回环上
+func (d *deadHosts) added(host string) {
+	if d == nil {
+		return
+	}
+
+	d.Lock()
+	delete(d.failures, host)
+	e := d.evicted[host]
+	d.Unlock()
+	if e == nil || e.Action != DeadHostRemove {
+		return
+	}
+
+	if err := d.proxy.consistent.DrainHostBy(host, drainSourceDeadHost); err != nil {
+		d.forget(host, e)
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	if d.evicted[host] != e || e.NextProbe != nil {
+		return
+	}
+	at := e.EvictedAt.Add(e.backoff)
+	d.scheduleProbe(e, at)
+	d.proxy.logger.Info("dead host registered again, probing before restoring it", "host", host, "probe_at", at)
+}
+
+// remove 服务器注销后清除计数，被自己注销的服务器保留记录，等待重新注册
+func (d *deadHosts) remove(host string) {
+	if d == nil {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	delete(d.failures, host)
+	e := d.evicted[host]
+	switch {
+	case e == nil:
+	case e.Action == DeadHostDrain:
+		delete(d.evicted, host)
+	default:
+		e.NextProbe = nil
+	}
+}
+
+// connectionFailure 没有收到响应、且不是因为客户端取消或超时的错误
+func connectionFailure(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errPerTryTimeout) {
+		return false
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// DeadHosts 因连续的连接失败被注销或摘除、还没有恢复的服务器
+func (p *Proxy) DeadHosts() []DeadHost {
+	hosts := make([]DeadHost, 0)
+	if p.deadHosts == nil {
+		return hosts
+	}
+
+	p.deadHosts.Lock()
+	defer p.deadHosts.Unlock()
+	for _, e := range p.deadHosts.evicted {
+		hosts = append(hosts, *e)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
+
+func (p *Proxy) handleDeadHosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, p.DeadHosts())
+}
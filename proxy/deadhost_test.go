@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDeadHostRecoveryKeepsOperatorDrain(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	host := ln.Addr().String()
+
+	config := DefaultDeadHostConfig()
+	config.ConsecutiveFailures = 1
+	config.Action = DeadHostDrain
+	// 测试中手动探测
+	config.ProbeInterval = time.Hour
+	config.RampUp = 0
+	p := newTestProxy(t, []string{host, "b:80"}, WithDeadHostEviction(config))
+	d := p.deadHosts
+
+	d.record(host, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED})
+	// 驱逐是异步的，等到安排了探测
+	e := waitProbeScheduled(t, d, host)
+	if !p.consistent.IsDrainedBy(host, drainSourceDeadHost) {
+		t.Fatal("dead host was not drained")
+	}
+	if err = p.consistent.DrainHost(host); err != nil {
+		t.Fatal(err)
+	}
+	d.probe(host, e)
+
+	if !p.consistent.IsDraining(host) {
+		t.Fatal("host drained by operator was undrained by dead host probe")
+	}
+	if p.consistent.IsDrainedBy(host, drainSourceDeadHost) {
+		t.Fatal("dead host probe did not undo its own drain")
+	}
+	if len(p.DeadHosts()) != 0 {
+		t.Fatal("recovered host is still listed as dead")
+	}
+}
+
+func waitProbeScheduled(t *testing.T, d *deadHosts, host string) *DeadHost {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		d.Lock()
+		e := d.evicted[host]
+		scheduled := e != nil && e.NextProbe != nil
+		d.Unlock()
+		if scheduled {
+			return e
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dead host was not evicted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// DebugHandler 返回运行时调试接口，排查线上性能问题时不必重新编译，应挂在带鉴权的管理端口上：
+//
+//	GET    /debug/pprof/           net/http/pprof的各项profile，如 /debug/pprof/profile?seconds=30
+//	GET    /debug/stats            goroutine数量、GC、内存，以及每个环的锁争用和正在转发的请求数
+func (rs *Rings) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/stats", rs.handleDebugStats)
+	return mux
+}
+
+type debugStats struct {
+	Goroutines int         `json:"goroutines"`
+	GC         gcStats     `json:"gc"`
+	Memory     memoryStats `json:"memory"`
+	Rings      []ringDebug `json:"rings"`
+}
+
+type gcStats struct {
+	NumGC uint32 `json:"num_gc"`
+	// 累计和最近一次的停顿时长（纳秒）
+	PauseTotalNs uint64     `json:"pause_total_ns"`
+	LastPauseNs  uint64     `json:"last_pause_ns"`
+	LastGC       *time.Time `json:"last_gc,omitempty"`
+	CPUFraction  float64    `json:"cpu_fraction"`
+}
+
+type memoryStats struct {
+	HeapAlloc   uint64 `json:"heap_alloc"`
+	HeapInuse   uint64 `json:"heap_inuse"`
+	HeapObjects uint64 `json:"heap_objects"`
+	Sys         uint64 `json:"sys"`
+}
+
+type ringDebug struct {
+	Name     string         `json:"name"`
+	Hosts    int            `json:"hosts"`
+	InFlight int64          `json:"in_flight"`
+	Lock     core.LockStats `json:"lock"`
+}
+
+func (rs *Rings) handleDebugStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	stats := debugStats{
+		Goroutines: runtime.NumGoroutine(),
+		GC: gcStats{
+			NumGC:        ms.NumGC,
+			PauseTotalNs: ms.PauseTotalNs,
+			LastPauseNs:  ms.PauseNs[(ms.NumGC+255)%256],
+			CPUFraction:  ms.GCCPUFraction,
+		},
+		Memory: memoryStats{
+			HeapAlloc:   ms.HeapAlloc,
+			HeapInuse:   ms.HeapInuse,
+			HeapObjects: ms.HeapObjects,
+			Sys:         ms.Sys,
+		},
+	}
+	if ms.NumGC > 0 {
+		last := time.Unix(0, int64(ms.LastGC))
+		stats.GC.LastGC = &last
+	}
+	for _, info := range rs.List() {
+		p, ok := rs.Get(info.Name)
+		if !ok {
+			continue
+		}
+		stats.Rings = append(stats.Rings, ringDebug{
+			Name:     info.Name,
+			Hosts:    info.Hosts,
+			InFlight: p.inflight.total(),
+			Lock:     p.consistent.LockStats(),
+		})
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
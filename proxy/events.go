@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+const defaultLoadInterval = time.Second
+
+type loadEvent struct {
+	Host string `json:"host"`
+	Load int64  `json:"load"`
+}
+
+// handleEvents 以Server-Sent Events推送拓扑和负载的变化：
+//
+//	event: Topology       连接建立时（以及版本过旧需要重新同步时）的完整拓扑
+//	event: HostAdded      拓扑事件，id为事件的版本号，断线重连时通过Last-Event-ID续传
+//	event: HostRemoved
+//	event: WeightChanged
+//	event: WarmupProgress 慢启动的服务器增加了虚拟节点，warmup为放到环上的比例
+//	event: LoadUpdated    每隔load_interval推送负载有变化的服务器，load_interval=0时不推送
+func (p *Proxy) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "internal", "streaming not supported")
+		return
+	}
+
+	interval := defaultLoadInterval
+	if s := r.URL.Query().Get("load_interval"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_param", "load_interval must be a non-negative duration")
+			return
+		}
+		interval = d
+	}
+
+	// 没有Last-Event-ID时先发送完整拓扑
+	since, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	resync := err != nil
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ctx := r.Context()
+	var loads <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		loads = ticker.C
+	}
+	last := make(map[string]int64)
+
+	// 同一时间只有一个等待拓扑事件的goroutine，负载推送不会打断它
+	var batch <-chan topologyBatch
+	for {
+		if resync {
+			t := p.consistent.Topology()
+			res := topologyResponse{Version: t.Version, Hosts: make([]hostResponse, 0, len(t.Hosts))}
+			for _, h := range t.Hosts {
+				res.Hosts = append(res.Hosts, newHostResponse(h))
+			}
+			if writeEvent(w, "Topology", strconv.FormatUint(t.Version, 10), res) != nil {
+				return
+			}
+			since, resync = t.Version, false
+		}
+		flusher.Flush()
+
+		if batch == nil {
+			batch = p.waitTopology(ctx, since)
+		}
+		select {
+		case <-ctx.Done():
+			return
+
+		case res := <-batch:
+			batch = nil
+			if errors.Is(res.err, core.ErrStaleVersion) {
+				resync = true
+				continue
+			}
+			if res.err != nil {
+				return
+			}
+			for _, ev := range res.events {
+				if ev.Type == core.HostRemoved {
+					delete(last, ev.Host)
+				}
+				if writeEvent(w, ev.Type.String(), strconv.FormatUint(ev.Version, 10), newTopologyEvent(ev)) != nil {
+					return
+				}
+			}
+			since = res.version
+
+		case <-loads:
+			for host, load := range p.consistent.GetLoads() {
+				if prev, ok := last[host]; ok && prev == load {
+					continue
+				}
+				last[host] = load
+				if writeEvent(w, "LoadUpdated", "", loadEvent{Host: host, Load: load}) != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+type topologyBatch struct {
+	events  []core.TopologyEvent
+	version uint64
+	err     error
+}
+
+// waitTopology 在后台等待版本号大于since的拓扑事件，ctx结束后返回
+func (p *Proxy) waitTopology(ctx context.Context, since uint64) <-chan topologyBatch {
+	ch := make(chan topologyBatch, 1)
+	go func() {
+		events, version, err := p.consistent.WaitChanges(ctx, since)
+		ch <- topologyBatch{events: events, version: version, err: err}
+	}()
+	return ch
+}
+
+func writeEvent(w http.ResponseWriter, event, id string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err = fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+type routeKey struct{}
+
+// route 一次请求的路由结果，hosts[0]为选中的服务器，其余为按环顺序的故障转移候选
+type route struct {
+	key   string
+	hash  uint64
+	hosts []string
+	retry RetryPolicy
+	// 每次尝试都计入实际转发的服务器的负载（core.Inc、Done），直到响应体读完或连接关闭
+	charge bool
+}
+
+func routeFrom(ctx context.Context) *route {
+	rt, _ := ctx.Value(routeKey{}).(*route)
+	return rt
+}
+
+// 基于httputil.ReverseProxy转发：流式传输请求和响应体，保留方法、请求头和状态码
+func newForwarder(transport http.RoundTripper, logger Logger, limits BodyLimits) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			rt := routeFrom(pr.In.Context())
+			pr.SetURL(&url.URL{Scheme: "http", Host: rt.hosts[0]})
+			pr.SetXForwarded()
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			rt := routeFrom(resp.Request.Context())
+			logger.Debug("backend response",
+				"request_id", RequestIDFrom(resp.Request.Context()), "key", rt.key, "key_hash", rt.hash,
+				"host", resp.Request.URL.Host, "status", resp.StatusCode)
+			return limitResponseBody(resp, limits.MaxResponseBytes)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logger.Error("forward failed",
+				"request_id", RequestIDFrom(r.Context()), "key", routeFrom(r.Context()).key, "error", err)
+			if IsGRPC(r) {
+				writeGRPCUnavailable(w, err)
+				return
+			}
+			// 请求超时，包括路由表配置的和客户端给出的
+			if errors.Is(err, context.DeadlineExceeded) {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
+
+// isUpgrade 判断是否为协议升级请求，如WebSocket；ReverseProxy会在后端返回101后双向转发连接上的数据
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeGRPCUnavailable 以gRPC的方式返回错误（只有响应头的响应），客户端得到UNAVAILABLE而不是HTTP状态码
+func writeGRPCUnavailable(w http.ResponseWriter, err error) {
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", "14")
+	h.Set("Grpc-Message", url.PathEscape(err.Error()))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, rt *route) {
+	ctx := context.WithValue(r.Context(), routeKey{}, rt)
+	p.forwarder.ServeHTTP(w, r.WithContext(ctx))
+}
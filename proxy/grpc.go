@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/dingqing/consistent-hash/api"
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// GRPCServer 以gRPC的方式提供注册、注销和路由查询，供非HTTP的客户端接入
+func (p *Proxy) GRPCServer() api.RegistryServer {
+	return &grpcServer{proxy: p}
+}
+
+type grpcServer struct {
+	api.UnimplementedRegistryServer
+	proxy *Proxy
+}
+
+func (s *grpcServer) RegisterHost(ctx context.Context, req *api.RegisterHostRequest) (*api.RegisterHostResponse, error) {
+	if req.Host == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing host")
+	}
+
+	meta := core.Metadata{
+		Datacenter: req.Datacenter,
+		Zone:       req.Zone,
+	}
+	err := s.proxy.RegisterHostTTL(req.Host, meta, time.Duration(req.TtlSeconds)*time.Second)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.RegisterHostResponse{}, nil
+}
+
+func (s *grpcServer) UnregisterHost(ctx context.Context, req *api.UnregisterHostRequest) (*api.UnregisterHostResponse, error) {
+	if err := s.proxy.UnregisterHost(req.Host); err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.UnregisterHostResponse{}, nil
+}
+
+func (s *grpcServer) Renew(ctx context.Context, req *api.RenewRequest) (*api.RenewResponse, error) {
+	if err := s.proxy.Renew(req.Host); err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.RenewResponse{}, nil
+}
+
+func (s *grpcServer) GetHost(ctx context.Context, req *api.GetHostRequest) (*api.GetHostResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing key")
+	}
+
+	mode := ModeHash
+	if req.Mode == api.Mode_MODE_CAPACIOUS {
+		mode = ModeCapacious
+	}
+	host, err := s.proxy.pick(ctx, req.Key, mode)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.GetHostResponse{Host: host}, nil
+}
+
+func (s *grpcServer) Watch(req *api.WatchRequest, stream api.Registry_WatchServer) error {
+	events := s.proxy.consistent.Subscribe()
+	defer s.proxy.consistent.Unsubscribe(events)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			// gRPC接口的EventType没有慢启动进度，服务器和权重都没有变化
+			if ev.Type == core.WarmupProgress {
+				continue
+			}
+			err := stream.Send(&api.TopologyEvent{
+				Type:    api.EventType(ev.Type),
+				Host:    ev.Host,
+				Weight:  int32(ev.Weight),
+				Version: ev.Version,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *grpcServer) GetTopology(ctx context.Context, req *api.GetTopologyRequest) (*api.GetTopologyResponse, error) {
+	data, err := s.proxy.consistent.Snapshot()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.GetTopologyResponse{Snapshot: data}, nil
+}
+
+// mutatingMethods 会修改拓扑、需要鉴权的方法
+var mutatingMethods = map[string]bool{
+	api.Registry_RegisterHost_FullMethodName:   true,
+	api.Registry_UnregisterHost_FullMethodName: true,
+	api.Registry_Renew_FullMethodName:          true,
+}
+
+// UnaryAuth 要求修改拓扑的调用在metadata中携带authorization: Bearer <token>，查询不受限制
+func UnaryAuth(tokens ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !mutatingMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			if token, ok := strings.CutPrefix(v, "Bearer "); ok && validToken(token, tokens) {
+				return handler(ctx, req)
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+}
+
+func grpcError(err error) error {
+	var code codes.Code
+	switch {
+	case errors.Is(err, core.ErrHostAlreadyExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, core.ErrHostNotFound):
+		code = codes.NotFound
+	case errors.Is(err, core.ErrNoHosts), errors.Is(err, core.ErrAllHostsOverloaded):
+		code = codes.Unavailable
+	case errors.Is(err, core.ErrInvalidTTL):
+		code = codes.InvalidArgument
+	case errors.Is(err, core.ErrNoTTL):
+		code = codes.FailedPrecondition
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type HealthCheckConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// 探测的路径，如 /healthz
+	Path string
+	// 连续失败多少次后标记为不健康
+	UnhealthyThreshold int
+	// 连续成功多少次后恢复
+	HealthyThreshold int
+}
+
+func DefaultHealthCheckConfig() HealthCheckConfig {
+	return HealthCheckConfig{
+		Interval:           5 * time.Second,
+		Timeout:            time.Second,
+		Path:               "/healthz",
+		UnhealthyThreshold: 3,
+		HealthyThreshold:   2,
+	}
+}
+
+// 健康检查以这个名义摘除服务器，恢复时只撤销自己的摘除
+const drainSourceHealth = "health"
+
+type hostHealth struct {
+	failures  int
+	successes int
+	unhealthy bool
+}
+
+// healthChecker 定期探测所有已注册的服务器，不健康时通过core的摘除机制停止向其路由
+type healthChecker struct {
+	proxy  *Proxy
+	config HealthCheckConfig
+	client *http.Client
+	hosts  map[string]*hostHealth
+	sync.Mutex
+}
+
+func newHealthChecker(p *Proxy, config HealthCheckConfig) *healthChecker {
+	return &healthChecker{
+		proxy:  p,
+		config: config,
+		client: &http.Client{Transport: p.transports, Timeout: config.Timeout},
+		hosts:  make(map[string]*hostHealth),
+	}
+}
+
+func (h *healthChecker) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.checkAll()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (h *healthChecker) checkAll() {
+	hosts := h.proxy.consistent.Hosts()
+
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			h.report(host, h.probe(host))
+		}(host)
+	}
+	wg.Wait()
+
+	h.forgetRemoved(hosts)
+}
+
+func (h *healthChecker) probe(host string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s%s", host, h.config.Path), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
+func (h *healthChecker) report(host string, healthy bool) {
+	h.Lock()
+	state, ok := h.hosts[host]
+	if !ok {
+		state = &hostHealth{}
+		h.hosts[host] = state
+	}
+
+	var changed bool
+	if healthy {
+		state.failures = 0
+		state.successes++
+		if state.unhealthy && state.successes >= h.config.HealthyThreshold {
+			state.unhealthy = false
+			changed = true
+		}
+	} else {
+		state.successes = 0
+		state.failures++
+		if !state.unhealthy && state.failures >= h.config.UnhealthyThreshold {
+			state.unhealthy = true
+			changed = true
+		}
+	}
+	h.Unlock()
+
+	if !changed {
+		return
+	}
+	// 只在健康状态发生变化时摘除或恢复服务器；管理接口、异常检测等的摘除不受影响
+	if healthy {
+		h.proxy.logger.Info("host is healthy again", "host", host)
+		_ = h.proxy.consistent.UndrainBy(host, drainSourceHealth)
+	} else {
+		h.proxy.logger.Warn("host is unhealthy, draining", "host", host)
+		_ = h.proxy.consistent.DrainHostBy(host, drainSourceHealth)
+	}
+}
+
+func (h *healthChecker) forgetRemoved(hosts []string) {
+	registered := make(map[string]struct{}, len(hosts))
+	for _, host := range hosts {
+		registered[host] = struct{}{}
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	for host := range h.hosts {
+		if _, ok := registered[host]; !ok {
+			delete(h.hosts, host)
+		}
+	}
+}
+
+// Healthy 健康检查是否认为服务器可用，未开启健康检查时总是返回true
+func (p *Proxy) Healthy(host string) bool {
+	if p.health == nil {
+		return true
+	}
+
+	p.health.Lock()
+	defer p.health.Unlock()
+
+	state, ok := p.health.hosts[host]
+	return !ok || !state.unhealthy
+}
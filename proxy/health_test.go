@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// newTestProxy 不开启任何后台探测的代理，hosts直接注册到环上
+func newTestProxy(t *testing.T, hosts []string, opts ...Option) *Proxy {
+	t.Helper()
+	c := core.New(10, nil)
+	for _, host := range hosts {
+		if err := c.RegisterHost(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts = append([]Option{WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))}, opts...)
+	p := New(c, opts...)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func newTestHealthChecker(p *Proxy) *healthChecker {
+	config := DefaultHealthCheckConfig()
+	config.UnhealthyThreshold = 1
+	config.HealthyThreshold = 1
+	return newHealthChecker(p, config)
+}
+
+func TestHealthRecoveryKeepsOperatorDrain(t *testing.T) {
+	p := newTestProxy(t, []string{"a:80", "b:80"})
+	h := newTestHealthChecker(p)
+
+	if err := p.consistent.DrainHost("a:80"); err != nil {
+		t.Fatal(err)
+	}
+	h.report("a:80", false)
+	h.report("a:80", true)
+
+	if !p.consistent.IsDraining("a:80") {
+		t.Fatal("host drained by operator was undrained by health check")
+	}
+	if p.consistent.IsDrainedBy("a:80", drainSourceHealth) {
+		t.Fatal("health check did not undo its own drain")
+	}
+}
+
+func TestHealthRecoveryUndrainsOwnDrain(t *testing.T) {
+	p := newTestProxy(t, []string{"a:80", "b:80"})
+	h := newTestHealthChecker(p)
+
+	h.report("a:80", false)
+	if !p.consistent.IsDraining("a:80") {
+		t.Fatal("unhealthy host was not drained")
+	}
+	h.report("a:80", true)
+	if p.consistent.IsDraining("a:80") {
+		t.Fatal("recovered host is still drained")
+	}
+}
+
+func TestOperatorUndrainKeepsHealthDrain(t *testing.T) {
+	p := newTestProxy(t, []string{"a:80", "b:80"})
+	h := newTestHealthChecker(p)
+
+	h.report("a:80", false)
+	if err := p.consistent.Undrain("a:80"); err != nil {
+		t.Fatal(err)
+	}
+	if !p.consistent.IsDraining("a:80") {
+		t.Fatal("operator undrain removed the health check drain")
+	}
+}
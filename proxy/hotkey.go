@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HotKeyConfig 每秒读请求数超过Threshold的key成为热点，分散到环上从key开始的Replicas台服务器
+type HotKeyConfig struct {
+	Threshold float64
+	Replicas  int
+	// 最后一次超过阈值之后持续Cooldown才恢复为只发往一台服务器，避免在阈值附近来回切换
+	Cooldown time.Duration
+}
+
+func DefaultHotKeyConfig() HotKeyConfig {
+	return HotKeyConfig{
+		Threshold: 1000,
+		Replicas:  3,
+		Cooldown:  10 * time.Second,
+	}
+}
+
+// hotKeys 按1秒的窗口统计每个key的读请求数
+type hotKeys struct {
+	config HotKeyConfig
+	counts map[string]int
+	window time.Time
+	// 热点key -> 冷却结束的时间
+	hot    map[string]time.Time
+	logger Logger
+	sync.Mutex
+}
+
+func newHotKeys(config HotKeyConfig) *hotKeys {
+	defaults := DefaultHotKeyConfig()
+	if config.Replicas <= 1 {
+		config.Replicas = defaults.Replicas
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = defaults.Cooldown
+	}
+	return &hotKeys{
+		config: config,
+		counts: make(map[string]int),
+		hot:    make(map[string]time.Time),
+		logger: defaultLogger(),
+	}
+}
+
+// observe 记录一次读请求，返回key当前是否为热点
+func (h *hotKeys) observe(key string) bool {
+	now := time.Now()
+	h.Lock()
+	defer h.Unlock()
+
+	if now.Sub(h.window) >= time.Second {
+		h.window = now
+		h.counts = make(map[string]int, len(h.counts))
+		for k, until := range h.hot {
+			if now.After(until) {
+				delete(h.hot, k)
+				h.logger.Info("hot key cooled down", "key", k)
+			}
+		}
+	}
+
+	h.counts[key]++
+	if float64(h.counts[key]) > h.config.Threshold {
+		if _, ok := h.hot[key]; !ok {
+			h.logger.Info("hot key detected", "key", key, "threshold", h.config.Threshold, "replicas", h.config.Replicas)
+		}
+		h.hot[key] = now.Add(h.config.Cooldown)
+		return true
+	}
+	until, ok := h.hot[key]
+	return ok && now.Before(until)
+}
+
+// spreadHotKey 在key之后的Replicas台服务器中按客户端连接选择一台：同一连接上的请求总是发往同一台，
+// 不同连接均匀分散；跳过摘除中的服务器，都不可用时仍使用host
+func (p *Proxy) spreadHotKey(r *http.Request, key, host string) string {
+	hosts, err := p.consistent.GetHosts(key, min(p.hotKeys.config.Replicas, p.consistent.Size()))
+	if err != nil {
+		return host
+	}
+	candidates := hosts[:0]
+	for _, h := range hosts {
+		if !p.consistent.IsDraining(h) {
+			candidates = append(candidates, h)
+		}
+	}
+	if len(candidates) == 0 {
+		return host
+	}
+	return candidates[p.consistent.HashKey(r.RemoteAddr)%uint64(len(candidates))]
+}
+
+func isRead(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
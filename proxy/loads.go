@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// inflight 每台服务器正在转发的请求数，两种模式的请求都计入，重试时按实际尝试的服务器计数
+type inflight struct {
+	counts map[string]int64
+	sync.Mutex
+}
+
+func newInflight() *inflight {
+	return &inflight{counts: make(map[string]int64)}
+}
+
+func (f *inflight) inc(host string) {
+	f.Lock()
+	defer f.Unlock()
+	f.counts[host]++
+}
+
+// 服务器已下线（remove之后）的请求结束时不再计数
+func (f *inflight) done(host string) {
+	f.Lock()
+	defer f.Unlock()
+	if n, ok := f.counts[host]; ok {
+		f.counts[host] = n - 1
+	}
+}
+
+func (f *inflight) get(host string) int64 {
+	f.Lock()
+	defer f.Unlock()
+	return f.counts[host]
+}
+
+// total 所有服务器正在转发的请求数之和
+func (f *inflight) total() int64 {
+	f.Lock()
+	defer f.Unlock()
+	var n int64
+	for _, c := range f.counts {
+		n += c
+	}
+	return n
+}
+
+func (f *inflight) remove(host string) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.counts, host)
+}
+
+type loadsResponse struct {
+	// 环上记录的负载之和（考虑容量的请求和升级后的长连接）
+	TotalLoad  int64      `json:"total_load"`
+	MaxLoad    int64      `json:"max_load"`
+	LoadFactor float64    `json:"load_factor"`
+	Hosts      []hostLoad `json:"hosts"`
+}
+
+type hostLoad struct {
+	Host string `json:"host"`
+	// 环上记录的负载，即GetLoads
+	Load int64 `json:"load"`
+	// 按当前总负载计算的上限，负载达到上限时GetHostCapacious跳过该服务器
+	MaxLoad  int64 `json:"max_load"`
+	InFlight int64 `json:"in_flight"`
+	Weight   int   `json:"weight"`
+	Draining bool  `json:"draining"`
+	// 开启负载上报时服务器最近一次上报的时间
+	ReportedAt *time.Time `json:"reported_at,omitempty"`
+}
+
+func (p *Proxy) handleLoads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	loads := p.consistent.GetLoads()
+	weights := p.consistent.GetWeights()
+	reported, _ := p.consistent.LoadProvider().(*core.ReportedLoads)
+	res := loadsResponse{
+		TotalLoad:  p.consistent.TotalLoad(),
+		MaxLoad:    p.consistent.MaxLoad(),
+		LoadFactor: p.consistent.LoadFactor(),
+		Hosts:      make([]hostLoad, 0, len(loads)),
+	}
+	for host, load := range loads {
+		// 并发注销的服务器直接跳过
+		bound, err := p.consistent.MaxLoadOf(host)
+		if err != nil {
+			continue
+		}
+		h := hostLoad{
+			Host:     host,
+			Load:     load,
+			MaxLoad:  bound,
+			InFlight: p.inflight.get(host),
+			Weight:   weights[host],
+			Draining: p.consistent.IsDraining(host),
+		}
+		if reported != nil {
+			if at, ok := reported.ReportedAt(host); ok {
+				h.ReportedAt = &at
+			}
+		}
+		res.Hosts = append(res.Hosts, h)
+	}
+	sort.Slice(res.Hosts, func(i, j int) bool { return res.Hosts[i].Host < res.Hosts[j].Host })
+	writeJSON(w, http.StatusOK, res)
+}
+
+type loadReport struct {
+	Load *int64 `json:"load"`
+	// 后端采集负载的时间，为空时为收到上报的时间
+	ReportedAt *time.Time `json:"reported_at"`
+}
+
+// handleLoadReport 后端定期上报自己的负载（CPU使用率、队列长度等，与其他服务器可比即可），交给core.UpdateLoadAt
+// 开启WithLoadReports时超过有效期的上报返回409，否则上报的值直接替换环上记录的请求数
+func (p *Proxy) handleLoadReport(w http.ResponseWriter, r *http.Request, host string) {
+	var report loadReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+	if report.Load == nil {
+		writeError(w, http.StatusBadRequest, "missing_param", "missing load")
+		return
+	}
+	at := time.Now()
+	if report.ReportedAt != nil {
+		at = *report.ReportedAt
+	}
+	if err := p.consistent.UpdateLoadAt(host, *report.Load, at); err != nil {
+		writeCoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,14 @@
+package proxy
+
+import (
+	"log/slog"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// Logger 与core使用同一个日志接口，*slog.Logger满足该接口
+type Logger = core.Logger
+
+func defaultLogger() Logger {
+	return slog.Default()
+}
@@ -0,0 +1,25 @@
+package proxy
+
+import "time"
+
+// Metrics 接收代理的运行指标，实现需要并发安全
+type Metrics interface {
+	// ObserveRoute 每个请求选出服务器之后调用，失败时host为空
+	ObserveRoute(host string, err error)
+	// ObserveBackend 每次请求后端之后调用，连接失败时status为0
+	ObserveBackend(host string, status int, latency time.Duration, err error)
+	// ObserveCache 开启响应缓存时每个GET请求调用一次，result为CacheHit、CacheMiss或CacheBypass
+	ObserveCache(result string)
+	// ObserveRateLimited 请求被限流时调用，scope为RateLimitClient或RateLimitKey
+	ObserveRateLimited(scope string)
+	// ObserveHostEvicted 服务器因连续的连接失败被注销或摘除时调用，action为remove或drain
+	ObserveHostEvicted(host, action string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveRoute(string, error)                       {}
+func (nopMetrics) ObserveBackend(string, int, time.Duration, error) {}
+func (nopMetrics) ObserveCache(string)                              {}
+func (nopMetrics) ObserveRateLimited(string)                        {}
+func (nopMetrics) ObserveHostEvicted(string, string)                {}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Middleware 包装转发的Handler，用于日志、鉴权、限流等通用逻辑
+type Middleware func(next http.Handler) http.Handler
+
+// Chain 按顺序包装h，mws[0]在最外层，最先处理请求
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// RequestIDHeader 请求ID所在的请求头，转发时一并带给后端
+const RequestIDHeader = "X-Request-ID"
+
+type requestInfoKey struct{}
+
+// requestInfo 由RequestID放入context，转发时填入路由结果，供Logging和AccessLog记录
+type requestInfo struct {
+	id   string
+	key  string
+	hash uint64
+	// 重试时为最后一次尝试的服务器
+	host string
+	// 尝试过的服务器数量，以及从第一次尝试到收到响应头的耗时
+	attempts        int
+	upstreamLatency time.Duration
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// RequestID 沿用请求头中的请求ID，没有时生成一个，并写入响应头
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if id == "" {
+				id = newRequestID()
+				r.Header.Set(RequestIDHeader, id)
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			ctx := context.WithValue(r.Context(), requestInfoKey{}, &requestInfo{id: id})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestIDFrom 返回RequestID中间件记录的请求ID，没有时返回空字符串
+func RequestIDFrom(ctx context.Context) string {
+	if info := requestInfoFrom(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Logging 记录每个请求的方法、路径、状态码、耗时，以及路由的key和选中的服务器
+// 放在RequestID之后才能记录请求ID和路由结果
+func Logging(logger Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+
+			args := []any{"method", r.Method, "uri", r.URL.RequestURI(), "status", sw.status, "latency", time.Since(start)}
+			if info := requestInfoFrom(r.Context()); info != nil {
+				args = append(args, "request_id", info.id, "key", info.key, "host", info.host)
+			}
+			logger.Info("request", args...)
+		})
+	}
+}
+
+// BearerAuth 要求请求头Authorization: Bearer <token>中的token属于tokens之一，否则返回401
+func BearerAuth(tokens ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !validToken(token, tokens) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// 逐个比较且不提前返回，避免通过耗时猜测token
+func validToken(token string, tokens []string) bool {
+	valid := 0
+	for _, t := range tokens {
+		valid |= subtle.ConstantTimeCompare([]byte(token), []byte(t))
+	}
+	return valid == 1
+}
+
+// RateLimit 令牌桶限流，每秒生成rate个令牌，最多积攒burst个，超出时返回429
+func RateLimit(rate float64, burst int) Middleware {
+	bucket := newTokenBucket(rate, burst)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !bucket.take() {
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) take() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// idle 到now时已经攒满，或者超过idle没有使用
+func (b *tokenBucket) idle(now time.Time, idle time.Duration) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	elapsed := now.Sub(b.last)
+	return elapsed > idle || b.tokens+elapsed.Seconds()*b.rate >= b.burst
+}
+
+// statusWriter 记录写出的状态码
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// 转发流式响应时需要Flush
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// 供http.ResponseController取得底层的ResponseWriter
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
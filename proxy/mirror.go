@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// MirrorConfig 把Ratio（0~1）比例的请求复制一份发往影子服务器，用真实流量测试新版本的后端；
+// 影子请求不等待、不重试，响应直接丢弃，也不计入负载
+type MirrorConfig struct {
+	Ratio float64
+	// 影子环，按同一个路由key选择服务器；为nil时发往本环上key之后的下一台服务器
+	Shadow *Proxy
+	// 请求体超过MaxBodyBytes或长度未知的请求不复制，默认1MB
+	MaxBodyBytes int64
+	// 影子请求的超时，默认5s
+	Timeout time.Duration
+}
+
+func DefaultMirrorConfig() MirrorConfig {
+	return MirrorConfig{
+		MaxBodyBytes: 1 << 20,
+		Timeout:      5 * time.Second,
+	}
+}
+
+// SetMirror 替换请求复制的配置，Ratio为0时关闭
+func (p *Proxy) SetMirror(config MirrorConfig) error {
+	if config.Ratio < 0 || config.Ratio > 1 {
+		return errors.New("mirror: ratio must be between 0 and 1")
+	}
+	if config.Shadow == p {
+		return errors.New("mirror: shadow ring must not be the ring itself")
+	}
+	if config.Ratio == 0 {
+		p.mirror.Store(nil)
+		return nil
+	}
+
+	defaults := DefaultMirrorConfig()
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = defaults.MaxBodyBytes
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	p.mirror.Store(&config)
+	return nil
+}
+
+// mirrorRequest 按比例把r复制一份发往影子服务器；需要读取请求体时返回可以再次读取的r
+func (p *Proxy) mirrorRequest(r *http.Request, key, host string) *http.Request {
+	config := p.mirror.Load()
+	if config == nil || rand.Float64() >= config.Ratio {
+		return r
+	}
+	shadow, transport := p.shadowHost(config, key, host)
+	if shadow == "" {
+		return r
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		if r.ContentLength < 0 || r.ContentLength > config.MaxBodyBytes {
+			return r
+		}
+		var err error
+		if body, err = io.ReadAll(io.LimitReader(r.Body, r.ContentLength)); err != nil {
+			// 已读出的部分仍交给主请求，由后端处理不完整的请求体
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			return r
+		}
+		r.Body = readCloser{bytes.NewReader(body), r.Body}
+	}
+
+	// 客户端断开不影响影子请求
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), config.Timeout)
+	req := r.Clone(ctx)
+	req.RequestURI = ""
+	req.URL.Scheme, req.URL.Host = "http", shadow
+	req.Host = ""
+	req.Header.Set("X-Mirrored-From", host)
+	req.Body = http.NoBody
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	go func() {
+		defer cancel()
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			p.logger.Debug("mirror request failed", "request_id", RequestIDFrom(ctx), "host", shadow, "error", err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		p.logger.Debug("mirror response", "request_id", RequestIDFrom(ctx), "host", shadow, "status", resp.StatusCode)
+	}()
+	return r
+}
+
+// shadowHost 选择影子服务器及其连接池，没有合适的服务器时返回空
+func (p *Proxy) shadowHost(config *MirrorConfig, key, host string) (string, http.RoundTripper) {
+	if config.Shadow != nil {
+		shadow, err := config.Shadow.consistent.GetHost(key)
+		if err != nil {
+			return "", nil
+		}
+		return shadow, config.Shadow.transports
+	}
+
+	hosts, err := p.consistent.GetHosts(key, min(2, p.consistent.Size()))
+	if err != nil {
+		return "", nil
+	}
+	for _, h := range hosts {
+		if h != host {
+			return h, p.transports
+		}
+	}
+	return "", nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
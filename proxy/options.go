@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+type Option func(p *Proxy)
+
+// WithTransport 设置连接后端时使用的超时与连接池参数
+func WithTransport(config TransportConfig) Option {
+	return func(p *Proxy) {
+		p.transports = newHostTransports(config)
+	}
+}
+
+// WithHealthCheck 开启对后端的主动健康检查
+func WithHealthCheck(config HealthCheckConfig) Option {
+	return func(p *Proxy) {
+		p.healthConfig = &config
+	}
+}
+
+// WithRequestTimeout 设置请求（包括响应体）的默认超时，路由规则和客户端的X-Request-Timeout可以覆盖
+func WithRequestTimeout(d time.Duration) Option {
+	return func(p *Proxy) {
+		p.timeout = d
+	}
+}
+
+// WithBodyLimits 限制请求体和响应体的大小
+func WithBodyLimits(limits BodyLimits) Option {
+	return func(p *Proxy) {
+		p.bodyLimits = limits
+	}
+}
+
+// WithRetry 后端连接失败时沿环换下一台服务器重试
+func WithRetry(policy RetryPolicy) Option {
+	return func(p *Proxy) {
+		p.retry = policy
+	}
+}
+
+// WithCircuitBreaker 为每个后端开启熔断
+func WithCircuitBreaker(config BreakerConfig) Option {
+	return func(p *Proxy) {
+		p.breakers = newBreakers(config)
+	}
+}
+
+// WithOutlierDetection 按错误率和延迟摘除明显比其他服务器差的后端，到期后逐步恢复
+func WithOutlierDetection(config OutlierConfig) Option {
+	return func(p *Proxy) {
+		p.outlierConfig = &config
+	}
+}
+
+// WithDeadHostEviction 连续多次连接失败的服务器被注销或摘除，不必等待主动健康检查
+func WithDeadHostEviction(config DeadHostConfig) Option {
+	return func(p *Proxy) {
+		p.deadHostConfig = &config
+	}
+}
+
+// WithLoadReports 有界负载改为按后端通过POST /v1/hosts/{host}/load上报的负载判断，超过maxAge没有上报的服务器按其他服务器的平均值计算
+func WithLoadReports(maxAge time.Duration) Option {
+	return func(p *Proxy) {
+		p.consistent.SetLoadProvider(core.NewReportedLoads(maxAge))
+	}
+}
+
+// WithChoices 设置ModeLeastLoaded的候选数量：越多负载越均衡，同一key落在不同服务器的可能越大，小于1时不修改
+func WithChoices(k int) Option {
+	return func(p *Proxy) {
+		if k >= 1 {
+			p.choices = k
+		}
+	}
+}
+
+// WithMiddleware 在转发的Handler外依次套上mws，mws[0]最先处理请求
+func WithMiddleware(mws ...Middleware) Option {
+	return func(p *Proxy) {
+		p.middlewares = append(p.middlewares, mws...)
+	}
+}
+
+// WithLogger 设置代理及其健康检查、熔断等组件使用的日志
+func WithLogger(l Logger) Option {
+	return func(p *Proxy) {
+		if l != nil {
+			p.logger = l
+		}
+	}
+}
+
+// WithTracerProvider 使用tp创建span，默认使用otel的全局TracerProvider
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(p *Proxy) {
+		p.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithMetrics 采集路由和后端请求的指标
+func WithMetrics(m Metrics) Option {
+	return func(p *Proxy) {
+		if m != nil {
+			p.metrics = m
+		}
+	}
+}
+
+// WithPeers 将本实例的拓扑变更广播给其他代理实例
+func WithPeers(config PeerConfig) Option {
+	return func(p *Proxy) {
+		if len(config.Peers) > 0 {
+			p.peers = newPeers(config)
+		}
+	}
+}
+
+// WithResponseCache 在代理上缓存后端的GET响应
+func WithResponseCache(config ResponseCacheConfig) Option {
+	return func(p *Proxy) {
+		p.cache = newResponseCache(config)
+	}
+}
+
+// WithRateLimit 按客户端IP和路由key限流，避免个别客户端占满服务器的负载容量
+func WithRateLimit(config RateLimitConfig) Option {
+	return func(p *Proxy) {
+		p.limiter = newRateLimiter(config)
+	}
+}
+
+// WithRoutingKey 设置从请求中取出路由key的方式
+func WithRoutingKey(e RoutingKeyExtractor) Option {
+	return func(p *Proxy) {
+		if e != nil {
+			p.keys = e
+		}
+	}
+}
+
+// WithCoalescing 合并同一key的并发GET请求，只向后端转发一次，避免热点key缓存失效时的惊群
+func WithCoalescing() Option {
+	return func(p *Proxy) {
+		p.flights = newFlightGroup()
+	}
+}
+
+// WithHotKeySpreading 把读请求速率超过阈值的key分散到多台服务器，Threshold不大于0时不开启
+func WithHotKeySpreading(config HotKeyConfig) Option {
+	return func(p *Proxy) {
+		if config.Threshold > 0 {
+			p.hotKeys = newHotKeys(config)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutlierConfig 异常检测：按统计窗口比较所有服务器的错误率和平均延迟，明显比其他服务器差的服务器被摘除一段时间
+type OutlierConfig struct {
+	// 统计窗口，每个窗口结束时检测一次
+	Interval time.Duration
+	// 窗口内请求数少于该值的服务器不参与统计
+	MinRequests int
+	// 参与统计的服务器少于该值时不检测
+	MinHosts int
+	// 错误率或平均延迟超过所有服务器的平均值加StdevFactor倍标准差时视为异常
+	StdevFactor float64
+	// 错误率至少达到该值才按错误率摘除，避免都很健康时按微小差异摘除
+	MinErrorRate float64
+	// 平均延迟至少达到该值才按延迟摘除，0表示不按延迟检测
+	MinLatency time.Duration
+	// 第n次被摘除时摘除n*BaseEjectionTime
+	BaseEjectionTime time.Duration
+	// 最多同时摘除百分之多少的服务器，至少可以摘除一台
+	MaxEjectionPercent int
+	// 摘除到期后在该时间内逐步恢复服务器的虚拟节点，0表示立即恢复
+	RampUp time.Duration
+}
+
+func DefaultOutlierConfig() OutlierConfig {
+	return OutlierConfig{
+		Interval:           10 * time.Second,
+		MinRequests:        20,
+		MinHosts:           3,
+		StdevFactor:        1.9,
+		MinErrorRate:       0.1,
+		MinLatency:         time.Second,
+		BaseEjectionTime:   30 * time.Second,
+		MaxEjectionPercent: 10,
+		RampUp:             30 * time.Second,
+	}
+}
+
+// 异常检测以这个名义摘除服务器，到期后只撤销自己的摘除
+const drainSourceOutlier = "outlier"
+
+type outlierStats struct {
+	requests int
+	errors   int
+	latency  time.Duration
+	// 摘除次数，每个正常的窗口减一
+	ejections int
+	// 为零时未被摘除
+	ejectedUntil time.Time
+}
+
+// OutlierHost 被异常检测摘除的服务器
+type OutlierHost struct {
+	Host         string    `json:"host"`
+	Reason       string    `json:"reason"`
+	Ejections    int       `json:"ejections"`
+	EjectedUntil time.Time `json:"ejected_until"`
+}
+
+// outliers 统计每台服务器的请求结果，通过core的摘除机制停止向异常的服务器路由
+// 只恢复自己摘除的服务器，不影响健康检查和管理接口的摘除
+type outliers struct {
+	proxy   *Proxy
+	config  OutlierConfig
+	hosts   map[string]*outlierStats
+	ejected map[string]*OutlierHost
+	sync.Mutex
+}
+
+func newOutliers(p *Proxy, config OutlierConfig) *outliers {
+	return &outliers{
+		proxy:   p,
+		config:  config,
+		hosts:   make(map[string]*outlierStats),
+		ejected: make(map[string]*OutlierHost),
+	}
+}
+
+// 未开启异常检测（nil）时不统计
+func (o *outliers) record(host string, success bool, latency time.Duration) {
+	if o == nil {
+		return
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	s := o.get(host)
+	s.requests++
+	s.latency += latency
+	if !success {
+		s.errors++
+	}
+}
+
+func (o *outliers) remove(host string) {
+	if o == nil {
+		return
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	delete(o.hosts, host)
+	delete(o.ejected, host)
+}
+
+func (o *outliers) get(host string) *outlierStats {
+	s, ok := o.hosts[host]
+	if !ok {
+		s = &outlierStats{}
+		o.hosts[host] = s
+	}
+	return s
+}
+
+func (o *outliers) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(o.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			o.reinstate()
+			o.detect()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reinstate 恢复摘除到期的服务器
+func (o *outliers) reinstate() {
+	now := time.Now()
+	var expired []string
+	o.Lock()
+	for host, e := range o.ejected {
+		if !now.Before(e.EjectedUntil) {
+			expired = append(expired, host)
+			delete(o.ejected, host)
+			if s, ok := o.hosts[host]; ok {
+				s.ejectedUntil = time.Time{}
+			}
+		}
+	}
+	o.Unlock()
+
+	c := o.proxy.consistent
+	for _, host := range expired {
+		// 期间被注销后重新注册的服务器已经不再是异常检测摘除的
+		if !c.IsDrainedBy(host, drainSourceOutlier) {
+			continue
+		}
+		o.proxy.logger.Info("outlier host reinstated", "host", host)
+		// 先减少虚拟节点再恢复，避免原来的key一下子全部回到这台服务器
+		if o.config.RampUp > 0 {
+			_ = c.WarmUp(host, o.config.RampUp)
+		}
+		_ = c.UndrainBy(host, drainSourceOutlier)
+	}
+}
+
+type outlierCandidate struct {
+	host      string
+	errorRate float64
+	latency   float64
+	reason    string
+	// 超出阈值的倍数，越大越先摘除
+	score float64
+}
+
+// detect 统计上一个窗口，摘除错误率或延迟明显高于其他服务器的服务器，并开始新的窗口
+func (o *outliers) detect() {
+	c := o.proxy.consistent
+	hosts := c.Hosts()
+
+	o.Lock()
+	var candidates []outlierCandidate
+	for _, host := range hosts {
+		s, ok := o.hosts[host]
+		if !ok || s.requests < o.config.MinRequests || !s.ejectedUntil.IsZero() {
+			continue
+		}
+		candidates = append(candidates, outlierCandidate{
+			host:      host,
+			errorRate: float64(s.errors) / float64(s.requests),
+			latency:   float64(s.latency) / float64(s.requests),
+		})
+	}
+	var outliers []outlierCandidate
+	if len(candidates) >= o.config.MinHosts {
+		outliers = o.findOutliers(candidates)
+	}
+	abnormal := make(map[string]bool, len(outliers))
+	for _, out := range outliers {
+		abnormal[out.host] = true
+	}
+	for host, s := range o.hosts {
+		if s.ejectedUntil.IsZero() && s.ejections > 0 && s.requests > 0 && !abnormal[host] {
+			s.ejections--
+		}
+		s.requests, s.errors, s.latency = 0, 0, 0
+	}
+	o.Unlock()
+
+	sort.Slice(outliers, func(i, j int) bool { return outliers[i].score > outliers[j].score })
+
+	for _, out := range outliers {
+		o.Lock()
+		ejected := len(o.ejected)
+		o.Unlock()
+		if ejected > 0 && ejected*100 >= o.config.MaxEjectionPercent*len(hosts) {
+			o.proxy.logger.Warn("outlier not ejected, max ejection percent reached", "host", out.host, "reason", out.reason)
+			return
+		}
+		// 已经被健康检查或管理接口摘除的服务器不归异常检测管
+		if c.IsDraining(out.host) {
+			continue
+		}
+		o.eject(out)
+	}
+}
+
+func (o *outliers) findOutliers(candidates []outlierCandidate) []outlierCandidate {
+	errorRates := make([]float64, len(candidates))
+	latencies := make([]float64, len(candidates))
+	for i, cand := range candidates {
+		errorRates[i] = cand.errorRate
+		latencies[i] = cand.latency
+	}
+	errorThreshold := max(threshold(errorRates, o.config.StdevFactor), o.config.MinErrorRate)
+	latencyThreshold := max(threshold(latencies, o.config.StdevFactor), float64(o.config.MinLatency))
+
+	var outliers []outlierCandidate
+	for _, cand := range candidates {
+		switch {
+		case cand.errorRate > errorThreshold:
+			cand.reason = "error_rate"
+			cand.score = cand.errorRate / errorThreshold
+		case o.config.MinLatency > 0 && cand.latency > latencyThreshold:
+			cand.reason = "latency"
+			cand.score = cand.latency / latencyThreshold
+		default:
+			continue
+		}
+		outliers = append(outliers, cand)
+	}
+	return outliers
+}
+
+// threshold 平均值加factor倍标准差
+func threshold(values []float64, factor float64) float64 {
+	var sum, sqSum float64
+	for _, v := range values {
+		sum += v
+		sqSum += v * v
+	}
+	n := float64(len(values))
+	mean := sum / n
+	return mean + factor*math.Sqrt(math.Max(sqSum/n-mean*mean, 0))
+}
+
+func (o *outliers) eject(out outlierCandidate) {
+	o.Lock()
+	s := o.get(out.host)
+	s.ejections++
+	s.ejectedUntil = time.Now().Add(time.Duration(s.ejections) * o.config.BaseEjectionTime)
+	e := &OutlierHost{Host: out.host, Reason: out.reason, Ejections: s.ejections, EjectedUntil: s.ejectedUntil}
+	o.ejected[out.host] = e
+	o.Unlock()
+
+	o.proxy.logger.Warn("outlier host ejected, draining",
+		"host", out.host, "reason", out.reason, "error_rate", out.errorRate,
+		"latency", time.Duration(out.latency), "until", e.EjectedUntil)
+	_ = o.proxy.consistent.DrainHostBy(out.host, drainSourceOutlier)
+}
+
+// Outliers 返回被异常检测摘除的服务器，未开启异常检测时为空
+func (p *Proxy) Outliers() []OutlierHost {
+	hosts := make([]OutlierHost, 0)
+	if p.outliers == nil {
+		return hosts
+	}
+
+	p.outliers.Lock()
+	defer p.outliers.Unlock()
+	for _, e := range p.outliers.ejected {
+		hosts = append(hosts, *e)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
+
+func (p *Proxy) handleOutliers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, p.Outliers())
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// ejectNow 以异常检测的名义摘除host，并让摘除立即到期
+func ejectNow(o *outliers, host string) {
+	o.eject(outlierCandidate{host: host, reason: "error_rate"})
+	o.Lock()
+	o.ejected[host].EjectedUntil = time.Now().Add(-time.Second)
+	o.Unlock()
+}
+
+func TestOutlierReinstateKeepsOperatorDrain(t *testing.T) {
+	p := newTestProxy(t, []string{"a:80", "b:80", "c:80"})
+	config := DefaultOutlierConfig()
+	config.RampUp = 0
+	o := newOutliers(p, config)
+
+	ejectNow(o, "a:80")
+	if err := p.consistent.DrainHost("a:80"); err != nil {
+		t.Fatal(err)
+	}
+	o.reinstate()
+
+	if !p.consistent.IsDraining("a:80") {
+		t.Fatal("host drained by operator was undrained by outlier detection")
+	}
+	if p.consistent.IsDrainedBy("a:80", drainSourceOutlier) {
+		t.Fatal("outlier detection did not undo its own drain")
+	}
+}
+
+func TestOutlierReinstateKeepsHealthDrain(t *testing.T) {
+	p := newTestProxy(t, []string{"a:80", "b:80", "c:80"})
+	config := DefaultOutlierConfig()
+	config.RampUp = 0
+	o := newOutliers(p, config)
+	h := newTestHealthChecker(p)
+
+	ejectNow(o, "a:80")
+	h.report("a:80", false)
+	o.reinstate()
+	if !p.consistent.IsDraining("a:80") {
+		t.Fatal("host drained by health check was undrained by outlier detection")
+	}
+
+	h.report("a:80", true)
+	if p.consistent.IsDraining("a:80") {
+		t.Fatal("host is still drained after both sources recovered")
+	}
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// PeerConfig 多个代理实例之间同步拓扑：本实例上的注册、注销和续期会广播给其他实例
+// 各实例按收到的顺序应用，不保证强一致，并发修改同一台服务器时结果可能不同
+type PeerConfig struct {
+	// 其他代理管理接口的地址，如 http://proxy-2:18890
+	Peers []string
+	// 调用其他代理管理接口使用的bearer token
+	Token  string
+	Client *http.Client
+	// 发送失败后的重试次数与间隔
+	Retries    int
+	RetryDelay time.Duration
+}
+
+func DefaultPeerConfig() PeerConfig {
+	return PeerConfig{
+		Client:     &http.Client{Timeout: 5 * time.Second},
+		Retries:    3,
+		RetryDelay: time.Second,
+	}
+}
+
+const peerQueueSize = 256
+
+// peers 每个对等实例一个发送队列，保证同一实例收到的变更与本地发生的顺序一致
+type peers struct {
+	config PeerConfig
+	queues map[string]chan Change
+	logger Logger
+}
+
+func newPeers(config PeerConfig) *peers {
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	ps := &peers{
+		config: config,
+		queues: make(map[string]chan Change, len(config.Peers)),
+	}
+	for _, peer := range config.Peers {
+		ps.queues[strings.TrimSuffix(peer, "/")] = make(chan Change, peerQueueSize)
+	}
+	return ps
+}
+
+func (ps *peers) run(stop <-chan struct{}) {
+	for peer, queue := range ps.queues {
+		go ps.send(peer, queue, stop)
+	}
+}
+
+// broadcast 不阻塞调用方，队列已满时丢弃该变更
+func (ps *peers) broadcast(c Change) {
+	if ps == nil {
+		return
+	}
+	for peer, queue := range ps.queues {
+		select {
+		case queue <- c:
+		default:
+			ps.logger.Warn("peer queue full, dropping topology change", "peer", peer, "op", c.Op, "host", c.Host)
+		}
+	}
+}
+
+func (ps *peers) send(peer string, queue <-chan Change, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case c := <-queue:
+			var err error
+			for i := 0; i <= ps.config.Retries; i++ {
+				if err = ps.post(peer, c); err == nil {
+					break
+				}
+				select {
+				case <-time.After(ps.config.RetryDelay):
+				case <-stop:
+					return
+				}
+			}
+			if err != nil {
+				ps.logger.Error("sync topology to peer failed", "peer", peer, "op", c.Op, "host", c.Host, "error", err)
+			}
+		}
+	}
+}
+
+func (ps *peers) post(peer string, c Change) error {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, peer+"/v1/peers/sync", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ps.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+ps.config.Token)
+	}
+
+	resp, err := ps.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+	return nil
+}
+
+// fetchHosts 从任意一个可用的对等实例取得当前的服务器列表
+func (ps *peers) fetchHosts() ([]hostResponse, error) {
+	err := errors.New("no peers")
+	for peer := range ps.queues {
+		var hosts []hostResponse
+		if hosts, err = ps.get(peer); err == nil {
+			return hosts, nil
+		}
+		ps.logger.Warn("fetch hosts from peer failed", "peer", peer, "error", err)
+	}
+	return nil, err
+}
+
+func (ps *peers) get(peer string) ([]hostResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, peer+"/v1/hosts", nil)
+	if err != nil {
+		return nil, err
+	}
+	if ps.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+ps.config.Token)
+	}
+
+	resp, err := ps.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer returned %s", resp.Status)
+	}
+
+	var hosts []hostResponse
+	err = json.NewDecoder(resp.Body).Decode(&hosts)
+	return hosts, err
+}
+
+// SyncFromPeers 新启动的实例从对等实例拉取当前的服务器列表，只添加本地没有的服务器
+func (p *Proxy) SyncFromPeers() error {
+	if p.peers == nil {
+		return nil
+	}
+
+	hosts, err := p.peers.fetchHosts()
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		err = p.registerHost(h.Host, 1, h.Meta, 0)
+		if errors.Is(err, core.ErrHostAlreadyExists) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if h.Weight != 1 || h.Draining {
+			weight, draining := h.Weight, h.Draining
+			if err = p.updateHost(h.Host, core.HostUpdate{Weight: &weight, Draining: &draining}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 应用其他实例广播来的变更，不再继续广播；重复的变更直接忽略
+func (p *Proxy) applyPeerChange(c Change) error {
+	err := p.ApplyChange(c)
+	if errors.Is(err, core.ErrHostAlreadyExists) || errors.Is(err, core.ErrHostNotFound) || errors.Is(err, core.ErrNoTTL) {
+		return nil
+	}
+	return err
+}
+
+func (p *Proxy) handlePeerSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var c Change
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+	if c.Host == "" {
+		writeError(w, http.StatusBadRequest, "missing_param", "missing host")
+		return
+	}
+	if err := p.applyPeerChange(c); err != nil {
+		writeCoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
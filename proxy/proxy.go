@@ -1,88 +1,483 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/http/httputil"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/dingqing/consistent-hash/core"
 )
 
 type Proxy struct {
 	consistent *core.Consistent
+	forwarder  *httputil.ReverseProxy
+	transports *hostTransports
+	retry      RetryPolicy
+	// 为nil时不熔断
+	breakers    *breakers
+	middlewares []Middleware
+	metrics     Metrics
+	logger      Logger
+	tracer      trace.Tracer
+	// 为nil时不做健康检查
+	healthConfig *HealthCheckConfig
+	health       *healthChecker
+	// 为nil时不做异常检测
+	outlierConfig *OutlierConfig
+	outliers      *outliers
+	// 为nil时不按连接失败驱逐服务器
+	deadHostConfig *DeadHostConfig
+	deadHosts      *deadHosts
+	// 为nil时不与其他实例同步拓扑
+	peers *peers
+	// 为nil时拓扑的写操作直接应用到本实例
+	replicator Replicator
+	// 为nil时不缓存响应
+	cache *responseCache
+	// 为nil时不合并并发请求
+	flights *flightGroup
+	// 为nil时不限流
+	limiter *rateLimiter
+	keys    RoutingKeyExtractor
+	// ModeLeastLoaded的候选数量
+	choices    int
+	bodyLimits BodyLimits
+	// 请求的默认超时，0表示不限制
+	timeout  time.Duration
+	inflight *inflight
+	chaos    *chaos
+	// 就绪检查时确认是否过期的拓扑同步来源
+	syncSources *syncSources
+	hotKeys     *hotKeys
+	mirror      atomic.Pointer[MirrorConfig]
+	// 为nil时不分流到green环
+	blueGreen atomic.Pointer[blueGreen]
+	// 槽位的分配随拓扑变化更新，slotsMu保证更新和写文件的顺序
+	slots     atomic.Pointer[core.Slots]
+	slotsMu   sync.Mutex
+	slotsPath string
+	stop      chan struct{}
+	// 拓扑快照文件，为空时不持久化
+	snapshotPath string
+	wal          *wal
+}
+
+// Mode 选择服务器的方式
+type Mode int
+
+const (
+	// ModeHash 普通一致性哈希
+	ModeHash Mode = iota
+	// ModeCapacious 考虑服务器容量的一致性哈希
+	ModeCapacious
+	// ModeLeastLoaded 在key之后最近的几台服务器中选负载最低的（有界选择），候选数量通过WithChoices设置
+	ModeLeastLoaded
+	// ModeTwoChoices 不按key，随机取两台服务器选负载较低的，用于无状态的请求；路由key可以为空
+	ModeTwoChoices
+	// ModeSlots 按key所在的槽位（共16384个，同Redis Cluster）选择服务器，槽位可以通过管理接口手动迁移
+	ModeSlots
+)
+
+// DefaultChoices ModeLeastLoaded默认的候选数量
+const DefaultChoices = 2
+
+func (m Mode) String() string {
+	switch m {
+	case ModeHash:
+		return "hash"
+	case ModeCapacious:
+		return "capacious"
+	case ModeLeastLoaded:
+		return "least-loaded"
+	case ModeTwoChoices:
+		return "two-choices"
+	case ModeSlots:
+		return "slots"
+	}
+	return "unknown"
+}
+
+// ParseMode 解析hash、capacious、least-loaded、two-choices或slots，空字符串为hash
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "", "hash":
+		return ModeHash, nil
+	case "capacious":
+		return ModeCapacious, nil
+	case "least-loaded":
+		return ModeLeastLoaded, nil
+	case "two-choices":
+		return ModeTwoChoices, nil
+	case "slots":
+		return ModeSlots, nil
+	}
+	return 0, fmt.Errorf("unknown mode %q, must be hash, capacious, least-loaded, two-choices or slots", s)
 }
 
-func New(consistent *core.Consistent) *Proxy {
+func New(consistent *core.Consistent, opts ...Option) *Proxy {
 	proxy := &Proxy{
+		consistent:  consistent,
+		transports:  newHostTransports(DefaultTransportConfig()),
+		inflight:    newInflight(),
+		chaos:       newChaos(),
+		syncSources: newSyncSources(),
+		stop:        make(chan struct{}),
+		metrics:     nopMetrics{},
+		logger:      defaultLogger(),
+		keys:        QueryKey("key"),
+		choices:     DefaultChoices,
+	}
+	for _, opt := range opts {
+		opt(proxy)
+	}
+	if proxy.tracer == nil {
+		proxy.tracer = otel.GetTracerProvider().Tracer(tracerName)
+	}
+	if proxy.breakers != nil {
+		proxy.breakers.logger = proxy.logger
+	}
+	if proxy.outlierConfig != nil {
+		proxy.outliers = newOutliers(proxy, *proxy.outlierConfig)
+	}
+	if proxy.deadHostConfig != nil {
+		proxy.deadHosts = newDeadHosts(proxy, *proxy.deadHostConfig)
+	}
+	proxy.forwarder = newForwarder(&retryTransport{
+		next:       &chaosTransport{next: proxy.transports, chaos: proxy.chaos},
 		consistent: consistent,
+		inflight:   proxy.inflight,
+		breakers:   proxy.breakers,
+		outliers:   proxy.outliers,
+		deadHosts:  proxy.deadHosts,
+		metrics:    proxy.metrics,
+		logger:     proxy.logger,
+		tracer:     proxy.tracer,
+	}, proxy.logger, proxy.bodyLimits)
+
+	consistent.OnExpire(proxy.expireHost)
+	events := consistent.Subscribe()
+	proxy.slots.Store(newSlots(consistent.Hosts()))
+	go proxy.watchTopology(events)
+	go proxy.runChaos()
+	if proxy.cache != nil {
+		proxy.cache.metrics = proxy.metrics
+	}
+	if proxy.hotKeys != nil {
+		proxy.hotKeys.logger = proxy.logger
+	}
+	if proxy.limiter != nil {
+		proxy.limiter.metrics = proxy.metrics
+		go proxy.limiter.run(proxy.stop)
+	}
+	if proxy.peers != nil {
+		proxy.peers.logger = proxy.logger
+		proxy.peers.run(proxy.stop)
+	}
+	if proxy.healthConfig != nil {
+		proxy.health = newHealthChecker(proxy, *proxy.healthConfig)
+		go proxy.health.run(proxy.stop)
+	}
+	if proxy.outliers != nil {
+		go proxy.outliers.run(proxy.stop)
 	}
 	return proxy
 }
 
-func (p *Proxy) GetHost(key string) (string, error) {
-
-	host, err := p.consistent.GetHost(key)
-	if err != nil {
-		return "", err
+// Close 停止后台的健康检查等任务，启用预写日志时最后写一次快照
+func (p *Proxy) Close() {
+	close(p.stop)
+	if p.wal != nil {
+		p.compact()
+		_ = p.wal.file.Close()
 	}
+}
 
-	resp, err := http.Get(fmt.Sprintf("http://%s?key=%s", host, key))
-	if err != nil {
-		return "", err
+// 服务器下线（包括TTL过期）后清理与之相关的状态
+func (p *Proxy) watchTopology(events <-chan core.TopologyEvent) {
+	for ev := range events {
+		switch ev.Type {
+		case core.HostAdded:
+			p.deadHosts.added(ev.Host)
+			p.syncSlots()
+		case core.HostRemoved:
+			p.syncSlots()
+			p.transports.remove(ev.Host)
+			p.inflight.remove(ev.Host)
+			p.breakers.remove(ev.Host)
+			p.outliers.remove(ev.Host)
+			p.deadHosts.remove(ev.Host)
+		}
 	}
-	defer resp.Body.Close()
+}
 
-	body, _ := ioutil.ReadAll(resp.Body)
+// Handler 取出路由key（默认为查询参数key，可通过WithRoutingKey更改），按mode选出服务器后将请求原样转发过去
+// 通过WithMiddleware注册的中间件包在最外层；经过路由表的请求使用路由规则中的路由key和重试策略
+func (p *Proxy) Handler(mode Mode) http.Handler {
+	return Chain(p.handler(mode), p.middlewares...)
+}
 
-	fmt.Printf("Response from host %s: %s\n", host, string(body))
+func (p *Proxy) handler(mode Mode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw, r, span := p.startRequestSpan(w, r, mode)
+		defer endRequestSpan(span, sw)
+		w = sw
+		if !p.limitRequestBody(w, r) {
+			return
+		}
 
-	return string(body), nil
-}
+		keys, policy := p.keys, p.retry
+		if o := routeOverrideFrom(r.Context()); o != nil {
+			if o.keys != nil {
+				keys = o.keys
+			}
+			if o.retry != nil {
+				policy = *o.retry
+			}
+		}
 
-func (p *Proxy) GetHostCapacious(key string) (string, error) {
+		key, err := keys.RoutingKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// two-choices模式不按key选择服务器，没有路由key的请求不缓存、不合并，也不按key限流
+		if key == "" && mode != ModeTwoChoices {
+			http.Error(w, "missing key", http.StatusBadRequest)
+			return
+		}
+		keyed := key != ""
+		// 分到green的请求完全由green环处理，包括它的限流、缓存和重试；没有路由key的请求留在当前环
+		if green := p.greenFor(key); keyed && green != nil {
+			green.handler(mode).ServeHTTP(w, r)
+			return
+		}
+		if p.limiter != nil && !p.limiter.allow(w, r, key) {
+			return
+		}
 
-	host, err := p.consistent.GetHostCapacious(key)
-	if err != nil {
-		return "", err
-	}
-	p.consistent.Inc(host)
+		// WebSocket等升级的连接不缓存也不合并
+		upgrade := isUpgrade(r)
+		r, cancel := p.withTimeout(r, upgrade)
+		defer cancel()
+		if p.cache != nil && keyed && !upgrade {
+			var finish func()
+			var hit bool
+			if w, finish, hit = p.cache.lookup(w, r, key); hit {
+				if info := requestInfoFrom(r.Context()); info != nil {
+					info.key = key
+				}
+				return
+			}
+			defer finish()
+		}
+		if p.flights != nil && keyed && !upgrade {
+			var finish func()
+			var served bool
+			if w, finish, served = p.flights.do(w, r, key); served {
+				return
+			}
+			defer finish()
+		}
+
+		host, err := p.tracePick(r.Context(), key, mode)
+		// 有界负载模式本身会把超载的请求分散出去，只在普通模式下分散热点key的读请求
+		if err == nil && p.hotKeys != nil && mode == ModeHash && isRead(r) && p.hotKeys.observe(key) {
+			host = p.spreadHotKey(r, key, host)
+		}
+		p.metrics.ObserveRoute(host, err)
+		if info := requestInfoFrom(r.Context()); info != nil {
+			info.key, info.hash, info.host = key, p.consistent.HashKey(key), host
+		}
+		if err != nil {
+			http.Error(w, err.Error(), errStatus(err))
+			return
+		}
 
-	time.AfterFunc(time.Second*10, func() { // drop the host after 10 seconds(for testing)!
-		fmt.Printf("dropping host: %s after 10 second\n", host)
-		p.consistent.Done(host)
+		if !upgrade && !IsGRPC(r) {
+			r = p.mirrorRequest(r, key, host)
+		}
+		p.forward(w, r, &route{
+			key:   key,
+			hash:  p.consistent.HashKey(key),
+			hosts: p.failoverHosts(key, host, policy),
+			retry: policy,
+			// 升级后的长连接占用后端资源，各种模式下都计入负载
+			charge: mode != ModeHash || upgrade,
+		})
 	})
+}
+
+// pick 只选择服务器，不增加负载
+func (p *Proxy) pick(ctx context.Context, key string, mode Mode) (string, error) {
+	switch mode {
+	case ModeCapacious:
+		return p.consistent.GetHostCapaciousCtx(ctx, key)
+	case ModeLeastLoaded:
+		return p.consistent.GetHostLeastLoaded(key, p.choices)
+	case ModeTwoChoices:
+		return p.consistent.GetHostTwoChoices()
+	case ModeSlots:
+		return p.pickSlot(ctx, key)
+	}
+	return p.pickHash(ctx, key)
+}
 
-	resp, err := http.Get(fmt.Sprintf("http://%s?key=%s", host, key))
+// 被摘除的服务器（如健康检查失败）不再接收请求，沿环选择下一台
+func (p *Proxy) pickHash(ctx context.Context, key string) (string, error) {
+	host, err := p.consistent.GetHostCtx(ctx, key)
+	if err != nil || !p.consistent.IsDraining(host) {
+		return host, err
+	}
+
+	hosts, err := p.consistent.GetHosts(key, p.consistent.Size())
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
+	for _, h := range hosts {
+		if !p.consistent.IsDraining(h) {
+			return h, nil
+		}
+	}
+	return "", core.ErrAllHostsOverloaded
+}
 
-	body, _ := ioutil.ReadAll(resp.Body)
+func (p *Proxy) RegisterHost(host string) error {
+	return p.RegisterHostWithMeta(host, core.Metadata{})
+}
+
+func (p *Proxy) RegisterHostWithMeta(host string, meta core.Metadata) error {
+	return p.RegisterHostTTL(host, meta, 0)
+}
 
-	fmt.Printf("Response from host %s: %s\n", host, string(body))
+func (p *Proxy) UnregisterHost(host string) error {
+	return p.commit(Change{Op: ChangeUnregister, Host: host})
+}
 
-	return string(body), nil
+// RegisterHostTTL 注册服务器，超过ttl没有续期时自动移除，ttl为0时永久有效
+func (p *Proxy) RegisterHostTTL(host string, meta core.Metadata, ttl time.Duration) error {
+	return p.RegisterHostWeighted(host, 1, meta, ttl)
 }
 
-func (p *Proxy) RegisterHost(host string) error {
+// RegisterHostWeighted 以指定的权重注册服务器，容量上限和标签在meta中
+func (p *Proxy) RegisterHostWeighted(host string, weight int, meta core.Metadata, ttl time.Duration) error {
+	switch {
+	case ttl < 0:
+		return core.ErrInvalidTTL
+	case weight <= 0:
+		return core.ErrInvalidWeight
+	case meta.Capacity < 0:
+		return core.ErrInvalidCapacity
+	}
+	return p.commit(Change{Op: ChangeRegister, Host: host, Meta: meta, TTL: ttl, Weight: weight})
+}
+
+func (p *Proxy) Renew(host string) error {
+	return p.commit(Change{Op: ChangeRenew, Host: host})
+}
+
+// UpdateHost 修改服务器的权重、容量上限和摘除状态，与注册一样同步给其他实例
+func (p *Proxy) UpdateHost(host string, u core.HostUpdate) error {
+	return p.commit(Change{Op: ChangeUpdate, Host: host, Update: &u})
+}
 
-	err := p.consistent.RegisterHost(host)
+// registerHost和unregisterHost只修改本实例的环
+func (p *Proxy) registerHost(host string, weight int, meta core.Metadata, ttl time.Duration) error {
+	err := p.consistent.RegisterHostWithMeta(host, weight, meta)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(fmt.Sprintf("register host: %s success", host))
-	return nil
+	p.logger.Info("host registered", "host", host)
+	// 先设置有效期，快照中才有
+	err = p.consistent.SetHostTTL(host, ttl)
+	p.persist(Change{Op: ChangeRegister, Host: host, Meta: meta, TTL: ttl, Weight: weight})
+	return err
 }
 
-func (p *Proxy) UnregisterHost(host string) error {
+// expireHost 有效期到期的服务器与管理接口的注销一样写入预写日志、同步给其他实例；其他实例可能已经先注销了它
+func (p *Proxy) expireHost(host string) error {
+	err := p.UnregisterHost(host)
+	if errors.Is(err, core.ErrHostNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (p *Proxy) unregisterHost(host string) error {
 	err := p.consistent.UnregisterHost(host)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(fmt.Sprintf("unregister host: %s success", host))
+	p.logger.Info("host unregistered", "host", host)
+	p.persist(Change{Op: ChangeUnregister, Host: host})
+	return nil
+}
+
+func (p *Proxy) updateHost(host string, u core.HostUpdate) error {
+	if err := p.consistent.UpdateHost(host, u); err != nil {
+		return err
+	}
+
+	p.logger.Info("host updated", "host", host)
+	p.persist(Change{Op: ChangeUpdate, Host: host, Update: &u})
 	return nil
 }
+
+// DescribeRing 返回环的JSON描述
+func (p *Proxy) DescribeRing() ([]byte, error) {
+	return p.consistent.Describe()
+}
+
+// EnableSnapshot 每次拓扑变化后将环的状态写入path
+func (p *Proxy) EnableSnapshot(path string) {
+	p.snapshotPath = path
+}
+
+// persist 启用预写日志时追加变更，否则重写整个快照
+func (p *Proxy) persist(c Change) {
+	if p.wal != nil {
+		if err := p.wal.append(c); err != nil {
+			p.logger.Error("append wal failed", "path", p.wal.path, "error", err)
+		}
+		return
+	}
+	if err := p.writeSnapshot(); err != nil {
+		p.logger.Error("write snapshot failed", "path", p.snapshotPath, "error", err)
+	}
+}
+
+func (p *Proxy) writeSnapshot() error {
+	if p.snapshotPath == "" {
+		return nil
+	}
+
+	data, err := p.consistent.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，避免写到一半时进程退出导致快照损坏
+	tmp := p.snapshotPath + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.snapshotPath)
+}
+
+// 环为空或所有服务器都超载时返回503，让调用方快速失败
+func errStatus(err error) int {
+	if errors.Is(err, core.ErrNoHosts) || errors.Is(err, core.ErrAllHostsOverloaded) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limit 令牌桶参数，每秒生成Rate个令牌，最多积攒Burst个；Rate不大于0时不限流
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitConfig 按客户端IP和路由key分别限流，任一超出都返回429
+type RateLimitConfig struct {
+	PerClient Limit
+	PerKey    Limit
+	// 按X-Forwarded-For中的第一个地址识别客户端，只应在代理前还有可信的负载均衡时开启
+	TrustForwardedFor bool
+	// 每隔该时间清理已经攒满（与新建的桶没有区别）或空闲超过该时间的令牌桶，下次请求时重新以满桶开始
+	IdleTimeout time.Duration
+	// 客户端和key各自最多保留的令牌桶数量，超出时淘汰最久未使用的，避免大量不同的key占满内存
+	MaxBuckets int
+}
+
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		PerClient:   Limit{Rate: 100, Burst: 200},
+		IdleTimeout: 10 * time.Minute,
+		MaxBuckets:  100000,
+	}
+}
+
+// 限流的维度，用于指标
+const (
+	RateLimitClient = "client"
+	RateLimitKey    = "key"
+)
+
+type rateLimiter struct {
+	config  RateLimitConfig
+	clients *bucketSet
+	keys    *bucketSet
+	metrics Metrics
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	defaults := DefaultRateLimitConfig()
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaults.IdleTimeout
+	}
+	if config.MaxBuckets <= 0 {
+		config.MaxBuckets = defaults.MaxBuckets
+	}
+	return &rateLimiter{
+		config:  config,
+		clients: newBucketSet(config.PerClient, config.MaxBuckets),
+		keys:    newBucketSet(config.PerKey, config.MaxBuckets),
+		metrics: nopMetrics{},
+	}
+}
+
+// allow 未超出限制时返回true，否则写出429
+func (l *rateLimiter) allow(w http.ResponseWriter, r *http.Request, key string) bool {
+	scope, limit := "", Limit{}
+	switch {
+	case !l.clients.take(clientIP(r, l.config.TrustForwardedFor)):
+		scope, limit = RateLimitClient, l.config.PerClient
+	case key != "" && !l.keys.take(key):
+		scope, limit = RateLimitKey, l.config.PerKey
+	default:
+		return true
+	}
+
+	l.metrics.ObserveRateLimited(scope)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/limit.Rate))))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+	return false
+}
+
+func (l *rateLimiter) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(l.config.IdleTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			l.clients.sweep(l.config.IdleTimeout)
+			l.keys.sweep(l.config.IdleTimeout)
+		}
+	}
+}
+
+// bucketSet 每个客户端或key一个令牌桶，按最近使用的顺序排列，超过max时淘汰最久未使用的
+type bucketSet struct {
+	limit   Limit
+	max     int
+	buckets map[string]*list.Element
+	// 最近使用的在前，元素为*idBucket
+	lru *list.List
+	sync.Mutex
+}
+
+type idBucket struct {
+	id string
+	*tokenBucket
+}
+
+func newBucketSet(limit Limit, max int) *bucketSet {
+	return &bucketSet{limit: limit, max: max, buckets: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (s *bucketSet) take(id string) bool {
+	if s.limit.Rate <= 0 {
+		return true
+	}
+
+	s.Lock()
+	e, ok := s.buckets[id]
+	if ok {
+		s.lru.MoveToFront(e)
+	} else {
+		if s.lru.Len() >= s.max {
+			s.remove(s.lru.Back())
+		}
+		e = s.lru.PushFront(&idBucket{id: id, tokenBucket: newTokenBucket(s.limit.Rate, s.limit.Burst)})
+		s.buckets[id] = e
+	}
+	b := e.Value.(*idBucket)
+	s.Unlock()
+	return b.take()
+}
+
+// sweep 清理已经攒满或空闲超过idle的令牌桶
+func (s *bucketSet) sweep(idle time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	for e := s.lru.Back(); e != nil; {
+		prev := e.Prev()
+		if b := e.Value.(*idBucket); b.idle(now, idle) {
+			s.remove(e)
+		}
+		e = prev
+	}
+}
+
+func (s *bucketSet) size() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.lru.Len()
+}
+
+// 需要持有锁
+func (s *bucketSet) remove(e *list.Element) {
+	s.lru.Remove(e)
+	delete(s.buckets, e.Value.(*idBucket).id)
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBucketCountStaysBounded(t *testing.T) {
+	s := newBucketSet(Limit{Rate: 1, Burst: 1}, 100)
+	for i := 0; i < 10000; i++ {
+		s.take(strconv.Itoa(i))
+	}
+	if n := s.size(); n != 100 {
+		t.Fatalf("bucket count = %d, want 100", n)
+	}
+
+	// 最近使用的key保留了它的令牌桶，仍然受限
+	if s.take("9999") {
+		t.Fatal("recently used key got a fresh bucket")
+	}
+	// 被淘汰的key重新以满桶开始
+	if !s.take("0") {
+		t.Fatal("evicted key was not given a fresh bucket")
+	}
+}
+
+func TestSweepRemovesFullBuckets(t *testing.T) {
+	// 100ms攒满一个令牌
+	s := newBucketSet(Limit{Rate: 10, Burst: 1}, 100)
+	s.take("refilled")
+	time.Sleep(150 * time.Millisecond)
+	s.take("empty")
+
+	s.sweep(time.Hour)
+	if n := s.size(); n != 1 {
+		t.Fatalf("bucket count after sweep = %d, want 1", n)
+	}
+	if s.take("empty") {
+		t.Fatal("bucket still refilling was removed by sweep")
+	}
+}
+
+func TestSweepRemovesIdleBuckets(t *testing.T) {
+	s := newBucketSet(Limit{Rate: 0.001, Burst: 10}, 100)
+	for i := 0; i < 10; i++ {
+		s.take(strconv.Itoa(i))
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	s.sweep(time.Millisecond)
+	if n := s.size(); n != 0 {
+		t.Fatalf("bucket count after sweep = %d, want 0", n)
+	}
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SyncSource 拓扑的同步来源，如Consul、DNS服务发现
+type SyncSource interface {
+	// LastSync 最近一次成功同步的时间，还没有同步成功过时为零值
+	LastSync() time.Time
+	// StaleAfter 超过该时长没有同步成功时认为环上的拓扑已经过期
+	StaleAfter() time.Duration
+}
+
+// syncSources 按名称记录的同步来源，就绪检查时逐个确认是否过期
+type syncSources struct {
+	sources map[string]SyncSource
+	sync.Mutex
+}
+
+func newSyncSources() *syncSources {
+	return &syncSources{sources: make(map[string]SyncSource)}
+}
+
+// AddSyncSource 登记拓扑的同步来源，它过期（包括还没有同步成功过）时/readyz返回503；同名的来源会被替换
+func (p *Proxy) AddSyncSource(name string, source SyncSource) {
+	p.syncSources.Lock()
+	defer p.syncSources.Unlock()
+	p.syncSources.sources[name] = source
+}
+
+type readiness struct {
+	Ready bool `json:"ready"`
+	// 已注册且未被摘除（健康检查、异常检测、手动摘除）的服务器数量
+	HealthyHosts int          `json:"healthy_hosts"`
+	Hosts        int          `json:"hosts"`
+	Sync         []syncStatus `json:"sync,omitempty"`
+	// 未就绪的原因
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+type syncStatus struct {
+	Source   string     `json:"source"`
+	LastSync *time.Time `json:"last_sync,omitempty"`
+	Stale    bool       `json:"stale"`
+}
+
+func (p *Proxy) readiness() readiness {
+	r := readiness{Ready: true}
+	for _, host := range p.consistent.Hosts() {
+		r.Hosts++
+		if !p.consistent.IsDraining(host) {
+			r.HealthyHosts++
+		}
+	}
+	if r.HealthyHosts == 0 {
+		r.Reasons = append(r.Reasons, "no healthy hosts")
+	}
+
+	p.syncSources.Lock()
+	names := make([]string, 0, len(p.syncSources.sources))
+	for name := range p.syncSources.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source := p.syncSources.sources[name]
+		status := syncStatus{Source: name}
+		last := source.LastSync()
+		if last.IsZero() {
+			status.Stale = true
+			r.Reasons = append(r.Reasons, name+": not synced yet")
+		} else {
+			status.LastSync = &last
+			if age := time.Since(last); age > source.StaleAfter() {
+				status.Stale = true
+				r.Reasons = append(r.Reasons, name+": last synced "+age.Round(time.Second).String()+" ago")
+			}
+		}
+		r.Sync = append(r.Sync, status)
+	}
+	p.syncSources.Unlock()
+
+	r.Ready = len(r.Reasons) == 0
+	return r
+}
+
+// Probes 返回供Kubernetes等使用的探针：
+//
+//	GET    /healthz                进程存活即返回200
+//	GET    /readyz                 环上没有健康的服务器，或拓扑的同步来源过期时返回503，避免把流量发给环为空的代理
+func (p *Proxy) Probes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", p.handleHealthz)
+	mux.HandleFunc("/readyz", p.handleReadyz)
+	return mux
+}
+
+func (p *Proxy) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (p *Proxy) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	ready := p.readiness()
+	status := http.StatusOK
+	if !ready.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, ready)
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+type ChangeOp string
+
+const (
+	ChangeRegister   ChangeOp = "register"
+	ChangeUnregister ChangeOp = "unregister"
+	ChangeRenew      ChangeOp = "renew"
+	ChangeUpdate     ChangeOp = "update"
+	// 把一段槽位迁移到Host，只影响slots模式
+	ChangeAssignSlots ChangeOp = "assign_slots"
+)
+
+// Change 一次拓扑变更
+type Change struct {
+	Op   ChangeOp      `json:"op"`
+	Host string        `json:"host"`
+	Meta core.Metadata `json:"meta"`
+	// 为0时永久有效
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Op为register时的权重，0表示1
+	Weight int `json:"weight,omitempty"`
+	// Op为update时要修改的属性
+	Update *core.HostUpdate `json:"update,omitempty"`
+	// Op为assign_slots时迁移的槽位
+	Slots *core.SlotRange `json:"slots,omitempty"`
+}
+
+// Replicator 接管拓扑的写操作：由复制层决定变更的顺序，再在每个实例上调用ApplyChange
+// Replicate应在本实例应用完变更后返回ApplyChange的结果
+type Replicator interface {
+	Replicate(c Change) error
+}
+
+// SetReplicator 之后的注册、注销和续期都交给r，不再直接修改本实例的环，也不再广播给对等实例
+// 应在开始处理请求之前调用
+func (p *Proxy) SetReplicator(r Replicator) {
+	p.replicator = r
+}
+
+// 本实例发起的变更：有复制层时交给复制层，否则在本地应用后广播给对等实例
+func (p *Proxy) commit(c Change) error {
+	if p.replicator != nil {
+		return p.replicator.Replicate(c)
+	}
+	if err := p.ApplyChange(c); err != nil {
+		return err
+	}
+	p.peers.broadcast(c)
+	return nil
+}
+
+// ApplyChange 在本实例的环上应用一次变更
+func (p *Proxy) ApplyChange(c Change) error {
+	switch c.Op {
+	case ChangeRegister:
+		return p.registerHost(c.Host, max(c.Weight, 1), c.Meta, c.TTL)
+	case ChangeUnregister:
+		return p.unregisterHost(c.Host)
+	case ChangeRenew:
+		return p.consistent.Renew(c.Host)
+	case ChangeUpdate:
+		if c.Update == nil {
+			return fmt.Errorf("update %s: missing fields", c.Host)
+		}
+		return p.updateHost(c.Host, *c.Update)
+	case ChangeAssignSlots:
+		if c.Slots == nil {
+			return fmt.Errorf("assign slots to %s: missing slots", c.Host)
+		}
+		return p.assignSlots(c.Slots.Start, c.Slots.End, c.Host)
+	}
+	return fmt.Errorf("unknown op %q", c.Op)
+}
+
+// Topology 以注册变更的形式返回当前所有服务器，不含有效期；权重或摘除状态不是默认值时再跟一条修改，
+// 最后是槽位的分配，按顺序应用后得到相同的环和槽位
+func (p *Proxy) Topology() []Change {
+	hosts := p.consistent.Hosts()
+	changes := make([]Change, 0, len(hosts))
+	for _, name := range hosts {
+		// 并发注销的服务器直接跳过
+		info, err := p.consistent.GetHostInfo(name)
+		if err != nil {
+			continue
+		}
+		changes = append(changes, Change{Op: ChangeRegister, Host: name, Meta: info.Meta})
+		if info.Weight != 1 || info.Draining {
+			weight, draining := info.Weight, info.Draining
+			changes = append(changes, Change{Op: ChangeUpdate, Host: name,
+				Update: &core.HostUpdate{Weight: &weight, Draining: &draining}})
+		}
+	}
+	for _, r := range p.slots.Load().Ranges() {
+		r := r
+		changes = append(changes, Change{Op: ChangeAssignSlots, Host: r.Host, Slots: &r})
+	}
+	return changes
+}
+
+// ResetTopology 让环上的服务器与changes一致：注销多余的服务器，注册缺少的服务器
+func (p *Proxy) ResetTopology(changes []Change) error {
+	desired := make(map[string]bool, len(changes))
+	for _, c := range changes {
+		desired[c.Host] = true
+	}
+	for _, host := range p.consistent.Hosts() {
+		if desired[host] {
+			continue
+		}
+		if err := p.unregisterHost(host); err != nil && !errors.Is(err, core.ErrHostNotFound) {
+			return err
+		}
+	}
+	for _, c := range changes {
+		err := p.ApplyChange(c)
+		if err != nil && !errors.Is(err, core.ErrHostAlreadyExists) {
+			return err
+		}
+	}
+	return nil
+}
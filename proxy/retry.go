@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type RetryPolicy struct {
+	// 失败后最多再尝试几台服务器，0表示不重试
+	Attempts int
+	// 每次尝试等待响应头的超时时间，0表示不限制
+	PerTryTimeout time.Duration
+	// 只重试幂等的请求方法
+	IdempotentOnly bool
+}
+
+var errPerTryTimeout = errors.New("per-try timeout exceeded")
+
+// retryTransport 连接级别的失败时沿环换下一台服务器重试，并跳过已熔断的服务器
+// 负载按每次尝试计入实际连接的服务器，失败的尝试立即释放，不会让重试后的服务器被算在最初选中的服务器上
+type retryTransport struct {
+	next       http.RoundTripper
+	consistent *core.Consistent
+	inflight   *inflight
+	breakers   *breakers
+	outliers   *outliers
+	deadHosts  *deadHosts
+	metrics    Metrics
+	logger     Logger
+	tracer     trace.Tracer
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := routeFrom(req.Context())
+	if rt == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	attempts := 1
+	if retryable(req, rt.retry) {
+		attempts += rt.retry.Attempts
+	}
+
+	var (
+		resp  *http.Response
+		err   = errCircuitOpen
+		tried int
+		first = time.Now()
+		info  = requestInfoFrom(req.Context())
+	)
+	for _, host := range rt.hosts {
+		if tried >= attempts {
+			break
+		}
+		// 跳过熔断的服务器不算作一次尝试
+		if !t.breakers.allow(host) {
+			continue
+		}
+		tried++
+
+		attempt := req.Clone(req.Context())
+		attempt.URL.Host = host
+		attempt.Host = ""
+		propagateDeadline(attempt)
+		attempt, span := startBackendSpan(t.tracer, attempt, host)
+		start := time.Now()
+		release := t.acquire(host, rt.charge)
+		resp, err = t.try(attempt, rt.retry.PerTryTimeout)
+		if err != nil {
+			release()
+		} else {
+			resp.Body = onCloseBody(resp.Body, release)
+		}
+		latency := time.Since(start)
+		t.observe(host, resp, err, latency)
+		endBackendSpan(span, resp, err)
+		success := err == nil && resp.StatusCode < http.StatusInternalServerError
+		t.breakers.record(host, success)
+		t.outliers.record(host, success, latency)
+		if req.Context().Err() == nil {
+			t.deadHosts.record(host, err)
+		}
+		if info != nil {
+			info.host, info.attempts, info.upstreamLatency = host, tried, time.Since(first)
+		}
+		if err == nil {
+			return resp, nil
+		}
+		t.logger.Warn("forward to host failed",
+			"request_id", RequestIDFrom(req.Context()), "key", rt.key, "host", host, "error", err)
+
+		// 客户端已经放弃时不再重试
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// acquire 开始转发到host，返回的函数在响应体读完或尝试失败后调用
+func (t *retryTransport) acquire(host string, charge bool) func() {
+	t.inflight.inc(host)
+	charged := charge && t.consistent.Inc(host) == nil
+	return func() {
+		t.inflight.done(host)
+		if charged {
+			t.consistent.Done(host)
+		}
+	}
+}
+
+// 请求体无法重放时不能重试
+func retryable(req *http.Request, policy RetryPolicy) bool {
+	if policy.Attempts <= 0 {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0 {
+		return false
+	}
+	if !policy.IdempotentOnly {
+		return true
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (t *retryTransport) try(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	if timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	// 超时只限制等待响应头，响应体读取完成（Close）后才释放ctx
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(timeout, cancel)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, errPerTryTimeout
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = onCloseBody(resp.Body, cancel)
+	return resp, nil
+}
+
+// onCloseBody Body关闭后执行onClose
+// 协议升级（101）后的Body是可写的连接，ReverseProxy用它双向转发，包装时需要保留Write
+func onCloseBody(body io.ReadCloser, onClose func()) io.ReadCloser {
+	if rwc, ok := body.(io.ReadWriteCloser); ok {
+		return &onCloseConn{ReadWriteCloser: rwc, onClose: onClose}
+	}
+	return &onCloseReader{ReadCloser: body, onClose: onClose}
+}
+
+type onCloseReader struct {
+	io.ReadCloser
+	onClose func()
+}
+
+func (b *onCloseReader) Close() error {
+	err := b.ReadCloser.Close()
+	b.onClose()
+	return err
+}
+
+type onCloseConn struct {
+	io.ReadWriteCloser
+	onClose func()
+}
+
+func (c *onCloseConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.onClose()
+	return err
+}
+
+// 选中的服务器之后，按环的顺序取出用于故障转移或跳过熔断的服务器；没有路由key时按随机的顺序
+func (p *Proxy) failoverHosts(key, host string, policy RetryPolicy) []string {
+	hosts := []string{host}
+	if policy.Attempts <= 0 && p.breakers == nil {
+		return hosts
+	}
+
+	var ring []string
+	if key == "" {
+		ring = p.consistent.Hosts()
+		rand.Shuffle(len(ring), func(i, j int) { ring[i], ring[j] = ring[j], ring[i] })
+	} else {
+		var err error
+		if ring, err = p.consistent.GetHosts(key, p.consistent.Size()); err != nil {
+			return hosts
+		}
+	}
+	for _, h := range ring {
+		if h == host || p.consistent.IsDraining(h) {
+			continue
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+func (t *retryTransport) observe(host string, resp *http.Response, err error, latency time.Duration) {
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	t.metrics.ObserveBackend(host, status, latency, err)
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// closedAddr 没有监听的地址，连接会被拒绝
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestRetryChargesAttemptedHost(t *testing.T) {
+	dead := closedAddr(t)
+	var p *Proxy
+	var loads map[string]int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loads = p.consistent.GetLoads()
+	}))
+	defer backend.Close()
+	alive := backend.Listener.Addr().String()
+
+	p = newTestProxy(t, []string{dead, alive}, WithRetry(RetryPolicy{Attempts: 1}))
+	key := keyPickedBy(t, p, ModeCapacious, dead)
+
+	rec := httptest.NewRecorder()
+	p.Handler(ModeCapacious).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?key="+key, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if loads[dead] != 0 || loads[alive] != 1 {
+		t.Fatalf("loads during retried request = %v, want only %s charged", loads, alive)
+	}
+	for host, load := range p.consistent.GetLoads() {
+		if load != 0 {
+			t.Fatalf("load of %s = %d after request, want 0", host, load)
+		}
+	}
+}
+
+// keyPickedBy 找到按mode选中host的key
+func keyPickedBy(t *testing.T, p *Proxy, mode Mode, host string) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i)
+		if h, err := p.pick(context.Background(), key, mode); err == nil && h == host {
+			return key
+		}
+	}
+	t.Fatalf("no key maps to %s", host)
+	return ""
+}
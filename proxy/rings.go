@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RingHeader 默认按该请求头中的环名选择环
+const RingHeader = "X-Ring"
+
+// Rings 一个进程管理多个命名的环，每个环由独立的Proxy负责，有各自的服务器、副本数和容量系数
+// 请求头中指定了环名时使用该环，否则按最长的路径前缀选择（转发时去掉前缀），都不匹配时使用默认环
+type Rings struct {
+	def    *Proxy
+	header string
+	named  map[string]*namedRing
+	// 按前缀长度从长到短排列
+	prefixes []*namedRing
+	sync.RWMutex
+}
+
+type namedRing struct {
+	name   string
+	prefix string
+	proxy  *Proxy
+}
+
+// RingInfo 环的名称、路径前缀和服务器数量
+type RingInfo struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix,omitempty"`
+	Hosts  int    `json:"hosts"`
+}
+
+// DefaultRingName 默认环的名称
+const DefaultRingName = "default"
+
+// NewRings def为默认环，header为空时使用RingHeader
+func NewRings(def *Proxy, header string) *Rings {
+	if header == "" {
+		header = RingHeader
+	}
+	return &Rings{def: def, header: header, named: make(map[string]*namedRing)}
+}
+
+// Add 添加一个命名的环，prefix为空时只能通过请求头选择，如 /cache
+func (rs *Rings) Add(name, prefix string, p *Proxy) error {
+	if name == "" || name == DefaultRingName {
+		return fmt.Errorf("invalid ring name %q", name)
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("ring %s: prefix must start with /", name)
+	}
+
+	rs.Lock()
+	defer rs.Unlock()
+	if _, ok := rs.named[name]; ok {
+		return fmt.Errorf("ring %s already exists", name)
+	}
+	for _, r := range rs.prefixes {
+		if prefix != "" && r.prefix == prefix {
+			return fmt.Errorf("ring %s: prefix %s is used by ring %s", name, prefix, r.name)
+		}
+	}
+
+	r := &namedRing{name: name, prefix: prefix, proxy: p}
+	rs.named[name] = r
+	if prefix != "" {
+		rs.prefixes = append(rs.prefixes, r)
+		sort.SliceStable(rs.prefixes, func(i, j int) bool {
+			return len(rs.prefixes[i].prefix) > len(rs.prefixes[j].prefix)
+		})
+	}
+	return nil
+}
+
+// Get 按名称取得环，名称为default时返回默认环
+func (rs *Rings) Get(name string) (*Proxy, bool) {
+	if name == DefaultRingName {
+		return rs.def, true
+	}
+	rs.RLock()
+	defer rs.RUnlock()
+	r, ok := rs.named[name]
+	if !ok {
+		return nil, false
+	}
+	return r.proxy, true
+}
+
+// List 返回所有环，默认环在最前，其余按名称排序
+func (rs *Rings) List() []RingInfo {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	infos := []RingInfo{{Name: DefaultRingName, Hosts: rs.def.consistent.Size()}}
+	names := make([]string, 0, len(rs.named))
+	for name := range rs.named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := rs.named[name]
+		infos = append(infos, RingInfo{Name: name, Prefix: r.prefix, Hosts: r.proxy.consistent.Size()})
+	}
+	return infos
+}
+
+// Close 停止所有命名环的后台任务，默认环由调用方关闭
+func (rs *Rings) Close() {
+	rs.RLock()
+	defer rs.RUnlock()
+	for _, r := range rs.named {
+		r.proxy.Close()
+	}
+}
+
+// selectRing 返回请求对应的环名和前缀，找不到请求头指定的环时ok为false
+func (rs *Rings) selectRing(r *http.Request) (name, prefix string, ok bool) {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	if name = r.Header.Get(rs.header); name != "" {
+		if _, ok = rs.named[name]; ok || name == DefaultRingName {
+			return name, "", true
+		}
+		return name, "", false
+	}
+	for _, nr := range rs.prefixes {
+		if r.URL.Path == nr.prefix || strings.HasPrefix(r.URL.Path, nr.prefix+"/") {
+			return nr.name, nr.prefix, true
+		}
+	}
+	return DefaultRingName, "", true
+}
+
+// Handler 为每个环创建handler(p)，按请求选择的环分发；按前缀选择时转发给handler的路径去掉了前缀
+func (rs *Rings) Handler(handler func(p *Proxy) http.Handler) http.Handler {
+	var mu sync.Mutex
+	handlers := make(map[string]http.Handler)
+	get := func(name string) http.Handler {
+		mu.Lock()
+		defer mu.Unlock()
+		if h, ok := handlers[name]; ok {
+			return h
+		}
+		p, _ := rs.Get(name)
+		h := handler(p)
+		handlers[name] = h
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, prefix, ok := rs.selectRing(r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "ring_not_found", fmt.Sprintf("ring %s not found", name))
+			return
+		}
+		if prefix != "" {
+			r = stripPrefix(r, prefix)
+		}
+		get(name).ServeHTTP(w, r)
+	})
+}
+
+func stripPrefix(r *http.Request, prefix string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.Path = strings.TrimPrefix(r.URL.Path, prefix)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = ""
+	r2.URL = &u
+	return r2
+}
+
+// AdminAPI GET /v1/rings列出所有环，/v1/rings/{name}/...转给该环的管理接口（路径改写为/v1/...），其余请求由默认环处理
+// /v1/bluegreen和/v1/rings/{name}/bluegreen查看（GET）、设置（PUT）和取消（DELETE）环的蓝绿部署
+func (rs *Rings) AdminAPI() http.Handler {
+	defaultAdmin := rs.def.AdminAPI()
+	admins := rs.Handler(func(p *Proxy) http.Handler { return p.AdminAPI() })
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/rings" {
+			if r.Method != http.MethodGet {
+				methodNotAllowed(w, http.MethodGet)
+				return
+			}
+			writeJSON(w, http.StatusOK, rs.List())
+			return
+		}
+		if r.URL.Path == "/v1/bluegreen" {
+			rs.handleBlueGreen(w, r, DefaultRingName)
+			return
+		}
+
+		rest, ok := strings.CutPrefix(r.URL.Path, "/v1/rings/")
+		if !ok {
+			defaultAdmin.ServeHTTP(w, r)
+			return
+		}
+		name, path, _ := strings.Cut(rest, "/")
+		if path == "bluegreen" {
+			if _, ok := rs.Get(name); !ok {
+				writeError(w, http.StatusNotFound, "ring_not_found", fmt.Sprintf("ring %s not found", name))
+				return
+			}
+			rs.handleBlueGreen(w, r, name)
+			return
+		}
+		r2 := stripPrefix(r, "/v1/rings/"+name)
+		r2.URL.Path = "/v1/" + path
+		r2.Header = r.Header.Clone()
+		r2.Header.Set(rs.header, name)
+		admins.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,421 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route 路由表中的一条规则，也是管理接口/v1/routes的JSON格式
+type Route struct {
+	Name string `json:"name"`
+	// 路径模式，以/结尾时匹配该前缀下的所有路径，否则精确匹配；*匹配任意一个路径段，如 /users/*/orders/
+	Pattern string `json:"pattern"`
+	// 环名，为空时使用默认环
+	Ring string `json:"ring,omitempty"`
+	// hash、capacious、least-loaded、two-choices或slots，为空时为hash
+	Mode string `json:"mode,omitempty"`
+	// 格式同ParseRoutingKey，为空时使用环的路由key
+	RoutingKey string `json:"routing_key,omitempty"`
+	// 转发时去掉路径中与模式匹配的部分，路由key仍按原始路径取出
+	StripPrefix bool `json:"strip_prefix,omitempty"`
+	// 为nil时使用环的重试策略
+	Retry *RouteRetry `json:"retry,omitempty"`
+	// 整个请求（包括响应体）的超时时间，如 5s，为空时使用环的默认超时；客户端给出的超时更短时使用客户端的
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// RouteRetry 路由规则的重试策略，含义同RetryPolicy
+type RouteRetry struct {
+	Attempts       int    `json:"attempts"`
+	PerTryTimeout  string `json:"per_try_timeout,omitempty"`
+	IdempotentOnly bool   `json:"idempotent_only,omitempty"`
+}
+
+// ErrRouteNotFound 路由表中没有该名称的规则
+var ErrRouteNotFound = errors.New("route not found")
+
+// ErrRouteExists 路由表中已有同名的规则
+var ErrRouteExists = errors.New("route already exists")
+
+type compiledRoute struct {
+	Route
+	segments []string
+	prefix   bool
+	proxy    *Proxy
+	handler  http.Handler
+	override routeOverride
+}
+
+type routeOverrideKey struct{}
+
+// routeOverride 路由规则对环的默认设置的覆盖，nil字段使用环的设置
+type routeOverride struct {
+	keys    RoutingKeyExtractor
+	retry   *RetryPolicy
+	timeout time.Duration
+}
+
+func routeOverrideFrom(ctx context.Context) *routeOverride {
+	o, _ := ctx.Value(routeOverrideKey{}).(*routeOverride)
+	return o
+}
+
+// Routes 路由表：按顺序匹配请求路径，第一条匹配的规则决定使用的环、路由key、重试策略和超时
+// 都不匹配时交给下一个handler（按环的前缀或请求头选择）。通过管理接口的修改只作用于本实例
+type Routes struct {
+	rings  *Rings
+	routes []*compiledRoute
+	// 每个环和模式的handler只创建一次
+	handlers   map[routeHandlerKey]http.Handler
+	handlersMu sync.Mutex
+	sync.RWMutex
+}
+
+type routeHandlerKey struct {
+	proxy *Proxy
+	mode  Mode
+}
+
+func NewRoutes(rings *Rings) *Routes {
+	return &Routes{rings: rings, handlers: make(map[routeHandlerKey]http.Handler)}
+}
+
+// Set 替换整个路由表，有一条规则无效时不做任何修改
+func (rt *Routes) Set(routes []Route) error {
+	compiled := make([]*compiledRoute, 0, len(routes))
+	names := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		if names[r.Name] {
+			return fmt.Errorf("%w: %s", ErrRouteExists, r.Name)
+		}
+		names[r.Name] = true
+		c, err := rt.compile(r)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, c)
+	}
+
+	rt.Lock()
+	defer rt.Unlock()
+	rt.routes = compiled
+	return nil
+}
+
+// Add 在路由表末尾添加一条规则
+func (rt *Routes) Add(r Route) error {
+	c, err := rt.compile(r)
+	if err != nil {
+		return err
+	}
+
+	rt.Lock()
+	defer rt.Unlock()
+	if rt.index(r.Name) >= 0 {
+		return fmt.Errorf("%w: %s", ErrRouteExists, r.Name)
+	}
+	rt.routes = append(rt.routes, c)
+	return nil
+}
+
+// Put 替换同名的规则（位置不变），不存在时添加到末尾
+func (rt *Routes) Put(r Route) error {
+	c, err := rt.compile(r)
+	if err != nil {
+		return err
+	}
+
+	rt.Lock()
+	defer rt.Unlock()
+	if i := rt.index(r.Name); i >= 0 {
+		rt.routes[i] = c
+		return nil
+	}
+	rt.routes = append(rt.routes, c)
+	return nil
+}
+
+// Remove 删除一条规则
+func (rt *Routes) Remove(name string) error {
+	rt.Lock()
+	defer rt.Unlock()
+	i := rt.index(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrRouteNotFound, name)
+	}
+	rt.routes = append(rt.routes[:i:i], rt.routes[i+1:]...)
+	return nil
+}
+
+// Get 按名称取得规则
+func (rt *Routes) Get(name string) (Route, error) {
+	rt.RLock()
+	defer rt.RUnlock()
+	i := rt.index(name)
+	if i < 0 {
+		return Route{}, fmt.Errorf("%w: %s", ErrRouteNotFound, name)
+	}
+	return rt.routes[i].Route, nil
+}
+
+// List 按匹配顺序返回所有规则
+func (rt *Routes) List() []Route {
+	rt.RLock()
+	defer rt.RUnlock()
+	routes := make([]Route, 0, len(rt.routes))
+	for _, c := range rt.routes {
+		routes = append(routes, c.Route)
+	}
+	return routes
+}
+
+func (rt *Routes) index(name string) int {
+	for i, c := range rt.routes {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (rt *Routes) compile(r Route) (*compiledRoute, error) {
+	if r.Name == "" {
+		return nil, errors.New("route name is required")
+	}
+	if !strings.HasPrefix(r.Pattern, "/") {
+		return nil, fmt.Errorf("route %s: pattern must start with /", r.Name)
+	}
+	c := &compiledRoute{Route: r, prefix: strings.HasSuffix(r.Pattern, "/")}
+	if trimmed := strings.Trim(r.Pattern, "/"); trimmed != "" {
+		c.segments = strings.Split(trimmed, "/")
+	}
+
+	ring := r.Ring
+	if ring == "" {
+		ring = DefaultRingName
+	}
+	p, ok := rt.rings.Get(ring)
+	if !ok {
+		return nil, fmt.Errorf("route %s: ring %s not found", r.Name, ring)
+	}
+	mode, err := ParseMode(r.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("route %s: %w", r.Name, err)
+	}
+	c.proxy, c.handler = p, rt.handler(p, mode)
+
+	if r.RoutingKey != "" {
+		keys, err := ParseRoutingKey(r.RoutingKey)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", r.Name, err)
+		}
+		c.override.keys = keys
+	}
+	if r.Retry != nil {
+		policy := RetryPolicy{Attempts: r.Retry.Attempts, IdempotentOnly: r.Retry.IdempotentOnly}
+		if r.Retry.PerTryTimeout != "" {
+			d, err := time.ParseDuration(r.Retry.PerTryTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: per_try_timeout: %w", r.Name, err)
+			}
+			policy.PerTryTimeout = d
+		}
+		c.override.retry = &policy
+	}
+	if r.Timeout != "" {
+		d, err := time.ParseDuration(r.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: timeout: %w", r.Name, err)
+		}
+		c.override.timeout = d
+	}
+	return c, nil
+}
+
+// match 返回路径中与模式匹配的部分
+func (c *compiledRoute) match(path string) (string, bool) {
+	var segments []string
+	if trimmed := strings.Trim(path, "/"); trimmed != "" {
+		segments = strings.Split(trimmed, "/")
+	}
+	if len(segments) < len(c.segments) || (!c.prefix && len(segments) != len(c.segments)) {
+		return "", false
+	}
+	for i, s := range c.segments {
+		if s != "*" && s != segments[i] {
+			return "", false
+		}
+	}
+	if len(c.segments) == 0 {
+		return "", true
+	}
+	return "/" + strings.Join(segments[:len(c.segments)], "/"), true
+}
+
+func (rt *Routes) lookup(path string) (*compiledRoute, string) {
+	rt.RLock()
+	defer rt.RUnlock()
+	for _, c := range rt.routes {
+		if matched, ok := c.match(path); ok {
+			return c, matched
+		}
+	}
+	return nil, ""
+}
+
+func (rt *Routes) handler(p *Proxy, mode Mode) http.Handler {
+	k := routeHandlerKey{proxy: p, mode: mode}
+	rt.handlersMu.Lock()
+	defer rt.handlersMu.Unlock()
+	h, ok := rt.handlers[k]
+	if !ok {
+		h = p.Handler(mode)
+		rt.handlers[k] = h
+	}
+	return h
+}
+
+// Handler 匹配路由表的请求按规则转发，其余交给next
+func (rt *Routes) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, matched := rt.lookup(r.URL.Path)
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		override := &c.override
+		if c.StripPrefix && matched != "" {
+			// 路由key按原始路径取出，path:N与模式中的路径段对应
+			keys := c.override.keys
+			if keys == nil {
+				keys = c.proxy.keys
+			}
+			key, err := keys.RoutingKey(r)
+			override = &routeOverride{
+				keys:    RoutingKeyFunc(func(*http.Request) (string, error) { return key, err }),
+				retry:   c.override.retry,
+				timeout: c.override.timeout,
+			}
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), routeOverrideKey{}, override))
+		if c.StripPrefix && matched != "" {
+			r = stripPrefix(r, matched)
+		}
+		c.handler.ServeHTTP(w, r)
+	})
+}
+
+// AdminAPI 路由表的管理接口：
+//
+//	GET    /v1/routes              按匹配顺序列出规则
+//	PUT    /v1/routes              替换整个路由表
+//	POST   /v1/routes              在末尾添加规则
+//	GET    /v1/routes/{name}       查看规则
+//	PUT    /v1/routes/{name}       替换或添加规则
+//	DELETE /v1/routes/{name}       删除规则
+func (rt *Routes) AdminAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/routes", rt.handleRoutes)
+	mux.HandleFunc("/v1/routes/", rt.handleRouteByName)
+	return mux
+}
+
+func (rt *Routes) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, rt.List())
+
+	case http.MethodPut:
+		var routes []Route
+		if err := json.NewDecoder(r.Body).Decode(&routes); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		if err := rt.Set(routes); err != nil {
+			writeRouteError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rt.List())
+
+	case http.MethodPost:
+		var route Route
+		if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		if err := rt.Add(route); err != nil {
+			writeRouteError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, route)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodPost)
+	}
+}
+
+func (rt *Routes) handleRouteByName(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/routes/")
+	if name == "" || strings.Contains(name, "/") {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no route for %s", r.URL.Path))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		route, err := rt.Get(name)
+		if err != nil {
+			writeRouteError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, route)
+
+	case http.MethodPut:
+		var route Route
+		if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		if route.Name == "" {
+			route.Name = name
+		}
+		if route.Name != name {
+			writeError(w, http.StatusBadRequest, "invalid_param", "route name does not match path")
+			return
+		}
+		if err := rt.Put(route); err != nil {
+			writeRouteError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, route)
+
+	case http.MethodDelete:
+		if err := rt.Remove(name); err != nil {
+			writeRouteError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func writeRouteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrRouteNotFound):
+		writeError(w, http.StatusNotFound, "route_not_found", err.Error())
+	case errors.Is(err, ErrRouteExists):
+		writeError(w, http.StatusConflict, "route_already_exists", err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_route", err.Error())
+	}
+}
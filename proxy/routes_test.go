@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		ok      bool
+		matched string
+	}{
+		{"/", "/", true, ""},
+		{"/", "/any/path", true, ""},
+		{"/users", "/users", true, "/users"},
+		{"/users", "/users/", true, "/users"},
+		{"/users", "/users/1", false, ""},
+		{"/users/", "/users", true, "/users"},
+		{"/users/", "/users/1/orders", true, "/users"},
+		{"/users/", "/usersx/1", false, ""},
+		{"/users/*", "/users/42", true, "/users/42"},
+		{"/users/*", "/users", false, ""},
+		{"/users/*/orders/", "/users/42/orders/7", true, "/users/42/orders"},
+		{"/users/*/orders/", "/users/42/items/7", false, ""},
+		{"/*/", "/cache/k", true, "/cache"},
+	}
+	rt := NewRoutes(NewRings(newTestProxy(t, []string{"a:80"}), RingHeader))
+	for _, tt := range tests {
+		c, err := rt.compile(Route{Name: "r", Pattern: tt.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+		matched, ok := c.match(tt.path)
+		if ok != tt.ok || matched != tt.matched {
+			t.Errorf("pattern %q, path %q: match = %q, %v; want %q, %v", tt.pattern, tt.path, matched, ok, tt.matched, tt.ok)
+		}
+	}
+}
+
+func TestRoutesFirstMatchWins(t *testing.T) {
+	rt := NewRoutes(NewRings(newTestProxy(t, []string{"a:80"}), RingHeader))
+	if err := rt.Set([]Route{
+		{Name: "exact", Pattern: "/users/me"},
+		{Name: "wildcard", Pattern: "/users/*"},
+		{Name: "prefix", Pattern: "/users/"},
+		{Name: "fallback", Pattern: "/api/"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/users/me", "exact"},
+		{"/users/42", "wildcard"},
+		{"/users/42/orders", "prefix"},
+		{"/api/v1", "fallback"},
+		{"/other", ""},
+	}
+	for _, tt := range tests {
+		c, _ := rt.lookup(tt.path)
+		got := ""
+		if c != nil {
+			got = c.Name
+		}
+		if got != tt.want {
+			t.Errorf("lookup(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRouteCompileErrors(t *testing.T) {
+	rt := NewRoutes(NewRings(newTestProxy(t, []string{"a:80"}), RingHeader))
+	tests := []struct {
+		name  string
+		route Route
+	}{
+		{"no name", Route{Pattern: "/"}},
+		{"relative pattern", Route{Name: "r", Pattern: "users/"}},
+		{"unknown ring", Route{Name: "r", Pattern: "/", Ring: "missing"}},
+		{"unknown mode", Route{Name: "r", Pattern: "/", Mode: "random"}},
+		{"bad routing key", Route{Name: "r", Pattern: "/", RoutingKey: "cookie"}},
+		{"bad timeout", Route{Name: "r", Pattern: "/", Timeout: "soon"}},
+		{"bad per try timeout", Route{Name: "r", Pattern: "/", Retry: &RouteRetry{Attempts: 2, PerTryTimeout: "1"}}},
+	}
+	for _, tt := range tests {
+		if err := rt.Add(tt.route); err == nil {
+			t.Errorf("%s: Add succeeded", tt.name)
+		}
+	}
+
+	if err := rt.Set([]Route{{Name: "r", Pattern: "/"}, {Name: "r", Pattern: "/a"}}); !errors.Is(err, ErrRouteExists) {
+		t.Fatalf("Set with duplicate names = %v, want ErrRouteExists", err)
+	}
+	if err := rt.Remove("r"); !errors.Is(err, ErrRouteNotFound) {
+		t.Fatalf("Remove unknown = %v, want ErrRouteNotFound", err)
+	}
+}
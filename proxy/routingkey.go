@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RoutingKeyExtractor 从请求中取出路由key，返回空字符串表示请求中没有key
+type RoutingKeyExtractor interface {
+	RoutingKey(r *http.Request) (string, error)
+}
+
+// RoutingKeyFunc 让普通函数实现RoutingKeyExtractor
+type RoutingKeyFunc func(r *http.Request) (string, error)
+
+func (f RoutingKeyFunc) RoutingKey(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// 读取JSON请求体时的大小上限
+const maxKeyBodyBytes = 1 << 20
+
+var errKeyBodyTooLarge = errors.New("request body too large to extract routing key")
+
+// QueryKey 取查询参数name，默认的路由key为QueryKey("key")
+func QueryKey(name string) RoutingKeyExtractor {
+	return RoutingKeyFunc(func(r *http.Request) (string, error) {
+		return r.URL.Query().Get(name), nil
+	})
+}
+
+// HeaderKey 取请求头name
+func HeaderKey(name string) RoutingKeyExtractor {
+	return RoutingKeyFunc(func(r *http.Request) (string, error) {
+		return r.Header.Get(name), nil
+	})
+}
+
+// CookieKey 取名为name的cookie
+func CookieKey(name string) RoutingKeyExtractor {
+	return RoutingKeyFunc(func(r *http.Request) (string, error) {
+		c, err := r.Cookie(name)
+		if err != nil {
+			return "", nil
+		}
+		return c.Value, nil
+	})
+}
+
+// PathSegmentKey 取URL路径中的第index段（从0开始），如 /users/42/orders 的第1段为42
+func PathSegmentKey(index int) RoutingKeyExtractor {
+	return RoutingKeyFunc(func(r *http.Request) (string, error) {
+		segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if index < 0 || index >= len(segments) {
+			return "", nil
+		}
+		return segments[index], nil
+	})
+}
+
+// JSONBodyKey 取JSON请求体中的字段，field可以用.访问嵌套字段，如 user.id
+// 读取后的请求体会放回请求中，转发给后端的内容不变
+func JSONBodyKey(field string) RoutingKeyExtractor {
+	path := strings.Split(field, ".")
+	return RoutingKeyFunc(func(r *http.Request) (string, error) {
+		if r.Body == nil || r.Body == http.NoBody {
+			return "", nil
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxKeyBodyBytes+1))
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		if len(body) > maxKeyBodyBytes {
+			return "", errKeyBodyTooLarge
+		}
+
+		var v any
+		if err = json.Unmarshal(body, &v); err != nil {
+			return "", fmt.Errorf("invalid JSON body: %w", err)
+		}
+		for _, name := range path {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return "", nil
+			}
+			v = obj[name]
+		}
+		return jsonKeyString(v), nil
+	})
+}
+
+func jsonKeyString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// FirstKey 依次尝试extractors，返回第一个非空的key
+func FirstKey(extractors ...RoutingKeyExtractor) RoutingKeyExtractor {
+	return RoutingKeyFunc(func(r *http.Request) (string, error) {
+		for _, e := range extractors {
+			key, err := e.RoutingKey(r)
+			if err != nil || key != "" {
+				return key, err
+			}
+		}
+		return "", nil
+	})
+}
+
+// ParseRoutingKey 解析配置中的路由key来源，格式为 来源:名称，多个以逗号分隔时依次尝试
+// 来源为query、header、cookie、path（名称为段的下标）、json（名称为字段路径）
+// 或ip（名称为remote时取连接地址，为forwarded时优先取X-Forwarded-For），如 header:X-User-ID,query:key
+func ParseRoutingKey(spec string) (RoutingKeyExtractor, error) {
+	var extractors []RoutingKeyExtractor
+	for _, part := range strings.Split(spec, ",") {
+		source, name, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid routing key source %q", part)
+		}
+
+		switch source {
+		case "query":
+			extractors = append(extractors, QueryKey(name))
+		case "header":
+			extractors = append(extractors, HeaderKey(name))
+		case "cookie":
+			extractors = append(extractors, CookieKey(name))
+		case "path":
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid path segment index %q", name)
+			}
+			extractors = append(extractors, PathSegmentKey(index))
+		case "json":
+			extractors = append(extractors, JSONBodyKey(name))
+		case "ip":
+			if name != "remote" && name != "forwarded" {
+				return nil, fmt.Errorf("invalid client ip source %q", name)
+			}
+			extractors = append(extractors, ClientIPKey(name == "forwarded"))
+		default:
+			return nil, fmt.Errorf("unknown routing key source %q", source)
+		}
+	}
+	if len(extractors) == 1 {
+		return extractors[0], nil
+	}
+	return FirstKey(extractors...), nil
+}
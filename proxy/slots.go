@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// newSlots 按服务器名的顺序依次加入，同样的服务器得到同样的分配
+func newSlots(hosts []string) *core.Slots {
+	slots := core.NewSlots()
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		_ = slots.RegisterHost(host)
+	}
+	return slots
+}
+
+// syncSlots 让槽位的服务器与环一致：加入环上新增的服务器，移除已注销的服务器
+// 拓扑事件在订阅者消费过慢时可能被丢弃，因此每次都与环整体比较，而不是只应用单个事件
+func (p *Proxy) syncSlots() {
+	p.slotsMu.Lock()
+	defer p.slotsMu.Unlock()
+
+	if p.syncSlotsLocked(p.slots.Load()) {
+		p.saveSlotsLocked()
+	}
+}
+
+func (p *Proxy) syncSlotsLocked(slots *core.Slots) bool {
+	ring := p.consistent.Hosts()
+	sort.Strings(ring)
+	inRing := make(map[string]struct{}, len(ring))
+	changed := false
+	for _, host := range ring {
+		inRing[host] = struct{}{}
+		if slots.RegisterHost(host) == nil {
+			changed = true
+		}
+	}
+	for _, host := range slots.Hosts() {
+		if _, ok := inRing[host]; !ok && slots.UnregisterHost(host) == nil {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// EnableSlots 从path恢复槽位的分配，之后每次变化（包括手动迁移）都写入path；文件不存在时保留按当前服务器生成的分配
+// 不调用时只在内存中维护，重启后按服务器名的顺序重新生成
+func (p *Proxy) EnableSlots(path string) error {
+	p.slotsMu.Lock()
+	defer p.slotsMu.Unlock()
+
+	p.slotsPath = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p.writeSlots()
+	}
+	if err != nil {
+		return err
+	}
+
+	var ranges []core.SlotRange
+	if err = json.Unmarshal(data, &ranges); err != nil {
+		return err
+	}
+	slots, err := core.RestoreSlots(ranges)
+	if err != nil {
+		return err
+	}
+	// 文件中的服务器可能已经注销，或者有新的服务器从快照恢复
+	p.syncSlotsLocked(slots)
+	p.slots.Store(slots)
+	return p.writeSlots()
+}
+
+func (p *Proxy) saveSlotsLocked() {
+	if err := p.writeSlots(); err != nil {
+		p.logger.Error("write slots failed", "path", p.slotsPath, "error", err)
+	}
+}
+
+func (p *Proxy) writeSlots() error {
+	if p.slotsPath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(p.slots.Load().Ranges())
+	if err != nil {
+		return err
+	}
+	tmp := p.slotsPath + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.slotsPath)
+}
+
+// AssignSlots 把[start, end]内的槽位迁移到host，返回移动的槽位数量；与注册、注销一样写入预写日志并同步给其他实例
+func (p *Proxy) AssignSlots(start, end int, host string) (int, error) {
+	p.syncSlots()
+	moved, err := p.slots.Load().CountMoves(start, end, host)
+	if err != nil {
+		return 0, err
+	}
+	err = p.commit(Change{Op: ChangeAssignSlots, Host: host, Slots: &core.SlotRange{Start: start, End: end, Host: host}})
+	if err != nil {
+		return 0, err
+	}
+	return moved, nil
+}
+
+// assignSlots 只修改本实例的槽位
+func (p *Proxy) assignSlots(start, end int, host string) error {
+	p.slotsMu.Lock()
+	defer p.slotsMu.Unlock()
+
+	// 拓扑事件是异步处理的，刚注册（或从预写日志重放）的服务器可能还没有加入槽位
+	slots := p.slots.Load()
+	synced := p.syncSlotsLocked(slots)
+	moved, err := slots.AssignSlots(start, end, host)
+	if err != nil {
+		return err
+	}
+	if moved > 0 {
+		p.logger.Info("slots assigned", "start", start, "end", end, "host", host, "moved", moved)
+		p.persist(Change{Op: ChangeAssignSlots, Host: host, Slots: &core.SlotRange{Start: start, End: end, Host: host}})
+	}
+	if synced || moved > 0 {
+		p.saveSlotsLocked()
+	}
+	return nil
+}
+
+// Slots 槽位的分配，只读，修改需通过AssignSlots
+func (p *Proxy) Slots() *core.Slots {
+	return p.slots.Load()
+}
+
+// 槽位所属的服务器被摘除时按普通模式选择
+func (p *Proxy) pickSlot(ctx context.Context, key string) (string, error) {
+	host, err := p.slots.Load().GetHost(key)
+	if err != nil || !p.consistent.IsDraining(host) {
+		return host, err
+	}
+	return p.pickHash(ctx, key)
+}
+
+type slotsResponse struct {
+	SlotCount int              `json:"slot_count"`
+	Ranges    []core.SlotRange `json:"ranges"`
+	// 每台服务器的槽位数量
+	Hosts map[string]int `json:"hosts"`
+	// 本次迁移实际移动的槽位数量
+	Moved *int `json:"moved,omitempty"`
+}
+
+type slotLookupResponse struct {
+	Key  string `json:"key"`
+	Slot int    `json:"slot"`
+	Host string `json:"host"`
+}
+
+type assignSlotsRequest struct {
+	Start *int   `json:"start"`
+	End   *int   `json:"end"`
+	Host  string `json:"host"`
+}
+
+func (p *Proxy) handleSlots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if key := r.URL.Query().Get("key"); key != "" {
+			slot := core.KeySlot(key)
+			host, err := p.slots.Load().HostOf(slot)
+			if err != nil {
+				writeCoreError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, slotLookupResponse{Key: key, Slot: slot, Host: host})
+			return
+		}
+		writeJSON(w, http.StatusOK, p.slotsResponse(nil))
+	case http.MethodPost:
+		var req assignSlotsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		if req.Start == nil || req.Host == "" {
+			writeError(w, http.StatusBadRequest, "missing_param", "missing start or host")
+			return
+		}
+		// 只给出start时迁移单个槽位
+		end := *req.Start
+		if req.End != nil {
+			end = *req.End
+		}
+		moved, err := p.AssignSlots(*req.Start, end, req.Host)
+		if err != nil {
+			writeCoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, p.slotsResponse(&moved))
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (p *Proxy) slotsResponse(moved *int) slotsResponse {
+	slots := p.slots.Load()
+	return slotsResponse{SlotCount: core.SlotCount, Ranges: slots.Ranges(), Hosts: slots.Counts(), Moved: moved}
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// recordingReplicator 记录交给复制层的变更，再在本实例上应用
+type recordingReplicator struct {
+	proxy   *Proxy
+	changes []Change
+}
+
+func (r *recordingReplicator) Replicate(c Change) error {
+	r.changes = append(r.changes, c)
+	return r.proxy.ApplyChange(c)
+}
+
+func TestAssignSlotsGoesThroughReplicator(t *testing.T) {
+	p := newTestProxy(t, []string{"a:80", "b:80"})
+	r := &recordingReplicator{proxy: p}
+	p.SetReplicator(r)
+
+	moved, err := p.AssignSlots(0, 99, "b:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Change{Op: ChangeAssignSlots, Host: "b:80", Slots: &core.SlotRange{Start: 0, End: 99, Host: "b:80"}}
+	if len(r.changes) != 1 || !reflect.DeepEqual(r.changes[0], want) {
+		t.Fatalf("replicated changes = %+v, want %+v", r.changes, want)
+	}
+	if host, _ := p.Slots().HostOf(0); host != "b:80" || moved == 0 {
+		t.Fatalf("slot 0 owned by %s after moving %d slots, want b:80", host, moved)
+	}
+}
+
+func TestAssignSlotsReplayedFromWAL(t *testing.T) {
+	dir := t.TempDir()
+	snapshot, wal := filepath.Join(dir, "ring.snapshot"), filepath.Join(dir, "ring.wal")
+
+	p := newTestProxy(t, []string{"a:80", "b:80"})
+	p.EnableSnapshot(snapshot)
+	if err := p.EnableWAL(wal, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.AssignSlots(0, 99, "b:80"); err != nil {
+		t.Fatal(err)
+	}
+	want := p.Slots().Ranges()
+
+	restarted := newTestProxy(t, []string{"a:80", "b:80"})
+	n, err := restarted.ReplayWAL(wal)
+	if err != nil || n != 1 {
+		t.Fatalf("replayed %d changes, %v; want 1", n, err)
+	}
+	if got := restarted.Slots().Ranges(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("slots after replay = %v, want %v", got, want)
+	}
+}
+
+func TestTopologyRestoresAssignedSlots(t *testing.T) {
+	p := newTestProxy(t, []string{"a:80", "b:80", "c:80"})
+	if _, err := p.AssignSlots(100, 199, "c:80"); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestProxy(t, nil)
+	if err := restored.ResetTopology(p.Topology()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.Slots().Ranges(), p.Slots().Ranges(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("slots after reset = %v, want %v", got, want)
+	}
+}
+
+func TestEnableSlotsWithEmptyRing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slots.json")
+
+	// 第一次启动时环为空，写出空的分配
+	if err := newTestProxy(t, nil).EnableSlots(path); err != nil {
+		t.Fatal(err)
+	}
+	restarted := newTestProxy(t, nil)
+	if err := restarted.EnableSlots(path); err != nil {
+		t.Fatal(err)
+	}
+	if r := restarted.Slots().Ranges(); len(r) != 0 {
+		t.Fatalf("slots = %v, want empty", r)
+	}
+}
+
+// 按cmd/proxy的顺序重启：恢复快照、重放预写日志、开启预写日志，再恢复槽位
+func TestAssignedSlotsSurviveWALCompaction(t *testing.T) {
+	dir := t.TempDir()
+	snapshot, wal, slotsPath := filepath.Join(dir, "ring.snapshot"), filepath.Join(dir, "ring.wal"), filepath.Join(dir, "ring.snapshot.slots")
+
+	start := func(c *core.Consistent) *Proxy {
+		p := New(c, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+		if _, err := p.ReplayWAL(wal); err != nil {
+			t.Fatal(err)
+		}
+		p.EnableSnapshot(snapshot)
+		if err := p.EnableWAL(wal, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.EnableSlots(slotsPath); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	c := core.New(10, nil)
+	for _, host := range []string{"a:80", "b:80"} {
+		if err := c.RegisterHost(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := start(c)
+	if _, err := p.AssignSlots(0, 99, "b:80"); err != nil {
+		t.Fatal(err)
+	}
+	want := p.Slots().Ranges()
+	// 写快照并清空预写日志
+	p.Close()
+
+	data, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := core.Restore(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := start(restored)
+	defer restarted.Close()
+	if got := restarted.Slots().Ranges(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("slots after restart = %v, want %v", got, want)
+	}
+}
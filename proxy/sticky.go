@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionCookieConfig 会话保持使用的cookie
+type SessionCookieConfig struct {
+	Name string
+	Path string
+	// 为0时为会话cookie，浏览器关闭后失效
+	MaxAge   time.Duration
+	Secure   bool
+	SameSite http.SameSite
+}
+
+func DefaultSessionCookieConfig() SessionCookieConfig {
+	return SessionCookieConfig{
+		Name:     "CHSESSION",
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// WithStickySession 按会话cookie路由，同一会话的请求总是转发到同一台服务器；请求没有cookie时生成一个并在响应中设置
+func WithStickySession(config SessionCookieConfig) Option {
+	return func(p *Proxy) {
+		if config.Name == "" {
+			config.Name = DefaultSessionCookieConfig().Name
+		}
+		p.keys = CookieKey(config.Name)
+		p.middlewares = append(p.middlewares, SessionCookie(config))
+	}
+}
+
+// SessionCookie 请求没有会话cookie时生成新的会话ID，加到请求中供路由使用，并通过Set-Cookie下发给客户端
+func SessionCookie(config SessionCookieConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := r.Cookie(config.Name); err != nil {
+				cookie := &http.Cookie{
+					Name:     config.Name,
+					Value:    newSessionID(),
+					Path:     config.Path,
+					MaxAge:   int(config.MaxAge.Seconds()),
+					Secure:   config.Secure,
+					HttpOnly: true,
+					SameSite: config.SameSite,
+				}
+				http.SetCookie(w, cookie)
+				r.AddCookie(cookie)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func newSessionID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ClientIPKey 以客户端IP作为路由key，同一客户端的请求总是转发到同一台服务器
+// trustForwardedFor为true时取X-Forwarded-For中的第一个地址，只应在代理前还有可信的负载均衡时开启
+func ClientIPKey(trustForwardedFor bool) RoutingKeyExtractor {
+	return RoutingKeyFunc(func(r *http.Request) (string, error) {
+		return clientIP(r, trustForwardedFor), nil
+	})
+}
+
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first, _, _ := strings.Cut(xff, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
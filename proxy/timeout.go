@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TimeoutHeader 客户端通过该请求头给出请求的超时时间（如 500ms），转发给后端时改为剩余的时间
+const TimeoutHeader = "X-Request-Timeout"
+
+// requestTimeout 决定请求的超时时间：路由规则的超时覆盖环的默认值，客户端给出的超时（X-Request-Timeout或grpc-timeout）更短时使用客户端的
+// 升级的长连接不受环的默认值限制，gRPC请求只按grpc-timeout限制；返回0表示不限制
+func (p *Proxy) requestTimeout(r *http.Request, upgrade bool) time.Duration {
+	timeout := p.timeout
+	if upgrade || IsGRPC(r) {
+		timeout = 0
+	}
+	if o := routeOverrideFrom(r.Context()); o != nil && o.timeout > 0 {
+		timeout = o.timeout
+	}
+
+	client, ok := clientTimeout(r)
+	if ok && (timeout == 0 || client < timeout) {
+		timeout = client
+	}
+	return timeout
+}
+
+// withTimeout 按requestTimeout为请求设置截止时间；客户端断开或超时后取消的ctx会中断对后端的请求，负载计数随之释放
+func (p *Proxy) withTimeout(r *http.Request, upgrade bool) (*http.Request, context.CancelFunc) {
+	timeout := p.requestTimeout(r, upgrade)
+	if timeout <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return r.WithContext(ctx), cancel
+}
+
+// clientTimeout 解析客户端给出的超时，无效或未给出时ok为false
+func clientTimeout(r *http.Request) (time.Duration, bool) {
+	if v := r.Header.Get(TimeoutHeader); v != "" {
+		d, err := time.ParseDuration(v)
+		return d, err == nil && d > 0
+	}
+	if v := r.Header.Get("Grpc-Timeout"); v != "" && IsGRPC(r) {
+		return parseGRPCTimeout(v)
+	}
+	return 0, false
+}
+
+// parseGRPCTimeout 解析grpc-timeout，格式为不超过8位的整数加单位H、M、S、m、u、n
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// propagateDeadline 把剩余的时间写入发往后端的请求头，让后端不必处理已经超时的请求；gRPC请求的grpc-timeout原样转发
+func propagateDeadline(req *http.Request) {
+	deadline, ok := req.Context().Deadline()
+	if !ok || IsGRPC(req) {
+		return
+	}
+	remaining := time.Until(deadline)
+	if remaining < time.Millisecond {
+		remaining = time.Millisecond
+	}
+	req.Header.Set(TimeoutHeader, remaining.Round(time.Millisecond).String())
+}
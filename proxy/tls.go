@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// TLSFiles 从文件加载TLS配置
+type TLSFiles struct {
+	// 信任的CA证书，为空时使用系统CA；指定后只信任该CA签发的证书
+	CAFile string
+	// 证书和私钥，连接后端时作为mTLS的客户端证书
+	CertFile string
+	KeyFile  string
+	// 校验证书时使用的服务器名，为空时使用连接的主机名
+	ServerName string
+}
+
+var errNoCACerts = errors.New("no certificates found in CA file")
+
+// LoadTLSConfig 按TLSFiles构造tls.Config，可用于TransportConfig.TLS或HostTLS
+func LoadTLSConfig(files TLSFiles) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: files.ServerName,
+	}
+
+	if files.CAFile != "" {
+		pem, err := os.ReadFile(files.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errNoCACerts
+		}
+		config.RootCAs = pool
+	}
+
+	if files.CertFile != "" || files.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+const (
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 5 * time.Minute
+)
+
+type topologyResponse struct {
+	Version uint64         `json:"version"`
+	Hosts   []hostResponse `json:"hosts"`
+}
+
+type topologyEvent struct {
+	Version uint64 `json:"version"`
+	Type    string `json:"type"`
+	Host    string `json:"host"`
+	Weight  int    `json:"weight"`
+	// WarmupProgress事件中放到环上的虚拟节点比例
+	Warmup float64 `json:"warmup,omitempty"`
+}
+
+type topologyChanges struct {
+	Version uint64          `json:"version"`
+	Events  []topologyEvent `json:"events"`
+}
+
+func newTopologyEvent(ev core.TopologyEvent) topologyEvent {
+	return topologyEvent{
+		Version: ev.Version,
+		Type:    ev.Type.String(),
+		Host:    ev.Host,
+		Weight:  ev.Weight,
+		Warmup:  ev.Warmup,
+	}
+}
+
+func (p *Proxy) handleTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	t := p.consistent.Topology()
+	res := topologyResponse{Version: t.Version, Hosts: make([]hostResponse, 0, len(t.Hosts))}
+	for _, h := range t.Hosts {
+		res.Hosts = append(res.Hosts, newHostResponse(h))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// 有版本号大于since的事件时立即返回，否则等到拓扑变化或超时；超时返回空的事件列表
+// since过旧时返回410，客户端应重新获取/v1/topology
+func (p *Proxy) handleTopologyWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", "since must be a version number")
+		return
+	}
+	timeout := defaultWatchTimeout
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		timeout, err = time.ParseDuration(s)
+		if err != nil || timeout <= 0 || timeout > maxWatchTimeout {
+			writeError(w, http.StatusBadRequest, "invalid_param", "timeout must be a positive duration up to "+maxWatchTimeout.String())
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	events, version, err := p.consistent.WaitChanges(ctx, since)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		if r.Context().Err() != nil {
+			// 客户端已断开
+			return
+		}
+		writeCoreError(w, err)
+		return
+	}
+
+	res := topologyChanges{Version: version, Events: make([]topologyEvent, 0, len(events))}
+	for _, ev := range events {
+		res.Events = append(res.Events, newTopologyEvent(ev))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/dingqing/consistent-hash/proxy"
+
+// 请求span：从请求头中取出上游的trace上下文，整个转发过程都在该span下
+func (p *Proxy) startRequestSpan(w http.ResponseWriter, r *http.Request, mode Mode) (*statusWriter, *http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := p.tracer.Start(ctx, "proxy.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("proxy.mode", mode.String()),
+		))
+	return &statusWriter{ResponseWriter: w, status: http.StatusOK}, r.WithContext(ctx), span
+}
+
+func endRequestSpan(span trace.Span, sw *statusWriter) {
+	span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+	if sw.status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(sw.status))
+	}
+	span.End()
+}
+
+// 环查找span：记录key、哈希值和选中的服务器
+func (p *Proxy) tracePick(ctx context.Context, key string, mode Mode) (string, error) {
+	ctx, span := p.tracer.Start(ctx, "ring.lookup", trace.WithAttributes(
+		attribute.String("ring.key", key),
+		attribute.String("ring.key_hash", strconv.FormatUint(p.consistent.HashKey(key), 10)),
+		attribute.String("proxy.mode", mode.String()),
+	))
+	defer span.End()
+
+	host, err := p.pick(ctx, key, mode)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	span.SetAttributes(attribute.String("ring.host", host))
+	return host, nil
+}
+
+// 后端span：把trace上下文注入到发往后端的请求头，响应体读完后结束
+func startBackendSpan(tracer trace.Tracer, req *http.Request, host string) (*http.Request, trace.Span) {
+	ctx, span := tracer.Start(req.Context(), "proxy.backend",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("server.address", host),
+			attribute.String("http.request.method", req.Method),
+		))
+	req = req.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, span
+}
+
+func endBackendSpan(span trace.Span, resp *http.Response, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	resp.Body = onCloseBody(resp.Body, func() { span.End() })
+}
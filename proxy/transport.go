@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+type TransportConfig struct {
+	// 每个后端最多保留的空闲连接数
+	MaxIdleConnsPerHost   int
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	// 连接后端使用的TLS配置，为nil时使用明文
+	TLS *tls.Config
+	// 按服务器覆盖TLS配置
+	HostTLS map[string]*tls.Config
+	// 明文后端也使用HTTP/2（h2c，不经升级直接发送HTTP/2前言），需要后端支持
+	// 未开启时只有gRPC请求使用h2c，TLS后端总是通过ALPN协商HTTP/2
+	H2C bool
+}
+
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		MaxIdleConnsPerHost:   32,
+		DialTimeout:           3 * time.Second,
+		KeepAlive:             30 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	}
+}
+
+// hostTransports 为每个后端维护独立的连接池，慢后端不会占满其他后端的连接
+type hostTransports struct {
+	config     TransportConfig
+	transports map[string]*http.Transport
+	h2c        map[string]*http2.Transport
+	sync.Mutex
+}
+
+func newHostTransports(config TransportConfig) *hostTransports {
+	return &hostTransports{
+		config:     config,
+		transports: make(map[string]*http.Transport),
+		h2c:        make(map[string]*http2.Transport),
+	}
+}
+
+// 转发和健康检查都按http构造请求，配置了TLS的后端在这里统一改为https
+func (t *hostTransports) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" && t.tlsConfig(req.URL.Host) != nil {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "https"
+	}
+	// gRPC只能使用HTTP/2
+	if req.URL.Scheme == "http" && (t.config.H2C || IsGRPC(req)) {
+		return t.getH2C(req.URL.Host).RoundTrip(req)
+	}
+	return t.get(req.URL.Host).RoundTrip(req)
+}
+
+// IsGRPC 判断请求是否为gRPC调用
+func IsGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+func (t *hostTransports) tlsConfig(host string) *tls.Config {
+	if config, ok := t.config.HostTLS[host]; ok {
+		return config
+	}
+	return t.config.TLS
+}
+
+func (t *hostTransports) get(host string) *http.Transport {
+	t.Lock()
+	defer t.Unlock()
+
+	if tr, ok := t.transports[host]; ok {
+		return tr
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   t.config.DialTimeout,
+		KeepAlive: t.config.KeepAlive,
+	}
+	tr := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConnsPerHost:   t.config.MaxIdleConnsPerHost,
+		ResponseHeaderTimeout: t.config.ResponseHeaderTimeout,
+		IdleConnTimeout:       t.config.IdleConnTimeout,
+		// 自定义了DialContext和TLS配置时需要显式开启HTTP/2
+		ForceAttemptHTTP2: true,
+	}
+	if config := t.tlsConfig(host); config != nil {
+		tr.TLSClientConfig = config.Clone()
+	}
+	t.transports[host] = tr
+	return tr
+}
+
+// getH2C 明文HTTP/2连接，同一后端的请求复用一个连接上的多个流
+func (t *hostTransports) getH2C(host string) *http2.Transport {
+	t.Lock()
+	defer t.Unlock()
+
+	if tr, ok := t.h2c[host]; ok {
+		return tr
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   t.config.DialTimeout,
+		KeepAlive: t.config.KeepAlive,
+	}
+	tr := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		ReadIdleTimeout: t.config.KeepAlive,
+	}
+	t.h2c[host] = tr
+	return tr
+}
+
+// 服务器下线后关闭其空闲连接
+func (t *hostTransports) remove(host string) {
+	t.Lock()
+	defer t.Unlock()
+
+	if tr, ok := t.transports[host]; ok {
+		tr.CloseIdleConnections()
+		delete(t.transports, host)
+	}
+	if tr, ok := t.h2c[host]; ok {
+		tr.CloseIdleConnections()
+		delete(t.h2c, host)
+	}
+}
@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+func TestExpiredHostUnregisteredThroughReplicator(t *testing.T) {
+	p := newTestProxy(t, []string{"a:80"})
+	r := &recordingReplicator{proxy: p}
+	p.SetReplicator(r)
+
+	if err := p.RegisterHostTTL("b:80", core.Metadata{}, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for p.consistent.Size() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expired host was not removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	last := r.changes[len(r.changes)-1]
+	if last.Op != ChangeUnregister || last.Host != "b:80" {
+		t.Fatalf("last replicated change = %+v, want unregister of b:80", last)
+	}
+}
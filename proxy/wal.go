@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// wal 拓扑变更的预写日志：每次本地变更追加一行JSON并落盘，定期写快照后清空
+type wal struct {
+	path string
+	file *os.File
+	sync.Mutex
+}
+
+func (w *wal) append(c Change) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	w.Lock()
+	defer w.Unlock()
+	if _, err = w.file.Write(data); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// EnableWAL 之后拓扑变更不再每次重写快照，而是追加到path；每隔interval写一次快照（包括负载）并清空日志
+// 需要先调用EnableSnapshot，启动时先恢复快照，再用ReplayWAL重放日志；快照不包含槽位，使用slots模式时还需EnableSlots
+func (p *Proxy) EnableWAL(path string, interval time.Duration) error {
+	if p.snapshotPath == "" {
+		return errors.New("wal: snapshot file is required")
+	}
+	if interval <= 0 {
+		return errors.New("wal: snapshot interval must be positive")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	p.wal = &wal{path: path, file: f}
+	// 把重放过的变更写入快照
+	p.compact()
+	go p.runWAL(interval)
+	return nil
+}
+
+// ReplayWAL 在本实例的环上按顺序重放path中的变更，返回重放的条数，文件不存在时什么都不做；需在EnableWAL之前调用
+// 写完快照、清空日志之前退出时日志与快照有重叠，服务器已存在、不存在的错误直接忽略；最后一行写到一半时丢弃
+func (p *Proxy) ReplayWAL(path string) (int, error) {
+	if p.wal != nil {
+		return 0, errors.New("wal: replay after EnableWAL")
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var c Change
+		if err = json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			p.logger.Warn("wal: discard corrupted entries", "path", path, "line", line, "error", err)
+			break
+		}
+		err = p.ApplyChange(c)
+		if err != nil && !errors.Is(err, core.ErrHostAlreadyExists) && !errors.Is(err, core.ErrHostNotFound) {
+			return n, fmt.Errorf("wal: line %d: %w", line, err)
+		}
+		n++
+	}
+	return n, scanner.Err()
+}
+
+func (p *Proxy) runWAL(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.compact()
+		}
+	}
+}
+
+// compact 写快照后清空日志；写快照失败时保留日志，下次再试
+func (p *Proxy) compact() {
+	p.wal.Lock()
+	defer p.wal.Unlock()
+
+	if err := p.writeSnapshot(); err != nil {
+		p.logger.Error("write snapshot failed", "path", p.snapshotPath, "error", err)
+		return
+	}
+	if err := p.wal.file.Truncate(0); err != nil {
+		p.logger.Error("truncate wal failed", "path", p.wal.path, "error", err)
+	}
+}
@@ -0,0 +1,43 @@
+package resp
+
+// 第一个参数为key的命令，按该key选择服务器
+var singleKey = map[string]bool{}
+
+func init() {
+	for _, cmd := range []string{
+		"GET", "SET", "SETNX", "SETEX", "PSETEX", "GETSET", "GETDEL", "GETEX", "APPEND", "STRLEN",
+		"INCR", "INCRBY", "INCRBYFLOAT", "DECR", "DECRBY", "GETRANGE", "SETRANGE",
+		"SETBIT", "GETBIT", "BITCOUNT", "BITPOS",
+		"EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT", "EXPIRETIME", "PEXPIRETIME", "TTL", "PTTL", "PERSIST", "TYPE", "DUMP", "RESTORE",
+		"HGET", "HSET", "HSETNX", "HMSET", "HMGET", "HDEL", "HLEN", "HSTRLEN", "HEXISTS", "HGETALL", "HKEYS", "HVALS",
+		"HINCRBY", "HINCRBYFLOAT", "HRANDFIELD", "HSCAN",
+		"LPUSH", "RPUSH", "LPUSHX", "RPUSHX", "LPOP", "RPOP", "LLEN", "LRANGE", "LINDEX", "LSET", "LREM", "LTRIM", "LINSERT", "LPOS",
+		"SADD", "SREM", "SMEMBERS", "SISMEMBER", "SMISMEMBER", "SCARD", "SPOP", "SRANDMEMBER", "SSCAN",
+		"ZADD", "ZREM", "ZSCORE", "ZMSCORE", "ZINCRBY", "ZCARD", "ZCOUNT", "ZLEXCOUNT", "ZRANK", "ZREVRANK",
+		"ZRANGE", "ZREVRANGE", "ZRANGEBYSCORE", "ZREVRANGEBYSCORE", "ZRANGEBYLEX", "ZREVRANGEBYLEX",
+		"ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZREMRANGEBYLEX", "ZPOPMIN", "ZPOPMAX", "ZRANDMEMBER", "ZSCAN",
+		"PFADD", "GEOADD", "GEODIST", "GEOHASH", "GEOPOS", "GEOSEARCH",
+		"XADD", "XLEN", "XRANGE", "XREVRANGE", "XDEL", "XTRIM",
+	} {
+		singleKey[cmd] = true
+	}
+}
+
+// 多个key的命令拆分到各台服务器后合并结果
+const (
+	// 返回与key一一对应的数组
+	multiGet = iota + 1
+	// 返回各台服务器结果之和
+	multiCount
+	// 参数为key value对，全部成功时返回OK
+	multiSet
+)
+
+var multiKey = map[string]int{
+	"MGET":   multiGet,
+	"DEL":    multiCount,
+	"UNLINK": multiCount,
+	"EXISTS": multiCount,
+	"TOUCH":  multiCount,
+	"MSET":   multiSet,
+}
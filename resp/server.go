@@ -0,0 +1,396 @@
+// Package resp 实现Redis协议的分片代理：解析客户端命令，按key在环上选出Redis实例后转发
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// Server 监听Addr，接受Redis客户端连接
+// 只支持与单个key相关的命令，以及拆分到多台服务器执行的MGET、MSET、DEL、UNLINK、EXISTS、TOUCH（跨服务器时不是原子的）
+// key中包含{tag}时按tag选择服务器，与Redis Cluster的hash tag一致
+type Server struct {
+	Addr string
+	Ring *core.Consistent
+	// 连接后端时使用的AUTH密码，为空时不认证
+	Password string
+	// 连接后端以及每次读写后端的超时
+	DialTimeout time.Duration
+	Logger      core.Logger
+}
+
+const (
+	defaultDialTimeout = 3 * time.Second
+	// 后端返回MOVED、ASK时最多跟随的次数，只跟随到环上的服务器
+	maxRedirects = 3
+)
+
+// Run 监听并处理连接，直到ctx取消；取消后关闭所有连接
+func (s *Server) Run(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, lis)
+}
+
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = lis.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+			sess := &session{server: s, backends: make(map[string]*backend)}
+			if err := sess.serve(conn); err != nil && ctx.Err() == nil {
+				logger.Warn("redis proxy connection failed", "client", conn.RemoteAddr().String(), "error", err)
+			}
+		}()
+	}
+}
+
+// session 一个客户端连接，按需建立到各台服务器的连接，命令按顺序执行
+type session struct {
+	server   *Server
+	backends map[string]*backend
+}
+
+type backend struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+func (sess *session) serve(conn net.Conn) error {
+	defer conn.Close()
+	defer sess.close()
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				_ = errorf("ERR Protocol error").WriteTo(w)
+				_ = w.Flush()
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := strings.EqualFold(string(args[0]), "QUIT")
+		reply := simple("OK")
+		if !quit {
+			reply = sess.exec(args)
+		}
+		if err = reply.WriteTo(w); err != nil {
+			return err
+		}
+		// 客户端批量发送（pipeline）时，读完缓冲的命令再一起写出
+		if r.Buffered() == 0 || quit {
+			if err = w.Flush(); err != nil {
+				return err
+			}
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+func (sess *session) exec(args [][]byte) Value {
+	name := strings.ToUpper(string(args[0]))
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			return bulk(args[1])
+		}
+		return simple("PONG")
+	case "ECHO":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		return bulk(args[1])
+	case "SELECT":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		if string(args[1]) != "0" {
+			return errorf("ERR DB index is out of range")
+		}
+		return simple("OK")
+	}
+
+	if singleKey[name] {
+		if len(args) < 2 {
+			return wrongArgs(name)
+		}
+		host, err := sess.server.Ring.GetHost(routingKey(args[1]))
+		if err != nil {
+			return errorf("ERR %v", err)
+		}
+		return sess.do(host, args)
+	}
+	switch multiKey[name] {
+	case multiGet:
+		return sess.mget(args)
+	case multiCount:
+		return sess.count(args)
+	case multiSet:
+		return sess.mset(args)
+	}
+	return errorf("ERR unsupported command '%s'", args[0])
+}
+
+// routingKey 与Redis Cluster一致，key中第一个{}内非空时只用其中的内容选择服务器
+func routingKey(key []byte) string {
+	if i := bytes.IndexByte(key, '{'); i >= 0 {
+		if j := bytes.IndexByte(key[i+1:], '}'); j > 0 {
+			return string(key[i+1 : i+1+j])
+		}
+	}
+	return string(key)
+}
+
+func wrongArgs(name string) Value {
+	return errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+// group 按服务器分组keys，返回每台服务器上的key在keys中的下标
+func (sess *session) group(keys [][]byte) (map[string][]int, error) {
+	groups := make(map[string][]int)
+	for i, key := range keys {
+		host, err := sess.server.Ring.GetHost(routingKey(key))
+		if err != nil {
+			return nil, err
+		}
+		groups[host] = append(groups[host], i)
+	}
+	return groups, nil
+}
+
+func (sess *session) mget(args [][]byte) Value {
+	if len(args) < 2 {
+		return wrongArgs("MGET")
+	}
+	keys := args[1:]
+	groups, err := sess.group(keys)
+	if err != nil {
+		return errorf("ERR %v", err)
+	}
+
+	result := Value{Type: '*', Array: make([]Value, len(keys))}
+	for host, idx := range groups {
+		sub := [][]byte{args[0]}
+		for _, i := range idx {
+			sub = append(sub, keys[i])
+		}
+		v := sess.do(host, sub)
+		if v.isError() {
+			return v
+		}
+		if len(v.Array) != len(idx) {
+			return errorf("ERR unexpected MGET reply from %s", host)
+		}
+		for j, i := range idx {
+			result.Array[i] = v.Array[j]
+		}
+	}
+	return result
+}
+
+func (sess *session) count(args [][]byte) Value {
+	if len(args) < 2 {
+		return wrongArgs(string(args[0]))
+	}
+	keys := args[1:]
+	groups, err := sess.group(keys)
+	if err != nil {
+		return errorf("ERR %v", err)
+	}
+
+	var total int64
+	for host, idx := range groups {
+		sub := [][]byte{args[0]}
+		for _, i := range idx {
+			sub = append(sub, keys[i])
+		}
+		v := sess.do(host, sub)
+		if v.isError() {
+			return v
+		}
+		n, err := strconv.ParseInt(v.Line, 10, 64)
+		if v.Type != ':' || err != nil {
+			return errorf("ERR unexpected %s reply from %s", args[0], host)
+		}
+		total += n
+	}
+	return integer(total)
+}
+
+func (sess *session) mset(args [][]byte) Value {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return wrongArgs("MSET")
+	}
+	keys := make([][]byte, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	groups, err := sess.group(keys)
+	if err != nil {
+		return errorf("ERR %v", err)
+	}
+
+	for host, idx := range groups {
+		sub := [][]byte{args[0]}
+		for _, i := range idx {
+			sub = append(sub, args[1+2*i], args[2+2*i])
+		}
+		if v := sess.do(host, sub); v.isError() {
+			return v
+		}
+	}
+	return simple("OK")
+}
+
+// do 在host上执行命令，后端返回MOVED或ASK时转到其指定的地址重试，客户端不会看到重定向
+// 指定的地址不在环上时把重定向原样返回给客户端，避免按后端的回复连接任意地址
+func (sess *session) do(host string, args [][]byte) Value {
+	cmd := commandValue(args)
+	asking := false
+	for i := 0; ; i++ {
+		b, err := sess.backend(host)
+		if err != nil {
+			return errorf("ERR connect to %s: %v", host, err)
+		}
+		if asking {
+			if _, err = b.roundTrip(commandValue([][]byte{[]byte("ASKING")})); err != nil {
+				sess.drop(host)
+				return errorf("ERR backend %s: %v", host, err)
+			}
+		}
+		v, err := b.roundTrip(cmd)
+		if err != nil {
+			sess.drop(host)
+			return errorf("ERR backend %s: %v", host, err)
+		}
+
+		redirect, addr, ok := parseRedirect(v)
+		if !ok || i == maxRedirects {
+			return v
+		}
+		if _, err = sess.server.Ring.GetHostInfo(addr); err != nil {
+			return v
+		}
+		host, asking = addr, redirect == "ASK"
+	}
+}
+
+// parseRedirect 解析 MOVED 3999 127.0.0.1:6381 或 ASK 3999 127.0.0.1:6381
+func parseRedirect(v Value) (string, string, bool) {
+	if v.Type != '-' {
+		return "", "", false
+	}
+	fields := strings.Fields(v.Line)
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", "", false
+	}
+	return fields[0], fields[2], true
+}
+
+func (sess *session) backend(host string) (*backend, error) {
+	if b, ok := sess.backends[host]; ok {
+		return b, nil
+	}
+
+	timeout := sess.server.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	b := &backend{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), timeout: timeout}
+	if sess.server.Password != "" {
+		v, err := b.roundTrip(commandValue([][]byte{[]byte("AUTH"), []byte(sess.server.Password)}))
+		if err == nil && v.isError() {
+			err = fmt.Errorf("auth: %s", v.Line)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	sess.backends[host] = b
+	return b, nil
+}
+
+func (sess *session) drop(host string) {
+	if b, ok := sess.backends[host]; ok {
+		_ = b.conn.Close()
+		delete(sess.backends, host)
+	}
+}
+
+func (sess *session) close() {
+	for host := range sess.backends {
+		sess.drop(host)
+	}
+}
+
+// roundTrip 发送命令并读取回复，整个过程不超过timeout，后端无响应时不会一直阻塞客户端
+func (b *backend) roundTrip(cmd Value) (Value, error) {
+	if err := b.conn.SetDeadline(time.Now().Add(b.timeout)); err != nil {
+		return Value{}, err
+	}
+	if err := cmd.WriteTo(b.w); err != nil {
+		return Value{}, err
+	}
+	if err := b.w.Flush(); err != nil {
+		return Value{}, err
+	}
+	return ReadValue(b.r)
+}
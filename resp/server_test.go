@@ -0,0 +1,309 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dingqing/consistent-hash/core"
+)
+
+// fakeRedis 内存中的Redis，只实现测试用到的命令；handle不为nil时优先处理
+type fakeRedis struct {
+	addr   string
+	data   map[string][]byte
+	handle func(args [][]byte) (Value, bool)
+	// 收到的命令数
+	calls atomic.Int64
+	sync.Mutex
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	f := &fakeRedis{addr: lis.Addr().String(), data: make(map[string][]byte)}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		f.calls.Add(1)
+		if err = f.exec(args).WriteTo(w); err != nil || w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) exec(args [][]byte) Value {
+	if f.handle != nil {
+		if v, ok := f.handle(args); ok {
+			return v
+		}
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	switch strings.ToUpper(string(args[0])) {
+	case "GET":
+		if v, ok := f.data[string(args[1])]; ok {
+			return bulk(v)
+		}
+		return Value{Type: '$', Null: true}
+	case "SET":
+		f.data[string(args[1])] = args[2]
+		return simple("OK")
+	case "MGET":
+		v := Value{Type: '*'}
+		for _, key := range args[1:] {
+			if b, ok := f.data[string(key)]; ok {
+				v.Array = append(v.Array, bulk(b))
+			} else {
+				v.Array = append(v.Array, Value{Type: '$', Null: true})
+			}
+		}
+		return v
+	case "MSET":
+		for i := 1; i < len(args); i += 2 {
+			f.data[string(args[i])] = args[i+1]
+		}
+		return simple("OK")
+	case "DEL", "EXISTS":
+		var n int64
+		for _, key := range args[1:] {
+			if _, ok := f.data[string(key)]; ok {
+				n++
+				if strings.EqualFold(string(args[0]), "DEL") {
+					delete(f.data, string(key))
+				}
+			}
+		}
+		return integer(n)
+	}
+	return errorf("ERR unknown command '%s'", args[0])
+}
+
+// startServer 启动代理，ring上是backends的地址；返回与代理的连接
+func startServer(t *testing.T, backends ...*fakeRedis) func(args ...string) Value {
+	t.Helper()
+	ring := core.New(50, nil)
+	for _, b := range backends {
+		if err := ring.RegisterHost(b.addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Ring: ring, DialTimeout: 200 * time.Millisecond, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = s.Serve(ctx, lis)
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		cancel()
+		<-done
+	})
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	return func(args ...string) Value {
+		t.Helper()
+		cmd := make([][]byte, len(args))
+		for i, a := range args {
+			cmd[i] = []byte(a)
+		}
+		if err := commandValue(cmd).WriteTo(w); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		v, err := ReadValue(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	tests := []struct {
+		name     string
+		redirect string
+		// 为false时重定向到环外的服务器
+		onRing bool
+		want   string
+	}{
+		{"moved on ring", "MOVED", true, "target"},
+		{"ask on ring", "ASK", true, "target"},
+		{"moved off ring", "MOVED", false, "MOVED"},
+		{"ask off ring", "ASK", false, "ASK"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, target, outside := newFakeRedis(t), newFakeRedis(t), newFakeRedis(t)
+			to := target
+			if !tt.onRing {
+				to = outside
+			}
+			// 无论key落在哪台服务器上，都重定向到to
+			redirect := func(args [][]byte) (Value, bool) {
+				return errorf("%s 1 %s", tt.redirect, to.addr), string(args[0]) == "GET"
+			}
+			owner.handle, target.handle = redirect, redirect
+			to.handle = func(args [][]byte) (Value, bool) {
+				if string(args[0]) == "GET" {
+					return bulk([]byte("target")), true
+				}
+				return simple("OK"), true
+			}
+			call := startServer(t, owner, target)
+
+			v := call("GET", "k")
+			if got := v.Line + string(v.Bulk); !strings.HasPrefix(got, tt.want) {
+				t.Fatalf("GET = %q, want prefix %q", got, tt.want)
+			}
+			if !tt.onRing && outside.calls.Load() != 0 {
+				t.Fatal("proxy followed a redirect to a host outside the ring")
+			}
+		})
+	}
+}
+
+func TestBackendDeadline(t *testing.T) {
+	stuck := newFakeRedis(t)
+	release := make(chan struct{})
+	defer close(release)
+	stuck.handle = func(args [][]byte) (Value, bool) {
+		<-release
+		return simple("OK"), true
+	}
+	call := startServer(t, stuck)
+
+	start := time.Now()
+	v := call("GET", "k")
+	if !v.isError() || !strings.Contains(v.Line, "timeout") {
+		t.Fatalf("GET on a stuck backend = %+v, want a timeout error", v)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("GET took %v", elapsed)
+	}
+}
+
+func TestMultiKeySplitting(t *testing.T) {
+	backends := []*fakeRedis{newFakeRedis(t), newFakeRedis(t), newFakeRedis(t)}
+	call := startServer(t, backends...)
+	keys := []string{"a", "b", "c", "d", "e", "f", "{user}:1", "{user}:2"}
+
+	mset := []string{"MSET"}
+	for _, key := range keys {
+		mset = append(mset, key, "v-"+key)
+	}
+	if v := call(mset...); v.Line != "OK" {
+		t.Fatalf("MSET = %+v", v)
+	}
+	// key应分散到多台服务器上，且带相同tag的key在同一台
+	var used int
+	for _, b := range backends {
+		b.Lock()
+		if len(b.data) > 0 {
+			used++
+		}
+		_, tagged1 := b.data["{user}:1"]
+		_, tagged2 := b.data["{user}:2"]
+		b.Unlock()
+		if tagged1 != tagged2 {
+			t.Fatal("keys with the same hash tag are on different hosts")
+		}
+	}
+	if used < 2 {
+		t.Fatalf("keys were stored on %d hosts, want several", used)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"mget in request order", []string{"MGET", "f", "a", "missing", "{user}:2", "c"}, "v-f,v-a,<nil>,v-{user}:2,v-c"},
+		{"mget duplicate keys", []string{"MGET", "b", "b"}, "v-b,v-b"},
+		{"exists sums hosts", []string{"EXISTS", "a", "b", "c", "missing"}, "3"},
+		{"del sums hosts", []string{"DEL", "a", "d", "missing"}, "2"},
+		{"mget after del", []string{"MGET", "a", "d", "e"}, "<nil>,<nil>,v-e"},
+		{"mget without keys", []string{"MGET"}, "ERR wrong number of arguments for 'mget' command"},
+		{"mset odd arguments", []string{"MSET", "a"}, "ERR wrong number of arguments for 'mset' command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := call(tt.args...)
+			var got string
+			switch v.Type {
+			case '*':
+				parts := make([]string, len(v.Array))
+				for i, e := range v.Array {
+					parts[i] = string(e.Bulk)
+					if e.Null {
+						parts[i] = "<nil>"
+					}
+				}
+				got = strings.Join(parts, ",")
+			default:
+				got = v.Line
+			}
+			if got != tt.want {
+				t.Fatalf("%v = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoutingKey(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"user:1", "user:1"},
+		{"{user}:1", "user"},
+		{"a{user}b", "user"},
+		{"{}:1", "{}:1"},
+		{"{user", "{user"},
+		{"{a}{b}", "a"},
+	}
+	for _, tt := range tests {
+		if got := routingKey([]byte(tt.key)); got != tt.want {
+			t.Errorf("routingKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 单个bulk string和数组的大小上限，与Redis的默认限制一致
+const (
+	maxBulkSize  = 512 << 20
+	maxArraySize = 1 << 20
+	// 嵌套数组的层数上限，防止恶意输入耗尽栈
+	maxDepth = 64
+	// 按长度预先分配的上限，超过的部分随着数据到达再增长，声明很大长度却不发送数据的连接不会占用内存
+	maxPrealloc = 64 << 10
+)
+
+var errProtocol = errors.New("protocol error")
+
+// Value 一个RESP值，只保存转发和合并需要的内容
+type Value struct {
+	Type byte
+	// 简单字符串、错误、整数等单行类型的内容
+	Line string
+	// bulk string的内容
+	Bulk []byte
+	// 数组、集合、推送的元素，map按键值交替存放
+	Array []Value
+	// $-1或*-1
+	Null bool
+}
+
+func simple(s string) Value { return Value{Type: '+', Line: s} }
+func errorf(format string, args ...any) Value {
+	return Value{Type: '-', Line: fmt.Sprintf(format, args...)}
+}
+func integer(n int64) Value { return Value{Type: ':', Line: strconv.FormatInt(n, 10)} }
+func bulk(b []byte) Value   { return Value{Type: '$', Bulk: b} }
+
+func (v Value) isError() bool { return v.Type == '-' || v.Type == '!' }
+
+// ReadValue 读取一个完整的RESP2/RESP3值
+func ReadValue(r *bufio.Reader) (Value, error) {
+	return readValue(r, 0)
+}
+
+func readValue(r *bufio.Reader, depth int) (Value, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return Value{}, err
+	}
+	if len(line) == 0 {
+		return Value{}, errProtocol
+	}
+
+	v := Value{Type: line[0]}
+	switch v.Type {
+	case '+', '-', ':', '_', ',', '#', '(':
+		v.Line = line[1:]
+	case '$', '!', '=':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxBulkSize {
+			return Value{}, errProtocol
+		}
+		if n < 0 {
+			v.Null = true
+			return v, nil
+		}
+		if v.Bulk, err = readBulk(r, n); err != nil {
+			return Value{}, err
+		}
+	case '*', '~', '>', '%':
+		if depth >= maxDepth {
+			return Value{}, errProtocol
+		}
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxArraySize {
+			return Value{}, errProtocol
+		}
+		if n < 0 {
+			v.Null = true
+			return v, nil
+		}
+		if v.Type == '%' {
+			n *= 2
+		}
+		v.Array = make([]Value, 0, min(n, maxPrealloc/32))
+		for i := 0; i < n; i++ {
+			e, err := readValue(r, depth+1)
+			if err != nil {
+				return Value{}, err
+			}
+			v.Array = append(v.Array, e)
+		}
+	default:
+		return Value{}, errProtocol
+	}
+	return v, nil
+}
+
+// readBulk 读取n字节的内容和结尾的\r\n
+func readBulk(r *bufio.Reader, n int) ([]byte, error) {
+	buf := make([]byte, 0, min(n+2, maxPrealloc))
+	for len(buf) < n+2 {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		m, err := r.Read(buf[len(buf):min(cap(buf), n+2)])
+		buf = buf[:len(buf)+m]
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return buf[:n], nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// WriteTo 按RESP编码写出v
+func (v Value) WriteTo(w *bufio.Writer) error {
+	switch v.Type {
+	case '$', '!', '=':
+		if v.Null {
+			_, err := w.WriteString("$-1\r\n")
+			return err
+		}
+		fmt.Fprintf(w, "%c%d\r\n", v.Type, len(v.Bulk))
+		_, _ = w.Write(v.Bulk)
+		_, err := w.WriteString("\r\n")
+		return err
+	case '*', '~', '>', '%':
+		if v.Null {
+			_, err := w.WriteString("*-1\r\n")
+			return err
+		}
+		n := len(v.Array)
+		if v.Type == '%' {
+			n /= 2
+		}
+		fmt.Fprintf(w, "%c%d\r\n", v.Type, n)
+		for _, e := range v.Array {
+			if err := e.WriteTo(w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "%c%s\r\n", v.Type, v.Line)
+	return err
+}
+
+// readCommand 读取客户端的一条命令，支持数组形式和telnet使用的inline形式
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != '*' {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		var args [][]byte
+		for _, f := range strings.Fields(line) {
+			args = append(args, []byte(f))
+		}
+		return args, nil
+	}
+
+	// 命令只能是bulk string组成的数组，逐个检查元素的类型，不递归读取嵌套的值
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArraySize {
+		return nil, errProtocol
+	}
+	args := make([][]byte, 0, min(max(n, 0), maxPrealloc/24))
+	for i := 0; i < n; i++ {
+		if b, err = r.Peek(1); err != nil {
+			return nil, err
+		}
+		if b[0] != '$' {
+			return nil, errProtocol
+		}
+		e, err := readValue(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		if e.Null {
+			return nil, errProtocol
+		}
+		args = append(args, e.Bulk)
+	}
+	return args, nil
+}
+
+func commandValue(args [][]byte) Value {
+	v := Value{Type: '*', Array: make([]Value, len(args))}
+	for i, a := range args {
+		v.Array[i] = bulk(a)
+	}
+	return v
+}
@@ -0,0 +1,57 @@
+package resp
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestReadValueLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"nested too deep", strings.Repeat("*1\r\n", maxDepth+1) + ":1\r\n"},
+		{"array too large", "*1048577\r\n"},
+		{"declared array without elements", "*1048576\r\n:1\r\n"},
+		{"declared bulk without data", "$536870912\r\nabc"},
+		{"bulk too large", "$536870913\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadValue(bufio.NewReader(strings.NewReader(tt.input))); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	v, err := ReadValue(bufio.NewReader(strings.NewReader(strings.Repeat("*1\r\n", maxDepth) + ":1\r\n")))
+	if err != nil {
+		t.Fatalf("nesting at the limit: %v", err)
+	}
+	for i := 0; i < maxDepth; i++ {
+		v = v.Array[0]
+	}
+	if v.Line != "1" {
+		t.Fatalf("innermost value = %+v", v)
+	}
+}
+
+func TestReadCommandRejectsNonBulk(t *testing.T) {
+	tests := []string{
+		"*2\r\n$3\r\nGET\r\n*1\r\n$1\r\na\r\n",
+		"*2\r\n$3\r\nGET\r\n:1\r\n",
+		"*2\r\n$3\r\nGET\r\n$-1\r\n",
+		"*x\r\n",
+	}
+	for _, input := range tests {
+		if _, err := readCommand(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("readCommand(%q) succeeded", input)
+		}
+	}
+
+	args, err := readCommand(bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$1\r\na\r\n")))
+	if err != nil || len(args) != 2 || string(args[0]) != "GET" || string(args[1]) != "a" {
+		t.Fatalf("readCommand = %q, %v", args, err)
+	}
+}